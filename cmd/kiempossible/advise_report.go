@@ -0,0 +1,160 @@
+package main
+
+import (
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/remediation"
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/report"
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/risk_analysis"
+)
+
+// Titles, column order and SARIF rules of the Advise report sections, by section key
+// Suppressed sections share the definition of the section they were suppressed from
+
+var adviseSections = map[string]report.Section{
+	"risky_permissions": {
+		Title: "Entities with risky permissions",
+		Columns: []string{"entity_name", "entity_type", "risk_score", "rule_id", "severity", "risk_reason", "cloud_identities",
+			"permission_source", "permission_source_type", "permission_binding", "permission_binding_type",
+			"last_used_time_or_unused_duration", "description", "references"},
+		SARIF: &report.SARIFRule{
+			SubjectColumns: []string{"entity_type", "entity_name", "permission_binding_type", "permission_binding", "permission_source_type", "permission_source"},
+		},
+	},
+	"workloads_with_risky_permissions": {
+		Title: "Workloads using ServiceAccounts with risky permissions",
+		Columns: []string{"workload_type", "workload_name", "service_account_name", "risk_score", "rule_id", "severity", "risk_reason",
+			"cloud_identity", "cloud_identity_type", "automount_token", "privileged", "host_path", "host_network", "host_pid", "service_exposure"},
+		SARIF: &report.SARIFRule{
+			Description:    "A workload runs with a ServiceAccount that has risky permissions",
+			SubjectColumns: []string{"workload_type", "workload_name", "service_account_name"},
+		},
+	},
+	"entities": {
+		Title:   "Risk scores per entity",
+		Columns: []string{"entity_name", "entity_type", "risk_score", "finding_count"},
+	},
+	"bindings": {
+		Title:   "Risk scores per binding",
+		Columns: []string{"binding_name", "binding_type", "risk_score", "finding_count"},
+	},
+	"workloads": {
+		Title:   "Risk scores per workload",
+		Columns: []string{"workload_name", "workload_type", "risk_score", "finding_count"},
+	},
+	"escalation_paths": {
+		Title:   "Privilege escalation paths",
+		Columns: []string{"entity_name", "entity_type", "target_id", "target_name", "step_count", "fully_used_in_window", "steps"},
+		SARIF: &report.SARIFRule{
+			ID:             risk_analysis.EscalationPathRuleID,
+			Name:           "Privilege escalation path",
+			Severity:       "high",
+			Description:    "The entity can reach a crown-jewel target through a chain of identities it can act as",
+			SubjectColumns: []string{"entity_type", "entity_name", "target_id"},
+		},
+	},
+	"unused_roles": {
+		Title:   "Roles where all permissions are unused",
+		Columns: []string{"role_name", "role_type", "unused_permission_count"},
+		SARIF: &report.SARIFRule{
+			ID:             risk_analysis.UnusedRoleRuleID,
+			Name:           "Unused role",
+			Severity:       "low",
+			Description:    "None of the role permissions were used in the observed period",
+			SubjectColumns: []string{"role_type", "role_name"},
+		},
+	},
+	"unused_bindings": {
+		Title:   "Bindings where all permissions are unused",
+		Columns: []string{"binding_name", "binding_type", "unused_permission_count"},
+		SARIF: &report.SARIFRule{
+			ID:             risk_analysis.UnusedBindingRuleID,
+			Name:           "Unused binding",
+			Severity:       "low",
+			Description:    "None of the permissions granted by the binding were used in the observed period",
+			SubjectColumns: []string{"binding_type", "binding_name"},
+		},
+	},
+	"denied_requests": {
+		Title: "Repeated denied requests against sensitive resources",
+		Columns: []string{"entity_name", "entity_type", "verb", "resource_type", "api_group", "permission_scope",
+			"denied_count", "first_denied_time", "last_denied_time", "last_denied_resource"},
+		SARIF: &report.SARIFRule{
+			ID:             risk_analysis.DeniedRequestRuleID,
+			Name:           "Repeated denied requests",
+			Severity:       "medium",
+			Description:    "The entity was repeatedly denied access to sensitive resources",
+			SubjectColumns: []string{"entity_type", "entity_name", "verb", "resource_type", "permission_scope"},
+		},
+	},
+	"unmatched_requests": {
+		Title: "Observed requests with no matching permission",
+		Columns: []string{"entity_name", "entity_type", "verb", "resource_type", "api_group", "permission_scope",
+			"observed_count", "first_observed_time", "last_observed_time", "last_observed_resource", "authorized_binding"},
+		SARIF: &report.SARIFRule{
+			ID:             risk_analysis.UnmatchedRequestRuleID,
+			Name:           "Observed request with no matching permission",
+			Severity:       "low",
+			Description:    "The entity made requests which no collected permission allows - the access was revoked, or is granted by a source which isn't collected",
+			SubjectColumns: []string{"entity_type", "entity_name", "verb", "resource_type", "permission_scope"},
+		},
+	},
+	"dangling_bindings": {
+		Title:   "Bindings to missing roles, ServiceAccounts or namespaces",
+		Columns: []string{"finding_type", "binding_type", "binding_name", "namespace", "role", "missing"},
+		SARIF: &report.SARIFRule{
+			ID:             risk_analysis.DanglingBindingRuleID,
+			Name:           "Dangling binding",
+			Severity:       "medium",
+			Description:    "The binding references an object which doesn't exist - whoever creates it gets the binding's permissions",
+			SubjectColumns: []string{"binding_type", "namespace", "binding_name", "missing"},
+		},
+	},
+	"unbound_roles": {
+		Title:   "Roles which no binding references",
+		Columns: []string{"role_type", "role_name", "namespace"},
+		SARIF: &report.SARIFRule{
+			ID:             risk_analysis.UnboundRoleRuleID,
+			Name:           "Unbound role",
+			Severity:       "low",
+			Description:    "No RoleBinding or ClusterRoleBinding references the role",
+			SubjectColumns: []string{"role_type", "namespace", "role_name"},
+		},
+	},
+	"remediation": {
+		Title: "Remediation for unused RBAC",
+		Columns: []string{"action", "object_type", "object_name", "namespace", "removed_subjects", "patch",
+			"commands", "evidence_window", "last_used_time"},
+	},
+	"expired_suppressions": {
+		Title:   "Expired suppressions",
+		Columns: []string{"expires", "justification", "entity", "entity_type", "binding", "role", "namespace", "rule_id", "regex"},
+	},
+}
+
+func adviseSection(group, key string, rows []map[string]interface{}) report.Section {
+	section := adviseSections[key]
+	section.Group = group
+	section.Key = key
+	section.Rows = rows
+	return section
+}
+
+func remediationRow(artifact remediation.RemediationArtifact) map[string]interface{} {
+	row := map[string]interface{}{
+		"action":          artifact.Action,
+		"object_type":     artifact.ObjectType,
+		"object_name":     artifact.ObjectName,
+		"commands":        artifact.Commands,
+		"evidence_window": artifact.EvidenceWindow,
+		"last_used_time":  artifact.LastUsedTime,
+	}
+	if artifact.Namespace != "" {
+		row["namespace"] = artifact.Namespace
+	}
+	if len(artifact.RemovedSubjects) > 0 {
+		row["removed_subjects"] = artifact.RemovedSubjects
+	}
+	if len(artifact.Patch) > 0 {
+		row["patch"] = artifact.Patch
+	}
+	return row
+}
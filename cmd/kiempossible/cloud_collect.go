@@ -4,13 +4,16 @@ import (
 	"database/sql"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
-	"encoding/json"
-
 	"github.com/PaloAltoNetworks/KIEMPossible/pkg/auth_handling"
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/kube_collection"
 	"github.com/PaloAltoNetworks/KIEMPossible/pkg/log_parsing"
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/remediation"
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/report"
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/risk_analysis"
 )
 
 // Handling log collection and processing from different cloud providers
@@ -28,6 +31,22 @@ func Collect() {
 	region := clusterInfo.Region
 	logFile := credentialsPath.LogFile
 
+	// Load identity normalization rules before any subjects or usernames are processed
+	if credentialsPath.IdentityRules != "" {
+		if err := log_parsing.LoadIdentityRules(credentialsPath.IdentityRules); err != nil {
+			fmt.Printf("Failed to load identity rules: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if credentialsPath.WorkloadKinds != "" {
+		if err := kube_collection.LoadWorkloadKinds(credentialsPath.WorkloadKinds); err != nil {
+			fmt.Printf("Failed to load workload kinds: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	clusterTypes := map[string]string{"aws": "EKS", "azure": "AKS", "gcp": "GKE", "local": "LOCAL", "offline": credentialsPath.ClusterType}
+
 	// Platform specific handling - cluster resource collection, logs extraction and processing and DB updates
 	if cloudProvider == "aws" {
 		client, err := auth_handling.AwsAuth(credentialsPath)
@@ -36,7 +55,7 @@ func Collect() {
 		}
 		namespaces := KubeCollect(clusterName, "EKS", client, nil, "", "", nil, "", "", credentialsPath)
 		log_parsing.InitSession(client)
-		logEventsFile, err := log_parsing.ExtractAWSLogs(log_parsing.GetSession(), clusterName)
+		logEventsFile, err := log_parsing.ExtractAWSLogs(log_parsing.GetSession(), clusterName, credentialsPath.RecordDenied)
 		if err != nil {
 			fmt.Printf("Failed to extract AWS logs: %+v\n", err)
 		} else {
@@ -54,7 +73,7 @@ func Collect() {
 			fmt.Printf("Failed to establish Azure client: %+v\n", err)
 		}
 		KubeCollect(clusterName, "AKS", nil, cred, subscriptionID, resourceGroup, nil, "", "", credentialsPath)
-		logEventsFile, err := log_parsing.ExtractAzureLogs(cred, clusterName, workspaceID)
+		logEventsFile, err := log_parsing.ExtractAzureLogs(cred, clusterName, workspaceID, credentialsPath.RecordDenied)
 		if err != nil {
 			fmt.Printf("Failed to extract Azure logs: %+v\n", err)
 		} else {
@@ -72,17 +91,21 @@ func Collect() {
 		if err != nil {
 			fmt.Printf("Failed to establish GCP client: %+v\n", err)
 		}
-		KubeCollect(clusterName, "GKE", nil, nil, "", "", cred, region, projectID, cred_path)
-		logEventsFile, err := log_parsing.ExtractGCPLogs(cred, clusterName, projectID, region)
+		namespaces := KubeCollect(clusterName, "GKE", nil, nil, "", "", cred, region, projectID, cred_path)
+		DB, err := auth_handling.DBConnect()
+		if err != nil {
+			fmt.Println("Error in DB Connection", err)
+		}
+		defer DB.Close()
+		fmt.Printf("Mapping GKE relevant IAM policy bindings...\n")
+		if err := log_parsing.HandleGCPIAMPolicies(cred, projectID, DB, namespaces); err != nil {
+			fmt.Printf("Failed to map GCP IAM policies: %+v\n", err)
+		}
+		logEventsFile, err := log_parsing.ExtractGCPLogs(cred, clusterName, projectID, region, credentialsPath.RecordDenied)
 		if err != nil {
 			fmt.Printf("Failed to extract GCP logs: %+v\n", err)
 		} else {
-			DB, err := auth_handling.DBConnect()
-			if err != nil {
-				fmt.Println("Error in DB Connection", err)
-			}
-			defer DB.Close()
-			log_parsing.HandleGCPLogs(logEventsFile, DB)
+			log_parsing.HandleGCPLogs(logEventsFile, DB, cred)
 		}
 
 	} else if cloudProvider == "local" {
@@ -96,288 +119,217 @@ func Collect() {
 				fmt.Println("Error in DB Connection", err)
 			}
 			defer DB.Close()
-			log_parsing.HandleLocalLogs(logEventsFile, DB)
+			log_parsing.HandleLocalLogs(logEventsFile, DB, credentialsPath.RecordDenied)
+		}
+
+	} else if cloudProvider == "offline" || cloudProvider == "snapshot" {
+		if cloudProvider == "offline" {
+			OfflineCollect(credentialsPath)
+		} else {
+			clusterTypes["snapshot"] = SnapshotImport(credentialsPath)
+		}
+		// The usage is optional without a cluster, from an audit log file of the cluster
+		if logFile != "" {
+			logEventsFile, err := log_parsing.ExtractLocalLogs(logFile)
+			if err != nil {
+				fmt.Printf("Failed to extract Local logs: %+v\n", err)
+			} else {
+				DB, err := auth_handling.DBConnect()
+				if err != nil {
+					fmt.Println("Error in DB Connection", err)
+				}
+				defer DB.Close()
+				log_parsing.HandleLocalLogs(logEventsFile, DB, credentialsPath.RecordDenied)
+			}
 		}
 	}
-}
 
-func Advise() {
-	fmt.Println("\n\033[31mPreparing output report...\033[0m")
-	currentDate := time.Now().Format("20060102")
-	filename := fmt.Sprintf("kiempossible_report_%s.json", currentDate)
-	file, err := os.Create(filename)
+	// Tag the permissions of managed identities and bindings, including the ones added from the logs
+	DB, err := auth_handling.DBConnect()
 	if err != nil {
-		fmt.Printf("Error creating report file: %v\n", err)
+		fmt.Println("Error in DB Connection", err)
 		return
 	}
-	defer file.Close()
+	defer DB.Close()
+	if err := kube_collection.TagManagedPermissions(DB, clusterTypes[cloudProvider]); err != nil {
+		fmt.Printf("Failed to tag managed permissions: %+v\n", err)
+	}
+}
+
+// Write the report and evaluate the --fail-on conditions, returning the exit code for CI
+// 0 when no condition matched, 1 on errors and 2 when a condition matched
+func Advise(credentialsPath auth_handling.CredentialsPath) int {
+	fmt.Println("\n\033[31mPreparing output report...\033[0m")
+	now := time.Now()
+	currentDate := now.Format("20060102")
+	outputPath := credentialsPath.OutputPath
+	if outputPath == "" {
+		outputPath = report.DefaultPath(credentialsPath.OutputFormat, now)
+	}
 
 	DB, err := auth_handling.DBConnect()
 	if err != nil {
 		fmt.Println("Error in DB Connection", err)
-		return
+		return 1
 	}
 	defer DB.Close()
 
-	report := make(map[string]interface{})
+	output := report.Report{GeneratedAt: now}
+	suppressed := make(map[string][]map[string]interface{})
 
-	// Section 1: Entities with Risky Permissions
-	riskyPermissions := []map[string]interface{}{}
-	query := `
-		SELECT entity_name, entity_type, permission_source, permission_source_type, permission_binding, permission_binding_type, 'Wide secret access permissions' AS risk_reason, last_used_time
-		FROM permission 
-		WHERE resource_type = 'secrets' AND verb IN('get', 'list') 
-		GROUP BY entity_name, entity_type, permission_source, permission_source_type, permission_binding, permission_binding_type, last_used_time
-
-		UNION ALL
-
-		SELECT entity_name, entity_type, permission_source, permission_source_type, permission_binding, permission_binding_type, 'nodes/proxy access permissions' AS risk_reason, last_used_time
-		FROM permission 
-		WHERE resource_type = 'nodes/proxy' AND verb IN ('create', 'get') AND permission_scope = 'cluster-wide' 
-		GROUP BY entity_name, entity_type, permission_source, permission_source_type, permission_binding, permission_binding_type, last_used_time
-		HAVING COUNT(DISTINCT verb) = 2
-
-		UNION ALL
-
-		SELECT entity_name, entity_type, permission_source, permission_source_type, permission_binding, permission_binding_type, 'serviceaccount token creation permissions' AS risk_reason, last_used_time
-		FROM permission 
-		WHERE resource_type = 'serviceaccounts/token' AND verb = 'create'
-		GROUP BY entity_name, entity_type, permission_source, permission_source_type, permission_binding, permission_binding_type, last_used_time
-
-		UNION ALL
-
-		SELECT entity_name, entity_type, permission_source, permission_source_type, permission_binding, permission_binding_type, 'Escalate, bind or impersonate permissions' AS risk_reason, last_used_time
-		FROM permission 
-		WHERE verb IN('escalate', 'bind', 'impersonate') AND permission_scope = 'cluster-wide' 
-		GROUP BY entity_name, entity_type, permission_source, permission_source_type, permission_binding, permission_binding_type, last_used_time
-
-		UNION ALL
-
-		SELECT a.entity_name, a.entity_type, a.permission_source, a.permission_source_type, a.permission_binding, a.permission_binding_type, 'CSR and certificate issuing permissions' AS risk_reason, a.last_used_time
-		FROM (
-			SELECT entity_name, entity_type, permission_source, permission_source_type, permission_binding, permission_binding_type, last_used_time
-			FROM permission 
-			WHERE resource_type = 'certificatesigningrequests' AND verb = 'create' AND permission_scope = 'cluster-wide' 
-			GROUP BY entity_name, entity_type, permission_source, permission_source_type, permission_binding, permission_binding_type, last_used_time
-		) AS a 
-		INNER JOIN (
-			SELECT entity_name, entity_type, permission_source, permission_source_type, permission_binding, permission_binding_type, last_used_time
-			FROM permission 
-			WHERE resource_type = 'certificatesigningrequests/approval' AND verb IN ('patch', 'update') 
-			GROUP BY entity_name, entity_type, permission_source, permission_source_type, permission_binding, permission_binding_type, last_used_time
-		) AS b 
-		ON a.entity_name = b.entity_name AND a.entity_type = b.entity_type 
-		AND a.permission_source = b.permission_source AND a.permission_source_type = b.permission_source_type 
-		AND a.permission_binding = b.permission_binding AND a.permission_binding_type = b.permission_binding_type
-
-		UNION ALL
-
-		SELECT entity_name, entity_type, permission_source, permission_source_type, permission_binding, permission_binding_type, 'Workload creation permissions' AS risk_reason, last_used_time
-		FROM permission 
-		WHERE resource_type IN ('pods', 'deployments', 'statefulsets', 'replicasets', 'daemonsets', 'jobs', 'cronjobs') 
-		AND verb = 'create'
-		GROUP BY entity_name, entity_type, permission_source, permission_source_type, permission_binding, permission_binding_type, last_used_time
-
-		UNION ALL
-
-		SELECT entity_name, entity_type, permission_source, permission_source_type, permission_binding, permission_binding_type, 'PersistentVolume creation permissions' AS risk_reason, last_used_time
-		FROM permission 
-		WHERE resource_type = 'persistentvolumes' AND verb = 'create' AND permission_scope = 'cluster-wide' 
-		GROUP BY entity_name, entity_type, permission_source, permission_source_type, permission_binding, permission_binding_type, last_used_time
-
-		UNION ALL
-
-		SELECT entity_name, entity_type, permission_source, permission_source_type, permission_binding, permission_binding_type, 'Admission webhook management permissions' AS risk_reason, last_used_time
-		FROM permission 
-		WHERE resource_type IN ('validatingwebhookconfigurations', 'mutatingwebhookconfigurations') 
-		AND verb IN ('create', 'delete', 'patch', 'update') AND permission_scope = 'cluster-wide' 
-		GROUP BY entity_name, entity_type, permission_source, permission_source_type, permission_binding, permission_binding_type, last_used_time
-
-		ORDER BY entity_name, entity_type, risk_reason
-	`
+	// Evaluate the risk rules (built-in and user defined) against the permissions
+	ruleSet, err := risk_analysis.LoadRules(credentialsPath.RiskRules)
+	if err != nil {
+		fmt.Printf("Error loading risk rules: %v\n", err)
+		return 1
+	}
+	allFindings, err := risk_analysis.EvaluateRules(DB, ruleSet.Rules)
+	if err != nil {
+		fmt.Printf("Error evaluating risk rules: %v\n", err)
+		return 1
+	}
+	err = risk_analysis.ScoreFindings(DB, allFindings)
+	if err != nil {
+		fmt.Printf("Error scoring risk findings: %v\n", err)
+		return 1
+	}
 
-	rows, err := DB.Query(query)
+	// Accepted risks are moved to the suppressed section, and left out of the scores
+	suppressions, err := risk_analysis.LoadSuppressions(credentialsPath.Suppressions, time.Now())
 	if err != nil {
-		fmt.Printf("Error querying database: %v\n", err)
-		return
+		fmt.Printf("Error loading suppressions: %v\n", err)
+		return 1
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var (
-			entityName            string
-			entityType            string
-			permissionSource      string
-			permissionSourceType  string
-			permissionBinding     string
-			permissionBindingType string
-			riskReason            string
-			lastUsedTimeRaw       sql.RawBytes
-		)
-
-		err := rows.Scan(&entityName, &entityType, &permissionSource, &permissionSourceType, &permissionBinding, &permissionBindingType, &riskReason, &lastUsedTimeRaw)
-		if err != nil {
-			fmt.Printf("Error scanning row: %v\n", err)
+	expiredSuppressions := []map[string]interface{}{}
+	for _, suppression := range suppressions.Expired {
+		fmt.Printf("Suppression expired on %s, its items are reported again: %s\n", suppression.Expires, suppression.Justification)
+		expiredSuppressions = append(expiredSuppressions, suppressionRow(suppression))
+	}
+	suppressed["expired_suppressions"] = expiredSuppressions
+
+	// Managed identities and bindings can't be changed, and are left out unless included
+	var ownedFindings []risk_analysis.Finding
+	managedCount := 0
+	for _, finding := range allFindings {
+		if finding.Managed && !credentialsPath.IncludeManaged {
+			managedCount++
 			continue
 		}
+		ownedFindings = append(ownedFindings, finding)
+	}
+	findings, suppressedFindings := suppressions.FilterFindings(ownedFindings)
+	entityScores, bindingScores := risk_analysis.EntityAndBindingScores(findings)
+	risk_analysis.SortFindingsByScore(findings, entityScores)
 
-		riskReason = strings.ToUpper(riskReason)
-		lastUsed := ""
-		if len(lastUsedTimeRaw) == 0 {
-			lastUsed = "UNUSED in the observed period"
-		} else {
-			lastUsedTimeStr := string(lastUsedTimeRaw)
-			t, err := time.Parse("2006-01-02 15:04:05", lastUsedTimeStr)
-			if err != nil {
-				lastUsed = "Parse error: " + lastUsedTimeStr
-			} else {
-				unusedDuration := time.Since(t)
-				days := int(unusedDuration.Hours() / 24)
-				hours := int(unusedDuration.Hours())
-				if days >= 1 {
-					lastUsed = fmt.Sprintf("UNUSED for at least %d days", days)
-				} else {
-					lastUsed = fmt.Sprintf("UNUSED for at least %d hours", hours)
-				}
-			}
-		}
-		row := map[string]interface{}{
-			"entity_name":                       entityName,
-			"entity_type":                       entityType,
-			"permission_source":                 permissionSource,
-			"permission_source_type":            permissionSourceType,
-			"permission_binding":                permissionBinding,
-			"permission_binding_type":           permissionBindingType,
-			"risk_reason":                       riskReason,
-			"last_used_time_or_unused_duration": lastUsed,
-		}
-		riskyPermissions = append(riskyPermissions, row)
+	// Cloud identities the ServiceAccounts can assume, listed with their risky permissions
+	cloudIdentities, err := risk_analysis.ServiceAccountIdentities(DB)
+	if err != nil {
+		fmt.Printf("Error querying service account identities: %v\n", err)
+		return 1
+	}
+
+	// Section 1: Entities with Risky Permissions
+	riskyPermissions := []map[string]interface{}{}
+	for _, finding := range findings {
+		riskyPermissions = append(riskyPermissions, findingRow(finding, cloudIdentities))
 	}
-	if err = rows.Err(); err != nil {
-		fmt.Printf("Error iterating over rows: %v\n", err)
+	output.Add(adviseSection("", "risky_permissions", riskyPermissions))
+	suppressedPermissions := []map[string]interface{}{}
+	for _, finding := range suppressedFindings {
+		suppression, _ := suppressions.Match(finding.SuppressionSubject())
+		suppressedPermissions = append(suppressedPermissions, withSuppression(findingRow(finding, cloudIdentities), suppression))
 	}
-	report["risky_permissions"] = riskyPermissions
+	suppressed["risky_permissions"] = suppressedPermissions
 
 	// Section 2: Workloads using Service Accounts with Risky Permissions
-	var count int
-	err = DB.QueryRow("SELECT COUNT(*) FROM rufus.workload_identities").Scan(&count)
+	allWorkloadFindings, err := risk_analysis.WorkloadFindings(DB, allFindings)
 	if err != nil {
-		fmt.Printf("Error checking workload_identities table: %v\n", err)
-		return
+		fmt.Printf("Error querying workload database: %v\n", err)
+		return 1
+	}
+	var workloadFindings []risk_analysis.WorkloadFinding
+	suppressedWorkloads := []map[string]interface{}{}
+	for _, workloadFinding := range allWorkloadFindings {
+		if workloadFinding.Finding.Managed && !credentialsPath.IncludeManaged {
+			continue
+		}
+		if suppression, ok := suppressions.Match(workloadFinding.SuppressionSubject()); ok {
+			suppressedWorkloads = append(suppressedWorkloads, withSuppression(workloadRow(workloadFinding), suppression))
+			continue
+		}
+		workloadFindings = append(workloadFindings, workloadFinding)
 	}
+	suppressed["workloads_with_risky_permissions"] = suppressedWorkloads
+	workloadScores := risk_analysis.WorkloadScores(workloadFindings)
+	risk_analysis.SortWorkloadFindingsByScore(workloadFindings, workloadScores)
 	workloads := []map[string]interface{}{}
-	if count != 0 {
-		workloadQuery := `
-		WITH risky_permissions AS (
-			SELECT DISTINCT entity_name, risk_reason
-			FROM (
-				SELECT entity_name, 'Wide secret access permissions' AS risk_reason
-				FROM permission 
-				WHERE resource_type = 'secrets' AND verb IN('get', 'list') AND permission_scope = 'cluster-wide' 
-				GROUP BY entity_name
-
-				UNION ALL
-
-				SELECT entity_name, 'nodes/proxy access permissions' AS risk_reason
-				FROM permission 
-				WHERE resource_type = 'nodes/proxy' AND verb IN ('create', 'get') AND permission_scope = 'cluster-wide' 
-				GROUP BY entity_name
-				HAVING COUNT(DISTINCT verb) = 2
-
-				UNION ALL
-
-				SELECT entity_name, 'serviceaccount token creation permissions' AS risk_reason
-				FROM permission 
-				WHERE resource_type = 'serviceaccounts/token' AND verb = 'create'
-				GROUP BY entity_name
-
-				UNION ALL
-
-				SELECT entity_name, 'Escalate, bind or impersonate permissions' AS risk_reason
-				FROM permission 
-				WHERE verb IN('escalate', 'bind', 'impersonate') AND permission_scope = 'cluster-wide' 
-				GROUP BY entity_name
-
-				UNION ALL
-
-				SELECT a.entity_name, 'CSR and certificate issuing permissions' AS risk_reason
-				FROM (
-					SELECT entity_name
-					FROM permission 
-					WHERE resource_type = 'certificatesigningrequests' AND verb = 'create' AND permission_scope = 'cluster-wide' 
-					GROUP BY entity_name
-				) AS a 
-				INNER JOIN (
-					SELECT entity_name
-					FROM permission 
-					WHERE resource_type = 'certificatesigningrequests/approval' AND verb IN ('patch', 'update') 
-					GROUP BY entity_name
-				) AS b 
-				ON a.entity_name = b.entity_name
-
-				UNION ALL
-
-				SELECT entity_name, 'Workload creation permissions' AS risk_reason
-				FROM permission 
-				WHERE resource_type IN ('pods', 'deployments', 'statefulsets', 'replicasets', 'daemonsets', 'jobs', 'cronjobs') 
-				AND verb = 'create' AND permission_scope IN('cluster-wide', 'kube-system') 
-				GROUP BY entity_name
-
-				UNION ALL
-
-				SELECT entity_name, 'PersistentVolume creation permissions' AS risk_reason
-				FROM permission 
-				WHERE resource_type = 'persistentvolumes' AND verb = 'create' AND permission_scope = 'cluster-wide' 
-				GROUP BY entity_name
-
-				UNION ALL
-
-				SELECT entity_name, 'Admission webhook management permissions' AS risk_reason
-				FROM permission 
-				WHERE resource_type IN ('validatingwebhookconfigurations', 'mutatingwebhookconfigurations') 
-				AND verb IN ('create', 'delete', 'patch', 'update') AND permission_scope = 'cluster-wide' 
-				GROUP BY entity_name
-			) AS all_risks
-		)
-		SELECT w.workload_type, w.workload_name, w.service_account_name, rp.risk_reason
-		FROM rufus.workload_identities w
-		INNER JOIN risky_permissions rp ON w.service_account_name = rp.entity_name
-		ORDER BY w.workload_type, w.workload_name
-		`
-		workloadRows, err := DB.Query(workloadQuery)
-		if err != nil {
-			fmt.Printf("Error querying workload database: %v\n", err)
-			return
-		}
-		defer workloadRows.Close()
-		for workloadRows.Next() {
-			var (
-				workloadType       string
-				workloadName       string
-				serviceAccountName string
-				riskReason         string
-			)
-			err := workloadRows.Scan(&workloadType, &workloadName, &serviceAccountName, &riskReason)
-			if err != nil {
-				fmt.Printf("Error scanning workload row: %v\n", err)
-				continue
-			}
-			row := map[string]interface{}{
-				"workload_type":        workloadType,
-				"workload_name":        workloadName,
-				"service_account_name": serviceAccountName,
-				"risk_reason":          strings.ToUpper(riskReason),
-			}
-			workloads = append(workloads, row)
+	for _, workloadFinding := range workloadFindings {
+		workloads = append(workloads, workloadRow(workloadFinding))
+	}
+	output.Add(adviseSection("", "workloads_with_risky_permissions", workloads))
+
+	// Risk scores per entity, binding and workload, highest first
+	output.Add(adviseSection("risk_scores", "entities", scoreRows(entityScores, "entity_name", "entity_type")))
+	output.Add(adviseSection("risk_scores", "bindings", scoreRows(bindingScores, "binding_name", "binding_type")))
+	output.Add(adviseSection("risk_scores", "workloads", scoreRows(workloadScores, "workload_name", "workload_type")))
+
+	// Section 3: Privilege escalation paths to the crown-jewel targets
+	paths, err := risk_analysis.FindEscalationPaths(DB, ruleSet.Targets, allFindings, credentialsPath.EscalationSteps)
+	if err != nil {
+		fmt.Printf("Error computing escalation paths: %v\n", err)
+		return 1
+	}
+	escalationPaths := []map[string]interface{}{}
+	suppressedPaths := []map[string]interface{}{}
+	for _, path := range paths {
+		steps := []map[string]interface{}{}
+		for _, step := range path.Steps {
+			steps = append(steps, map[string]interface{}{
+				"from_entity_name": step.From.Name,
+				"from_entity_type": step.From.Type,
+				"to_entity_name":   step.To.Name,
+				"to_entity_type":   step.To.Type,
+				"action":           step.Action,
+				"resource_type":    step.ResourceType,
+				"verb":             step.Verb,
+				"permission_scope": step.PermissionScope,
+				"used_in_window":   step.LastUsedTime != "",
+				"last_used_time":   step.LastUsedTime,
+			})
+		}
+		holder := path.TargetHolder
+		steps = append(steps, map[string]interface{}{
+			"from_entity_name":        holder.EntityName,
+			"from_entity_type":        holder.EntityType,
+			"action":                  "Use " + holder.RuleName,
+			"permission_source":       holder.PermissionSource,
+			"permission_source_type":  holder.PermissionSourceType,
+			"permission_binding":      holder.PermissionBinding,
+			"permission_binding_type": holder.PermissionBindingType,
+			"used_in_window":          holder.LastUsedTime != "",
+			"last_used_time":          holder.LastUsedTime,
+		})
+		row := map[string]interface{}{
+			"entity_name":          path.Source.Name,
+			"entity_type":          path.Source.Type,
+			"target_id":            path.TargetID,
+			"target_name":          path.TargetName,
+			"step_count":           len(path.Steps),
+			"fully_used_in_window": path.FullyUsed(),
+			"steps":                steps,
 		}
-		if err = workloadRows.Err(); err != nil {
-			fmt.Printf("Error iterating over workload rows: %v\n", err)
+		if suppression, ok := suppressions.Match(path.SuppressionSubject()); ok {
+			suppressedPaths = append(suppressedPaths, withSuppression(row, suppression))
+			continue
 		}
+		escalationPaths = append(escalationPaths, row)
 	}
-	report["workloads_with_risky_permissions"] = workloads
+	output.Add(adviseSection("", "escalation_paths", escalationPaths))
+	suppressed["escalation_paths"] = suppressedPaths
 
-	// Section 3: Roles where all permissions are unused
+	// Section 4: Roles where all permissions are unused
 	unusedRoles := []map[string]interface{}{}
+	suppressedRoles := []map[string]interface{}{}
 	rolesQuery := `
 		SELECT 
 			permission_source AS rbac_object,
@@ -392,13 +344,19 @@ func Advise() {
 			  WHERE last_used_time >= (NOW() - INTERVAL 7 DAY)
 				AND permission_source_type IN ('Role', 'ClusterRole')
 		  )
+		  AND (? OR permission_source NOT IN (
+			  SELECT permission_source
+			  FROM permission
+			  WHERE managed = TRUE
+				AND permission_source_type IN ('Role', 'ClusterRole')
+		  ))
 		GROUP BY permission_source, permission_source_type
 		ORDER BY unused_permission_count DESC
 	`
-	rolesRows, err := DB.Query(rolesQuery)
+	rolesRows, err := DB.Query(rolesQuery, credentialsPath.IncludeManaged)
 	if err != nil {
 		fmt.Printf("Error querying unused roles: %v\n", err)
-		return
+		return 1
 	}
 	defer rolesRows.Close()
 	for rolesRows.Next() {
@@ -414,15 +372,21 @@ func Advise() {
 			"role_type":               rbacType,
 			"unused_permission_count": unusedCount,
 		}
+		if suppression, ok := suppressions.Match(risk_analysis.SuppressionSubject{Role: rbacObject, RuleID: risk_analysis.UnusedRoleRuleID}); ok {
+			suppressedRoles = append(suppressedRoles, withSuppression(row, suppression))
+			continue
+		}
 		unusedRoles = append(unusedRoles, row)
 	}
 	if err = rolesRows.Err(); err != nil {
 		fmt.Printf("Error iterating over unused roles rows: %v\n", err)
 	}
-	report["unused_roles"] = unusedRoles
+	output.Add(adviseSection("", "unused_roles", unusedRoles))
+	suppressed["unused_roles"] = suppressedRoles
 
-	// Section 4: Bindings where all permissions are unused
+	// Section 5: Bindings where all permissions are unused
 	unusedBindings := []map[string]interface{}{}
+	suppressedBindings := []map[string]interface{}{}
 	bindingsQuery := `
 		SELECT 
 			permission_binding AS rbac_object,
@@ -437,13 +401,19 @@ func Advise() {
 			  WHERE last_used_time >= (NOW() - INTERVAL 7 DAY)
 				AND permission_binding_type IN ('RoleBinding', 'ClusterRoleBinding')
 		  )
+		  AND (? OR permission_binding NOT IN (
+			  SELECT permission_binding
+			  FROM permission
+			  WHERE managed = TRUE
+				AND permission_binding_type IN ('RoleBinding', 'ClusterRoleBinding')
+		  ))
 		GROUP BY permission_binding, permission_binding_type
 		ORDER BY unused_permission_count DESC
 	`
-	bindingsRows, err := DB.Query(bindingsQuery)
+	bindingsRows, err := DB.Query(bindingsQuery, credentialsPath.IncludeManaged)
 	if err != nil {
 		fmt.Printf("Error querying unused bindings: %v\n", err)
-		return
+		return 1
 	}
 	defer bindingsRows.Close()
 	for bindingsRows.Next() {
@@ -459,22 +429,399 @@ func Advise() {
 			"binding_type":            rbacType,
 			"unused_permission_count": unusedCount,
 		}
+		if suppression, ok := suppressions.Match(risk_analysis.SuppressionSubject{Binding: rbacObject, RuleID: risk_analysis.UnusedBindingRuleID}); ok {
+			suppressedBindings = append(suppressedBindings, withSuppression(row, suppression))
+			continue
+		}
 		unusedBindings = append(unusedBindings, row)
 	}
 	if err = bindingsRows.Err(); err != nil {
 		fmt.Printf("Error iterating over unused bindings rows: %v\n", err)
 	}
-	report["unused_bindings"] = unusedBindings
+	output.Add(adviseSection("", "unused_bindings", unusedBindings))
+	suppressed["unused_bindings"] = suppressedBindings
+
+	// Section 6: Repeated denied requests against sensitive resources
+	// Sensitive resources are the ones of the risk rules
+	deniedRequests := []map[string]interface{}{}
+	suppressedDenied := []map[string]interface{}{}
+	deniedQuery := `
+		SELECT 
+			entity_name, entity_type, api_group, resource_type, verb, permission_scope,
+			denied_count, first_denied_time, last_denied_time, last_denied_resource
+		FROM denied_requests
+		WHERE denied_count >= ?
+		ORDER BY denied_count DESC
+	`
+	deniedRows, err := DB.Query(deniedQuery, credentialsPath.DeniedThreshold)
+	if err != nil {
+		fmt.Printf("Error querying denied requests: %v\n", err)
+		return 1
+	}
+	defer deniedRows.Close()
+	for deniedRows.Next() {
+		var entityName, entityType, apiGroup, resourceType, verb, permissionScope string
+		var deniedCount int
+		var firstDenied, lastDenied, lastDeniedResource sql.NullString
+		err := deniedRows.Scan(&entityName, &entityType, &apiGroup, &resourceType, &verb, &permissionScope,
+			&deniedCount, &firstDenied, &lastDenied, &lastDeniedResource)
+		if err != nil {
+			fmt.Printf("Error scanning denied requests row: %v\n", err)
+			continue
+		}
+		if !ruleSet.SensitiveResource(resourceType) {
+			continue
+		}
+		row := map[string]interface{}{
+			"entity_name":          entityName,
+			"entity_type":          entityType,
+			"api_group":            apiGroup,
+			"resource_type":        resourceType,
+			"verb":                 verb,
+			"permission_scope":     permissionScope,
+			"denied_count":         deniedCount,
+			"first_denied_time":    firstDenied.String,
+			"last_denied_time":     lastDenied.String,
+			"last_denied_resource": lastDeniedResource.String,
+		}
+		subject := risk_analysis.SuppressionSubject{
+			Entity:     entityName,
+			EntityType: entityType,
+			Namespace:  risk_analysis.ScopeNamespace(permissionScope),
+			RuleID:     risk_analysis.DeniedRequestRuleID,
+		}
+		if suppression, ok := suppressions.Match(subject); ok {
+			suppressedDenied = append(suppressedDenied, withSuppression(row, suppression))
+			continue
+		}
+		deniedRequests = append(deniedRequests, row)
+	}
+	if err = deniedRows.Err(); err != nil {
+		fmt.Printf("Error iterating over denied requests rows: %v\n", err)
+	}
+	output.Add(adviseSection("", "denied_requests", deniedRequests))
+	suppressed["denied_requests"] = suppressedDenied
 
-	// Write JSON to file
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	err = encoder.Encode(report)
+	// Section 7: Observed requests with no matching permission - revoked during the log window, or from sources which aren't collected
+	unmatchedRequests := []map[string]interface{}{}
+	suppressedUnmatched := []map[string]interface{}{}
+	unmatchedRows, err := DB.Query(`
+		SELECT 
+			entity_name, entity_type, api_group, resource_type, verb, permission_scope,
+			observed_count, first_observed_time, last_observed_time, last_observed_resource,
+			authorized_binding, authorized_binding_type
+		FROM unmatched_requests
+		ORDER BY observed_count DESC
+	`)
 	if err != nil {
-		fmt.Printf("Error writing JSON report: %v\n", err)
-		return
+		fmt.Printf("Error querying unmatched requests: %v\n", err)
+		return 1
+	}
+	defer unmatchedRows.Close()
+	for unmatchedRows.Next() {
+		var entityName, entityType, apiGroup, resourceType, verb, permissionScope string
+		var observedCount int
+		var firstObserved, lastObserved, lastObservedResource, authorizedBinding, authorizedBindingType sql.NullString
+		err := unmatchedRows.Scan(&entityName, &entityType, &apiGroup, &resourceType, &verb, &permissionScope,
+			&observedCount, &firstObserved, &lastObserved, &lastObservedResource, &authorizedBinding, &authorizedBindingType)
+		if err != nil {
+			fmt.Printf("Error scanning unmatched requests row: %v\n", err)
+			continue
+		}
+		row := map[string]interface{}{
+			"entity_name":            entityName,
+			"entity_type":            entityType,
+			"api_group":              apiGroup,
+			"resource_type":          resourceType,
+			"verb":                   verb,
+			"permission_scope":       permissionScope,
+			"observed_count":         observedCount,
+			"first_observed_time":    firstObserved.String,
+			"last_observed_time":     lastObserved.String,
+			"last_observed_resource": lastObservedResource.String,
+		}
+		if authorizedBinding.String != "" {
+			row["authorized_binding"] = authorizedBindingType.String + "/" + authorizedBinding.String
+		}
+		subject := risk_analysis.SuppressionSubject{
+			Entity:     entityName,
+			EntityType: entityType,
+			Binding:    authorizedBinding.String,
+			Namespace:  risk_analysis.ScopeNamespace(permissionScope),
+			RuleID:     risk_analysis.UnmatchedRequestRuleID,
+		}
+		if suppression, ok := suppressions.Match(subject); ok {
+			suppressedUnmatched = append(suppressedUnmatched, withSuppression(row, suppression))
+			continue
+		}
+		unmatchedRequests = append(unmatchedRequests, row)
+	}
+	if err = unmatchedRows.Err(); err != nil {
+		fmt.Printf("Error iterating over unmatched requests rows: %v\n", err)
+	}
+	output.Add(adviseSection("", "unmatched_requests", unmatchedRequests))
+	suppressed["unmatched_requests"] = suppressedUnmatched
+
+	// Sections 8 and 9: Bindings to missing roles, ServiceAccounts or namespaces, and roles bound by nothing
+	danglingBindings := []map[string]interface{}{}
+	suppressedDangling := []map[string]interface{}{}
+	unboundRoles := []map[string]interface{}{}
+	suppressedUnbound := []map[string]interface{}{}
+	orphanedRows, err := DB.Query(`
+		SELECT finding_type, object_type, object_name, object_namespace, role_type, role_name,
+			missing_type, missing_name, missing_namespace
+		FROM orphaned_rbac
+		ORDER BY finding_type, object_type, object_namespace, object_name
+	`)
+	if err != nil {
+		fmt.Printf("Error querying orphaned RBAC: %v\n", err)
+		return 1
+	}
+	defer orphanedRows.Close()
+	for orphanedRows.Next() {
+		var findingType, objectType, objectName, objectNamespace, roleType, roleName, missingType, missingName, missingNamespace string
+		err := orphanedRows.Scan(&findingType, &objectType, &objectName, &objectNamespace, &roleType, &roleName,
+			&missingType, &missingName, &missingNamespace)
+		if err != nil {
+			fmt.Printf("Error scanning orphaned RBAC row: %v\n", err)
+			continue
+		}
+		if findingType == kube_collection.UnboundRoleFinding {
+			row := map[string]interface{}{
+				"role_type": objectType,
+				"role_name": objectName,
+				"namespace": objectNamespace,
+			}
+			subject := risk_analysis.SuppressionSubject{Role: objectName, Namespace: objectNamespace, RuleID: risk_analysis.UnboundRoleRuleID}
+			if suppression, ok := suppressions.Match(subject); ok {
+				suppressedUnbound = append(suppressedUnbound, withSuppression(row, suppression))
+				continue
+			}
+			unboundRoles = append(unboundRoles, row)
+			continue
+		}
+		missing := missingType + " " + missingName
+		if missingNamespace != "" {
+			missing = fmt.Sprintf("%s %s/%s", missingType, missingNamespace, missingName)
+		}
+		row := map[string]interface{}{
+			"finding_type": findingType,
+			"binding_type": objectType,
+			"binding_name": objectName,
+			"namespace":    objectNamespace,
+			"role":         roleType + " " + roleName,
+			"missing":      missing,
+		}
+		subject := risk_analysis.SuppressionSubject{Binding: objectName, Role: roleName, Namespace: objectNamespace, RuleID: risk_analysis.DanglingBindingRuleID}
+		if suppression, ok := suppressions.Match(subject); ok {
+			suppressedDangling = append(suppressedDangling, withSuppression(row, suppression))
+			continue
+		}
+		danglingBindings = append(danglingBindings, row)
+	}
+	if err = orphanedRows.Err(); err != nil {
+		fmt.Printf("Error iterating over orphaned RBAC rows: %v\n", err)
+	}
+	output.Add(adviseSection("", "dangling_bindings", danglingBindings))
+	suppressed["dangling_bindings"] = suppressedDangling
+	output.Add(adviseSection("", "unbound_roles", unboundRoles))
+	suppressed["unbound_roles"] = suppressedUnbound
+
+	// Section 10: Remediation for unused roles, bindings and binding subjects
+	var windowStart, windowEnd string
+	err = DB.QueryRow("SELECT NOW() - INTERVAL 7 DAY, NOW()").Scan(&windowStart, &windowEnd)
+	if err != nil {
+		fmt.Printf("Error getting evidence window: %v\n", err)
+		return 1
+	}
+	allArtifacts, err := remediation.GenerateRBACRemediation(DB, windowStart, windowEnd, credentialsPath.IncludeManaged)
+	if err != nil {
+		fmt.Printf("Error generating remediation: %v\n", err)
+		return 1
 	}
+	remediationArtifacts := []remediation.RemediationArtifact{}
+	suppressedArtifacts := []map[string]interface{}{}
+	for _, artifact := range allArtifacts {
+		subject := risk_analysis.SuppressionSubject{Namespace: artifact.Namespace}
+		if artifact.ObjectType == "Role" || artifact.ObjectType == "ClusterRole" {
+			subject.Role = artifact.ObjectName
+			subject.RuleID = risk_analysis.UnusedRoleRuleID
+		} else {
+			subject.Binding = artifact.ObjectName
+			subject.RuleID = risk_analysis.UnusedBindingRuleID
+		}
+		if suppression, ok := suppressions.Match(subject); ok {
+			row := map[string]interface{}{
+				"action":      artifact.Action,
+				"object_type": artifact.ObjectType,
+				"object_name": artifact.ObjectName,
+				"namespace":   artifact.Namespace,
+			}
+			suppressedArtifacts = append(suppressedArtifacts, withSuppression(row, suppression))
+			continue
+		}
+		remediationArtifacts = append(remediationArtifacts, artifact)
+	}
+	remediationRows := []map[string]interface{}{}
+	for _, artifact := range remediationArtifacts {
+		remediationRows = append(remediationRows, remediationRow(artifact))
+	}
+	output.Add(adviseSection("", "remediation", remediationRows))
+	suppressed["remediation"] = suppressedArtifacts
+	if len(remediationArtifacts) > 0 {
+		// Next to the report
+		scriptFilename := filepath.Join(filepath.Dir(outputPath), fmt.Sprintf("kiempossible_remediation_%s.sh", currentDate))
+		if err := remediation.WriteRemediationScript(scriptFilename, remediationArtifacts); err != nil {
+			fmt.Printf("Error writing remediation script: %v\n", err)
+		} else {
+			fmt.Printf("Remediation commands written to %s\n", scriptFilename)
+		}
+	}
+
+	// Suppressed items, in the order of their sections
+	for _, key := range []string{"risky_permissions", "workloads_with_risky_permissions", "escalation_paths", "unused_roles", "unused_bindings", "denied_requests", "unmatched_requests", "dangling_bindings", "unbound_roles", "remediation", "expired_suppressions"} {
+		output.Add(adviseSection("suppressed", key, suppressed[key]))
+	}
+
+	// Write the report in the requested format
+	if err := output.Write(outputPath, credentialsPath.OutputFormat); err != nil {
+		fmt.Printf("Error writing report: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Report written to %s\n", outputPath)
 
 	// Print notice to screen
+	if managedCount > 0 {
+		fmt.Printf("\n%d risky permission findings of managed identities and bindings were left out - set the --include-managed flag to include them\n", managedCount)
+	}
 	fmt.Println("\n\033[31mNOTICE: Unused permissions observed in the ingestion timeframe are shown with a last used time. Unused Permissions not observed are shown without. Explore the database for more information.\033[0m")
+
+	// CI gate - compare against the --fail-on conditions, and a previous report for new results
+	if credentialsPath.FailOn == "" {
+		return 0
+	}
+	var baseline *report.Report
+	if credentialsPath.Baseline != "" {
+		baselineReport, err := report.ReadJSON(credentialsPath.Baseline)
+		if err != nil {
+			fmt.Printf("Error loading baseline report: %v\n", err)
+			return 1
+		}
+		for i, section := range baselineReport.Sections {
+			baselineReport.Sections[i] = adviseSection(section.Group, section.Key, section.Rows)
+		}
+		baseline = &baselineReport
+	}
+	violations := output.Gate(report.ParseFailOn(credentialsPath.FailOn), baseline)
+	if len(violations) == 0 {
+		fmt.Printf("\nNo findings matched --fail-on %s\n", credentialsPath.FailOn)
+		return 0
+	}
+	fmt.Printf("\n\033[31mFAILED: %d findings matched --fail-on %s\033[0m\n", len(violations), credentialsPath.FailOn)
+	for i, violation := range violations {
+		if i == gateSummaryLimit {
+			fmt.Printf("  ... and %d more, see %s\n", len(violations)-gateSummaryLimit, outputPath)
+			break
+		}
+		fmt.Printf("  [%s] %s %s: %s\n", violation.Condition, violation.Severity, violation.RuleID, violation.Message)
+	}
+	return 2
+}
+
+// Violations listed in the --fail-on summary
+const gateSummaryLimit = 20
+
+// Describe how long a permission has been unused
+func unusedDuration(lastUsedTime string) string {
+	if lastUsedTime == "" {
+		return "UNUSED in the observed period"
+	}
+	t, err := time.Parse("2006-01-02 15:04:05", lastUsedTime)
+	if err != nil {
+		return "Parse error: " + lastUsedTime
+	}
+	unused := time.Since(t)
+	days := int(unused.Hours() / 24)
+	hours := int(unused.Hours())
+	if days >= 1 {
+		return fmt.Sprintf("UNUSED for at least %d days", days)
+	}
+	return fmt.Sprintf("UNUSED for at least %d hours", hours)
+}
+
+func scoreRows(scores []risk_analysis.Score, nameKey, typeKey string) []map[string]interface{} {
+	rows := []map[string]interface{}{}
+	for _, score := range scores {
+		rows = append(rows, map[string]interface{}{
+			nameKey:         score.Name,
+			typeKey:         score.Type,
+			"risk_score":    score.Score,
+			"finding_count": score.FindingCount,
+		})
+	}
+	return rows
+}
+
+func findingRow(finding risk_analysis.Finding, cloudIdentities map[string][]string) map[string]interface{} {
+	identities := ""
+	if finding.EntityType == "ServiceAccount" {
+		identities = strings.Join(cloudIdentities[finding.EntityName], ", ")
+	}
+	return map[string]interface{}{
+		"entity_name":                       finding.EntityName,
+		"entity_type":                       finding.EntityType,
+		"permission_source":                 finding.PermissionSource,
+		"permission_source_type":            finding.PermissionSourceType,
+		"permission_binding":                finding.PermissionBinding,
+		"permission_binding_type":           finding.PermissionBindingType,
+		"rule_id":                           finding.RuleID,
+		"severity":                          finding.Severity,
+		"risk_score":                        finding.Score,
+		"risk_reason":                       strings.ToUpper(finding.RuleName),
+		"description":                       finding.Description,
+		"references":                        finding.References,
+		"last_used_time_or_unused_duration": unusedDuration(finding.LastUsedTime),
+		"cloud_identities":                  identities,
+	}
+}
+
+func workloadRow(workloadFinding risk_analysis.WorkloadFinding) map[string]interface{} {
+	return map[string]interface{}{
+		"workload_type":        workloadFinding.WorkloadType,
+		"workload_name":        workloadFinding.WorkloadName,
+		"service_account_name": workloadFinding.ServiceAccountName,
+		"rule_id":              workloadFinding.Finding.RuleID,
+		"severity":             workloadFinding.Finding.Severity,
+		"risk_score":           workloadFinding.Score,
+		"risk_reason":          strings.ToUpper(workloadFinding.Finding.RuleName),
+		"automount_token":      workloadFinding.AutomountToken,
+		"privileged":           workloadFinding.Privileged,
+		"host_path":            workloadFinding.HostPath,
+		"host_network":         workloadFinding.HostNetwork,
+		"host_pid":             workloadFinding.HostPID,
+		"service_exposure":     workloadFinding.ServiceExposure,
+		"cloud_identity":       workloadFinding.CloudIdentity,
+		"cloud_identity_type":  workloadFinding.CloudIdentityType,
+	}
+}
+
+func suppressionRow(suppression risk_analysis.Suppression) map[string]interface{} {
+	return map[string]interface{}{
+		"entity":        suppression.Entity,
+		"entity_type":   suppression.EntityType,
+		"binding":       suppression.Binding,
+		"role":          suppression.Role,
+		"namespace":     suppression.Namespace,
+		"rule_id":       suppression.RuleID,
+		"regex":         suppression.Regex,
+		"expires":       suppression.Expires,
+		"justification": suppression.Justification,
+	}
+}
+
+// Add the suppression matching a report row to it
+func withSuppression(row map[string]interface{}, suppression risk_analysis.Suppression) map[string]interface{} {
+	row["suppression_justification"] = suppression.Justification
+	row["suppression_expires"] = suppression.Expires
+	return row
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/auth_handling"
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/remediation"
+)
+
+// Generate least-privilege Roles/ClusterRoles from the usage observed in a previous collection
+
+func GenerateRoles(args []string) {
+	cmd := flag.NewFlagSet("generate-roles", flag.ExitOnError)
+	entity := cmd.String("entity", "", "Entity to generate roles for")
+	entityType := cmd.String("entity-type", "", "[OPTIONAL] Type of the entity (User, Group, ServiceAccount...)")
+	binding := cmd.String("binding", "", "Binding to generate roles for (covers all of its subjects)")
+	bindingType := cmd.String("binding-type", "", "[OPTIONAL] Type of the binding (RoleBinding, ClusterRoleBinding...)")
+	serviceAccount := cmd.String("service-account", "", "ServiceAccount to generate roles for (namespace:name)")
+	workload := cmd.String("workload", "", "Workload whose ServiceAccount to generate roles for (requires a collection with --collect-workloads)")
+	workloadType := cmd.String("workload-type", "", "[OPTIONAL] Type of the workload (Deployment, DaemonSet...)")
+	name := cmd.String("name", "", "[OPTIONAL] Name of the generated roles")
+	includeUnobserved := cmd.Bool("include-unobserved", false, "[OPTIONAL] Also include permissions which are granted but were not observed in the logs")
+	output := cmd.String("output", "", "[OPTIONAL] File to write the YAML to (default stdout)")
+	cmd.Parse(args)
+
+	DB, err := auth_handling.DBConnect()
+	if err != nil {
+		fmt.Println("Error in DB Connection", err)
+		os.Exit(1)
+	}
+	defer DB.Close()
+
+	objects, err := remediation.GenerateRoles(DB, remediation.RoleGenerationOptions{
+		Selector: remediation.RoleSelector{
+			Entity:         *entity,
+			EntityType:     *entityType,
+			Binding:        *binding,
+			BindingType:    *bindingType,
+			ServiceAccount: *serviceAccount,
+			Workload:       *workload,
+			WorkloadType:   *workloadType,
+		},
+		Name:              *name,
+		IncludeUnobserved: *includeUnobserved,
+	})
+	if err != nil {
+		fmt.Printf("Failed to generate roles: %v\n", err)
+		os.Exit(1)
+	}
+
+	data, err := remediation.MarshalYAMLDocuments(objects)
+	if err != nil {
+		fmt.Printf("Error marshaling roles: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *output == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(*output, data, 0644); err != nil {
+		fmt.Printf("Error writing roles to %s: %v\n", *output, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Roles written to %s\n", *output)
+}
@@ -13,6 +13,7 @@ import (
 	v1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 )
 
 // Handling kube collection and processing from different cloud providers
@@ -21,7 +22,12 @@ import (
 func KubeCollect(clusterName, clusterType string, sess *session.Session, azure_cred *azidentity.ClientSecretCredential, subscriptionID, resourceGroup string, gcp_cred *google.Credentials, region, projectID string, cred_file auth_handling.CredentialsPath) *v1.NamespaceList {
 
 	// Connect to the cluster
-	clientset, err := auth_handling.KubeConnect(clusterName, clusterType, sess, azure_cred, subscriptionID, resourceGroup, gcp_cred, region, projectID, cred_file)
+	config, err := auth_handling.KubeConfig(clusterName, clusterType, sess, azure_cred, subscriptionID, resourceGroup, gcp_cred, region, projectID, cred_file)
+	if err != nil {
+		fmt.Printf("error getting Kubernetes clientset: %v\n", err)
+		os.Exit(1)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		fmt.Printf("error getting Kubernetes clientset: %v\n", err)
 		os.Exit(1)
@@ -66,10 +72,22 @@ func KubeCollect(clusterName, clusterType string, sess *session.Session, azure_c
 		fmt.Println("Error storing RoleBindings permissions in the database:", err)
 	}
 
+	count, err := kube_collection.CollectOrphanedRBAC(clientset, DB, roles, clusterRoles)
+	if err != nil {
+		fmt.Println("Error storing dangling bindings and unbound roles in the database:", err)
+	} else if count > 0 {
+		fmt.Printf("Found %d dangling bindings and unbound roles\n", count)
+	}
+
+	// ServiceAccount token secrets, for the escalation paths through secret reads
+	if _, err := kube_collection.CollectTokenSecrets(config, DB); err != nil {
+		fmt.Println("Error storing ServiceAccount token secrets in the database:", err)
+	}
+
 	// Collect workloads if flag is set
 	if cred_file.CollectWorkloads {
 		fmt.Printf("\nCollecting workload information...\n")
-		if err := kube_collection.Collect_workloads(clientset, DB, clusterType, clusterName, sess); err != nil {
+		if err := kube_collection.Collect_workloads(clientset, config, DB, clusterType, clusterName, sess); err != nil {
 			fmt.Printf("Failed to collect workloads: %+v\n", err)
 		}
 		fmt.Printf("Workload information collected!\n\n")
@@ -2,11 +2,43 @@ package main
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/PaloAltoNetworks/KIEMPossible/pkg/auth_handling"
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/report"
 )
 
 func main() {
+	// Commands working on the results of a previous collection
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "generate-roles":
+			GenerateRoles(os.Args[2:])
+			return
+		case "whois", "entity":
+			Whois(os.Args[1], os.Args[2:])
+			return
+		case "who-can":
+			WhoCan(os.Args[2:])
+			return
+		case "simulate":
+			Simulate(os.Args[2:])
+			return
+		case "verify":
+			Verify(os.Args[2:])
+			return
+		case "export-discovery":
+			ExportDiscovery(os.Args[2:])
+			return
+		case "snapshot":
+			// snapshot import loads the DB like a collection, and is handled with the provider commands
+			if len(os.Args) > 2 && os.Args[2] == "export" {
+				SnapshotExport(os.Args[3:])
+				return
+			}
+		}
+	}
+
 	banner := `
 	 _  _____ ___ __  __ ___           _ _    _     
 	| |/ /_ _| __|  \/  | _ \___ _____(_) |__| |___ 
@@ -16,8 +48,33 @@ func main() {
 `
 	fmt.Println(banner)
 	credPath, _, _ := auth_handling.Authenticator()
+	if credPath.FailOn != "" && !credPath.ShouldAdvise {
+		fmt.Println("--fail-on requires --advise")
+		os.Exit(1)
+	}
+	if report.NeedsBaseline(report.ParseFailOn(credPath.FailOn)) && credPath.Baseline == "" {
+		fmt.Println("--fail-on new requires --baseline")
+		os.Exit(1)
+	}
+	if credPath.ShouldAdvise {
+		if err := report.ValidateFormat(credPath.OutputFormat); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if credPath.DeniedThreshold < 1 {
+			fmt.Println("--denied-threshold must be at least 1")
+			os.Exit(1)
+		}
+		if credPath.EscalationSteps < 1 {
+			fmt.Println("--escalation-max-steps must be at least 1")
+			os.Exit(1)
+		}
+	}
 	Collect()
 	if credPath.ShouldAdvise {
-		Advise()
+		// The exit code only reflects the report with --fail-on, for CI
+		if code := Advise(credPath); code != 0 && credPath.FailOn != "" {
+			os.Exit(code)
+		}
 	}
 }
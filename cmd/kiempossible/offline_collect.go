@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/auth_handling"
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/kube_collection"
+)
+
+// Offline collection from manifests, rendered Helm charts or cluster dumps - the same processing as KubeCollect, without a cluster
+
+func OfflineCollect(cred_file auth_handling.CredentialsPath) {
+	fmt.Printf("\x1b[1;36m-------\nOffline Mode\n-------\x1b[0m\n")
+
+	objects := kube_collection.NewOfflineObjects()
+	if err := objects.LoadPaths(cred_file.Manifests, cred_file.Namespace); err != nil {
+		fmt.Println("Error reading manifests:", err)
+		os.Exit(1)
+	}
+	if cred_file.HelmChart != "" {
+		rendered, err := kube_collection.RenderHelmChart(cred_file.HelmChart, cred_file.HelmRelease, cred_file.Namespace, cred_file.HelmValues)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if err := objects.Load(bytes.NewReader(rendered), cred_file.HelmChart, cred_file.Namespace); err != nil {
+			fmt.Println("Error reading rendered Helm chart:", err)
+			os.Exit(1)
+		}
+	}
+	if err := objects.AggregateClusterRoles(); err != nil {
+		fmt.Println("Error aggregating ClusterRoles:", err)
+	}
+	fmt.Printf("Read %d documents - %d Roles, %d ClusterRoles, %d RoleBindings, %d ClusterRoleBindings (%d documents of other kinds skipped)\n",
+		objects.Documents, len(objects.Roles), len(objects.ClusterRoles), len(objects.RoleBindings), len(objects.ClusterRoleBindings), objects.Skipped)
+
+	// Discovery snapshot for wildcard flattening, with the custom resources defined in the manifests
+	apiResourceLists, err := kube_collection.LoadDiscoverySnapshot(cred_file.Discovery)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	resourceTypes, subresources, err := kube_collection.OfflineResources(apiResourceLists, objects.CustomResources)
+	if err != nil {
+		fmt.Println("Error in discovery snapshot:", err)
+		os.Exit(1)
+	}
+
+	storeCollectedObjects(objects, resourceTypes, subresources, cred_file.ClusterType, false)
+}
+
+// Calculate the permissions of objects read without a cluster and insert them with the workloads into the DB
+// Dangling bindings and unbound roles are only looked for in complete dumps, manifests reference objects defined elsewhere
+func storeCollectedObjects(objects *kube_collection.OfflineObjects, resourceTypes []kube_collection.ResourceType, subresources map[string]string, clusterType string, complete bool) {
+	DB, err := auth_handling.DBConnect()
+	if err != nil {
+		fmt.Println("Error in DB Connection", err)
+		os.Exit(1)
+	}
+	defer DB.Close()
+
+	err = auth_handling.ClearDatabase(DB)
+	if err != nil {
+		fmt.Printf("Failed to clear database: %+v\n", err)
+	}
+
+	fmt.Printf("Calculating permissions and inserting into DB...\n")
+	err = kube_collection.StoreClusterRoleBindings(DB, objects.ClusterRoleBindings, objects.ClusterRoles, resourceTypes, subresources, objects.AllNamespaces())
+	if err != nil {
+		fmt.Println("Error storing clusterRoleBindings permissions in the database:", err)
+	}
+	err = kube_collection.StoreRoleBindings(DB, objects.RoleBindings, objects.ClusterRoles, objects.Roles, resourceTypes, subresources)
+	if err != nil {
+		fmt.Println("Error storing RoleBindings permissions in the database:", err)
+	}
+	if complete {
+		count, err := objects.StoreOrphanedRBAC(DB)
+		if err != nil {
+			fmt.Println("Error storing dangling bindings and unbound roles in the database:", err)
+		} else if count > 0 {
+			fmt.Printf("Found %d dangling bindings and unbound roles\n", count)
+		}
+	}
+
+	if err := kube_collection.StoreTokenSecrets(DB, objects.TokenSecrets); err != nil {
+		fmt.Println("Error storing ServiceAccount token secrets in the database:", err)
+	}
+
+	// Workloads are stored whenever there are some, there is no cost to reading them
+	count, err := objects.StoreWorkloads(DB, clusterType)
+	if err != nil {
+		fmt.Printf("Failed to store workloads: %+v\n", err)
+	} else if count > 0 {
+		fmt.Printf("Stored %d workloads\n", count)
+	}
+}
+
+// Write the resources of the kubeconfig cluster as a discovery snapshot, for offline collections with --discovery
+func ExportDiscovery(args []string) {
+	cmd := flag.NewFlagSet("export-discovery", flag.ExitOnError)
+	output := cmd.String("output", "kiempossible_discovery.json", "[OPTIONAL] File to write the snapshot to")
+	cmd.Parse(args)
+
+	clientset, err := auth_handling.KubeConnect("", "LOCAL", nil, nil, "", "", nil, "", "", auth_handling.CredentialsPath{})
+	if err != nil {
+		fmt.Printf("error getting Kubernetes clientset: %v\n", err)
+		os.Exit(1)
+	}
+	apiResourceLists, err := kube_collection.ExportDiscoverySnapshot(clientset)
+	if err != nil {
+		fmt.Printf("Failed to get the cluster resources: %v\n", err)
+		os.Exit(1)
+	}
+	if err := writeInventoryOutput(apiResourceLists, "json", *output, "Discovery snapshot", nil); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
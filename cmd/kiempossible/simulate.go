@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/auth_handling"
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/kube_collection"
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/simulation"
+)
+
+// Simulate proposed RBAC changes against the permissions and usage of a previous collection
+// Exits with code 2 when observed requests would be denied, for CI
+
+func Simulate(args []string) {
+	cmd := flag.NewFlagSet("simulate", flag.ExitOnError)
+	namespace := cmd.String("namespace", "default", "[OPTIONAL] Namespace of the proposed Roles and RoleBindings without one")
+	discoveryFile := cmd.String("discovery", "", "[OPTIONAL] Discovery snapshot written by export-discovery (default the built-in Kubernetes resources)")
+	outputFormat := cmd.String("output-format", "text", "[OPTIONAL] Output format - text, json or yaml")
+	output := cmd.String("output", "", "[OPTIONAL] File to write the output to (default stdout)")
+	usage := func() {
+		fmt.Printf("Usage: %s simulate <files or directories> [options]\n", os.Args[0])
+		fmt.Printf("Proposed Roles, ClusterRoles and bindings are added or replace the collected ones, objects annotated with %s: delete are deleted\n", simulation.DeleteAnnotation)
+		cmd.PrintDefaults()
+	}
+	cmd.Usage = usage
+
+	// The proposal files come first, with the options after them
+	var paths []string
+	for len(args) > 0 {
+		cmd.Parse(args)
+		if cmd.NArg() == 0 {
+			break
+		}
+		paths = append(paths, cmd.Arg(0))
+		args = cmd.Args()[1:]
+	}
+	if len(paths) == 0 {
+		usage()
+		os.Exit(1)
+	}
+	if *outputFormat != "text" && *outputFormat != "json" && *outputFormat != "yaml" {
+		fmt.Printf("Unsupported output format %q (text, json or yaml)\n", *outputFormat)
+		os.Exit(1)
+	}
+
+	proposal := kube_collection.NewOfflineObjects()
+	if err := proposal.LoadPaths(paths, *namespace); err != nil {
+		fmt.Println("Error reading the proposed changes:", err)
+		os.Exit(1)
+	}
+	if err := proposal.AggregateClusterRoles(); err != nil {
+		fmt.Println("Error aggregating ClusterRoles:", err)
+	}
+	apiResourceLists, err := kube_collection.LoadDiscoverySnapshot(*discoveryFile)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	resourceTypes, subresources, err := kube_collection.OfflineResources(apiResourceLists, proposal.CustomResources)
+	if err != nil {
+		fmt.Println("Error in discovery snapshot:", err)
+		os.Exit(1)
+	}
+
+	DB, err := auth_handling.DBConnect()
+	if err != nil {
+		fmt.Println("Error in DB Connection", err)
+		os.Exit(1)
+	}
+	defer DB.Close()
+
+	result, err := simulation.Simulate(DB, proposal, resourceTypes, subresources)
+	if err != nil {
+		fmt.Printf("Failed to simulate the changes: %v\n", err)
+		os.Exit(1)
+	}
+	if result.Broken == nil {
+		result.Broken = []simulation.BrokenRequest{}
+	}
+
+	err = writeInventoryOutput(result, *outputFormat, *output, "Simulation result", func(w io.Writer) error {
+		return simulation.WriteText(w, result)
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if len(result.Broken) > 0 {
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/auth_handling"
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/kube_collection"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Cluster snapshots - export the collection inputs from a cluster, and import them into the DB on another machine
+
+func SnapshotExport(args []string) {
+	cmd := flag.NewFlagSet("snapshot export", flag.ExitOnError)
+	output := cmd.String("output", "", "[OPTIONAL] Path of the snapshot (default kiempossible_snapshot_YYYYMMDD.tar.gz)")
+	clusterType := cmd.String("cluster-type", "LOCAL", "[OPTIONAL] Type of the cluster, recorded for the import - EKS, AKS, GKE or LOCAL")
+	workloadKinds := cmd.String("workload-kinds", "", "[OPTIONAL] Path to a YAML file with additional custom resource workload kinds to export")
+	cmd.Parse(args)
+
+	*clusterType = strings.ToUpper(*clusterType)
+	if *clusterType != "EKS" && *clusterType != "AKS" && *clusterType != "GKE" && *clusterType != "LOCAL" {
+		fmt.Printf("Unsupported cluster type: %s (EKS, AKS, GKE or LOCAL)\n", *clusterType)
+		os.Exit(1)
+	}
+	if *workloadKinds != "" {
+		if err := kube_collection.LoadWorkloadKinds(*workloadKinds); err != nil {
+			fmt.Printf("Failed to load workload kinds: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	config, err := auth_handling.KubeConfig("", "LOCAL", nil, nil, "", "", nil, "", "", auth_handling.CredentialsPath{})
+	if err != nil {
+		fmt.Printf("error getting Kubernetes clientset: %v\n", err)
+		os.Exit(1)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		fmt.Printf("error getting Kubernetes clientset: %v\n", err)
+		os.Exit(1)
+	}
+
+	path := *output
+	if path == "" {
+		path = fmt.Sprintf("kiempossible_snapshot_%s.tar.gz", time.Now().Format("20060102"))
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		fmt.Printf("Failed to create snapshot: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Exporting cluster snapshot...\n")
+	manifest, err := kube_collection.ExportSnapshot(clientset, config, file, *clusterType)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// Don't leave a partial snapshot behind
+		os.Remove(path)
+		fmt.Printf("Failed to export snapshot: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Snapshot (version %d) written to %s - %d RoleBindings, %d ClusterRoleBindings, %d ServiceAccounts, %d pods\n",
+		manifest.Version, path, manifest.Counts["rolebindings"], manifest.Counts["clusterrolebindings"], manifest.Counts["serviceaccounts"], manifest.Counts["pods"])
+}
+
+// Load a snapshot into the DB, returning the cluster type for the managed identities
+func SnapshotImport(cred_file auth_handling.CredentialsPath) string {
+	fmt.Printf("\x1b[1;36m-------\nSnapshot Mode\n-------\x1b[0m\n")
+
+	file, err := os.Open(cred_file.Snapshot)
+	if err != nil {
+		fmt.Printf("Failed to open snapshot: %v\n", err)
+		os.Exit(1)
+	}
+	defer file.Close()
+
+	snapshot, err := kube_collection.ImportSnapshot(file)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	clusterType := snapshot.Manifest.ClusterType
+	if cred_file.ClusterType != "" {
+		clusterType = cred_file.ClusterType
+	}
+	objects := snapshot.Objects
+	fmt.Printf("Snapshot of a %s cluster taken at %s - %d Roles, %d ClusterRoles, %d RoleBindings, %d ClusterRoleBindings\n",
+		clusterType, snapshot.Manifest.CreatedAt.Format("2006-01-02 15:04:05"), len(objects.Roles), len(objects.ClusterRoles), len(objects.RoleBindings), len(objects.ClusterRoleBindings))
+
+	resourceTypes, subresources, err := snapshot.Resources()
+	if err != nil {
+		fmt.Println("Error in snapshot discovery:", err)
+		os.Exit(1)
+	}
+	storeCollectedObjects(objects, resourceTypes, subresources, clusterType, true)
+	return clusterType
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/auth_handling"
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/log_parsing"
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/verification"
+)
+
+// Check a sample of the collected permissions against the authorizer of the kubeconfig cluster with SubjectAccessReviews
+// Exits with code 2 when there are discrepancies, for CI
+
+func Verify(args []string) {
+	cmd := flag.NewFlagSet("verify", flag.ExitOnError)
+	sample := cmd.Int("sample", 100, "[OPTIONAL] Number of permissions to sample")
+	seed := cmd.Int64("seed", 0, "[OPTIONAL] Seed of the sample, to repeat a previous run (default random)")
+	entity := cmd.String("entity", "", "[OPTIONAL] Only sample the permissions of this entity (default all entities)")
+	checkMissing := cmd.Bool("check-missing", true, "[OPTIONAL] Also check a verb the DB doesn't grant for every sampled permission")
+	identityRules := cmd.String("identity-rules", "", "[OPTIONAL] Path to the YAML file with the username and group normalization rules of the collection")
+	outputFormat := cmd.String("output-format", "text", "[OPTIONAL] Output format - text, json or yaml")
+	output := cmd.String("output", "", "[OPTIONAL] File to write the output to (default stdout)")
+	cmd.Usage = func() {
+		fmt.Printf("Usage: %s verify [options]\n", os.Args[0])
+		fmt.Printf("Requires create on subjectaccessreviews in the kubeconfig cluster, which must be the collected one\n")
+		cmd.PrintDefaults()
+	}
+	cmd.Parse(args)
+
+	if *sample <= 0 {
+		fmt.Println("--sample must be a positive number")
+		os.Exit(1)
+	}
+	if *outputFormat != "text" && *outputFormat != "json" && *outputFormat != "yaml" {
+		fmt.Printf("Unsupported output format %q (text, json or yaml)\n", *outputFormat)
+		os.Exit(1)
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano() % (1 << 31)
+	}
+	if *identityRules != "" {
+		if err := log_parsing.LoadIdentityRules(*identityRules); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	clientset, err := auth_handling.KubeConnect("", "LOCAL", nil, nil, "", "", nil, "", "", auth_handling.CredentialsPath{})
+	if err != nil {
+		fmt.Printf("error getting Kubernetes clientset: %v\n", err)
+		os.Exit(1)
+	}
+	DB, err := auth_handling.DBConnect()
+	if err != nil {
+		fmt.Println("Error in DB Connection", err)
+		os.Exit(1)
+	}
+	defer DB.Close()
+
+	result, err := verification.Verify(context.Background(), DB, clientset, verification.Options{
+		Sample:       *sample,
+		Seed:         *seed,
+		Entity:       *entity,
+		CheckMissing: *checkMissing,
+	})
+	if err != nil {
+		fmt.Printf("Failed to verify the permissions: %v\n", err)
+		os.Exit(1)
+	}
+
+	err = writeInventoryOutput(result, *outputFormat, *output, "Verification result", func(w io.Writer) error {
+		return verification.WriteText(w, result)
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if len(result.Discrepancies) > 0 {
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/auth_handling"
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/inventory"
+)
+
+// Print the identities which can perform a verb on a resource, from a previous collection
+
+func WhoCan(args []string) {
+	cmd := flag.NewFlagSet("who-can", flag.ExitOnError)
+	namespace := cmd.String("namespace", "", "[OPTIONAL] Namespace (default any namespace and cluster-wide)")
+	name := cmd.String("name", "", "[OPTIONAL] Resource name, '*' includes grants restricted to specific names (default grants on any name only)")
+	apiGroup := cmd.String("api-group", "", "[OPTIONAL] API group without the version (default any group)")
+	outputFormat := cmd.String("output-format", "text", "[OPTIONAL] Output format - text, json or yaml")
+	output := cmd.String("output", "", "[OPTIONAL] File to write the output to (default stdout)")
+	usage := func() {
+		fmt.Printf("Usage: %s who-can <verb> <resource> [options]\n", os.Args[0])
+		fmt.Printf("The verb and resource (resource or resource/subresource) support globs, e.g. who-can create 'pods/*'\n")
+		cmd.PrintDefaults()
+	}
+	cmd.Usage = usage
+
+	// The verb and resource come first, with the options after them
+	var positional []string
+	for len(args) > 0 {
+		cmd.Parse(args)
+		if cmd.NArg() == 0 {
+			break
+		}
+		positional = append(positional, cmd.Arg(0))
+		args = cmd.Args()[1:]
+	}
+	if len(positional) != 2 {
+		usage()
+		os.Exit(1)
+	}
+	if *outputFormat != "text" && *outputFormat != "json" && *outputFormat != "yaml" {
+		fmt.Printf("Unsupported output format %q (text, json or yaml)\n", *outputFormat)
+		os.Exit(1)
+	}
+
+	DB, err := auth_handling.DBConnect()
+	if err != nil {
+		fmt.Println("Error in DB Connection", err)
+		os.Exit(1)
+	}
+	defer DB.Close()
+
+	answers, err := inventory.WhoCan(DB, inventory.WhoCanQuery{
+		Verb:      positional[0],
+		Resource:  positional[1],
+		APIGroup:  *apiGroup,
+		Namespace: *namespace,
+		Name:      *name,
+	})
+	if err != nil {
+		fmt.Printf("Failed to query permissions: %v\n", err)
+		os.Exit(1)
+	}
+	if answers == nil {
+		answers = []inventory.WhoCanAnswer{}
+	}
+
+	err = writeInventoryOutput(answers, *outputFormat, *output, "Who-can answers", func(w io.Writer) error {
+		return inventory.WriteWhoCanText(w, answers)
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/auth_handling"
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/inventory"
+	"sigs.k8s.io/yaml"
+)
+
+// Print the effective permissions of an identity and their usage, from a previous collection
+
+func Whois(command string, args []string) {
+	cmd := flag.NewFlagSet(command, flag.ExitOnError)
+	entity := cmd.String("entity", "", "Entity to describe (or pass it as the first argument)")
+	entityType := cmd.String("entity-type", "", "[OPTIONAL] Type of the entity (User, Group, ServiceAccount...)")
+	outputFormat := cmd.String("output-format", "text", "[OPTIONAL] Output format - text, json or yaml")
+	output := cmd.String("output", "", "[OPTIONAL] File to write the output to (default stdout)")
+	cmd.Parse(args)
+
+	// The entity can be passed first, with the options after it
+	if *entity == "" && cmd.NArg() > 0 {
+		*entity = cmd.Arg(0)
+		cmd.Parse(cmd.Args()[1:])
+	}
+	if *entity == "" {
+		fmt.Printf("Usage: %s %s --entity <name> [options]\n", os.Args[0], command)
+		cmd.PrintDefaults()
+		os.Exit(1)
+	}
+	if *outputFormat != "text" && *outputFormat != "json" && *outputFormat != "yaml" {
+		fmt.Printf("Unsupported output format %q (text, json or yaml)\n", *outputFormat)
+		os.Exit(1)
+	}
+
+	DB, err := auth_handling.DBConnect()
+	if err != nil {
+		fmt.Println("Error in DB Connection", err)
+		os.Exit(1)
+	}
+	defer DB.Close()
+
+	entities, err := inventory.GetEntityPermissions(DB, *entity, *entityType)
+	if err != nil {
+		fmt.Printf("Failed to get entity permissions: %v\n", err)
+		os.Exit(1)
+	}
+	if len(entities) == 0 {
+		fmt.Printf("No permissions found for %s\n", *entity)
+		os.Exit(1)
+	}
+
+	err = writeInventoryOutput(entities, *outputFormat, *output, "Entity permissions", func(w io.Writer) error {
+		return inventory.WriteEntityText(w, entities)
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// Write the result of an inventory command as text, JSON or YAML, to stdout or a file
+func writeInventoryOutput(value interface{}, format, output, description string, writeText func(io.Writer) error) error {
+	var data []byte
+	var err error
+	switch format {
+	case "json":
+		data, err = json.MarshalIndent(value, "", "  ")
+		data = append(data, '\n')
+	case "yaml":
+		data, err = yaml.Marshal(value)
+	default:
+		var b bytes.Buffer
+		err = writeText(&b)
+		data = b.Bytes()
+	}
+	if err != nil {
+		return fmt.Errorf("error formatting %s: %v", strings.ToLower(description), err)
+	}
+
+	if output == "" {
+		os.Stdout.Write(data)
+		return nil
+	}
+	if err := os.WriteFile(output, data, 0644); err != nil {
+		return fmt.Errorf("error writing %s to %s: %v", strings.ToLower(description), output, err)
+	}
+	fmt.Printf("%s written to %s\n", description, output)
+	return nil
+}
@@ -14,6 +14,7 @@ CREATE TABLE IF NOT EXISTS rufus.permission (
     permission_binding_type VARCHAR(20) NOT NULL,
     last_used_time DATETIME NULL,
     last_used_resource VARCHAR(150) NULL,
+    managed BOOLEAN NOT NULL DEFAULT FALSE,
     UNIQUE KEY unique_permission (entity_name, entity_type, api_group, resource_type, verb, permission_scope, permission_source, permission_source_type, permission_binding, permission_binding_type)
 );
 
@@ -23,9 +24,104 @@ CREATE TABLE IF NOT EXISTS rufus.workload_identities (
     workload_type VARCHAR(30) NOT NULL,
     workload_name VARCHAR(100) NOT NULL,
     service_account_name VARCHAR(100) NOT NULL,
-    workload_identity VARCHAR(100) NOT NULL,
+    workload_identity VARCHAR(255) NOT NULL,
     original_owner_type VARCHAR(30) NOT NULL,
     original_owner_name VARCHAR(100) NOT NULL,
+    automount_token BOOLEAN NOT NULL DEFAULT TRUE,
+    privileged BOOLEAN NOT NULL DEFAULT FALSE,
+    host_path BOOLEAN NOT NULL DEFAULT FALSE,
+    host_network BOOLEAN NOT NULL DEFAULT FALSE,
+    host_pid BOOLEAN NOT NULL DEFAULT FALSE,
+    service_exposure VARCHAR(20) NOT NULL DEFAULT '',
     UNIQUE KEY unique_workload (workload_type, workload_name, service_account_name, original_owner_type, original_owner_name)
 );
 
+
+
+CREATE TABLE IF NOT EXISTS rufus.denied_requests (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    entity_name VARCHAR(100) NOT NULL,
+    entity_type VARCHAR(30) NOT NULL,
+    api_group VARCHAR(150) NOT NULL,
+    resource_type VARCHAR(100) NOT NULL,
+    verb VARCHAR(30) NOT NULL,
+    permission_scope VARCHAR(70) NOT NULL,
+    denied_count INT NOT NULL DEFAULT 1,
+    first_denied_time DATETIME NULL,
+    last_denied_time DATETIME NULL,
+    last_denied_resource VARCHAR(150) NULL,
+    UNIQUE KEY unique_denied_request (entity_name, entity_type, api_group, resource_type, verb, permission_scope)
+);
+
+
+CREATE TABLE IF NOT EXISTS rufus.unmatched_requests (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    entity_name VARCHAR(100) NOT NULL,
+    entity_type VARCHAR(30) NOT NULL,
+    api_group VARCHAR(150) NOT NULL,
+    resource_type VARCHAR(100) NOT NULL,
+    verb VARCHAR(30) NOT NULL,
+    permission_scope VARCHAR(70) NOT NULL,
+    observed_count INT NOT NULL DEFAULT 1,
+    first_observed_time DATETIME NULL,
+    last_observed_time DATETIME NULL,
+    last_observed_resource VARCHAR(150) NULL,
+    authorized_binding VARCHAR(150) NULL,
+    authorized_binding_type VARCHAR(30) NULL,
+    authorized_source VARCHAR(150) NULL,
+    authorized_source_type VARCHAR(30) NULL,
+    UNIQUE KEY unique_unmatched_request (entity_name, entity_type, api_group, resource_type, verb, permission_scope)
+);
+
+
+CREATE TABLE IF NOT EXISTS rufus.orphaned_rbac (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    finding_type VARCHAR(30) NOT NULL,
+    object_type VARCHAR(30) NOT NULL,
+    object_name VARCHAR(150) NOT NULL,
+    object_namespace VARCHAR(70) NOT NULL,
+    role_type VARCHAR(30) NOT NULL,
+    role_name VARCHAR(150) NOT NULL,
+    missing_type VARCHAR(30) NOT NULL,
+    missing_name VARCHAR(150) NOT NULL,
+    missing_namespace VARCHAR(70) NOT NULL
+);
+
+
+
+CREATE TABLE IF NOT EXISTS rufus.service_account_identities (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    service_account_name VARCHAR(100) NOT NULL,
+    identity_type VARCHAR(30) NOT NULL,
+    identity VARCHAR(255) NOT NULL,
+    UNIQUE KEY unique_identity (service_account_name, identity_type, identity)
+);
+
+
+
+CREATE TABLE IF NOT EXISTS rufus.binding_subjects (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    binding_type VARCHAR(20) NOT NULL,
+    binding_namespace VARCHAR(70) NOT NULL,
+    binding_name VARCHAR(100) NOT NULL,
+    subject_index INT NOT NULL,
+    subject_kind VARCHAR(30) NOT NULL,
+    subject_api_group VARCHAR(150) NOT NULL,
+    subject_name VARCHAR(150) NOT NULL,
+    subject_namespace VARCHAR(70) NOT NULL
+);
+
+
+CREATE TABLE IF NOT EXISTS rufus.default_cluster_roles (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(150) NOT NULL,
+    UNIQUE KEY unique_default_cluster_role (name)
+);
+
+
+CREATE TABLE IF NOT EXISTS rufus.service_account_token_secrets (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    service_account_name VARCHAR(100) NOT NULL,
+    secret_name VARCHAR(255) NOT NULL,
+    UNIQUE KEY unique_token_secret (service_account_name, secret_name)
+);
@@ -10,22 +10,16 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/containerservice/armcontainerservice/v6"
 	"gopkg.in/yaml.v3"
-	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
 
-func connectToAKS(cred *azidentity.ClientSecretCredential, clusterName, subscription, resourceGroup string) (client *kubernetes.Clientset, err error) {
+func connectToAKS(cred *azidentity.ClientSecretCredential, clusterName, subscription, resourceGroup string) (*rest.Config, error) {
 	// Try connecting using InClusterConfig
 	config, err := rest.InClusterConfig()
 	if err == nil {
-		clientset, err := kubernetes.NewForConfig(config)
-		if err == nil {
-			return clientset, nil
-		}
-		fmt.Printf("Failed to create Kubernetes client using InClusterConfig: %v\n", err)
-	} else {
-		fmt.Printf("No InCluster Config, Trying AKS Flow...\n")
+		return config, nil
 	}
+	fmt.Printf("No InCluster Config, Trying AKS Flow...\n")
 	// Revert to AKS flow dynamically creating kubeconfig
 	clientFactory, err := armcontainerservice.NewClientFactory(subscription, cred, nil)
 	if err != nil {
@@ -98,10 +92,6 @@ func connectToAKS(cred *azidentity.ClientSecretCredential, clusterName, subscrip
 		BearerToken: token.Token,
 	}
 
-	clientset, err := kubernetes.NewForConfig(restConfig)
-	if err != nil {
-		return nil, err
-	}
 	fmt.Printf("Connected to %+v successfully!\n", clusterName)
-	return clientset, nil
+	return restConfig, nil
 }
@@ -29,9 +29,82 @@ func DBConnect() (*sql.DB, error) {
 		return nil, err
 	}
 
+	err = migrateColumns(DB)
+	if err != nil {
+		fmt.Println("Error migrating database:", err)
+		return nil, err
+	}
+
 	return DB, err
 }
 
+// Columns added to the tables of create_tables.sql after their creation. CREATE TABLE IF NOT EXISTS leaves the tables
+// of an existing DB as they are, so missing columns are added on connection
+var addedColumns = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"permission", "managed", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"workload_identities", "automount_token", "BOOLEAN NOT NULL DEFAULT TRUE"},
+	{"workload_identities", "privileged", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"workload_identities", "host_path", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"workload_identities", "host_network", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"workload_identities", "host_pid", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"workload_identities", "service_exposure", "VARCHAR(20) NOT NULL DEFAULT ''"},
+}
+
+// Columns widened in create_tables.sql after their creation, modified on connection when shorter than length
+var widenedColumns = []struct {
+	table      string
+	column     string
+	length     int
+	definition string
+}{
+	{"workload_identities", "workload_identity", 255, "VARCHAR(255) NOT NULL"},
+}
+
+func migrateColumns(db *sql.DB) error {
+	for _, c := range addedColumns {
+		var count int
+		err := db.QueryRow(`
+			SELECT COUNT(*) FROM information_schema.COLUMNS
+			WHERE TABLE_SCHEMA = 'rufus' AND TABLE_NAME = ? AND COLUMN_NAME = ?
+		`, c.table, c.column).Scan(&count)
+		if err != nil {
+			return fmt.Errorf("failed to check column %s.%s: %v", c.table, c.column, err)
+		}
+		if count > 0 {
+			continue
+		}
+		_, err = db.Exec(fmt.Sprintf("ALTER TABLE rufus.%s ADD COLUMN %s %s", c.table, c.column, c.definition))
+		if err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %v", c.table, c.column, err)
+		}
+	}
+	for _, c := range widenedColumns {
+		var length int
+		err := db.QueryRow(`
+			SELECT CHARACTER_MAXIMUM_LENGTH FROM information_schema.COLUMNS
+			WHERE TABLE_SCHEMA = 'rufus' AND TABLE_NAME = ? AND COLUMN_NAME = ?
+		`, c.table, c.column).Scan(&length)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to check column %s.%s: %v", c.table, c.column, err)
+		}
+		if length >= c.length {
+			continue
+		}
+		_, err = db.Exec(fmt.Sprintf("ALTER TABLE rufus.%s MODIFY COLUMN %s %s", c.table, c.column, c.definition))
+		if err != nil {
+			return fmt.Errorf("failed to widen column %s.%s: %v", c.table, c.column, err)
+		}
+	}
+	return nil
+}
+
 func ClearDatabase(db *sql.DB) error {
 	tx, err := db.Begin()
 	if err != nil {
@@ -49,6 +122,41 @@ func ClearDatabase(db *sql.DB) error {
 		return fmt.Errorf("failed to clear table rufus.workload_identities: %v", err)
 	}
 
+	_, err = tx.Exec("DELETE FROM rufus.denied_requests")
+	if err != nil {
+		return fmt.Errorf("failed to clear table rufus.denied_requests: %v", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM rufus.unmatched_requests")
+	if err != nil {
+		return fmt.Errorf("failed to clear table rufus.unmatched_requests: %v", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM rufus.orphaned_rbac")
+	if err != nil {
+		return fmt.Errorf("failed to clear table rufus.orphaned_rbac: %v", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM rufus.service_account_identities")
+	if err != nil {
+		return fmt.Errorf("failed to clear table rufus.service_account_identities: %v", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM rufus.binding_subjects")
+	if err != nil {
+		return fmt.Errorf("failed to clear table rufus.binding_subjects: %v", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM rufus.default_cluster_roles")
+	if err != nil {
+		return fmt.Errorf("failed to clear table rufus.default_cluster_roles: %v", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM rufus.service_account_token_secrets")
+	if err != nil {
+		return fmt.Errorf("failed to clear table rufus.service_account_token_secrets: %v", err)
+	}
+
 	_, err = tx.Exec("ALTER TABLE rufus.permission AUTO_INCREMENT = 1")
 	if err != nil {
 		return fmt.Errorf("failed to reset AUTO_INCREMENT: %v", err)
@@ -59,6 +167,41 @@ func ClearDatabase(db *sql.DB) error {
 		return fmt.Errorf("failed to reset AUTO_INCREMENT: %v", err)
 	}
 
+	_, err = tx.Exec("ALTER TABLE rufus.denied_requests AUTO_INCREMENT = 1")
+	if err != nil {
+		return fmt.Errorf("failed to reset AUTO_INCREMENT: %v", err)
+	}
+
+	_, err = tx.Exec("ALTER TABLE rufus.unmatched_requests AUTO_INCREMENT = 1")
+	if err != nil {
+		return fmt.Errorf("failed to reset AUTO_INCREMENT: %v", err)
+	}
+
+	_, err = tx.Exec("ALTER TABLE rufus.orphaned_rbac AUTO_INCREMENT = 1")
+	if err != nil {
+		return fmt.Errorf("failed to reset AUTO_INCREMENT: %v", err)
+	}
+
+	_, err = tx.Exec("ALTER TABLE rufus.service_account_identities AUTO_INCREMENT = 1")
+	if err != nil {
+		return fmt.Errorf("failed to reset AUTO_INCREMENT: %v", err)
+	}
+
+	_, err = tx.Exec("ALTER TABLE rufus.binding_subjects AUTO_INCREMENT = 1")
+	if err != nil {
+		return fmt.Errorf("failed to reset AUTO_INCREMENT: %v", err)
+	}
+
+	_, err = tx.Exec("ALTER TABLE rufus.default_cluster_roles AUTO_INCREMENT = 1")
+	if err != nil {
+		return fmt.Errorf("failed to reset AUTO_INCREMENT: %v", err)
+	}
+
+	_, err = tx.Exec("ALTER TABLE rufus.service_account_token_secrets AUTO_INCREMENT = 1")
+	if err != nil {
+		return fmt.Errorf("failed to reset AUTO_INCREMENT: %v", err)
+	}
+
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit transaction: %v", err)
 	}
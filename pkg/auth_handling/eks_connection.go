@@ -8,23 +8,17 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/eks"
-	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"sigs.k8s.io/aws-iam-authenticator/pkg/token"
 )
 
-func connectToEKS(sess *session.Session, clusterName string) (client *kubernetes.Clientset, err error) {
+func connectToEKS(sess *session.Session, clusterName string) (*rest.Config, error) {
 	// Try connecting using InClusterConfig
 	config, err := rest.InClusterConfig()
 	if err == nil {
-		clientset, err := kubernetes.NewForConfig(config)
-		if err == nil {
-			return clientset, nil
-		}
-		fmt.Printf("Failed to create Kubernetes client using InClusterConfig: %v\n", err)
-	} else {
-		fmt.Printf("No InCluster Config, Trying EKS Flow...\n")
+		return config, nil
 	}
+	fmt.Printf("No InCluster Config, Trying EKS Flow...\n")
 	// Revert to EKS flow dynamically creating kubeconfig
 	eksSvc := eks.New(sess)
 	input := &eks.DescribeClusterInput{
@@ -50,16 +44,12 @@ func connectToEKS(sess *session.Session, clusterName string) (client *kubernetes
 	if err != nil {
 		return nil, err
 	}
-	clientset, err := kubernetes.NewForConfig(&rest.Config{
+	fmt.Printf("Connected to %+v successfully!\n", clusterName)
+	return &rest.Config{
 		Host:        aws.StringValue(result.Cluster.Endpoint),
 		BearerToken: token.Token,
 		TLSClientConfig: rest.TLSClientConfig{
 			CAData: ca,
 		},
-	})
-	if err != nil {
-		return nil, err
-	}
-	fmt.Printf("Connected to %+v successfully!\n", clusterName)
-	return clientset, nil
+	}, nil
 }
@@ -4,9 +4,10 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 )
 
-// Establish command line flows and help for aws, azure, gcp and local. Returns the credential information
+// Establish command line flows and help for aws, azure, gcp, local, offline and snapshot import. Returns the credential information
 
 type CredentialsPath struct {
 	FilePath         string
@@ -16,6 +17,79 @@ type CredentialsPath struct {
 	ClientSecret     string
 	CollectWorkloads bool
 	ShouldAdvise     bool
+	RecordDenied     bool
+	IdentityRules    string
+	WorkloadKinds    string
+	RiskRules        string
+	Suppressions     string
+	IncludeManaged   bool
+	OutputFormat     string
+	OutputPath       string
+	FailOn           string
+	Baseline         string
+	DeniedThreshold  int
+	EscalationSteps  int
+	Manifests        []string // Offline collection inputs
+	HelmChart        string
+	HelmValues       []string
+	HelmRelease      string
+	Namespace        string
+	Discovery        string
+	ClusterType      string
+	Snapshot         string
+}
+
+// Flags shared by all the provider commands
+type commonFlags struct {
+	collectWorkloads *bool
+	advise           *bool
+	recordDenied     *bool
+	identityRules    *string
+	workloadKinds    *string
+	riskRules        *string
+	suppressions     *string
+	includeManaged   *bool
+	outputFormat     *string
+	output           *string
+	failOn           *string
+	baseline         *string
+	deniedThreshold  *int
+	escalationSteps  *int
+}
+
+func addCommonFlags(cmd *flag.FlagSet) *commonFlags {
+	return &commonFlags{
+		collectWorkloads: cmd.Bool("collect-workloads", false, "[OPTIONAL] Collect workload information"),
+		advise:           cmd.Bool("advise", false, "[OPTIONAL] Run analysis and provide recommendations"),
+		recordDenied:     cmd.Bool("record-denied", false, "[OPTIONAL] Also ingest denied (403) requests as attempted-access signals"),
+		identityRules:    cmd.String("identity-rules", "", "[OPTIONAL] Path to a YAML file with username and group normalization rules"),
+		workloadKinds:    cmd.String("workload-kinds", "", "[OPTIONAL] Path to a YAML file with the pod template paths of additional custom resource workload kinds for --collect-workloads"),
+		riskRules:        cmd.String("risk-rules", "", "[OPTIONAL] Path to a YAML file with additional risk rules for --advise"),
+		suppressions:     cmd.String("suppressions", "", "[OPTIONAL] Path to a YAML file with accepted risks to suppress in the --advise report"),
+		includeManaged:   cmd.Bool("include-managed", false, "[OPTIONAL] Include Kubernetes system and managed-provider identities and bindings in the --advise recommendations"),
+		outputFormat:     cmd.String("output-format", "json", "[OPTIONAL] Format of the --advise report - json, sarif, csv, markdown or html"),
+		output:           cmd.String("output", "", "[OPTIONAL] Path of the --advise report (default kiempossible_report_YYYYMMDD.<format>)"),
+		failOn:           cmd.String("fail-on", "", "[OPTIONAL] Exit with code 2 when --advise finds results matching any of these comma separated conditions - a severity (critical, high, medium, low), a rule id, any, or new (requires --baseline)"),
+		baseline:         cmd.String("baseline", "", "[OPTIONAL] Path to a previous JSON report, for --fail-on new"),
+		deniedThreshold:  cmd.Int("denied-threshold", 3, "[OPTIONAL] Number of denied requests on a sensitive resource from which --advise reports an entity"),
+		escalationSteps:  cmd.Int("escalation-max-steps", 4, "[OPTIONAL] Maximum number of steps of the privilege escalation paths reported by --advise"),
+	}
+}
+
+func (f *commonFlags) apply(credentialsPath *CredentialsPath) {
+	credentialsPath.ShouldAdvise = *f.advise
+	credentialsPath.RecordDenied = *f.recordDenied
+	credentialsPath.IdentityRules = *f.identityRules
+	credentialsPath.WorkloadKinds = *f.workloadKinds
+	credentialsPath.RiskRules = *f.riskRules
+	credentialsPath.Suppressions = *f.suppressions
+	credentialsPath.IncludeManaged = *f.includeManaged
+	credentialsPath.OutputFormat = *f.outputFormat
+	credentialsPath.OutputPath = *f.output
+	credentialsPath.FailOn = *f.failOn
+	credentialsPath.Baseline = *f.baseline
+	credentialsPath.DeniedThreshold = *f.deniedThreshold
+	credentialsPath.EscalationSteps = *f.escalationSteps
 }
 
 type ClusterInfo struct {
@@ -47,24 +121,65 @@ func AcceptCredentials(awsClusterName, azureTenantID, azureClientID, azureClient
 	return CredentialsPath{}, ClusterInfo{}, fmt.Errorf("no valid credentials provided")
 }
 
+// Split a comma separated flag value
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func acceptOfflineInputs(manifests, helmChart, helmValues, helmRelease, namespace, discovery, clusterType, logFile string, collectWorkloads bool) (CredentialsPath, error) {
+	if manifests == "" && helmChart == "" {
+		return CredentialsPath{}, fmt.Errorf("offline requires --manifests or --helm-chart")
+	}
+	clusterType = strings.ToUpper(clusterType)
+	if clusterType != "EKS" && clusterType != "AKS" && clusterType != "GKE" && clusterType != "LOCAL" {
+		return CredentialsPath{}, fmt.Errorf("unsupported cluster type: %s (EKS, AKS, GKE or LOCAL)", clusterType)
+	}
+	return CredentialsPath{
+		LogFile:          logFile,
+		CollectWorkloads: collectWorkloads,
+		Manifests:        splitList(manifests),
+		HelmChart:        helmChart,
+		HelmValues:       splitList(helmValues),
+		HelmRelease:      helmRelease,
+		Namespace:        namespace,
+		Discovery:        discovery,
+		ClusterType:      clusterType,
+	}, nil
+}
+
+func acceptSnapshotInputs(snapshot, clusterType, logFile string, collectWorkloads bool) (CredentialsPath, error) {
+	if snapshot == "" {
+		return CredentialsPath{}, fmt.Errorf("snapshot import requires a snapshot file")
+	}
+	clusterType = strings.ToUpper(clusterType)
+	if clusterType != "" && clusterType != "EKS" && clusterType != "AKS" && clusterType != "GKE" && clusterType != "LOCAL" {
+		return CredentialsPath{}, fmt.Errorf("unsupported cluster type: %s (EKS, AKS, GKE or LOCAL)", clusterType)
+	}
+	return CredentialsPath{Snapshot: snapshot, ClusterType: clusterType, LogFile: logFile, CollectWorkloads: collectWorkloads}, nil
+}
+
 func Authenticator() (CredentialsPath, ClusterInfo, string) {
 	var cmd = flag.NewFlagSet("auth", flag.ExitOnError)
 	var awsCmd = flag.NewFlagSet("aws", flag.ExitOnError)
 	var azureCmd = flag.NewFlagSet("azure", flag.ExitOnError)
 	var gcpCmd = flag.NewFlagSet("gcp", flag.ExitOnError)
 	var localCmd = flag.NewFlagSet("local", flag.ExitOnError)
+	var offlineCmd = flag.NewFlagSet("offline", flag.ExitOnError)
+	var snapshotCmd = flag.NewFlagSet("snapshot import", flag.ExitOnError)
 
-	// Add collect-workloads flag to all subcommands
-	awsCollectWorkloads := awsCmd.Bool("collect-workloads", false, "[OPTIONAL] Collect workload information")
-	azureCollectWorkloads := azureCmd.Bool("collect-workloads", false, "[OPTIONAL] Collect workload information")
-	gcpCollectWorkloads := gcpCmd.Bool("collect-workloads", false, "[OPTIONAL] Collect workload information")
-	localCollectWorkloads := localCmd.Bool("collect-workloads", false, "[OPTIONAL] Collect workload information")
-
-	// Add advise flag to all subcommands
-	awsAdvise := awsCmd.Bool("advise", false, "[OPTIONAL] Run analysis and provide recommendations")
-	azureAdvise := azureCmd.Bool("advise", false, "[OPTIONAL] Run analysis and provide recommendations")
-	gcpAdvise := gcpCmd.Bool("advise", false, "[OPTIONAL] Run analysis and provide recommendations")
-	localAdvise := localCmd.Bool("advise", false, "[OPTIONAL] Run analysis and provide recommendations")
+	// Add the shared flags (collect-workloads, advise...) to all subcommands
+	awsFlags := addCommonFlags(awsCmd)
+	azureFlags := addCommonFlags(azureCmd)
+	gcpFlags := addCommonFlags(gcpCmd)
+	localFlags := addCommonFlags(localCmd)
+	offlineFlags := addCommonFlags(offlineCmd)
+	snapshotFlags := addCommonFlags(snapshotCmd)
 
 	awsClusterName := awsCmd.String("cluster-name", "", "AWS cluster name")
 
@@ -83,6 +198,19 @@ func Authenticator() (CredentialsPath, ClusterInfo, string) {
 
 	logFile := localCmd.String("log-file", "", "Path to log file")
 
+	offlineManifests := offlineCmd.String("manifests", "", "Comma separated YAML/JSON files or directories with manifests or kubectl get -o yaml dumps")
+	offlineHelmChart := offlineCmd.String("helm-chart", "", "[OPTIONAL] Helm chart to render with helm template and analyze")
+	offlineHelmValues := offlineCmd.String("helm-values", "", "[OPTIONAL] Comma separated values files for --helm-chart")
+	offlineHelmRelease := offlineCmd.String("helm-release", "release", "[OPTIONAL] Release name for --helm-chart")
+	offlineNamespace := offlineCmd.String("namespace", "default", "[OPTIONAL] Namespace of the namespaced objects without one")
+	offlineDiscovery := offlineCmd.String("discovery", "", "[OPTIONAL] Discovery snapshot written by export-discovery (default the built-in Kubernetes resources)")
+	offlineClusterType := offlineCmd.String("cluster-type", "LOCAL", "[OPTIONAL] Type of the target cluster, for managed identities - EKS, AKS, GKE or LOCAL")
+	offlineLogFile := offlineCmd.String("log-file", "", "[OPTIONAL] Path to an audit log file with the usage of the permissions")
+
+	snapshotFile := snapshotCmd.String("file", "", "Snapshot written by snapshot export (or pass it as the first argument)")
+	snapshotClusterType := snapshotCmd.String("cluster-type", "", "[OPTIONAL] Override the cluster type recorded in the snapshot - EKS, AKS, GKE or LOCAL")
+	snapshotLogFile := snapshotCmd.String("log-file", "", "[OPTIONAL] Path to an audit log file with the usage of the permissions")
+
 	var args []string
 	if len(os.Args) > 1 {
 		args = os.Args[1:]
@@ -95,6 +223,15 @@ func Authenticator() (CredentialsPath, ClusterInfo, string) {
 		fmt.Fprintf(os.Stderr, "  azure\tUse for AKS Clusters\n")
 		fmt.Fprintf(os.Stderr, "  gcp\tUse for GKE Clusters\n")
 		fmt.Fprintf(os.Stderr, "  local\tUse local log file\n")
+		fmt.Fprintf(os.Stderr, "  simulate\tReplay the observed usage against proposed RBAC changes\n")
+		fmt.Fprintf(os.Stderr, "  verify\tCheck a sample of the collected permissions against the cluster's authorizer\n")
+		fmt.Fprintf(os.Stderr, "  offline\tAnalyze RBAC from manifests, Helm charts or cluster dumps, without a cluster\n")
+		fmt.Fprintf(os.Stderr, "  export-discovery\tExport the resources of the kubeconfig cluster for offline analysis\n")
+		fmt.Fprintf(os.Stderr, "  snapshot export\tExport the RBAC, workloads and discovery of the kubeconfig cluster to an archive\n")
+		fmt.Fprintf(os.Stderr, "  snapshot import\tLoad a snapshot archive into the DB and analyze it, without a cluster\n")
+		fmt.Fprintf(os.Stderr, "  generate-roles\tGenerate least-privilege roles from observed usage\n")
+		fmt.Fprintf(os.Stderr, "  whois\tShow the effective permissions of an identity and their usage (alias entity)\n")
+		fmt.Fprintf(os.Stderr, "  who-can\tShow the identities which can perform a verb on a resource\n")
 		fmt.Fprintf(os.Stderr, "\n")
 		fmt.Fprintf(os.Stderr, "Use '%s [command] -help' for command-specific help.\n", os.Args[0])
 	}
@@ -113,6 +250,20 @@ func Authenticator() (CredentialsPath, ClusterInfo, string) {
 		gcpCmd.Parse(args[1:])
 	case "local":
 		localCmd.Parse(args[1:])
+	case "offline":
+		offlineCmd.Parse(args[1:])
+	case "snapshot":
+		// snapshot export is handled before authentication, it only needs the kubeconfig
+		if len(args) < 2 || args[1] != "import" {
+			fmt.Fprintf(os.Stderr, "Usage: %s snapshot export|import [options]\n", os.Args[0])
+			os.Exit(1)
+		}
+		snapshotCmd.Parse(args[2:])
+		// The snapshot can be passed first, with the options after it
+		if *snapshotFile == "" && snapshotCmd.NArg() > 0 {
+			*snapshotFile = snapshotCmd.Arg(0)
+			snapshotCmd.Parse(snapshotCmd.Args()[1:])
+		}
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", args[0])
 		cmd.Usage()
@@ -127,20 +278,28 @@ func Authenticator() (CredentialsPath, ClusterInfo, string) {
 	switch args[0] {
 	case "aws":
 		cloudProvider = "aws"
-		credentialsPath, clusterInfo, err = AcceptCredentials(*awsClusterName, "", "", "", "", "", "", "", "", "", "", "", "", *awsCollectWorkloads)
-		credentialsPath.ShouldAdvise = *awsAdvise
+		credentialsPath, clusterInfo, err = AcceptCredentials(*awsClusterName, "", "", "", "", "", "", "", "", "", "", "", "", *awsFlags.collectWorkloads)
+		awsFlags.apply(&credentialsPath)
 	case "azure":
 		cloudProvider = "azure"
-		credentialsPath, clusterInfo, err = AcceptCredentials("", *azureTenantID, *azureClientID, *azureClientSecret, *azureClusterName, *azureWorkspaceID, *azureSubscriptionID, *azureResourceGroup, "", "", "", "", "", *azureCollectWorkloads)
-		credentialsPath.ShouldAdvise = *azureAdvise
+		credentialsPath, clusterInfo, err = AcceptCredentials("", *azureTenantID, *azureClientID, *azureClientSecret, *azureClusterName, *azureWorkspaceID, *azureSubscriptionID, *azureResourceGroup, "", "", "", "", "", *azureFlags.collectWorkloads)
+		azureFlags.apply(&credentialsPath)
 	case "gcp":
 		cloudProvider = "gcp"
-		credentialsPath, clusterInfo, err = AcceptCredentials("", "", "", "", "", "", "", "", *gcpCredentialsFile, *gcpClusterName, *gcpProjectID, *gcpRegion, "", *gcpCollectWorkloads)
-		credentialsPath.ShouldAdvise = *gcpAdvise
+		credentialsPath, clusterInfo, err = AcceptCredentials("", "", "", "", "", "", "", "", *gcpCredentialsFile, *gcpClusterName, *gcpProjectID, *gcpRegion, "", *gcpFlags.collectWorkloads)
+		gcpFlags.apply(&credentialsPath)
 	case "local":
 		cloudProvider = "local"
-		credentialsPath, clusterInfo, err = AcceptCredentials("", "", "", "", "", "", "", "", "", "", "", "", *logFile, *localCollectWorkloads)
-		credentialsPath.ShouldAdvise = *localAdvise
+		credentialsPath, clusterInfo, err = AcceptCredentials("", "", "", "", "", "", "", "", "", "", "", "", *logFile, *localFlags.collectWorkloads)
+		localFlags.apply(&credentialsPath)
+	case "offline":
+		cloudProvider = "offline"
+		credentialsPath, err = acceptOfflineInputs(*offlineManifests, *offlineHelmChart, *offlineHelmValues, *offlineHelmRelease, *offlineNamespace, *offlineDiscovery, *offlineClusterType, *offlineLogFile, *offlineFlags.collectWorkloads)
+		offlineFlags.apply(&credentialsPath)
+	case "snapshot":
+		cloudProvider = "snapshot"
+		credentialsPath, err = acceptSnapshotInputs(*snapshotFile, *snapshotClusterType, *snapshotLogFile, *snapshotFlags.collectWorkloads)
+		snapshotFlags.apply(&credentialsPath)
 	default:
 		fmt.Println("Error: Invalid cloud provider")
 		os.Exit(1)
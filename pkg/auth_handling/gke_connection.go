@@ -14,22 +14,16 @@ import (
 	"golang.org/x/oauth2/jwt"
 	container2 "google.golang.org/api/container/v1"
 	"google.golang.org/api/option"
-	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
 
-func connectToGKE(cred *google.Credentials, clusterName, region, projectID string, cred_file CredentialsPath) (client *kubernetes.Clientset, err error) {
+func connectToGKE(cred *google.Credentials, clusterName, region, projectID string, cred_file CredentialsPath) (*rest.Config, error) {
 	// Try connecting using InClusterConfig
 	config, err := rest.InClusterConfig()
 	if err == nil {
-		clientset, err := kubernetes.NewForConfig(config)
-		if err == nil {
-			return clientset, nil
-		}
-		fmt.Printf("Failed to create Kubernetes client using InClusterConfig: %v\n", err)
-	} else {
-		fmt.Printf("No InCluster Config, Trying GKE Flow...\n")
+		return config, nil
 	}
+	fmt.Printf("No InCluster Config, Trying GKE Flow...\n")
 	// Revert to GKE flow using multiple APIs to get all the necessary info
 	containerClient, err := container.NewClusterManagerClient(context.Background(), option.WithCredentials(cred))
 	if err != nil {
@@ -53,18 +47,14 @@ func connectToGKE(cred *google.Credentials, clusterName, region, projectID strin
 		return nil, err
 	}
 
-	clientset, err := kubernetes.NewForConfig(&rest.Config{
+	fmt.Printf("Connected to %+v successfully!\n", clusterName)
+	return &rest.Config{
 		Host:        cluster.Endpoint,
 		BearerToken: token.AccessToken,
 		TLSClientConfig: rest.TLSClientConfig{
 			CAData: ca,
 		},
-	})
-	if err != nil {
-		return nil, err
-	}
-	fmt.Printf("Connected to %+v successfully!\n", clusterName)
-	return clientset, nil
+	}, nil
 }
 
 type sa struct {
@@ -15,6 +15,15 @@ import (
 
 // Select authflow based on cluster type
 func KubeConnect(clusterName string, clusterType string, aws_sess *session.Session, azure_cred *azidentity.ClientSecretCredential, Sub, RG string, gcp_cred *google.Credentials, region, projectID string, cred_file CredentialsPath) (client *kubernetes.Clientset, err error) {
+	config, err := KubeConfig(clusterName, clusterType, aws_sess, azure_cred, Sub, RG, gcp_cred, region, projectID, cred_file)
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+// Get the REST config of the cluster, for clients other than the clientset (e.g. the dynamic client)
+func KubeConfig(clusterName string, clusterType string, aws_sess *session.Session, azure_cred *azidentity.ClientSecretCredential, Sub, RG string, gcp_cred *google.Credentials, region, projectID string, cred_file CredentialsPath) (*rest.Config, error) {
 	switch clusterType {
 	case "EKS":
 		fmt.Printf("\x1b[1;31m\x1b[38;5;208m-------\nEKS Mode\n-------\x1b[0m\n")
@@ -34,18 +43,13 @@ func KubeConnect(clusterName string, clusterType string, aws_sess *session.Sessi
 	}
 }
 
-func connectToLocal() (client *kubernetes.Clientset, err error) {
+func connectToLocal() (*rest.Config, error) {
 	// Try connecting using InClusterConfig
 	config, err := rest.InClusterConfig()
 	if err == nil {
-		clientset, err := kubernetes.NewForConfig(config)
-		if err == nil {
-			return clientset, nil
-		}
-		fmt.Printf("Failed to create Kubernetes client using InClusterConfig: %v\n", err)
-	} else {
-		fmt.Printf("No InCluster Config, Trying with KubeConfig...\n")
+		return config, nil
 	}
+	fmt.Printf("No InCluster Config, Trying with KubeConfig...\n")
 	// Revert to using kubeconfig
 	userHomeDir, err := os.UserHomeDir()
 	if err != nil {
@@ -61,11 +65,6 @@ func connectToLocal() (client *kubernetes.Clientset, err error) {
 		os.Exit(1)
 	}
 
-	clientset, err := kubernetes.NewForConfig(kubeConfig)
-	if err != nil {
-		return nil, err
-	}
-
 	fmt.Printf("Connected to Cluster successfully!\n")
-	return clientset, nil
+	return kubeConfig, nil
 }
@@ -0,0 +1,248 @@
+package inventory
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Effective permissions of an identity, grouped by source and binding, with their observed usage
+
+type EffectivePermission struct {
+	APIGroup         string `json:"api_group"`
+	ResourceType     string `json:"resource_type"`
+	Verb             string `json:"verb"`
+	Scope            string `json:"scope"`
+	Used             bool   `json:"used"`
+	LastUsedTime     string `json:"last_used_time,omitempty"`
+	LastUsedResource string `json:"last_used_resource,omitempty"`
+}
+
+// Permissions granted through a single source (Role, ClusterRole, Group, EKS Access Policy...) and binding
+type PermissionSource struct {
+	Source      string                `json:"source"`
+	SourceType  string                `json:"source_type"`
+	Binding     string                `json:"binding"`
+	BindingType string                `json:"binding_type"`
+	UsedCount   int                   `json:"used_count"`
+	Permissions []EffectivePermission `json:"permissions"`
+}
+
+type EntityWorkload struct {
+	WorkloadType      string `json:"workload_type"`
+	WorkloadName      string `json:"workload_name"`
+	WorkloadIdentity  string `json:"workload_identity,omitempty"`
+	OriginalOwnerType string `json:"original_owner_type"`
+	OriginalOwnerName string `json:"original_owner_name"`
+}
+
+// A cloud identity the pods of a ServiceAccount can assume
+type CloudIdentity struct {
+	IdentityType string `json:"identity_type"`
+	Identity     string `json:"identity"`
+}
+
+type EntityPermissions struct {
+	EntityName      string             `json:"entity_name"`
+	EntityType      string             `json:"entity_type"`
+	Managed         bool               `json:"managed"`
+	PermissionCount int                `json:"permission_count"`
+	UsedCount       int                `json:"used_count"`
+	Sources         []PermissionSource `json:"sources"`
+	Workloads       []EntityWorkload   `json:"workloads,omitempty"`        // Workloads running with the ServiceAccount
+	CloudIdentities []CloudIdentity    `json:"cloud_identities,omitempty"` // Cloud identities of the ServiceAccount
+}
+
+// Get the effective permissions of every entity with the name (and type, if set)
+func GetEntityPermissions(db *sql.DB, entityName, entityType string) ([]EntityPermissions, error) {
+	query := `
+		SELECT entity_name, entity_type, api_group, resource_type, verb, permission_scope,
+		       permission_source, permission_source_type, permission_binding, permission_binding_type,
+		       last_used_time, last_used_resource, managed
+		FROM permission
+		WHERE entity_name = ?`
+	args := []interface{}{entityName}
+	if entityType != "" {
+		query += " AND entity_type = ?"
+		args = append(args, entityType)
+	}
+	query += `
+		ORDER BY entity_type, permission_source_type, permission_source, permission_binding_type, permission_binding,
+		         api_group, resource_type, verb, permission_scope`
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query entity permissions: %v", err)
+	}
+	defer rows.Close()
+
+	type sourceKey struct{ source, sourceType, binding, bindingType string }
+	var entities []EntityPermissions
+	entityIndex := make(map[string]int)
+	sourceIndex := make(map[string]map[sourceKey]int)
+	for rows.Next() {
+		var name, typ, source, sourceType, binding, bindingType string
+		var p EffectivePermission
+		var lastUsed, lastUsedResource sql.NullString
+		var managed bool
+		if err := rows.Scan(&name, &typ, &p.APIGroup, &p.ResourceType, &p.Verb, &p.Scope,
+			&source, &sourceType, &binding, &bindingType, &lastUsed, &lastUsedResource, &managed); err != nil {
+			return nil, fmt.Errorf("failed to scan entity permission: %v", err)
+		}
+		p.Used = lastUsed.Valid
+		p.LastUsedTime = lastUsed.String
+		p.LastUsedResource = lastUsedResource.String
+
+		i, ok := entityIndex[typ]
+		if !ok {
+			i = len(entities)
+			entityIndex[typ] = i
+			sourceIndex[typ] = make(map[sourceKey]int)
+			entities = append(entities, EntityPermissions{EntityName: name, EntityType: typ})
+		}
+		entity := &entities[i]
+		entity.Managed = entity.Managed || managed
+
+		key := sourceKey{source, sourceType, binding, bindingType}
+		j, ok := sourceIndex[typ][key]
+		if !ok {
+			j = len(entity.Sources)
+			sourceIndex[typ][key] = j
+			entity.Sources = append(entity.Sources, PermissionSource{Source: source, SourceType: sourceType, Binding: binding, BindingType: bindingType})
+		}
+		entity.Sources[j].Permissions = append(entity.Sources[j].Permissions, p)
+		entity.PermissionCount++
+		if p.Used {
+			entity.Sources[j].UsedCount++
+			entity.UsedCount++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read entity permissions: %v", err)
+	}
+
+	for i := range entities {
+		if entities[i].EntityType != "ServiceAccount" {
+			continue
+		}
+		workloads, err := serviceAccountWorkloads(db, entities[i].EntityName)
+		if err != nil {
+			return nil, err
+		}
+		entities[i].Workloads = workloads
+		cloudIdentities, err := serviceAccountCloudIdentities(db, entities[i].EntityName)
+		if err != nil {
+			return nil, err
+		}
+		entities[i].CloudIdentities = cloudIdentities
+	}
+	return entities, nil
+}
+
+// Get the cloud identities of a ServiceAccount (namespace:name)
+func serviceAccountCloudIdentities(db *sql.DB, serviceAccount string) ([]CloudIdentity, error) {
+	rows, err := db.Query(`
+		SELECT identity_type, identity
+		FROM service_account_identities
+		WHERE service_account_name = ?
+		ORDER BY identity_type, identity
+	`, serviceAccount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query service account identities: %v", err)
+	}
+	defer rows.Close()
+
+	var identities []CloudIdentity
+	for rows.Next() {
+		var identity CloudIdentity
+		if err := rows.Scan(&identity.IdentityType, &identity.Identity); err != nil {
+			return nil, fmt.Errorf("failed to scan service account identity: %v", err)
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+// Get the workloads running with a ServiceAccount (namespace:name)
+func serviceAccountWorkloads(db *sql.DB, serviceAccount string) ([]EntityWorkload, error) {
+	rows, err := db.Query(`
+		SELECT workload_type, workload_name, workload_identity, original_owner_type, original_owner_name
+		FROM workload_identities
+		WHERE service_account_name = ?
+		ORDER BY workload_type, workload_name
+	`, serviceAccount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query workload identities: %v", err)
+	}
+	defer rows.Close()
+
+	var workloads []EntityWorkload
+	for rows.Next() {
+		var w EntityWorkload
+		if err := rows.Scan(&w.WorkloadType, &w.WorkloadName, &w.WorkloadIdentity, &w.OriginalOwnerType, &w.OriginalOwnerName); err != nil {
+			return nil, fmt.Errorf("failed to scan workload identity: %v", err)
+		}
+		workloads = append(workloads, w)
+	}
+	return workloads, rows.Err()
+}
+
+// Write the effective permissions as human readable text
+func WriteEntityText(w io.Writer, entities []EntityPermissions) error {
+	var b strings.Builder
+	for i, entity := range entities {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "%s %s", entity.EntityType, entity.EntityName)
+		if entity.Managed {
+			b.WriteString(" (managed)")
+		}
+		fmt.Fprintf(&b, "\n%d permissions, %d used in the observed period\n", entity.PermissionCount, entity.UsedCount)
+
+		for _, source := range entity.Sources {
+			fmt.Fprintf(&b, "\n  %s %s via %s %s (%d/%d used)\n", source.SourceType, source.Source, source.BindingType, source.Binding, source.UsedCount, len(source.Permissions))
+			permissions := append([]EffectivePermission{}, source.Permissions...)
+			sort.SliceStable(permissions, func(i, j int) bool {
+				return permissions[i].Used && !permissions[j].Used
+			})
+			for _, p := range permissions {
+				status := "unused"
+				if p.Used {
+					status = "USED  "
+				}
+				fmt.Fprintf(&b, "    %s %s %s (%s) in %s", status, p.Verb, p.ResourceType, p.APIGroup, p.Scope)
+				if p.Used {
+					fmt.Fprintf(&b, " - last used %s", p.LastUsedTime)
+					if p.LastUsedResource != "" {
+						fmt.Fprintf(&b, " on %s", p.LastUsedResource)
+					}
+				}
+				b.WriteString("\n")
+			}
+		}
+
+		if entity.EntityType == "ServiceAccount" {
+			if len(entity.CloudIdentities) > 0 {
+				b.WriteString("\n  Cloud identities:\n")
+				for _, identity := range entity.CloudIdentities {
+					fmt.Fprintf(&b, "    %s %s\n", identity.IdentityType, identity.Identity)
+				}
+			}
+			b.WriteString("\n  Workloads:\n")
+			if len(entity.Workloads) == 0 {
+				b.WriteString("    none collected (requires a collection with --collect-workloads)\n")
+			}
+			for _, workload := range entity.Workloads {
+				fmt.Fprintf(&b, "    %s %s (owner %s %s)", workload.WorkloadType, workload.WorkloadName, workload.OriginalOwnerType, workload.OriginalOwnerName)
+				if workload.WorkloadIdentity != "" {
+					fmt.Fprintf(&b, " - cloud identity %s", workload.WorkloadIdentity)
+				}
+				b.WriteString("\n")
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,173 @@
+package inventory
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Reverse queries - which identities can perform a verb on a resource, from the collected permissions
+// Covers every source stored in the permission table, including group-inherited and EKS access policy grants
+
+// Values support globs, and empty values match anything
+type WhoCanQuery struct {
+	Verb      string
+	Resource  string // Resource or resource/subresource
+	APIGroup  string // API group without the version, "" matches any group
+	Namespace string // Empty for any namespace (and cluster-wide)
+	Name      string // Resource name, empty for grants on any name (grants restricted to names require a matching Name)
+}
+
+// Identities granted the permission through a single source and binding
+type WhoCanAnswer struct {
+	EntityName       string   `json:"entity_name"`
+	EntityType       string   `json:"entity_type"`
+	Verb             string   `json:"verb"`
+	ResourceType     string   `json:"resource_type"`
+	APIGroup         string   `json:"api_group"`
+	Scopes           []string `json:"scopes"`
+	Source           string   `json:"source"`
+	SourceType       string   `json:"source_type"`
+	Binding          string   `json:"binding"`
+	BindingType      string   `json:"binding_type"`
+	Managed          bool     `json:"managed"`
+	LastUsedTime     string   `json:"last_used_time,omitempty"` // Latest usage of the permission by the identity, in any matching scope
+	LastUsedResource string   `json:"last_used_resource,omitempty"`
+}
+
+func globMatch(pattern, value string) bool {
+	if pattern == "" || pattern == "*" || pattern == value {
+		return true
+	}
+	ok, err := path.Match(pattern, value)
+	return err == nil && ok
+}
+
+// Whether a permission_scope value (cluster-wide, ns, ns/name or cluster-wide/name) grants access in the namespace and name
+// Without a name, the question is about any name, which scopes restricted to a name don't grant
+func scopeMatches(scope, namespace, name string) bool {
+	scopeNamespace, scopeName, named := strings.Cut(scope, "/")
+	if scopeNamespace != "cluster-wide" && namespace != "" && !globMatch(namespace, scopeNamespace) {
+		return false
+	}
+	if named && (name == "" || !globMatch(name, scopeName)) {
+		return false
+	}
+	return true
+}
+
+// API group without the version, from the api_group column
+func apiGroupName(apiGroup string) string {
+	if idx := strings.LastIndex(apiGroup, "/"); idx != -1 {
+		return apiGroup[:idx]
+	}
+	return ""
+}
+
+// Get the identities which can perform the query, one answer per identity, permission, source and binding
+func WhoCan(db *sql.DB, query WhoCanQuery) ([]WhoCanAnswer, error) {
+	var clauses []string
+	var args []interface{}
+	for column, value := range map[string]string{"verb": query.Verb, "resource_type": query.Resource} {
+		if value != "" && !strings.ContainsAny(value, "*?[") {
+			clauses = append(clauses, column+" = ?")
+			args = append(args, value)
+		}
+	}
+	filter := "1 = 1"
+	if len(clauses) > 0 {
+		filter = strings.Join(clauses, " AND ")
+	}
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT entity_name, entity_type, api_group, resource_type, verb, permission_scope,
+		       permission_source, permission_source_type, permission_binding, permission_binding_type,
+		       last_used_time, last_used_resource, managed
+		FROM permission
+		WHERE %s
+		ORDER BY entity_type, entity_name, permission_binding_type, permission_binding, resource_type, verb, permission_scope
+	`, filter), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query permissions: %v", err)
+	}
+	defer rows.Close()
+
+	type answerKey struct {
+		entityName, entityType, verb, resourceType, apiGroup, source, sourceType, binding, bindingType string
+	}
+	var answers []WhoCanAnswer
+	index := make(map[answerKey]int)
+	for rows.Next() {
+		var a WhoCanAnswer
+		var scope string
+		var lastUsed, lastUsedResource sql.NullString
+		if err := rows.Scan(&a.EntityName, &a.EntityType, &a.APIGroup, &a.ResourceType, &a.Verb, &scope,
+			&a.Source, &a.SourceType, &a.Binding, &a.BindingType, &lastUsed, &lastUsedResource, &a.Managed); err != nil {
+			return nil, fmt.Errorf("failed to scan permission: %v", err)
+		}
+		if !globMatch(query.Verb, a.Verb) || !globMatch(query.Resource, a.ResourceType) ||
+			(query.APIGroup != "" && !globMatch(query.APIGroup, apiGroupName(a.APIGroup))) ||
+			!scopeMatches(scope, query.Namespace, query.Name) {
+			continue
+		}
+
+		key := answerKey{a.EntityName, a.EntityType, a.Verb, a.ResourceType, a.APIGroup, a.Source, a.SourceType, a.Binding, a.BindingType}
+		i, ok := index[key]
+		if !ok {
+			i = len(answers)
+			index[key] = i
+			answers = append(answers, a)
+		}
+		answers[i].Scopes = append(answers[i].Scopes, scope)
+		if lastUsed.String > answers[i].LastUsedTime {
+			answers[i].LastUsedTime = lastUsed.String
+			answers[i].LastUsedResource = lastUsedResource.String
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read permissions: %v", err)
+	}
+
+	// Identities which used the permission first
+	sort.SliceStable(answers, func(i, j int) bool {
+		return answers[i].LastUsedTime > answers[j].LastUsedTime
+	})
+	return answers, nil
+}
+
+// Scopes as text, summarized when there are many of them
+func scopesText(scopes []string) string {
+	if len(scopes) > 3 {
+		return fmt.Sprintf("%s and %d more scopes", strings.Join(scopes[:3], ", "), len(scopes)-3)
+	}
+	return strings.Join(scopes, ", ")
+}
+
+// Write the answers as human readable text
+func WriteWhoCanText(w io.Writer, answers []WhoCanAnswer) error {
+	var b strings.Builder
+	entities := make(map[string]bool)
+	for _, a := range answers {
+		entities[a.EntityType+"/"+a.EntityName] = true
+	}
+	fmt.Fprintf(&b, "%d identities, %d grants\n", len(entities), len(answers))
+	for _, a := range answers {
+		fmt.Fprintf(&b, "\n%s %s", a.EntityType, a.EntityName)
+		if a.Managed {
+			b.WriteString(" (managed)")
+		}
+		fmt.Fprintf(&b, "\n  %s %s (%s) in %s\n", a.Verb, a.ResourceType, a.APIGroup, scopesText(a.Scopes))
+		fmt.Fprintf(&b, "  via %s %s, bound by %s %s\n", a.SourceType, a.Source, a.BindingType, a.Binding)
+		if a.LastUsedTime == "" {
+			b.WriteString("  not used in the observed period\n")
+		} else if a.LastUsedResource != "" {
+			fmt.Fprintf(&b, "  last used %s on %s\n", a.LastUsedTime, a.LastUsedResource)
+		} else {
+			fmt.Fprintf(&b, "  last used %s\n", a.LastUsedTime)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,104 @@
+package inventory
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestScopeMatches(t *testing.T) {
+	tests := []struct {
+		name            string
+		scope           string
+		namespace, item string
+		want            bool
+	}{
+		{"cluster-wide for any namespace", "cluster-wide", "", "", true},
+		{"cluster-wide for a namespace", "cluster-wide", "payments", "", true},
+		{"cluster-wide for a name", "cluster-wide", "payments", "db", true},
+		{"namespace for any namespace", "payments", "", "", true},
+		{"namespace", "payments", "payments", "", true},
+		{"other namespace", "billing", "payments", "", false},
+		{"namespace for a name", "payments", "payments", "db", true},
+		{"namespace glob", "payments-prod", "payments-*", "", true},
+		{"namespace glob mismatch", "billing-prod", "payments-*", "", false},
+		{"name without a name", "payments/db", "payments", "", false},
+		{"name without a namespace or name", "payments/db", "", "", false},
+		{"name", "payments/db", "payments", "db", true},
+		{"other name", "payments/db", "payments", "cache", false},
+		{"name glob", "payments/db-primary", "", "db-*", true},
+		{"any name", "payments/db", "", "*", true},
+		{"cluster-wide name without a name", "cluster-wide/alice", "", "", false},
+		{"cluster-wide name in a namespace", "cluster-wide/alice", "payments", "alice", true},
+		{"cluster-wide other name", "cluster-wide/alice", "", "bob", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scopeMatches(tt.scope, tt.namespace, tt.item); got != tt.want {
+				t.Errorf("scopeMatches(%q, %q, %q) = %v, want %v", tt.scope, tt.namespace, tt.item, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWhoCanGlobs(t *testing.T) {
+	columns := []string{"entity_name", "entity_type", "api_group", "resource_type", "verb", "permission_scope",
+		"permission_source", "permission_source_type", "permission_binding", "permission_binding_type",
+		"last_used_time", "last_used_resource", "managed"}
+	tests := []struct {
+		name       string
+		query      WhoCanQuery
+		wantFilter string // Patterns are left out of the SQL filter
+		wantArgs   int
+		want       []string
+	}{
+		{"exact verb and resource", WhoCanQuery{Verb: "create", Resource: "pods/exec"},
+			`WHERE (verb|resource_type) = \? AND (verb|resource_type) = \?`, 2, []string{"alice pods/exec"}},
+		{"resource glob", WhoCanQuery{Verb: "create", Resource: "pods/*"},
+			`WHERE verb = \? ORDER`, 1, []string{"alice pods/exec", "bob pods/portforward"}},
+		{"verb glob", WhoCanQuery{Verb: "*", Resource: "pods/exec"},
+			`WHERE resource_type = \? ORDER`, 1, []string{"carol pods/exec", "alice pods/exec"}},
+		{"verb and resource globs", WhoCanQuery{Verb: "[cg]*", Resource: "pods/*"},
+			`WHERE 1 = 1`, 0, []string{"carol pods/exec", "alice pods/exec", "bob pods/portforward"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			rows := sqlmock.NewRows(columns).
+				AddRow("alice", "User", "v1", "pods/exec", "create", "payments", "exec", "Role", "exec", "RoleBinding", nil, nil, false).
+				AddRow("bob", "User", "v1", "pods/portforward", "create", "payments", "debug", "Role", "debug", "RoleBinding", nil, nil, false).
+				AddRow("carol", "User", "v1", "pods/exec", "get", "payments", "exec", "Role", "exec", "RoleBinding", "2026-10-17 10:00:00", "payments/pods/api-0", false).
+				AddRow("dave", "User", "v1", "pods", "create", "payments", "edit", "Role", "edit", "RoleBinding", nil, nil, false)
+			expected := mock.ExpectQuery(tt.wantFilter).WillReturnRows(rows)
+			if tt.wantArgs > 0 {
+				args := make([]driver.Value, tt.wantArgs)
+				for i := range args {
+					args[i] = sqlmock.AnyArg()
+				}
+				expected.WithArgs(args...)
+			}
+
+			answers, err := WhoCan(db, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, a := range answers {
+				got = append(got, a.EntityName+" "+a.ResourceType)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("WhoCan() = %v, want %v", got, tt.want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -36,6 +36,11 @@ type PermissionContext struct {
 	BindingType  string
 }
 
+// Destination of the processed permissions - the prepared insert statement, or an in-memory collector for simulations
+type permissionExecer interface {
+	Exec(args ...interface{}) (sql.Result, error)
+}
+
 // Prepare SQL statement for inserting permissions
 func preparePermissionStatement(db *sql.DB) (*sql.Stmt, error) {
 	return db.Prepare(`
@@ -68,7 +73,7 @@ func prepareResources(client *kubernetes.Clientset) ([]ResourceType, map[string]
 
 // Handle the processing of subresources for a given resource type
 func processSubresource(
-	stmt *sql.Stmt,
+	stmt permissionExecer,
 	ctx PermissionContext,
 	verb string,
 	scope string,
@@ -93,7 +98,7 @@ func processSubresource(
 }
 
 func executePermissionStatement(
-	stmt *sql.Stmt,
+	stmt permissionExecer,
 	ctx PermissionContext,
 	verb string,
 	scope string,
@@ -109,7 +114,7 @@ func executePermissionStatement(
 }
 
 func processResourceType(
-	stmt *sql.Stmt,
+	stmt permissionExecer,
 	ctx PermissionContext,
 	resourceType ResourceType,
 	verb string,
@@ -139,7 +144,7 @@ func processResourceType(
 
 // Handles with resource names
 func processWithResourceNames(
-	stmt *sql.Stmt,
+	stmt permissionExecer,
 	ctx PermissionContext,
 	verb string,
 	resourceNames []string,
@@ -147,7 +152,7 @@ func processWithResourceNames(
 	subresources map[string]string,
 ) error {
 	for _, resourceName := range resourceNames {
-		scope := resourceName
+		scope := "cluster-wide/" + resourceName
 		if namespace != "" {
 			scope = fmt.Sprintf("%s/%s", namespace, resourceName)
 		}
@@ -165,7 +170,7 @@ func processWithResourceNames(
 
 // Handle without resource names
 func processWithoutResourceNames(
-	stmt *sql.Stmt,
+	stmt permissionExecer,
 	ctx PermissionContext,
 	verb string,
 	namespace string,
@@ -184,7 +189,7 @@ func processWithoutResourceNames(
 }
 
 func processRule(
-	stmt *sql.Stmt,
+	stmt permissionExecer,
 	ctx PermissionContext,
 	rule rbacv1.PolicyRule,
 	resourceTypes []ResourceType,
@@ -220,21 +225,50 @@ func CollectRoleBindings(
 	clusterRoles map[string]*rbacv1.ClusterRole,
 	roles map[string]*rbacv1.Role,
 ) error {
-	stmt, err := preparePermissionStatement(db)
+	resourceTypes, subresources, err := prepareResources(client)
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
 
-	resourceTypes, subresources, err := prepareResources(client)
+	namespaces, err := client.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
 	if err != nil {
 		return err
 	}
 
-	namespaces, err := client.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+	var roleBindings []rbacv1.RoleBinding
+	for _, namespace := range namespaces.Items {
+		rbList, err := client.RbacV1().RoleBindings(namespace.Name).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			return err
+		}
+		roleBindings = append(roleBindings, rbList.Items...)
+	}
+
+	return StoreRoleBindings(db, roleBindings, clusterRoles, roles, resourceTypes, subresources)
+}
+
+// Calculate the permissions granted by RoleBindings and insert them into the DB - shared by the cluster and offline collections
+func StoreRoleBindings(
+	db *sql.DB,
+	roleBindings []rbacv1.RoleBinding,
+	clusterRoles map[string]*rbacv1.ClusterRole,
+	roles map[string]*rbacv1.Role,
+	resourceTypes []ResourceType,
+	subresources map[string]string,
+) error {
+	var subjects []bindingSubjects
+	for _, rb := range roleBindings {
+		subjects = append(subjects, bindingSubjects{"RoleBinding", rb.Namespace, rb.Name, rb.Subjects})
+	}
+	if err := storeBindingSubjects(db, subjects); err != nil {
+		return fmt.Errorf("error inserting RoleBinding subjects: %v", err)
+	}
+
+	stmt, err := preparePermissionStatement(db)
 	if err != nil {
 		return err
 	}
+	defer stmt.Close()
 
 	log_parsing.GlobalProgressBar.Start("roles and roleBindings processed")
 	defer func() {
@@ -243,25 +277,19 @@ func CollectRoleBindings(
 		fmt.Printf("Inserted RoleBinding Permissions!\n")
 	}()
 
-	for _, namespace := range namespaces.Items {
-		rbList, err := client.RbacV1().RoleBindings(namespace.Name).List(context.TODO(), metav1.ListOptions{})
-		if err != nil {
+	for _, rb := range roleBindings {
+		recordDefaultRoleBinding(rb)
+		if err := processRoleBinding(stmt, rb, rb.Namespace, roles, clusterRoles, resourceTypes, subresources); err != nil {
 			return err
 		}
-
-		for _, rb := range rbList.Items {
-			if err := processRoleBinding(stmt, rb, namespace.Name, roles, clusterRoles, resourceTypes, subresources); err != nil {
-				return err
-			}
-			log_parsing.GlobalProgressBar.Add(1)
-		}
+		log_parsing.GlobalProgressBar.Add(1)
 	}
 
 	return nil
 }
 
 func processRoleBinding(
-	stmt *sql.Stmt,
+	stmt permissionExecer,
 	rb rbacv1.RoleBinding,
 	namespace string,
 	roles map[string]*rbacv1.Role,
@@ -273,14 +301,15 @@ func processRoleBinding(
 	allNamespaces := []string{namespace}
 
 	for _, subject := range rb.Subjects {
-		entityName := subject.Name
-		if subject.Kind == "ServiceAccount" {
-			entityName = fmt.Sprintf("%s:%s", namespace, subject.Name)
+		subjectNamespace := subject.Namespace
+		if subjectNamespace == "" {
+			subjectNamespace = namespace
 		}
+		entityName, entityType := log_parsing.NormalizeSubject(subject.Kind, subject.Name, subjectNamespace)
 
 		ctx := PermissionContext{
 			EntityName:  entityName,
-			EntityType:  subject.Kind,
+			EntityType:  entityType,
 			BindingName: rb.Name,
 			BindingType: "RoleBinding",
 		}
@@ -316,26 +345,50 @@ func CollectClusterRoleBindings(
 	db *sql.DB,
 	clusterRoles map[string]*rbacv1.ClusterRole,
 ) error {
-	stmt, err := preparePermissionStatement(db)
+	resourceTypes, subresources, err := prepareResources(client)
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
 
-	resourceTypes, subresources, err := prepareResources(client)
+	crbList, err := client.RbacV1().ClusterRoleBindings().List(context.TODO(), metav1.ListOptions{})
 	if err != nil {
 		return err
 	}
 
-	crbList, err := client.RbacV1().ClusterRoleBindings().List(context.TODO(), metav1.ListOptions{})
+	namespaces, err := client.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
 	if err != nil {
 		return err
 	}
 
-	namespaces, err := client.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+	return StoreClusterRoleBindings(db, crbList.Items, clusterRoles, resourceTypes, subresources, namespaces.Items)
+}
+
+// Calculate the permissions granted by ClusterRoleBindings and insert them into the DB - shared by the cluster and offline collections
+// Namespaced resources are expanded into the namespaces
+func StoreClusterRoleBindings(
+	db *sql.DB,
+	clusterRoleBindings []rbacv1.ClusterRoleBinding,
+	clusterRoles map[string]*rbacv1.ClusterRole,
+	resourceTypes []ResourceType,
+	subresources map[string]string,
+	namespaces []v1.Namespace,
+) error {
+	var subjects []bindingSubjects
+	for _, crb := range clusterRoleBindings {
+		subjects = append(subjects, bindingSubjects{"ClusterRoleBinding", "", crb.Name, crb.Subjects})
+	}
+	if err := storeBindingSubjects(db, subjects); err != nil {
+		return fmt.Errorf("error inserting ClusterRoleBinding subjects: %v", err)
+	}
+	if err := storeDefaultClusterRoles(db, clusterRoles); err != nil {
+		return fmt.Errorf("error inserting default ClusterRoles: %v", err)
+	}
+
+	stmt, err := preparePermissionStatement(db)
 	if err != nil {
 		return err
 	}
+	defer stmt.Close()
 
 	log_parsing.GlobalProgressBar.Start("clusterRoles and clusterRoleBindings processed")
 	defer func() {
@@ -344,8 +397,9 @@ func CollectClusterRoleBindings(
 		fmt.Printf("Inserted ClusterRoleBinding Permissions!\n")
 	}()
 
-	for _, crb := range crbList.Items {
-		if err := processClusterRoleBinding(stmt, crb, clusterRoles, resourceTypes, subresources, namespaces.Items); err != nil {
+	for _, crb := range clusterRoleBindings {
+		recordDefaultClusterRoleBinding(crb)
+		if err := processClusterRoleBinding(stmt, crb, clusterRoles, resourceTypes, subresources, namespaces); err != nil {
 			return err
 		}
 		log_parsing.GlobalProgressBar.Add(1)
@@ -355,7 +409,7 @@ func CollectClusterRoleBindings(
 }
 
 func processClusterRoleBinding(
-	stmt *sql.Stmt,
+	stmt permissionExecer,
 	crb rbacv1.ClusterRoleBinding,
 	clusterRoles map[string]*rbacv1.ClusterRole,
 	resourceTypes []ResourceType,
@@ -375,14 +429,11 @@ func processClusterRoleBinding(
 	}
 
 	for _, subject := range crb.Subjects {
-		entityName := subject.Name
-		if subject.Kind == "ServiceAccount" {
-			entityName = fmt.Sprintf("%s:%s", subject.Namespace, subject.Name)
-		}
+		entityName, entityType := log_parsing.NormalizeSubject(subject.Kind, subject.Name, subject.Namespace)
 
 		ctx := PermissionContext{
 			EntityName:  entityName,
-			EntityType:  subject.Kind,
+			EntityType:  entityType,
 			SourceName:  clusterRole.Name,
 			SourceType:  "ClusterRole",
 			BindingName: crb.Name,
@@ -397,3 +448,46 @@ func processClusterRoleBinding(
 	}
 	return nil
 }
+
+// In-memory destination of the processed permissions, in the order of the insert statement columns
+type permissionCollector struct {
+	permissions []PermissionContext
+}
+
+func (c *permissionCollector) Exec(args ...interface{}) (sql.Result, error) {
+	column := func(i int) string {
+		value, _ := args[i].(string)
+		return value
+	}
+	c.permissions = append(c.permissions, PermissionContext{
+		EntityName:   column(0),
+		EntityType:   column(1),
+		ResourceType: ResourceType{APIGroup: column(2), ResourceType: column(3), Verb: column(4)},
+		Verb:         column(4),
+		Scope:        column(5),
+		SourceName:   column(6),
+		SourceType:   column(7),
+		BindingName:  column(8),
+		BindingType:  column(9),
+	})
+	return nil, nil
+}
+
+// Compute the permissions the rules grant to the entity of ctx without a DB, with the same logic as the collection
+// The namespace is the namespace of a RoleBinding, or "" for a ClusterRoleBinding expanded into allNamespaces
+func ComputePermissions(
+	ctx PermissionContext,
+	rules []rbacv1.PolicyRule,
+	namespace string,
+	resourceTypes []ResourceType,
+	subresources map[string]string,
+	allNamespaces []string,
+) ([]PermissionContext, error) {
+	collector := &permissionCollector{}
+	for _, rule := range rules {
+		if err := processRule(collector, ctx, rule, resourceTypes, namespace, subresources, allNamespaces); err != nil {
+			return nil, err
+		}
+	}
+	return collector.permissions, nil
+}
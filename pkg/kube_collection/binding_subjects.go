@@ -0,0 +1,79 @@
+package kube_collection
+
+import (
+	"database/sql"
+	"sort"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+// The collected subjects of the bindings and the ClusterRoles which come with the cluster, for the remediation of
+// unused RBAC - patches address the subjects of the live binding by index, and default roles aren't deleted
+
+type bindingSubjects struct {
+	bindingType string
+	namespace   string
+	name        string
+	subjects    []rbacv1.Subject
+}
+
+// Insert the subjects of the bindings, with their position in the binding, into the binding_subjects table
+func storeBindingSubjects(db *sql.DB, bindings []bindingSubjects) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO rufus.binding_subjects (
+			binding_type, binding_namespace, binding_name, subject_index,
+			subject_kind, subject_api_group, subject_name, subject_namespace
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, binding := range bindings {
+		for i, subject := range binding.subjects {
+			_, err := stmt.Exec(binding.bindingType, binding.namespace, binding.name, i,
+				subject.Kind, subject.APIGroup, subject.Name, subject.Namespace)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// Insert the bootstrap and aggregated ClusterRoles into the default_cluster_roles table
+func storeDefaultClusterRoles(db *sql.DB, clusterRoles map[string]*rbacv1.ClusterRole) error {
+	var names []string
+	for name, clusterRole := range clusterRoles {
+		if isDefaultOrAggregatedClusterRole(clusterRole, clusterRoles) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare("INSERT IGNORE INTO rufus.default_cluster_roles (name) VALUES (?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, name := range names {
+		if _, err := stmt.Exec(name); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
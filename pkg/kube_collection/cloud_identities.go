@@ -0,0 +1,172 @@
+package kube_collection
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/eks"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Cloud identities of ServiceAccounts - the cloud roles which the pods of a ServiceAccount can assume, resolved once per
+// ServiceAccount and joined to every workload running with it
+
+const (
+	IRSAIdentity          = "IRSA"           // eks.amazonaws.com/role-arn annotation
+	EKSPodIdentity        = "EKSPodIdentity" // EKS Pod Identity association
+	AzureWorkloadIdentity = "AzureWorkloadIdentity"
+	GKEWorkloadIdentity   = "GKEWorkloadIdentity"
+)
+
+type ServiceAccountIdentity struct {
+	ServiceAccountName string // namespace:name
+	IdentityType       string
+	Identity           string // Role ARN, client ID or Google service account email
+}
+
+// Get the cloud identities of a ServiceAccount from its annotations and the EKS Pod Identity associations (keyed by
+// namespace/name), only for the mechanisms of the cluster's provider - the annotations of other providers do nothing.
+// IRSA comes before Pod Identity, as the AWS SDKs prefer web identity credentials
+func getServiceAccountIdentities(sa *corev1.ServiceAccount, clusterType string, podIdentities map[string]string) []ServiceAccountIdentity {
+	name := fmt.Sprintf("%s:%s", sa.Namespace, sa.Name)
+	var identities []ServiceAccountIdentity
+	add := func(identityType, identity string) {
+		if identity != "" {
+			identities = append(identities, ServiceAccountIdentity{ServiceAccountName: name, IdentityType: identityType, Identity: identity})
+		}
+	}
+	switch clusterType {
+	case "EKS":
+		add(IRSAIdentity, sa.Annotations["eks.amazonaws.com/role-arn"])
+		add(EKSPodIdentity, podIdentities[sa.Namespace+"/"+sa.Name])
+	case "AKS":
+		add(AzureWorkloadIdentity, sa.Annotations["azure.workload.identity/client-id"])
+	case "GKE":
+		add(GKEWorkloadIdentity, sa.Annotations["iam.gke.io/gcp-service-account"])
+	}
+	return identities
+}
+
+// The cloud identity of a workload - the identity of its ServiceAccount. Azure Workload Identity is only injected into
+// pods labeled azure.workload.identity/use, where an AZURE_CLIENT_ID variable takes precedence over the ServiceAccount
+func (wctx workloadContext) workloadIdentity(template corev1.PodTemplateSpec) string {
+	saName := template.Spec.ServiceAccountName
+	if saName == "" {
+		saName = "default"
+	}
+	identities := wctx.identities[saName]
+	for _, identity := range identities {
+		if identity.IdentityType != AzureWorkloadIdentity {
+			return identity.Identity
+		}
+	}
+
+	if template.Labels["azure.workload.identity/use"] != "true" {
+		return ""
+	}
+	for _, container := range template.Spec.Containers {
+		for _, env := range container.Env {
+			if env.Name == "AZURE_CLIENT_ID" && env.Value != "" {
+				return env.Value
+			}
+		}
+	}
+	for _, identity := range identities {
+		if identity.IdentityType == AzureWorkloadIdentity {
+			return identity.Identity
+		}
+	}
+	return ""
+}
+
+// Add the cloud identities of the ServiceAccounts of a namespace to its workload context
+func (wctx *workloadContext) addServiceAccountIdentities(clusterType string, podIdentities map[string]string) {
+	wctx.identities = make(map[string][]ServiceAccountIdentity)
+	for name, sa := range wctx.serviceAccounts {
+		if identities := getServiceAccountIdentities(sa, clusterType, podIdentities); len(identities) > 0 {
+			wctx.identities[name] = identities
+		}
+	}
+}
+
+// The cloud identities of a workload context, sorted by ServiceAccount
+func (wctx workloadContext) serviceAccountIdentities() []ServiceAccountIdentity {
+	var names []string
+	for name := range wctx.identities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var identities []ServiceAccountIdentity
+	for _, name := range names {
+		identities = append(identities, wctx.identities[name]...)
+	}
+	return identities
+}
+
+// Get the EKS Pod Identity associations of the cluster, role ARNs keyed by namespace/ServiceAccount
+func getEKSPodIdentities(sess *session.Session, clusterName string) (map[string]string, error) {
+	podIdentities := make(map[string]string)
+	eksSvc := eks.New(sess)
+	var associationIDs []*string
+	err := eksSvc.ListPodIdentityAssociationsPages(&eks.ListPodIdentityAssociationsInput{ClusterName: &clusterName},
+		func(page *eks.ListPodIdentityAssociationsOutput, lastPage bool) bool {
+			for _, assoc := range page.Associations {
+				if assoc.AssociationId != nil {
+					associationIDs = append(associationIDs, assoc.AssociationId)
+				}
+			}
+			return true
+		})
+	if err != nil {
+		return podIdentities, err
+	}
+	// The role of an association is only returned by describe
+	for _, associationID := range associationIDs {
+		descOutput, err := eksSvc.DescribePodIdentityAssociation(&eks.DescribePodIdentityAssociationInput{
+			ClusterName:   &clusterName,
+			AssociationId: associationID,
+		})
+		if err != nil {
+			fmt.Printf("Warning: failed to describe EKS pod identity association %s: %v\n", *associationID, err)
+			continue
+		}
+		if descOutput.Association == nil {
+			continue
+		}
+		association := descOutput.Association
+		if association.Namespace != nil && association.ServiceAccount != nil && association.RoleArn != nil {
+			podIdentities[*association.Namespace+"/"+*association.ServiceAccount] = *association.RoleArn
+		}
+	}
+	return podIdentities, nil
+}
+
+// Insert the cloud identities of ServiceAccounts into the service_account_identities table
+func StoreServiceAccountIdentities(db *sql.DB, identities []ServiceAccountIdentity) error {
+	if len(identities) == 0 {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT IGNORE INTO rufus.service_account_identities (service_account_name, identity_type, identity)
+		VALUES (?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, identity := range identities {
+		if _, err := stmt.Exec(identity.ServiceAccountName, identity.IdentityType, identity.Identity); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,41 @@
+package kube_collection
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetServiceAccountIdentities(t *testing.T) {
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "payments", Annotations: map[string]string{
+		"eks.amazonaws.com/role-arn":        "arn:aws:iam::123456789012:role/irsa",
+		"azure.workload.identity/client-id": "00000000-0000-0000-0000-000000000000",
+		"iam.gke.io/gcp-service-account":    "api@project.iam.gserviceaccount.com",
+	}}}
+	podIdentities := map[string]string{"payments/api": "arn:aws:iam::123456789012:role/pod-identity"}
+	tests := []struct {
+		clusterType string
+		want        []ServiceAccountIdentity
+	}{
+		{"EKS", []ServiceAccountIdentity{
+			{ServiceAccountName: "payments:api", IdentityType: IRSAIdentity, Identity: "arn:aws:iam::123456789012:role/irsa"},
+			{ServiceAccountName: "payments:api", IdentityType: EKSPodIdentity, Identity: "arn:aws:iam::123456789012:role/pod-identity"},
+		}},
+		{"AKS", []ServiceAccountIdentity{
+			{ServiceAccountName: "payments:api", IdentityType: AzureWorkloadIdentity, Identity: "00000000-0000-0000-0000-000000000000"},
+		}},
+		{"GKE", []ServiceAccountIdentity{
+			{ServiceAccountName: "payments:api", IdentityType: GKEWorkloadIdentity, Identity: "api@project.iam.gserviceaccount.com"},
+		}},
+		{"LOCAL", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.clusterType, func(t *testing.T) {
+			if got := getServiceAccountIdentities(sa, tt.clusterType, podIdentities); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getServiceAccountIdentities() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
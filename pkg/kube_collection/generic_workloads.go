@@ -0,0 +1,283 @@
+package kube_collection
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+)
+
+// Workloads - the built-in kinds which run pods, and custom resources which embed pod templates (Argo Rollouts, Knative
+// Services...) or name the ServiceAccount of the pods they create (KubeVirt VMs, Spark applications), collected with the
+// dynamic client
+
+// A workload kind and where the pods of its objects are described
+// Paths are dot separated fields of the object, where * matches every item of a list (or value of a map), and the empty
+// path is the object itself (a Pod)
+type WorkloadKind struct {
+	APIVersion          string   `yaml:"apiVersion"`          // group/version, e.g. argoproj.io/v1alpha1
+	Kind                string   `yaml:"kind"`                // e.g. Rollout
+	TemplatePaths       []string `yaml:"templatePaths"`       // Pod templates (metadata and spec), e.g. spec.template
+	ServiceAccountPaths []string `yaml:"serviceAccountPaths"` // ServiceAccount names, for kinds without a full pod template
+}
+
+type workloadKindsFile struct {
+	WorkloadKinds []WorkloadKind `yaml:"workloadKinds"`
+}
+
+// Built-in kinds, replaced by configured kinds with the same apiVersion and kind
+var defaultWorkloadKinds = []WorkloadKind{
+	{APIVersion: "v1", Kind: "Pod", TemplatePaths: []string{""}},
+	{APIVersion: "apps/v1", Kind: "Deployment", TemplatePaths: []string{"spec.template"}},
+	{APIVersion: "apps/v1", Kind: "DaemonSet", TemplatePaths: []string{"spec.template"}},
+	{APIVersion: "apps/v1", Kind: "ReplicaSet", TemplatePaths: []string{"spec.template"}},
+	{APIVersion: "apps/v1", Kind: "StatefulSet", TemplatePaths: []string{"spec.template"}},
+	{APIVersion: "batch/v1", Kind: "Job", TemplatePaths: []string{"spec.template"}},
+	{APIVersion: "batch/v1", Kind: "CronJob", TemplatePaths: []string{"spec.jobTemplate.spec.template"}},
+	{APIVersion: "argoproj.io/v1alpha1", Kind: "Rollout", TemplatePaths: []string{"spec.template"}},
+	{APIVersion: "argoproj.io/v1alpha1", Kind: "Workflow", ServiceAccountPaths: []string{"spec.serviceAccountName", "spec.templates.*.serviceAccountName"}},
+	{APIVersion: "serving.knative.dev/v1", Kind: "Service", TemplatePaths: []string{"spec.template"}},
+	{APIVersion: "kubevirt.io/v1", Kind: "VirtualMachine", ServiceAccountPaths: []string{"spec.template.spec.volumes.*.serviceAccount.serviceAccountName"}},
+	{APIVersion: "sparkoperator.k8s.io/v1beta2", Kind: "SparkApplication", ServiceAccountPaths: []string{"spec.driver.serviceAccount", "spec.executor.serviceAccount"}},
+}
+
+var workloadKinds = defaultWorkloadKinds
+
+// Load additional workload kinds from a YAML file, e.g.
+//
+//	workloadKinds:
+//	  - apiVersion: example.com/v1
+//	    kind: Worker
+//	    templatePaths: ["spec.podTemplate"]
+//	  - apiVersion: example.com/v1
+//	    kind: Pipeline
+//	    serviceAccountPaths: ["spec.steps.*.serviceAccountName"]
+func LoadWorkloadKinds(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read workload kinds file: %v", err)
+	}
+	var kindsFile workloadKindsFile
+	if err := yaml.Unmarshal(data, &kindsFile); err != nil {
+		return fmt.Errorf("failed to parse workload kinds file: %v", err)
+	}
+
+	configured := make(map[string]bool)
+	var kinds []WorkloadKind
+	for i, kind := range kindsFile.WorkloadKinds {
+		if kind.APIVersion == "" || kind.Kind == "" {
+			return fmt.Errorf("workload kind %d must set apiVersion and kind", i+1)
+		}
+		if len(kind.TemplatePaths) == 0 && len(kind.ServiceAccountPaths) == 0 {
+			return fmt.Errorf("workload kind %d must set templatePaths or serviceAccountPaths", i+1)
+		}
+		configured[kind.APIVersion+"/"+kind.Kind] = true
+		kinds = append(kinds, kind)
+	}
+	for _, kind := range defaultWorkloadKinds {
+		if !configured[kind.APIVersion+"/"+kind.Kind] {
+			kinds = append(kinds, kind)
+		}
+	}
+	workloadKinds = kinds
+	return nil
+}
+
+func findWorkloadKind(apiVersion, kind string) (WorkloadKind, bool) {
+	for _, workloadKind := range workloadKinds {
+		if workloadKind.APIVersion == apiVersion && workloadKind.Kind == kind {
+			return workloadKind, true
+		}
+	}
+	return WorkloadKind{}, false
+}
+
+func pathSegments(path string) []string {
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, ".")
+}
+
+// The values at a path of an object
+func valuesAtPath(value interface{}, segments []string) []interface{} {
+	if len(segments) == 0 {
+		if value == nil {
+			return nil
+		}
+		return []interface{}{value}
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if segments[0] != "*" {
+			return valuesAtPath(v[segments[0]], segments[1:])
+		}
+		var keys []string
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var values []interface{}
+		for _, key := range keys {
+			values = append(values, valuesAtPath(v[key], segments[1:])...)
+		}
+		return values
+	case []interface{}:
+		if segments[0] != "*" {
+			return nil
+		}
+		var values []interface{}
+		for _, item := range v {
+			values = append(values, valuesAtPath(item, segments[1:])...)
+		}
+		return values
+	}
+	return nil
+}
+
+// Get the pod templates of an object of the kind. ServiceAccount paths give templates with only the ServiceAccount set
+func (k WorkloadKind) podTemplates(object map[string]interface{}) ([]corev1.PodTemplateSpec, error) {
+	var templates []corev1.PodTemplateSpec
+	for _, path := range k.TemplatePaths {
+		for _, value := range valuesAtPath(object, pathSegments(path)) {
+			fields, ok := value.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			var template corev1.PodTemplateSpec
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(fields, &template); err != nil {
+				return nil, fmt.Errorf("invalid pod template at %s: %v", path, err)
+			}
+			templates = append(templates, template)
+		}
+	}
+	for _, path := range k.ServiceAccountPaths {
+		for _, value := range valuesAtPath(object, pathSegments(path)) {
+			if name, ok := value.(string); ok && name != "" {
+				templates = append(templates, corev1.PodTemplateSpec{Spec: corev1.PodSpec{ServiceAccountName: name}})
+			}
+		}
+	}
+	return templates, nil
+}
+
+// Build the workloads of an object of the kind, one per ServiceAccount of its pod templates
+// Pod templates without a ServiceAccount run with the namespace's default ServiceAccount
+func (k WorkloadKind) workloads(obj metav1.Object, templates []corev1.PodTemplateSpec, wctx workloadContext) []WorkloadInfo {
+	ownerType, ownerName := wctx.ownerInfo(obj, strings.ToLower(k.Kind))
+	var workloads []WorkloadInfo
+	for _, template := range templates {
+		saName := template.Spec.ServiceAccountName
+		if saName == "" {
+			saName = "default"
+		}
+		workloads = append(workloads, WorkloadInfo{
+			WorkloadType:       k.Kind,
+			WorkloadName:       obj.GetName(),
+			ServiceAccountName: fmt.Sprintf("%s:%s", obj.GetNamespace(), saName),
+			WorkloadIdentity:   wctx.workloadIdentity(template),
+			OriginalOwnerType:  ownerType,
+			OriginalOwnerName:  ownerName,
+			Security:           getWorkloadSecurity(template, wctx),
+		})
+	}
+	return mergeWorkloads(workloads)
+}
+
+// Combine the rows of a workload which share a ServiceAccount (e.g. the driver and executors of a Spark application),
+// keeping the riskiest security attributes of their pod templates
+func mergeWorkloads(workloads []WorkloadInfo) []WorkloadInfo {
+	index := make(map[string]int)
+	var merged []WorkloadInfo
+	for _, w := range workloads {
+		key := strings.Join([]string{w.WorkloadType, w.WorkloadName, w.ServiceAccountName, w.OriginalOwnerType, w.OriginalOwnerName}, "/")
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(merged)
+			merged = append(merged, w)
+			continue
+		}
+		m := &merged[i]
+		if m.WorkloadIdentity == "" {
+			m.WorkloadIdentity = w.WorkloadIdentity
+		}
+		m.Security.AutomountToken = m.Security.AutomountToken || w.Security.AutomountToken
+		m.Security.Privileged = m.Security.Privileged || w.Security.Privileged
+		m.Security.HostPath = m.Security.HostPath || w.Security.HostPath
+		m.Security.HostNetwork = m.Security.HostNetwork || w.Security.HostNetwork
+		m.Security.HostPID = m.Security.HostPID || w.Security.HostPID
+		if exposureRanks[w.Security.ServiceExposure] > exposureRanks[m.Security.ServiceExposure] {
+			m.Security.ServiceExposure = w.Security.ServiceExposure
+		}
+	}
+	return merged
+}
+
+// The dynamic client and REST mapper of the cluster
+func dynamicClients(config *rest.Config) (dynamic.Interface, meta.RESTMapper, error) {
+	dyn, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dyn, restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient)), nil
+}
+
+// Get the resource of a workload kind, if the cluster serves it as a namespaced resource
+func workloadKindMapping(mapper meta.RESTMapper, kind WorkloadKind) (*meta.RESTMapping, bool) {
+	gvk := schema.FromAPIVersionAndKind(kind.APIVersion, kind.Kind)
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		if !meta.IsNoMatchError(err) {
+			fmt.Printf("Warning: failed to get the resource of %s %s: %v\n", kind.APIVersion, kind.Kind, err)
+		}
+		return nil, false
+	}
+	return mapping, mapping.Scope.Name() == meta.RESTScopeNameNamespace
+}
+
+// Collect the workloads of the workload kinds served by the cluster, in all the namespaces with a workload context
+func collectWorkloads(dyn dynamic.Interface, mapper meta.RESTMapper, contexts map[string]workloadContext) []WorkloadInfo {
+	var workloads []WorkloadInfo
+	for _, kind := range workloadKinds {
+		mapping, ok := workloadKindMapping(mapper, kind)
+		if !ok {
+			continue
+		}
+		list, err := dyn.Resource(mapping.Resource).Namespace(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			fmt.Printf("Warning: failed to list %s: %v\n", mapping.Resource.String(), err)
+			continue
+		}
+		for i := range list.Items {
+			obj := &list.Items[i]
+			wctx, ok := contexts[obj.GetNamespace()]
+			if !ok {
+				continue
+			}
+			templates, err := kind.podTemplates(obj.Object)
+			if err != nil {
+				fmt.Printf("Warning: skipping %s %s/%s: %v\n", kind.Kind, obj.GetNamespace(), obj.GetName(), err)
+				continue
+			}
+			workloads = append(workloads, kind.workloads(obj, templates, wctx)...)
+		}
+	}
+	return workloads
+}
@@ -0,0 +1,117 @@
+package kube_collection
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValuesAtPath(t *testing.T) {
+	object := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"serviceAccountName": "api",
+			"templates": []interface{}{
+				map[string]interface{}{"serviceAccountName": "git"},
+				map[string]interface{}{"name": "no-sa"},
+				map[string]interface{}{"serviceAccountName": "push"},
+			},
+			"roles": map[string]interface{}{
+				"worker": map[string]interface{}{"serviceAccount": "worker"},
+				"driver": map[string]interface{}{"serviceAccount": "driver"},
+			},
+		},
+	}
+	tests := []struct {
+		name string
+		path string
+		want []interface{}
+	}{
+		{"field", "spec.serviceAccountName", []interface{}{"api"}},
+		{"missing field", "spec.missing", nil},
+		{"every item of a list", "spec.templates.*.serviceAccountName", []interface{}{"git", "push"}},
+		{"every value of a map, by key", "spec.roles.*.serviceAccount", []interface{}{"driver", "worker"}},
+		{"named field of a list", "spec.templates.0.serviceAccountName", nil},
+		{"field of a string", "spec.serviceAccountName.name", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := valuesAtPath(object, pathSegments(tt.path)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("valuesAtPath(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+
+	if got := valuesAtPath(object, pathSegments("")); !reflect.DeepEqual(got, []interface{}{object}) {
+		t.Errorf("valuesAtPath(\"\") = %v, want the object", got)
+	}
+}
+
+func TestPodTemplates(t *testing.T) {
+	pod := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata":   map[string]interface{}{"name": "api-0", "labels": map[string]interface{}{"app": "api"}},
+		"spec":       map[string]interface{}{"serviceAccountName": "api", "hostPID": true},
+		"status":     map[string]interface{}{"phase": "Running"},
+	}
+	kind, _ := findWorkloadKind("v1", "Pod")
+	templates, err := kind.podTemplates(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(templates) != 1 || templates[0].Spec.ServiceAccountName != "api" || !templates[0].Spec.HostPID || templates[0].Labels["app"] != "api" {
+		t.Errorf("podTemplates() of a Pod = %+v, want the Pod as its template", templates)
+	}
+
+	cronjob := map[string]interface{}{
+		"spec": map[string]interface{}{"jobTemplate": map[string]interface{}{"spec": map[string]interface{}{"template": map[string]interface{}{
+			"spec": map[string]interface{}{"serviceAccountName": "backup"},
+		}}}},
+	}
+	kind, _ = findWorkloadKind("batch/v1", "CronJob")
+	templates, err = kind.podTemplates(cronjob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(templates) != 1 || templates[0].Spec.ServiceAccountName != "backup" {
+		t.Errorf("podTemplates() of a CronJob = %+v, want the template of its jobs", templates)
+	}
+}
+
+func TestWorkloadsDefaultServiceAccount(t *testing.T) {
+	kind, _ := findWorkloadKind("apps/v1", "Deployment")
+	obj := &metav1.ObjectMeta{Name: "api", Namespace: "payments"}
+	workloads := kind.workloads(obj, []corev1.PodTemplateSpec{{}}, workloadContext{})
+	if len(workloads) != 1 || workloads[0].ServiceAccountName != "payments:default" || workloads[0].OriginalOwnerType != "deployment" {
+		t.Errorf("workloads() = %+v, want the default ServiceAccount of the namespace", workloads)
+	}
+}
+
+func TestMergeWorkloads(t *testing.T) {
+	driver := WorkloadInfo{
+		WorkloadType: "SparkApplication", WorkloadName: "etl", ServiceAccountName: "data:spark",
+		OriginalOwnerType: "sparkapplication", OriginalOwnerName: "etl",
+		Security: WorkloadSecurity{HostPath: true, ServiceExposure: "ClusterIP"},
+	}
+	executor := driver
+	executor.WorkloadIdentity = "arn:aws:iam::123456789012:role/etl"
+	executor.Security = WorkloadSecurity{AutomountToken: true, Privileged: true, ServiceExposure: "NodePort"}
+	other := driver
+	other.ServiceAccountName = "data:other"
+	other.Security = WorkloadSecurity{}
+
+	got := mergeWorkloads([]WorkloadInfo{driver, other, executor})
+	want := []WorkloadInfo{
+		{
+			WorkloadType: "SparkApplication", WorkloadName: "etl", ServiceAccountName: "data:spark",
+			WorkloadIdentity: "arn:aws:iam::123456789012:role/etl", OriginalOwnerType: "sparkapplication", OriginalOwnerName: "etl",
+			Security: WorkloadSecurity{AutomountToken: true, Privileged: true, HostPath: true, ServiceExposure: "NodePort"},
+		},
+		other,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeWorkloads() = %+v, want %+v", got, want)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strings"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/kubernetes"
@@ -12,8 +13,6 @@ import (
 
 // Get the resource types in the cluster, their API groups and whether or not they're namespaced
 func GetResourceTypesAndAPIGroups(client *kubernetes.Clientset) ([]ResourceType, error) {
-	resourceTypes := []ResourceType{}
-
 	// Force a discovery cache refresh
 	_, err := client.Discovery().RESTClient().Get().AbsPath("/apis").DoRaw(context.TODO())
 	if err != nil {
@@ -30,6 +29,12 @@ func GetResourceTypesAndAPIGroups(client *kubernetes.Clientset) ([]ResourceType,
 		}
 	}
 
+	return ResourceTypesFromDiscovery(apiResourceList)
+}
+
+// Get the resource types from discovery results (from the cluster or a snapshot) and add the impersonation resources
+func ResourceTypesFromDiscovery(apiResourceList []*metav1.APIResourceList) ([]ResourceType, error) {
+	resourceTypes := []ResourceType{}
 	for _, apiResourceGroup := range apiResourceList {
 		groupVersion, err := schema.ParseGroupVersion(apiResourceGroup.GroupVersion)
 		if err != nil {
@@ -49,9 +54,26 @@ func GetResourceTypesAndAPIGroups(client *kubernetes.Clientset) ([]ResourceType,
 			})
 		}
 	}
+	resourceTypes = append(resourceTypes, impersonationResources...)
 	return resourceTypes, nil
 }
 
+// Resources only used to authorize impersonation - these aren't served by the API so discovery doesn't return them
+var impersonationResources = []ResourceType{
+	{APIGroup: "v1", ResourceType: "users", Namespaced: false},
+	{APIGroup: "v1", ResourceType: "groups", Namespaced: false},
+	{APIGroup: "authentication.k8s.io/v1", ResourceType: "uids", Namespaced: false},
+}
+
+func isImpersonationResource(resourceType string) bool {
+	for _, rt := range impersonationResources {
+		if rt.ResourceType == resourceType {
+			return true
+		}
+	}
+	return false
+}
+
 // Logic to flatten out wildcards into the smallest possible permission subset (instead of 1 line for *, have 1 line X [individual permissions granted by wildcard])
 func FlattenWildcards(resourceTypes []ResourceType, verb, resource, apiGroup string) ([]ResourceType, error) {
 	var flattenedResourceTypes []ResourceType
@@ -134,8 +156,9 @@ func GetVerbsForResourceType(resourceType string) ([]string, error) {
 		"roles":                      {"bind", "escalate", "create", "delete", "deletecollection", "get", "list", "patch", "update", "watch"},
 		"clusterroles":               {"bind", "escalate", "create", "delete", "deletecollection", "get", "list", "patch", "update", "watch"},
 		"serviceaccounts":            {"impersonate", "create", "delete", "deletecollection", "get", "list", "patch", "update", "watch"},
-		"users":                      {"impersonate", "create", "delete", "deletecollection", "get", "list", "patch", "update", "watch"},
-		"groups":                     {"impersonate", "create", "delete", "deletecollection", "get", "list", "patch", "update", "watch"},
+		"users":                      {"impersonate"},
+		"groups":                     {"impersonate"},
+		"uids":                       {"impersonate"},
 		// To add more resource types and their verbs
 	}
 
@@ -167,9 +190,14 @@ func ContainsVerb(resourceType, verb string) bool {
 		"serviceaccounts":            {"impersonate"},
 		"users":                      {"impersonate"},
 		"groups":                     {"impersonate"},
+		"uids":                       {"impersonate"},
 		// Add more resource types and their verbs
 	}
 
+	if isImpersonationResource(resourceType) {
+		return verb == "impersonate"
+	}
+
 	if _, ok := standardVerbs[verb]; ok {
 		return true
 	}
@@ -194,6 +222,11 @@ func GetSubresources(client *kubernetes.Clientset) (map[string]string, error) {
 		return nil, err
 	}
 
+	return SubresourcesFromDiscovery(apiResourceLists)
+}
+
+// Get the subresources and their API group from discovery results (from the cluster or a snapshot)
+func SubresourcesFromDiscovery(apiResourceLists []*metav1.APIResourceList) (map[string]string, error) {
 	resources := make(map[string]string)
 	for _, apiResourceList := range apiResourceLists {
 		groupVersion, err := schema.ParseGroupVersion(apiResourceList.GroupVersion)
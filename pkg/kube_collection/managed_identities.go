@@ -0,0 +1,152 @@
+package kube_collection
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+// Curated Kubernetes system and managed-provider identities and bindings, which can't be changed by the cluster owner
+// Their permissions are tagged as managed in the DB and left out of the least-privilege recommendations by default
+
+// A managed pattern matches permissions where every set field matches. Values are globs (* only)
+type ManagedPattern struct {
+	Entity     string
+	EntityType string
+	Binding    string
+}
+
+// Managed in every cluster type - the control plane components, nodes and kube-system ServiceAccounts
+// Bindings created by the API server are recognized by their rbac-defaults label when they are stored, not by name
+var commonManagedPatterns = []ManagedPattern{
+	{Entity: "system:kube-controller-manager"},
+	{Entity: "system:kube-scheduler"},
+	{Entity: "system:kube-proxy"},
+	{Entity: "system:nodes", EntityType: "Group"},
+	{Entity: "system:node:*"},
+	{EntityType: "Node"},
+	{Entity: "system:serviceaccount:kube-system:*"},
+	{Entity: "kube-system:*", EntityType: "ServiceAccount"},
+}
+
+// Subjects which are never managed, even when bound by a default binding - their permissions are granted to everyone
+// (or every client certificate of the group) and are always worth reporting
+var unmanagedEntities = []string{"system:anonymous", "system:unauthenticated", "system:authenticated", "system:masters"}
+
+// A binding created by the API server, labeled kubernetes.io/bootstrapping=rbac-defaults
+type defaultBinding struct {
+	bindingType string
+	namespace   string // RoleBindings only
+	name        string
+}
+
+// The default bindings of the last collection, recorded while the bindings are stored
+var defaultBindings []defaultBinding
+
+// Managed per cluster type (the clusterType values used for collection)
+var providerManagedPatterns = map[string][]ManagedPattern{
+	"EKS": {
+		{Entity: "eks:*"},
+		{Entity: "amazon-cloudwatch:*", EntityType: "ServiceAccount"},
+		{Entity: "amazon-guardduty:*", EntityType: "ServiceAccount"},
+		{Binding: "eks:*"},
+		{Binding: "aws-node"},
+		{Binding: "vpc-resource-controller-rolebinding"},
+	},
+	"AKS": {
+		{Entity: "aks:*"},
+		{Entity: "aksService"},
+		{Entity: "masterclient"},
+		{Entity: "nodeclient"},
+		{Entity: "calico-system:*", EntityType: "ServiceAccount"},
+		{Entity: "tigera-operator:*", EntityType: "ServiceAccount"},
+		{Entity: "gatekeeper-system:*", EntityType: "ServiceAccount"},
+		{Binding: "aks:*"},
+		{Binding: "aks-*"},
+	},
+	"GKE": {
+		{Entity: "gke-*"},
+		{Entity: "kubelet"},
+		{Entity: "gmp-system:*", EntityType: "ServiceAccount"},
+		{Entity: "gmp-public:*", EntityType: "ServiceAccount"},
+		{Binding: "gke-*"},
+		{Binding: "gce:*"},
+		{Binding: "kubelet-*"},
+	},
+	"LOCAL": {},
+}
+
+// Get the managed patterns for a cluster type
+func ManagedPatterns(clusterType string) []ManagedPattern {
+	return append(append([]ManagedPattern{}, commonManagedPatterns...), providerManagedPatterns[clusterType]...)
+}
+
+// Convert a glob to a LIKE pattern
+func globToLike(glob string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`, "*", "%")
+	return replacer.Replace(glob)
+}
+
+func isDefaultBinding(labels map[string]string) bool {
+	return labels["kubernetes.io/bootstrapping"] == "rbac-defaults"
+}
+
+// Record the default bindings among stored RoleBindings and ClusterRoleBindings, for TagManagedPermissions
+func recordDefaultRoleBinding(rb rbacv1.RoleBinding) {
+	if isDefaultBinding(rb.Labels) {
+		defaultBindings = append(defaultBindings, defaultBinding{bindingType: "RoleBinding", namespace: rb.Namespace, name: rb.Name})
+	}
+}
+
+func recordDefaultClusterRoleBinding(crb rbacv1.ClusterRoleBinding) {
+	if isDefaultBinding(crb.Labels) {
+		defaultBindings = append(defaultBindings, defaultBinding{bindingType: "ClusterRoleBinding", name: crb.Name})
+	}
+}
+
+// Tag the permissions of managed identities and bindings in the DB
+// Called after log processing, since the logs and IAM policies may add permissions
+func TagManagedPermissions(db *sql.DB, clusterType string) error {
+	unmanaged := "entity_name NOT IN (?" + strings.Repeat(", ?", len(unmanagedEntities)-1) + ")"
+	tag := func(clauses []string, args []interface{}) error {
+		for _, entity := range unmanagedEntities {
+			args = append(args, entity)
+		}
+		_, err := db.Exec("UPDATE permission SET managed = TRUE WHERE "+strings.Join(append(clauses, unmanaged), " AND "), args...)
+		if err != nil {
+			return fmt.Errorf("failed to tag managed permissions: %v", err)
+		}
+		return nil
+	}
+
+	for _, pattern := range ManagedPatterns(clusterType) {
+		var clauses []string
+		var args []interface{}
+		for column, value := range map[string]string{"entity_name": pattern.Entity, "entity_type": pattern.EntityType, "permission_binding": pattern.Binding} {
+			if value == "" {
+				continue
+			}
+			clauses = append(clauses, column+" LIKE ?")
+			args = append(args, globToLike(value))
+		}
+		if err := tag(clauses, args); err != nil {
+			return err
+		}
+	}
+
+	// The permissions of a RoleBinding are scoped to its namespace, which tells it apart from namesakes
+	for _, binding := range defaultBindings {
+		clauses := []string{"permission_binding = ?", "permission_binding_type = ?"}
+		args := []interface{}{binding.name, binding.bindingType}
+		if binding.bindingType == "RoleBinding" {
+			clauses = append(clauses, "(permission_scope = ? OR permission_scope LIKE ?)")
+			args = append(args, binding.namespace, globToLike(binding.namespace+"/*"))
+		}
+		if err := tag(clauses, args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
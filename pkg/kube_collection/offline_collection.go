@@ -0,0 +1,488 @@
+package kube_collection
+
+import (
+	"bytes"
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Offline collection - RBAC and workloads read from YAML/JSON manifests, rendered Helm charts or `kubectl get -o yaml` dumps
+// instead of a live cluster. Permissions are calculated with the same logic as the cluster collection, using a discovery snapshot
+
+// Discovery of the built-in Kubernetes resources, used when no snapshot is exported from a cluster
+//
+//go:embed default_discovery.json
+var defaultDiscoveryJSON []byte
+
+// A workload read from the manifests, with its pod template
+type offlineWorkload struct {
+	workloadType string
+	ownerType    string
+	meta         metav1.ObjectMeta
+	template     v1.PodTemplateSpec
+}
+
+// Objects read from the manifests
+type OfflineObjects struct {
+	Namespaces          []v1.Namespace
+	Roles               map[string]*rbacv1.Role        // Keyed by namespace/name, like CollectRoles
+	ClusterRoles        map[string]*rbacv1.ClusterRole // Keyed by name, like CollectClusterRoles
+	RoleBindings        []rbacv1.RoleBinding
+	ClusterRoleBindings []rbacv1.ClusterRoleBinding
+	ServiceAccounts     map[string]*v1.ServiceAccount // Keyed by namespace/name
+	Services            []v1.Service                  // For the exposure of the workloads
+	TokenSecrets        []TokenSecret                 // ServiceAccount token secrets, for the escalation paths
+	CustomResources     []ResourceType                // Resources of the CustomResourceDefinitions in the manifests
+	Documents           int
+	Skipped             int // Documents of kinds which aren't used
+	workloads           []offlineWorkload
+}
+
+func NewOfflineObjects() *OfflineObjects {
+	return &OfflineObjects{
+		Roles:           make(map[string]*rbacv1.Role),
+		ClusterRoles:    make(map[string]*rbacv1.ClusterRole),
+		ServiceAccounts: make(map[string]*v1.ServiceAccount),
+	}
+}
+
+// Read the manifests in files and directories (recursively, .yaml, .yml and .json files)
+// Namespaced objects without a namespace are placed in defaultNamespace
+func (o *OfflineObjects) LoadPaths(paths []string, defaultNamespace string) error {
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() {
+				return nil
+			}
+			// Files passed directly are read whatever their extension
+			ext := strings.ToLower(filepath.Ext(path))
+			if path != root && ext != ".yaml" && ext != ".yml" && ext != ".json" {
+				return nil
+			}
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			return o.Load(file, path, defaultNamespace)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read manifests from %s: %v", root, err)
+		}
+	}
+	return nil
+}
+
+// Read the YAML or JSON documents of a single source, including List kinds (e.g. `kubectl get -o yaml` output)
+func (o *OfflineObjects) Load(r io.Reader, source, defaultNamespace string) error {
+	decoder := k8syaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("failed to parse %s: %v", source, err)
+		}
+		if len(raw) == 0 || string(raw) == "null" {
+			continue
+		}
+		if err := o.addObject(raw, defaultNamespace); err != nil {
+			return fmt.Errorf("failed to parse %s: %v", source, err)
+		}
+	}
+}
+
+func (o *OfflineObjects) addObject(raw json.RawMessage, defaultNamespace string) error {
+	var typeMeta metav1.TypeMeta
+	if err := json.Unmarshal(raw, &typeMeta); err != nil {
+		return err
+	}
+	o.Documents++
+
+	if strings.HasSuffix(typeMeta.Kind, "List") {
+		var list struct {
+			Items []json.RawMessage `json:"items"`
+		}
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return err
+		}
+		o.Documents--
+		for _, item := range list.Items {
+			if err := o.addObject(item, defaultNamespace); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	setNamespace := func(meta *metav1.ObjectMeta) {
+		if meta.Namespace == "" {
+			meta.Namespace = defaultNamespace
+		}
+	}
+	addWorkload := func(workloadType, ownerType string, meta metav1.ObjectMeta, template v1.PodTemplateSpec) {
+		setNamespace(&meta)
+		o.workloads = append(o.workloads, offlineWorkload{workloadType: workloadType, ownerType: ownerType, meta: meta, template: template})
+	}
+
+	switch typeMeta.GroupVersionKind().GroupKind() {
+	case schema.GroupKind{Kind: "Namespace"}:
+		var namespace v1.Namespace
+		if err := json.Unmarshal(raw, &namespace); err != nil {
+			return err
+		}
+		o.Namespaces = append(o.Namespaces, namespace)
+	case schema.GroupKind{Kind: "ServiceAccount"}:
+		var sa v1.ServiceAccount
+		if err := json.Unmarshal(raw, &sa); err != nil {
+			return err
+		}
+		setNamespace(&sa.ObjectMeta)
+		o.ServiceAccounts[sa.Namespace+"/"+sa.Name] = &sa
+	case schema.GroupKind{Kind: "Service"}:
+		var service v1.Service
+		if err := json.Unmarshal(raw, &service); err != nil {
+			return err
+		}
+		setNamespace(&service.ObjectMeta)
+		o.Services = append(o.Services, service)
+	case schema.GroupKind{Kind: "Secret"}:
+		// Only the metadata and type are kept
+		var secret struct {
+			metav1.ObjectMeta `json:"metadata"`
+			Type              v1.SecretType `json:"type"`
+		}
+		if err := json.Unmarshal(raw, &secret); err != nil {
+			return err
+		}
+		setNamespace(&secret.ObjectMeta)
+		tokenSecret, ok := tokenSecretOf(secret.ObjectMeta, secret.Type)
+		if !ok {
+			o.Skipped++
+			return nil
+		}
+		o.TokenSecrets = append(o.TokenSecrets, tokenSecret)
+	case schema.GroupKind{Group: rbacv1.GroupName, Kind: "Role"}:
+		var role rbacv1.Role
+		if err := json.Unmarshal(raw, &role); err != nil {
+			return err
+		}
+		setNamespace(&role.ObjectMeta)
+		o.Roles[fmt.Sprintf("%s/%s", role.Namespace, role.Name)] = &role
+	case schema.GroupKind{Group: rbacv1.GroupName, Kind: "ClusterRole"}:
+		var clusterRole rbacv1.ClusterRole
+		if err := json.Unmarshal(raw, &clusterRole); err != nil {
+			return err
+		}
+		o.ClusterRoles[clusterRole.Name] = &clusterRole
+	case schema.GroupKind{Group: rbacv1.GroupName, Kind: "RoleBinding"}:
+		var rb rbacv1.RoleBinding
+		if err := json.Unmarshal(raw, &rb); err != nil {
+			return err
+		}
+		setNamespace(&rb.ObjectMeta)
+		o.RoleBindings = append(o.RoleBindings, rb)
+	case schema.GroupKind{Group: rbacv1.GroupName, Kind: "ClusterRoleBinding"}:
+		var crb rbacv1.ClusterRoleBinding
+		if err := json.Unmarshal(raw, &crb); err != nil {
+			return err
+		}
+		o.ClusterRoleBindings = append(o.ClusterRoleBindings, crb)
+	case schema.GroupKind{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"}:
+		return o.addCustomResourceDefinition(raw)
+	default:
+		kind, ok := findWorkloadKind(typeMeta.APIVersion, typeMeta.Kind)
+		if !ok {
+			o.Skipped++
+			return nil
+		}
+		var object unstructured.Unstructured
+		if err := json.Unmarshal(raw, &object.Object); err != nil {
+			return err
+		}
+		templates, err := kind.podTemplates(object.Object)
+		if err != nil {
+			return fmt.Errorf("%s %s: %v", kind.Kind, object.GetName(), err)
+		}
+		meta := metav1.ObjectMeta{
+			Name:            object.GetName(),
+			Namespace:       object.GetNamespace(),
+			UID:             object.GetUID(),
+			Labels:          object.GetLabels(),
+			OwnerReferences: object.GetOwnerReferences(),
+		}
+		for _, template := range templates {
+			addWorkload(kind.Kind, strings.ToLower(kind.Kind), meta, template)
+		}
+	}
+	return nil
+}
+
+// Add the resources of a CustomResourceDefinition, in its first served version, so rules on them are flattened like in a cluster
+func (o *OfflineObjects) addCustomResourceDefinition(raw json.RawMessage) error {
+	var crd struct {
+		Spec struct {
+			Group string `json:"group"`
+			Names struct {
+				Plural string `json:"plural"`
+			} `json:"names"`
+			Scope    string `json:"scope"`
+			Versions []struct {
+				Name   string `json:"name"`
+				Served bool   `json:"served"`
+			} `json:"versions"`
+			Subresources map[string]json.RawMessage `json:"subresources"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(raw, &crd); err != nil {
+		return err
+	}
+	for _, version := range crd.Spec.Versions {
+		if !version.Served {
+			continue
+		}
+		apiGroup := fmt.Sprintf("%s/%s", crd.Spec.Group, version.Name)
+		namespaced := crd.Spec.Scope != "Cluster"
+		o.CustomResources = append(o.CustomResources, ResourceType{APIGroup: apiGroup, ResourceType: crd.Spec.Names.Plural, Namespaced: namespaced})
+		for subresource := range crd.Spec.Subresources {
+			o.CustomResources = append(o.CustomResources, ResourceType{APIGroup: apiGroup, ResourceType: crd.Spec.Names.Plural + "/" + subresource, Namespaced: namespaced})
+		}
+		break
+	}
+	return nil
+}
+
+// Fill the rules of aggregated ClusterRoles from the ClusterRoles matching their selectors, like the aggregation controller does in a cluster
+func (o *OfflineObjects) AggregateClusterRoles() error {
+	var names []string
+	for name := range o.ClusterRoles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, clusterRole := range o.ClusterRoles {
+		if clusterRole.AggregationRule == nil {
+			continue
+		}
+		for _, labelSelector := range clusterRole.AggregationRule.ClusterRoleSelectors {
+			selector, err := metav1.LabelSelectorAsSelector(&labelSelector)
+			if err != nil {
+				return fmt.Errorf("invalid aggregation rule in ClusterRole %s: %v", clusterRole.Name, err)
+			}
+			for _, name := range names {
+				source := o.ClusterRoles[name]
+				if source != clusterRole && source.AggregationRule == nil && selector.Matches(labels.Set(source.Labels)) {
+					clusterRole.Rules = append(clusterRole.Rules, source.Rules...)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// The namespaces in the manifests - the Namespace objects and the namespaces of the namespaced objects
+func (o *OfflineObjects) AllNamespaces() []v1.Namespace {
+	seen := make(map[string]bool)
+	var namespaces []v1.Namespace
+	add := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			namespaces = append(namespaces, v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}})
+		}
+	}
+	for _, namespace := range o.Namespaces {
+		add(namespace.Name)
+	}
+	for _, role := range o.Roles {
+		add(role.Namespace)
+	}
+	for _, rb := range o.RoleBindings {
+		add(rb.Namespace)
+	}
+	for _, sa := range o.ServiceAccounts {
+		add(sa.Namespace)
+	}
+	for _, workload := range o.workloads {
+		add(workload.meta.Namespace)
+	}
+	sort.Slice(namespaces, func(i, j int) bool { return namespaces[i].Name < namespaces[j].Name })
+	return namespaces
+}
+
+// The ServiceAccounts and Services of a namespace, for the security attributes of its workloads
+func (o *OfflineObjects) workloadContext(namespace, clusterType string) workloadContext {
+	wctx := workloadContext{serviceAccounts: make(map[string]*v1.ServiceAccount)}
+	for _, sa := range o.ServiceAccounts {
+		if sa.Namespace == namespace {
+			wctx.serviceAccounts[sa.Name] = sa
+		}
+	}
+	for _, service := range o.Services {
+		if service.Namespace == namespace {
+			wctx.services = append(wctx.services, service)
+		}
+	}
+	// EKS Pod Identity associations aren't Kubernetes objects, only the annotations are read
+	wctx.addServiceAccountIdentities(clusterType, nil)
+	return wctx
+}
+
+// Find owners among the workloads of the manifests
+func (o *OfflineObjects) ownerLookup() ownerLookup {
+	owners := make(map[string]*metav1.ObjectMeta)
+	for i := range o.workloads {
+		workload := &o.workloads[i]
+		owners[workload.meta.Namespace+"/"+workload.workloadType+"/"+workload.meta.Name] = &workload.meta
+	}
+	return func(namespace string, ref metav1.OwnerReference) (metav1.Object, bool) {
+		owner, ok := owners[namespace+"/"+ref.Kind+"/"+ref.Name]
+		if !ok || (ref.UID != "" && owner.UID != "" && owner.UID != ref.UID) {
+			return nil, false
+		}
+		return owner, true
+	}
+}
+
+// Insert the workloads into the DB. Pod templates without a ServiceAccount run with the namespace's default ServiceAccount
+// The cloud identities are resolved for the mechanisms of the cluster type
+func (o *OfflineObjects) StoreWorkloads(db *sql.DB, clusterType string) (int, error) {
+	var identities []ServiceAccountIdentity
+	for _, namespace := range o.AllNamespaces() {
+		identities = append(identities, o.workloadContext(namespace.Name, clusterType).serviceAccountIdentities()...)
+	}
+	if err := StoreServiceAccountIdentities(db, identities); err != nil {
+		return 0, fmt.Errorf("error inserting service account identities: %v", err)
+	}
+
+	stmt, err := prepareWorkloadStatement(db)
+	if err != nil {
+		return 0, fmt.Errorf("error preparing statement: %v", err)
+	}
+	defer stmt.Close()
+
+	owners := o.ownerLookup()
+	var workloads []WorkloadInfo
+	for _, workload := range o.workloads {
+		saName := workload.template.Spec.ServiceAccountName
+		if saName == "" {
+			saName = "default"
+		}
+		wctx := o.workloadContext(workload.meta.Namespace, clusterType)
+		wctx.owners = owners
+		ownerType, ownerName := wctx.ownerInfo(&workload.meta, workload.ownerType)
+		workloads = append(workloads, WorkloadInfo{
+			WorkloadType:       workload.workloadType,
+			WorkloadName:       workload.meta.Name,
+			ServiceAccountName: fmt.Sprintf("%s:%s", workload.meta.Namespace, saName),
+			WorkloadIdentity:   wctx.workloadIdentity(workload.template),
+			OriginalOwnerType:  ownerType,
+			OriginalOwnerName:  ownerName,
+			Security:           getWorkloadSecurity(workload.template, wctx),
+		})
+	}
+
+	// Custom resources may have several pod templates with the same ServiceAccount
+	workloads = mergeWorkloads(workloads)
+	for _, info := range workloads {
+		_, err := stmt.Exec(info.insertArgs()...)
+		if err != nil {
+			return 0, fmt.Errorf("error inserting %s workload: %v", strings.ToLower(info.WorkloadType), err)
+		}
+	}
+	return len(workloads), nil
+}
+
+// Render a Helm chart with the helm binary, including its CRDs
+func RenderHelmChart(chart, release, namespace string, valuesFiles []string) ([]byte, error) {
+	args := []string{"template", release, chart, "--namespace", namespace, "--include-crds"}
+	for _, valuesFile := range valuesFiles {
+		args = append(args, "--values", valuesFile)
+	}
+	var stdout, stderr bytes.Buffer
+	command := exec.Command("helm", args...)
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	if err := command.Run(); err != nil {
+		return nil, fmt.Errorf("failed to render helm chart %s: %v %s", chart, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// Read a discovery snapshot exported with export-discovery, or the bundled snapshot of the built-in resources when the path is empty
+func LoadDiscoverySnapshot(path string) ([]*metav1.APIResourceList, error) {
+	data := defaultDiscoveryJSON
+	if path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read discovery snapshot: %v", err)
+		}
+	}
+	var apiResourceLists []*metav1.APIResourceList
+	if err := json.Unmarshal(data, &apiResourceLists); err != nil {
+		return nil, fmt.Errorf("failed to parse discovery snapshot: %v", err)
+	}
+	return apiResourceLists, nil
+}
+
+// Get the preferred resources of the cluster, for an offline collection of manifests of the same cluster
+func ExportDiscoverySnapshot(client *kubernetes.Clientset) ([]*metav1.APIResourceList, error) {
+	apiResourceLists, err := client.Discovery().ServerPreferredResources()
+	if err != nil {
+		if discovery.IsGroupDiscoveryFailedError(err) {
+			fmt.Println("Warning: Some API groups failed to load, but continuing...")
+		} else {
+			return nil, err
+		}
+	}
+	return apiResourceLists, nil
+}
+
+// Get the resource types and subresources from a discovery snapshot, with the custom resources of the manifests
+func OfflineResources(apiResourceLists []*metav1.APIResourceList, customResources []ResourceType) ([]ResourceType, map[string]string, error) {
+	resourceTypes, err := ResourceTypesFromDiscovery(apiResourceLists)
+	if err != nil {
+		return nil, nil, err
+	}
+	subresources, err := SubresourcesFromDiscovery(apiResourceLists)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	known := make(map[string]bool)
+	for _, rt := range resourceTypes {
+		known[rt.APIGroup+"/"+rt.ResourceType] = true
+	}
+	for _, rt := range customResources {
+		if known[rt.APIGroup+"/"+rt.ResourceType] {
+			continue
+		}
+		resourceTypes = append(resourceTypes, rt)
+		if strings.Contains(rt.ResourceType, "/") {
+			subresources[rt.ResourceType] = rt.APIGroup
+		}
+	}
+	return resourceTypes, subresources, nil
+}
@@ -0,0 +1,241 @@
+package kube_collection
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Dangling and orphaned RBAC - bindings referencing roles, ServiceAccounts or namespaces which don't exist, and roles
+// which no binding references. A dangling binding grants its permissions to whoever recreates the missing object
+
+const (
+	MissingRoleFinding           = "missing-role"
+	MissingServiceAccountFinding = "missing-service-account"
+	DeletedNamespaceFinding      = "deleted-namespace"
+	InvalidSubjectFinding        = "invalid-subject"
+	UnboundRoleFinding           = "unbound-role"
+)
+
+type OrphanedRBAC struct {
+	FindingType      string
+	ObjectType       string // RoleBinding, ClusterRoleBinding, Role or ClusterRole
+	ObjectName       string
+	ObjectNamespace  string // Empty for cluster scoped objects
+	RoleType         string // The role of a binding
+	RoleName         string
+	MissingType      string // The object which doesn't exist (or can't, for invalid subjects) - Role, ClusterRole, ServiceAccount or Namespace
+	MissingName      string
+	MissingNamespace string
+}
+
+// Find the dangling bindings and unbound roles of a complete set of RBAC objects
+// serviceAccounts and namespaces are the names of the existing objects, ServiceAccounts keyed by namespace/name
+func FindOrphanedRBAC(
+	roles map[string]*rbacv1.Role,
+	clusterRoles map[string]*rbacv1.ClusterRole,
+	roleBindings []rbacv1.RoleBinding,
+	clusterRoleBindings []rbacv1.ClusterRoleBinding,
+	serviceAccounts map[string]bool,
+	namespaces map[string]bool,
+) []OrphanedRBAC {
+	var findings []OrphanedRBAC
+	boundRoles := make(map[string]bool)
+	boundClusterRoles := make(map[string]bool)
+
+	checkBinding := func(finding OrphanedRBAC, roleRef rbacv1.RoleRef, subjects []rbacv1.Subject) {
+		finding.RoleType, finding.RoleName = roleRef.Kind, roleRef.Name
+		roleExists := false
+		if roleRef.Kind == "Role" {
+			key := fmt.Sprintf("%s/%s", finding.ObjectNamespace, roleRef.Name)
+			boundRoles[key] = true
+			_, roleExists = roles[key]
+		} else {
+			boundClusterRoles[roleRef.Name] = true
+			_, roleExists = clusterRoles[roleRef.Name]
+		}
+		if !roleExists {
+			missing := finding
+			missing.FindingType = MissingRoleFinding
+			missing.MissingType, missing.MissingName = roleRef.Kind, roleRef.Name
+			if roleRef.Kind == "Role" {
+				missing.MissingNamespace = finding.ObjectNamespace
+			}
+			findings = append(findings, missing)
+		}
+
+		for _, subject := range subjects {
+			if subject.Kind != "ServiceAccount" {
+				continue
+			}
+			namespace := subject.Namespace
+			if namespace == "" {
+				namespace = finding.ObjectNamespace
+			}
+			missing := finding
+			if namespace == "" {
+				// A ClusterRoleBinding ServiceAccount subject must set its namespace, the apiserver rejects it otherwise
+				missing.FindingType = InvalidSubjectFinding
+				missing.MissingType, missing.MissingName = "ServiceAccount", subject.Name
+			} else if !namespaces[namespace] {
+				missing.FindingType = DeletedNamespaceFinding
+				missing.MissingType, missing.MissingName = "Namespace", namespace
+			} else if !serviceAccounts[namespace+"/"+subject.Name] {
+				missing.FindingType = MissingServiceAccountFinding
+				missing.MissingType, missing.MissingName, missing.MissingNamespace = "ServiceAccount", subject.Name, namespace
+			} else {
+				continue
+			}
+			findings = append(findings, missing)
+		}
+	}
+
+	for _, rb := range roleBindings {
+		checkBinding(OrphanedRBAC{ObjectType: "RoleBinding", ObjectName: rb.Name, ObjectNamespace: rb.Namespace}, rb.RoleRef, rb.Subjects)
+	}
+	for _, crb := range clusterRoleBindings {
+		checkBinding(OrphanedRBAC{ObjectType: "ClusterRoleBinding", ObjectName: crb.Name}, crb.RoleRef, crb.Subjects)
+	}
+
+	for key, role := range roles {
+		if !boundRoles[key] && role.Labels["kubernetes.io/bootstrapping"] != "rbac-defaults" {
+			findings = append(findings, OrphanedRBAC{FindingType: UnboundRoleFinding, ObjectType: "Role", ObjectName: role.Name, ObjectNamespace: role.Namespace})
+		}
+	}
+	for name, clusterRole := range clusterRoles {
+		if !boundClusterRoles[name] && !isDefaultOrAggregatedClusterRole(clusterRole, clusterRoles) {
+			findings = append(findings, OrphanedRBAC{FindingType: UnboundRoleFinding, ObjectType: "ClusterRole", ObjectName: name})
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if a.FindingType != b.FindingType {
+			return a.FindingType < b.FindingType
+		}
+		if a.ObjectType != b.ObjectType {
+			return a.ObjectType < b.ObjectType
+		}
+		if a.ObjectNamespace != b.ObjectNamespace {
+			return a.ObjectNamespace < b.ObjectNamespace
+		}
+		return a.ObjectName < b.ObjectName
+	})
+	return findings
+}
+
+// ClusterRoles which are expected to have no bindings - the bootstrap roles of the apiserver, and the roles which are
+// only used through the aggregation rule of another ClusterRole
+func isDefaultOrAggregatedClusterRole(clusterRole *rbacv1.ClusterRole, clusterRoles map[string]*rbacv1.ClusterRole) bool {
+	if clusterRole.Labels["kubernetes.io/bootstrapping"] == "rbac-defaults" {
+		return true
+	}
+	for label := range clusterRole.Labels {
+		if strings.HasPrefix(label, "rbac.authorization.k8s.io/aggregate-to-") {
+			return true
+		}
+	}
+	for _, aggregating := range clusterRoles {
+		if aggregating.AggregationRule == nil {
+			continue
+		}
+		for _, selector := range aggregating.AggregationRule.ClusterRoleSelectors {
+			s, err := metav1.LabelSelectorAsSelector(&selector)
+			if err == nil && !s.Empty() && s.Matches(labels.Set(clusterRole.Labels)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// List the bindings, ServiceAccounts and namespaces of the cluster, and store the dangling bindings and unbound roles
+func CollectOrphanedRBAC(
+	client *kubernetes.Clientset,
+	db *sql.DB,
+	roles map[string]*rbacv1.Role,
+	clusterRoles map[string]*rbacv1.ClusterRole,
+) (int, error) {
+	roleBindings, err := client.RbacV1().RoleBindings(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return 0, err
+	}
+	clusterRoleBindings, err := client.RbacV1().ClusterRoleBindings().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return 0, err
+	}
+	serviceAccountList, err := client.CoreV1().ServiceAccounts(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return 0, err
+	}
+	namespaceList, err := client.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return 0, err
+	}
+
+	serviceAccounts := make(map[string]bool)
+	for _, sa := range serviceAccountList.Items {
+		serviceAccounts[sa.Namespace+"/"+sa.Name] = true
+	}
+	findings := FindOrphanedRBAC(roles, clusterRoles, roleBindings.Items, clusterRoleBindings.Items, serviceAccounts, namespaceNames(namespaceList.Items))
+	return len(findings), StoreOrphanedRBAC(db, findings)
+}
+
+// Find and store the dangling bindings and unbound roles of objects read without a cluster
+// Only meaningful for complete dumps (e.g. snapshots) - partial manifests reference objects defined elsewhere
+func (o *OfflineObjects) StoreOrphanedRBAC(db *sql.DB) (int, error) {
+	serviceAccounts := make(map[string]bool)
+	for key := range o.ServiceAccounts {
+		serviceAccounts[key] = true
+	}
+	findings := FindOrphanedRBAC(o.Roles, o.ClusterRoles, o.RoleBindings, o.ClusterRoleBindings, serviceAccounts, namespaceNames(o.Namespaces))
+	return len(findings), StoreOrphanedRBAC(db, findings)
+}
+
+func namespaceNames(namespaces []v1.Namespace) map[string]bool {
+	names := make(map[string]bool)
+	for _, namespace := range namespaces {
+		names[namespace.Name] = true
+	}
+	return names
+}
+
+// Insert the findings into the orphaned_rbac table
+func StoreOrphanedRBAC(db *sql.DB, findings []OrphanedRBAC) error {
+	if len(findings) == 0 {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO rufus.orphaned_rbac (
+			finding_type, object_type, object_name, object_namespace, role_type, role_name,
+			missing_type, missing_name, missing_namespace
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, f := range findings {
+		_, err := stmt.Exec(f.FindingType, f.ObjectType, f.ObjectName, f.ObjectNamespace, f.RoleType, f.RoleName,
+			f.MissingType, f.MissingName, f.MissingNamespace)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
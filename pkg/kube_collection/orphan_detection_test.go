@@ -0,0 +1,123 @@
+package kube_collection
+
+import (
+	"reflect"
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func serviceAccountSubject(namespace, name string) rbacv1.Subject {
+	return rbacv1.Subject{Kind: "ServiceAccount", Name: name, Namespace: namespace}
+}
+
+func TestFindOrphanedRBAC(t *testing.T) {
+	roles := map[string]*rbacv1.Role{
+		"payments/reader": {ObjectMeta: metav1.ObjectMeta{Name: "reader", Namespace: "payments"}},
+		"payments/unused": {ObjectMeta: metav1.ObjectMeta{Name: "unused", Namespace: "payments"}},
+	}
+	clusterRoles := map[string]*rbacv1.ClusterRole{
+		"view":     {ObjectMeta: metav1.ObjectMeta{Name: "view", Labels: map[string]string{"kubernetes.io/bootstrapping": "rbac-defaults"}}},
+		"old-view": {ObjectMeta: metav1.ObjectMeta{Name: "old-view"}},
+	}
+	roleBindings := []rbacv1.RoleBinding{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "reader", Namespace: "payments"},
+			RoleRef:    rbacv1.RoleRef{Kind: "Role", Name: "reader"},
+			Subjects:   []rbacv1.Subject{{Kind: "ServiceAccount", Name: "api"}, {Kind: "User", Name: "alice"}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "writer", Namespace: "payments"},
+			RoleRef:    rbacv1.RoleRef{Kind: "Role", Name: "writer"},
+			Subjects:   []rbacv1.Subject{serviceAccountSubject("payments", "worker")},
+		},
+	}
+	clusterRoleBindings := []rbacv1.ClusterRoleBinding{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "billing-view"},
+			RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "view"},
+			Subjects:   []rbacv1.Subject{serviceAccountSubject("billing", "api"), {Kind: "ServiceAccount", Name: "ci"}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "edit"},
+			RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "edit"},
+			Subjects:   []rbacv1.Subject{{Kind: "Group", Name: "devs"}},
+		},
+	}
+	serviceAccounts := map[string]bool{"payments/api": true}
+	namespaces := map[string]bool{"payments": true}
+
+	got := FindOrphanedRBAC(roles, clusterRoles, roleBindings, clusterRoleBindings, serviceAccounts, namespaces)
+	want := []OrphanedRBAC{
+		{FindingType: DeletedNamespaceFinding, ObjectType: "ClusterRoleBinding", ObjectName: "billing-view", RoleType: "ClusterRole", RoleName: "view",
+			MissingType: "Namespace", MissingName: "billing"},
+		{FindingType: InvalidSubjectFinding, ObjectType: "ClusterRoleBinding", ObjectName: "billing-view", RoleType: "ClusterRole", RoleName: "view",
+			MissingType: "ServiceAccount", MissingName: "ci"},
+		{FindingType: MissingRoleFinding, ObjectType: "ClusterRoleBinding", ObjectName: "edit", RoleType: "ClusterRole", RoleName: "edit",
+			MissingType: "ClusterRole", MissingName: "edit"},
+		{FindingType: MissingRoleFinding, ObjectType: "RoleBinding", ObjectName: "writer", ObjectNamespace: "payments", RoleType: "Role", RoleName: "writer",
+			MissingType: "Role", MissingName: "writer", MissingNamespace: "payments"},
+		{FindingType: MissingServiceAccountFinding, ObjectType: "RoleBinding", ObjectName: "writer", ObjectNamespace: "payments", RoleType: "Role", RoleName: "writer",
+			MissingType: "ServiceAccount", MissingName: "worker", MissingNamespace: "payments"},
+		{FindingType: UnboundRoleFinding, ObjectType: "ClusterRole", ObjectName: "old-view"},
+		{FindingType: UnboundRoleFinding, ObjectType: "Role", ObjectName: "unused", ObjectNamespace: "payments"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FindOrphanedRBAC() =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestIsDefaultOrAggregatedClusterRole(t *testing.T) {
+	clusterRoles := map[string]*rbacv1.ClusterRole{
+		"monitoring": {
+			ObjectMeta: metav1.ObjectMeta{Name: "monitoring"},
+			AggregationRule: &rbacv1.AggregationRule{ClusterRoleSelectors: []metav1.LabelSelector{
+				{MatchLabels: map[string]string{"example.com/aggregate-to-monitoring": "true"}},
+			}},
+		},
+		"everything": {
+			ObjectMeta:      metav1.ObjectMeta{Name: "everything"},
+			AggregationRule: &rbacv1.AggregationRule{ClusterRoleSelectors: []metav1.LabelSelector{{}}},
+		},
+	}
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   bool
+	}{
+		{"bootstrap role", map[string]string{"kubernetes.io/bootstrapping": "rbac-defaults"}, true},
+		{"aggregated into a default role", map[string]string{"rbac.authorization.k8s.io/aggregate-to-view": "true"}, true},
+		{"selected by an aggregation rule", map[string]string{"example.com/aggregate-to-monitoring": "true"}, true},
+		{"not selected by an aggregation rule", map[string]string{"example.com/aggregate-to-monitoring": "false"}, false},
+		{"empty selectors select nothing", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clusterRole := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "tested", Labels: tt.labels}}
+			if got := isDefaultOrAggregatedClusterRole(clusterRole, clusterRoles); got != tt.want {
+				t.Errorf("isDefaultOrAggregatedClusterRole() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindOrphanedRBACAggregatedClusterRole(t *testing.T) {
+	clusterRoles := map[string]*rbacv1.ClusterRole{
+		"monitoring": {
+			ObjectMeta: metav1.ObjectMeta{Name: "monitoring"},
+			AggregationRule: &rbacv1.AggregationRule{ClusterRoleSelectors: []metav1.LabelSelector{
+				{MatchLabels: map[string]string{"example.com/aggregate-to-monitoring": "true"}},
+			}},
+		},
+		"monitoring-metrics": {ObjectMeta: metav1.ObjectMeta{Name: "monitoring-metrics", Labels: map[string]string{"example.com/aggregate-to-monitoring": "true"}}},
+	}
+	clusterRoleBindings := []rbacv1.ClusterRoleBinding{{
+		ObjectMeta: metav1.ObjectMeta{Name: "monitoring"},
+		RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "monitoring"},
+		Subjects:   []rbacv1.Subject{{Kind: "Group", Name: "sre"}},
+	}}
+	if got := FindOrphanedRBAC(nil, clusterRoles, nil, clusterRoleBindings, nil, nil); len(got) != 0 {
+		t.Errorf("FindOrphanedRBAC() = %+v, want no findings", got)
+	}
+}
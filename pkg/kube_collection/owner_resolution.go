@@ -0,0 +1,100 @@
+package kube_collection
+
+import (
+	"context"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// Finds the object an ownerReference points to. The namespace is the one of the object holding the reference
+type ownerLookup func(namespace string, ref metav1.OwnerReference) (metav1.Object, bool)
+
+// Owner chains are cut after this many references, in case of reference loops
+const maxOwnerDepth = 10
+
+// Get the top-level controller of an object by following its ownerReferences transitively, e.g. Pod -> ReplicaSet ->
+// Deployment, or ReplicaSet -> Rollout. When an owner can't be found (deleted, or not readable) the chain ends at its
+// reference. Objects without owners are their own owner, with resourceType as the type
+func resolveOwner(obj metav1.Object, resourceType string, lookup ownerLookup) (string, string) {
+	ownerType, ownerName := resourceType, obj.GetName()
+	current := obj
+	for depth := 0; depth < maxOwnerDepth; depth++ {
+		ref, ok := controllerReference(current)
+		if !ok {
+			break
+		}
+		ownerType, ownerName = ref.Kind, ref.Name
+		if lookup == nil {
+			break
+		}
+		owner, found := lookup(obj.GetNamespace(), ref)
+		if !found {
+			break
+		}
+		current = owner
+	}
+	return ownerType, ownerName
+}
+
+// The controller reference of an object, or its first owner when none of them is the controller
+func controllerReference(obj metav1.Object) (metav1.OwnerReference, bool) {
+	refs := obj.GetOwnerReferences()
+	if len(refs) == 0 {
+		return metav1.OwnerReference{}, false
+	}
+	for _, ref := range refs {
+		if ref.Controller != nil && *ref.Controller {
+			return ref, true
+		}
+	}
+	return refs[0], true
+}
+
+// Gets owners from the cluster with the dynamic client. Owners are cached, as the same controllers own many objects
+type clusterOwnerLookup struct {
+	dyn    dynamic.Interface
+	mapper meta.RESTMapper
+	cache  map[string]*unstructured.Unstructured
+}
+
+func newClusterOwnerLookup(dyn dynamic.Interface, mapper meta.RESTMapper) ownerLookup {
+	l := &clusterOwnerLookup{dyn: dyn, mapper: mapper, cache: make(map[string]*unstructured.Unstructured)}
+	return l.get
+}
+
+func (l *clusterOwnerLookup) get(namespace string, ref metav1.OwnerReference) (metav1.Object, bool) {
+	key := strings.Join([]string{namespace, ref.APIVersion, ref.Kind, ref.Name}, "/")
+	owner, ok := l.cache[key]
+	if !ok {
+		owner = l.fetch(namespace, ref)
+		l.cache[key] = owner
+	}
+	// A recreated owner with the same name isn't the owner of the reference
+	if owner == nil || (ref.UID != "" && owner.GetUID() != ref.UID) {
+		return nil, false
+	}
+	return owner, true
+}
+
+func (l *clusterOwnerLookup) fetch(namespace string, ref metav1.OwnerReference) *unstructured.Unstructured {
+	gvk := schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind)
+	mapping, err := l.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil
+	}
+	var owner *unstructured.Unstructured
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		owner, err = l.dyn.Resource(mapping.Resource).Namespace(namespace).Get(context.TODO(), ref.Name, metav1.GetOptions{})
+	} else {
+		owner, err = l.dyn.Resource(mapping.Resource).Get(context.TODO(), ref.Name, metav1.GetOptions{})
+	}
+	if err != nil {
+		return nil
+	}
+	return owner
+}
@@ -0,0 +1,114 @@
+package kube_collection
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func ownedBy(name string, refs ...metav1.OwnerReference) *metav1.ObjectMeta {
+	return &metav1.ObjectMeta{Name: name, Namespace: "payments", OwnerReferences: refs}
+}
+
+func ownerRef(kind, name string, controller bool) metav1.OwnerReference {
+	return metav1.OwnerReference{APIVersion: "apps/v1", Kind: kind, Name: name, Controller: &controller}
+}
+
+// Looks up owners among objects keyed by kind/name
+func mapLookup(objects map[string]metav1.Object) ownerLookup {
+	return func(namespace string, ref metav1.OwnerReference) (metav1.Object, bool) {
+		owner, ok := objects[ref.Kind+"/"+ref.Name]
+		return owner, ok
+	}
+}
+
+func TestResolveOwner(t *testing.T) {
+	replicaSet := ownedBy("api-5d8f", ownerRef("Deployment", "api", true))
+	deployment := ownedBy("api")
+	pod := ownedBy("api-5d8f-x2k", ownerRef("Node", "node-1", false), ownerRef("ReplicaSet", "api-5d8f", true))
+	orphan := ownedBy("api-0", ownerRef("StatefulSet", "deleted", true))
+
+	lookup := mapLookup(map[string]metav1.Object{"ReplicaSet/api-5d8f": replicaSet, "Deployment/api": deployment})
+	tests := []struct {
+		name      string
+		obj       metav1.Object
+		lookup    ownerLookup
+		wantType  string
+		wantOwner string
+	}{
+		{"no owners", deployment, lookup, "deployment", "api"},
+		{"controller chain", pod, lookup, "Deployment", "api"},
+		{"missing owner", orphan, lookup, "StatefulSet", "deleted"},
+		{"without lookup", pod, nil, "ReplicaSet", "api-5d8f"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ownerType, ownerName := resolveOwner(tt.obj, "deployment", tt.lookup)
+			if ownerType != tt.wantType || ownerName != tt.wantOwner {
+				t.Errorf("resolveOwner() = %s %s, want %s %s", ownerType, ownerName, tt.wantType, tt.wantOwner)
+			}
+		})
+	}
+}
+
+func TestResolveOwnerLoop(t *testing.T) {
+	// a owns b owns a - the chain is cut after maxOwnerDepth lookups
+	objects := map[string]metav1.Object{
+		"ReplicaSet/a": ownedBy("a", ownerRef("ReplicaSet", "b", true)),
+		"ReplicaSet/b": ownedBy("b", ownerRef("ReplicaSet", "a", true)),
+	}
+	lookups := 0
+	lookup := func(namespace string, ref metav1.OwnerReference) (metav1.Object, bool) {
+		lookups++
+		return mapLookup(objects)(namespace, ref)
+	}
+	ownerType, ownerName := resolveOwner(objects["ReplicaSet/a"], "replicaset", lookup)
+	if lookups != maxOwnerDepth {
+		t.Errorf("resolveOwner() made %d lookups, want %d", lookups, maxOwnerDepth)
+	}
+	if ownerType != "ReplicaSet" || ownerName != "a" {
+		t.Errorf("resolveOwner() = %s %s, want the owner at the cutoff, ReplicaSet a", ownerType, ownerName)
+	}
+}
+
+func TestClusterOwnerLookup(t *testing.T) {
+	gvk := schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(gvk, meta.RESTScopeNamespace)
+
+	deployment := &unstructured.Unstructured{}
+	deployment.SetGroupVersionKind(gvk)
+	deployment.SetNamespace("payments")
+	deployment.SetName("api")
+	deployment.SetUID("new-uid")
+	dyn := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), deployment)
+	lookup := newClusterOwnerLookup(dyn, mapper)
+
+	tests := []struct {
+		name string
+		ref  metav1.OwnerReference
+		want bool
+	}{
+		{"same UID", metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "api", UID: "new-uid"}, true},
+		{"reference without UID", metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "api"}, true},
+		{"recreated owner", metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "api", UID: "old-uid"}, false},
+		{"missing owner", metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "web"}, false},
+		{"unknown kind", metav1.OwnerReference{APIVersion: "example.com/v1", Kind: "Worker", Name: "api"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			owner, found := lookup("payments", tt.ref)
+			if found != tt.want {
+				t.Errorf("lookup() found = %v, want %v", found, tt.want)
+			}
+			if found && owner.GetName() != "api" {
+				t.Errorf("lookup() = %s, want api", owner.GetName())
+			}
+		})
+	}
+}
@@ -0,0 +1,446 @@
+package kube_collection
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Cluster snapshots - the discovery results, RBAC objects, namespaces, ServiceAccounts, ServiceAccount token secret names
+// and workloads (the objects of the workload kinds, built-in and custom) of a cluster in a
+// versioned tarball, so the collection can be imported into the DB on another machine without cluster credentials
+//
+// Layout of the archive:
+//   manifest.json             - SnapshotManifest
+//   discovery/preferred.json  - preferred resources, for the resource types (GetResourceTypesAndAPIGroups)
+//   discovery/all.json        - resources of all the versions, for the subresources (GetSubresources)
+//   objects/<resource>.json   - a List of the objects of each collected resource
+
+// Version of the archive layout, raised on incompatible changes
+const SnapshotVersion = 1
+
+type SnapshotManifest struct {
+	Version     int            `json:"version"`
+	CreatedAt   time.Time      `json:"createdAt"`
+	ClusterType string         `json:"clusterType"`
+	Counts      map[string]int `json:"counts"` // Objects per resource
+}
+
+type Snapshot struct {
+	Manifest           SnapshotManifest
+	PreferredResources []*metav1.APIResourceList
+	AllResources       []*metav1.APIResourceList
+	Objects            *OfflineObjects
+}
+
+// A collected resource - its file in the archive, the type of its objects and how to list them
+// Workloads are exported separately, with the dynamic client (see ExportSnapshot)
+type snapshotResource struct {
+	name       string
+	apiVersion string
+	kind       string
+	list       func(ctx context.Context, client *kubernetes.Clientset) (interface{}, error)
+}
+
+var snapshotResources = []snapshotResource{
+	{"namespaces", "v1", "Namespace", func(ctx context.Context, client *kubernetes.Clientset) (interface{}, error) {
+		list, err := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return list.Items, nil
+	}},
+	{"serviceaccounts", "v1", "ServiceAccount", func(ctx context.Context, client *kubernetes.Clientset) (interface{}, error) {
+		list, err := client.CoreV1().ServiceAccounts(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return list.Items, nil
+	}},
+	{"services", "v1", "Service", func(ctx context.Context, client *kubernetes.Clientset) (interface{}, error) {
+		list, err := client.CoreV1().Services(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return list.Items, nil
+	}},
+	{"roles", "rbac.authorization.k8s.io/v1", "Role", func(ctx context.Context, client *kubernetes.Clientset) (interface{}, error) {
+		list, err := client.RbacV1().Roles(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return list.Items, nil
+	}},
+	{"clusterroles", "rbac.authorization.k8s.io/v1", "ClusterRole", func(ctx context.Context, client *kubernetes.Clientset) (interface{}, error) {
+		list, err := client.RbacV1().ClusterRoles().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return list.Items, nil
+	}},
+	{"rolebindings", "rbac.authorization.k8s.io/v1", "RoleBinding", func(ctx context.Context, client *kubernetes.Clientset) (interface{}, error) {
+		list, err := client.RbacV1().RoleBindings(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return list.Items, nil
+	}},
+	{"clusterrolebindings", "rbac.authorization.k8s.io/v1", "ClusterRoleBinding", func(ctx context.Context, client *kubernetes.Clientset) (interface{}, error) {
+		list, err := client.RbacV1().ClusterRoleBindings().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return list.Items, nil
+	}},
+}
+
+// Fields of a pod template which the workload collection reads, copied into snapshots with a transformation of their value
+// Snapshots are handed to analysts without cluster credentials - the rest of the workload objects (commands, environment
+// values, annotations such as the last applied configuration) may hold credentials
+var snapshotTemplateFields = []struct {
+	path      string
+	transform func(interface{}) interface{}
+}{
+	{"metadata.labels", nil},
+	{"spec.serviceAccountName", nil},
+	{"spec.automountServiceAccountToken", nil},
+	{"spec.hostNetwork", nil},
+	{"spec.hostPID", nil},
+	{"spec.volumes.*.hostPath", func(interface{}) interface{} { return map[string]interface{}{} }},
+	{"spec.initContainers.*.securityContext.privileged", nil},
+	{"spec.containers.*.securityContext.privileged", nil},
+	{"spec.initContainers.*.env", azureClientIDEnv},
+	{"spec.containers.*.env", azureClientIDEnv},
+}
+
+// Metadata of workload objects kept in snapshots, for the owner chains
+var snapshotMetadataFields = []string{"metadata.name", "metadata.namespace", "metadata.uid", "metadata.labels", "metadata.ownerReferences"}
+
+// Only the AZURE_CLIENT_ID variable of a container's environment is read, for Azure Workload Identity
+func azureClientIDEnv(value interface{}) interface{} {
+	env, _ := value.([]interface{})
+	var kept []interface{}
+	for _, item := range env {
+		if variable, ok := item.(map[string]interface{}); ok && variable["name"] == "AZURE_CLIENT_ID" {
+			kept = append(kept, map[string]interface{}{"name": variable["name"], "value": variable["value"]})
+		}
+	}
+	if kept == nil {
+		return nil
+	}
+	return kept
+}
+
+// Copy the value at a path of src into dst, creating the maps and lists along the path (* matches every item of a list
+// or value of a map, like in valuesAtPath). Returns dst with the value set, or unchanged when src has no value there
+func copyPath(src, dst interface{}, segments []string, transform func(interface{}) interface{}) interface{} {
+	if len(segments) == 0 {
+		if transform != nil {
+			src = transform(src)
+		}
+		if src == nil {
+			return dst
+		}
+		return src
+	}
+	switch v := src.(type) {
+	case map[string]interface{}:
+		d, _ := dst.(map[string]interface{})
+		for key, value := range v {
+			if segments[0] != "*" && segments[0] != key {
+				continue
+			}
+			if copied := copyPath(value, d[key], segments[1:], transform); copied != nil {
+				if d == nil {
+					d = make(map[string]interface{})
+				}
+				d[key] = copied
+			}
+		}
+		if d == nil {
+			return dst
+		}
+		return d
+	case []interface{}:
+		if segments[0] != "*" {
+			return dst
+		}
+		// Items keep their positions, so the fields of an item copied by several paths stay together
+		d, _ := dst.([]interface{})
+		if len(d) != len(v) {
+			d = make([]interface{}, len(v))
+		}
+		for i, item := range v {
+			d[i] = copyPath(item, d[i], segments[1:], transform)
+			if _, isMap := item.(map[string]interface{}); isMap && d[i] == nil {
+				d[i] = map[string]interface{}{}
+			}
+		}
+		return d
+	}
+	return dst
+}
+
+// Strip a workload object down to its metadata, the read fields of the pod templates at the template paths and the
+// names at the ServiceAccount paths of its kind
+func stripWorkload(object map[string]interface{}, kind WorkloadKind) map[string]interface{} {
+	var stripped interface{} = map[string]interface{}{"apiVersion": object["apiVersion"], "kind": object["kind"]}
+	for _, field := range append(append([]string{}, snapshotMetadataFields...), kind.ServiceAccountPaths...) {
+		stripped = copyPath(object, stripped, pathSegments(field), nil)
+	}
+	for _, templatePath := range kind.TemplatePaths {
+		for _, field := range snapshotTemplateFields {
+			segments := append(pathSegments(templatePath), pathSegments(field.path)...)
+			stripped = copyPath(object, stripped, segments, field.transform)
+		}
+	}
+	return stripped.(map[string]interface{})
+}
+
+// Build a List document of the objects - list responses don't set the type of their items, and the managed fields aren't needed
+// Objects of a workload kind are stripped (see stripWorkload)
+func snapshotList(apiVersion, kind string, items interface{}, workload *WorkloadKind) ([]byte, int, error) {
+	data, err := json.Marshal(items)
+	if err != nil {
+		return nil, 0, err
+	}
+	var objects []map[string]interface{}
+	if err := json.Unmarshal(data, &objects); err != nil {
+		return nil, 0, err
+	}
+	for i, object := range objects {
+		object["apiVersion"] = apiVersion
+		object["kind"] = kind
+		if metadata, ok := object["metadata"].(map[string]interface{}); ok {
+			delete(metadata, "managedFields")
+		}
+		if workload != nil {
+			objects[i] = stripWorkload(object, *workload)
+		}
+	}
+	if objects == nil {
+		objects = []map[string]interface{}{}
+	}
+	data, err = json.MarshalIndent(map[string]interface{}{"apiVersion": "v1", "kind": "List", "items": objects}, "", "  ")
+	return data, len(objects), err
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: modTime}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// Write a snapshot of the cluster as a gzipped tarball
+func ExportSnapshot(client *kubernetes.Clientset, config *rest.Config, w io.Writer, clusterType string) (SnapshotManifest, error) {
+	manifest := SnapshotManifest{Version: SnapshotVersion, CreatedAt: time.Now().UTC(), ClusterType: clusterType, Counts: make(map[string]int)}
+	files := make(map[string][]byte)
+
+	preferred, err := client.Discovery().ServerPreferredResources()
+	if err != nil {
+		if discovery.IsGroupDiscoveryFailedError(err) {
+			fmt.Println("Warning: Some API groups failed to load, but continuing...")
+		} else {
+			return manifest, fmt.Errorf("failed to get preferred resources: %v", err)
+		}
+	}
+	_, all, err := client.Discovery().ServerGroupsAndResources()
+	if err != nil {
+		if discovery.IsGroupDiscoveryFailedError(err) {
+			fmt.Println("Warning: Some API groups failed to load, but continuing...")
+		} else {
+			return manifest, fmt.Errorf("failed to get resources: %v", err)
+		}
+	}
+	if files["discovery/preferred.json"], err = json.MarshalIndent(preferred, "", "  "); err != nil {
+		return manifest, err
+	}
+	if files["discovery/all.json"], err = json.MarshalIndent(all, "", "  "); err != nil {
+		return manifest, err
+	}
+
+	for _, resource := range snapshotResources {
+		items, err := resource.list(context.TODO(), client)
+		if err != nil {
+			return manifest, fmt.Errorf("failed to list %s: %v", resource.name, err)
+		}
+		data, count, err := snapshotList(resource.apiVersion, resource.kind, items, nil)
+		if err != nil {
+			return manifest, fmt.Errorf("failed to encode %s: %v", resource.name, err)
+		}
+		files["objects/"+resource.name+".json"] = data
+		manifest.Counts[resource.name] = count
+	}
+
+	// Objects of the workload kinds served by the cluster, stripped down to what the workload collection reads. Files
+	// are named resource.group, or resource for the core group
+	dyn, mapper, err := dynamicClients(config)
+	if err != nil {
+		return manifest, err
+	}
+	for _, kind := range workloadKinds {
+		mapping, ok := workloadKindMapping(mapper, kind)
+		if !ok {
+			continue
+		}
+		name := mapping.Resource.Resource
+		if mapping.Resource.Group != "" {
+			name += "." + mapping.Resource.Group
+		}
+		list, err := dyn.Resource(mapping.Resource).Namespace(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			fmt.Printf("Warning: failed to list %s: %v\n", name, err)
+			continue
+		}
+		data, count, err := snapshotList(kind.APIVersion, kind.Kind, list.Items, &kind)
+		if err != nil {
+			return manifest, fmt.Errorf("failed to encode %s: %v", name, err)
+		}
+		files["objects/"+name+".json"] = data
+		manifest.Counts[name] = count
+	}
+
+	// ServiceAccount token secrets, with only the metadata read from them
+	tokenSecrets, err := listTokenSecrets(config)
+	if err != nil {
+		fmt.Printf("Warning: failed to list ServiceAccount token secrets: %v\n", err)
+	}
+	var secrets []map[string]interface{}
+	for _, secret := range tokenSecrets {
+		secrets = append(secrets, map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"name":        secret.SecretName,
+				"namespace":   secret.Namespace,
+				"annotations": map[string]interface{}{corev1.ServiceAccountNameKey: secret.ServiceAccount},
+			},
+			"type": string(corev1.SecretTypeServiceAccountToken),
+		})
+	}
+	if files["objects/secrets.json"], manifest.Counts["secrets"], err = snapshotList("v1", "Secret", secrets, nil); err != nil {
+		return manifest, fmt.Errorf("failed to encode secrets: %v", err)
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	// The manifest goes first so readers can check the version before the rest
+	if err := writeTarFile(tw, "manifest.json", manifestData, manifest.CreatedAt); err != nil {
+		return manifest, err
+	}
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := writeTarFile(tw, name, files[name], manifest.CreatedAt); err != nil {
+			return manifest, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return manifest, err
+	}
+	return manifest, gz.Close()
+}
+
+// Read a snapshot written by ExportSnapshot
+func ImportSnapshot(r io.Reader) (*Snapshot, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %v", err)
+	}
+	defer gz.Close()
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read snapshot: %v", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from snapshot: %v", header.Name, err)
+		}
+		files[path.Clean(header.Name)] = data
+	}
+
+	snapshot := &Snapshot{Objects: NewOfflineObjects()}
+	manifestData, ok := files["manifest.json"]
+	if !ok {
+		return nil, fmt.Errorf("not a snapshot: manifest.json is missing")
+	}
+	if err := json.Unmarshal(manifestData, &snapshot.Manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot manifest: %v", err)
+	}
+	if snapshot.Manifest.Version < 1 || snapshot.Manifest.Version > SnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d (supported up to %d)", snapshot.Manifest.Version, SnapshotVersion)
+	}
+
+	for name, target := range map[string]*[]*metav1.APIResourceList{
+		"discovery/preferred.json": &snapshot.PreferredResources,
+		"discovery/all.json":       &snapshot.AllResources,
+	} {
+		data, ok := files[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid snapshot: %s is missing", name)
+		}
+		if err := json.Unmarshal(data, target); err != nil {
+			return nil, fmt.Errorf("failed to parse %s from snapshot: %v", name, err)
+		}
+	}
+
+	// Objects are read like offline manifests - they already have their namespaces
+	var names []string
+	for name := range files {
+		if strings.HasPrefix(name, "objects/") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := snapshot.Objects.Load(bytes.NewReader(files[name]), name, ""); err != nil {
+			return nil, err
+		}
+	}
+	return snapshot, nil
+}
+
+// Get the resource types and subresources like the cluster collection does from discovery
+func (s *Snapshot) Resources() ([]ResourceType, map[string]string, error) {
+	resourceTypes, err := ResourceTypesFromDiscovery(s.PreferredResources)
+	if err != nil {
+		return nil, nil, err
+	}
+	subresources, err := SubresourcesFromDiscovery(s.AllResources)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resourceTypes, subresources, nil
+}
@@ -0,0 +1,100 @@
+package kube_collection
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestStripWorkload(t *testing.T) {
+	var deployment map[string]interface{}
+	err := json.Unmarshal([]byte(`{
+		"apiVersion": "apps/v1",
+		"kind": "Deployment",
+		"metadata": {
+			"name": "api",
+			"namespace": "payments",
+			"uid": "1234",
+			"labels": {"app": "api"},
+			"annotations": {"kubectl.kubernetes.io/last-applied-configuration": "{\"password\": \"hunter2\"}"}
+		},
+		"spec": {
+			"replicas": 2,
+			"template": {
+				"metadata": {"labels": {"app": "api", "azure.workload.identity/use": "true"}, "annotations": {"a": "b"}},
+				"spec": {
+					"serviceAccountName": "api",
+					"automountServiceAccountToken": false,
+					"hostNetwork": true,
+					"volumes": [{"name": "config", "configMap": {"name": "api"}}, {"name": "host", "hostPath": {"path": "/var/run"}}],
+					"containers": [{
+						"name": "api",
+						"image": "api:1",
+						"command": ["api", "--password=hunter2"],
+						"env": [{"name": "DB_PASSWORD", "value": "hunter2"}, {"name": "AZURE_CLIENT_ID", "value": "client-id"}],
+						"securityContext": {"privileged": true, "runAsUser": 0}
+					}]
+				}
+			}
+		}
+	}`), &deployment)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata": map[string]interface{}{
+			"name":      "api",
+			"namespace": "payments",
+			"uid":       "1234",
+			"labels":    map[string]interface{}{"app": "api"},
+		},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{"labels": map[string]interface{}{"app": "api", "azure.workload.identity/use": "true"}},
+				"spec": map[string]interface{}{
+					"serviceAccountName":           "api",
+					"automountServiceAccountToken": false,
+					"hostNetwork":                  true,
+					"volumes":                      []interface{}{map[string]interface{}{}, map[string]interface{}{"hostPath": map[string]interface{}{}}},
+					"containers": []interface{}{map[string]interface{}{
+						"env":             []interface{}{map[string]interface{}{"name": "AZURE_CLIENT_ID", "value": "client-id"}},
+						"securityContext": map[string]interface{}{"privileged": true},
+					}},
+				},
+			},
+		},
+	}
+	if got := stripWorkload(deployment, WorkloadKind{TemplatePaths: []string{"spec.template"}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("stripWorkload() = %v, want %v", got, want)
+	}
+}
+
+func TestStripWorkloadServiceAccountPaths(t *testing.T) {
+	workflow := map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       "Workflow",
+		"metadata":   map[string]interface{}{"name": "build", "namespace": "ci"},
+		"spec": map[string]interface{}{
+			"arguments": map[string]interface{}{"parameters": []interface{}{map[string]interface{}{"name": "token", "value": "secret"}}},
+			"templates": []interface{}{
+				map[string]interface{}{"name": "checkout", "serviceAccountName": "git"},
+				map[string]interface{}{"name": "push", "container": map[string]interface{}{"args": []interface{}{"--token=secret"}}},
+			},
+		},
+	}
+	kind, _ := findWorkloadKind("argoproj.io/v1alpha1", "Workflow")
+	want := map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       "Workflow",
+		"metadata":   map[string]interface{}{"name": "build", "namespace": "ci"},
+		"spec": map[string]interface{}{
+			"templates": []interface{}{map[string]interface{}{"serviceAccountName": "git"}, map[string]interface{}{}},
+		},
+	}
+	if got := stripWorkload(workflow, kind); !reflect.DeepEqual(got, want) {
+		t.Errorf("stripWorkload() = %v, want %v", got, want)
+	}
+}
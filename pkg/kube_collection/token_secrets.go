@@ -0,0 +1,87 @@
+package kube_collection
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
+)
+
+// Long-lived ServiceAccount tokens - secrets of type kubernetes.io/service-account-token, which anyone who can read
+// the secrets of their namespace can use. Only their metadata is read, never the tokens
+
+type TokenSecret struct {
+	Namespace      string
+	SecretName     string
+	ServiceAccount string // Name of the ServiceAccount in the namespace
+}
+
+// Get the token secret of a Secret object, if it is one
+func tokenSecretOf(meta metav1.ObjectMeta, secretType corev1.SecretType) (TokenSecret, bool) {
+	serviceAccount := meta.Annotations[corev1.ServiceAccountNameKey]
+	if secretType != corev1.SecretTypeServiceAccountToken || serviceAccount == "" {
+		return TokenSecret{}, false
+	}
+	return TokenSecret{Namespace: meta.Namespace, SecretName: meta.Name, ServiceAccount: serviceAccount}, true
+}
+
+// List the token secrets of the cluster with the metadata client, so the tokens aren't transferred
+func listTokenSecrets(config *rest.Config) ([]TokenSecret, error) {
+	client, err := metadata.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	list, err := client.Resource(corev1.SchemeGroupVersion.WithResource("secrets")).Namespace(metav1.NamespaceAll).List(context.TODO(),
+		metav1.ListOptions{FieldSelector: "type=" + string(corev1.SecretTypeServiceAccountToken)})
+	if err != nil {
+		return nil, err
+	}
+	var secrets []TokenSecret
+	for _, item := range list.Items {
+		if secret, ok := tokenSecretOf(item.ObjectMeta, corev1.SecretTypeServiceAccountToken); ok {
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets, nil
+}
+
+// Collect the token secrets of the cluster into the DB, returning how many were found
+func CollectTokenSecrets(config *rest.Config, db *sql.DB) (int, error) {
+	secrets, err := listTokenSecrets(config)
+	if err != nil {
+		return 0, err
+	}
+	return len(secrets), StoreTokenSecrets(db, secrets)
+}
+
+// Insert token secrets into the service_account_token_secrets table
+func StoreTokenSecrets(db *sql.DB, secrets []TokenSecret) error {
+	if len(secrets) == 0 {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT IGNORE INTO rufus.service_account_token_secrets (service_account_name, secret_name)
+		VALUES (?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, secret := range secrets {
+		if _, err := stmt.Exec(fmt.Sprintf("%s:%s", secret.Namespace, secret.ServiceAccount), secret.SecretName); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,104 @@
+package kube_collection
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Pod spec attributes which change the risk of the ServiceAccount a workload runs with
+type WorkloadSecurity struct {
+	AutomountToken  bool // The ServiceAccount token is mounted into the pods
+	Privileged      bool // A container runs privileged
+	HostPath        bool // A hostPath volume is mounted
+	HostNetwork     bool
+	HostPID         bool
+	ServiceExposure string // Most exposed type of the Services selecting the pods - LoadBalancer, NodePort, ClusterIP or empty
+}
+
+// The objects of a namespace which the security attributes of its workloads depend on
+type workloadContext struct {
+	serviceAccounts map[string]*corev1.ServiceAccount // Keyed by name
+	services        []corev1.Service
+	owners          ownerLookup                         // For the top-level controllers of the workloads
+	identities      map[string][]ServiceAccountIdentity // Cloud identities keyed by ServiceAccount name
+}
+
+var exposureRanks = map[string]int{
+	"":                                     0,
+	string(corev1.ServiceTypeClusterIP):    1,
+	string(corev1.ServiceTypeNodePort):     2,
+	string(corev1.ServiceTypeLoadBalancer): 3,
+}
+
+func getWorkloadSecurity(template corev1.PodTemplateSpec, wctx workloadContext) WorkloadSecurity {
+	spec := template.Spec
+	security := WorkloadSecurity{
+		AutomountToken: true,
+		HostNetwork:    spec.HostNetwork,
+		HostPID:        spec.HostPID,
+	}
+
+	// The pod setting takes precedence over the ServiceAccount one, and the token is mounted by default
+	saName := spec.ServiceAccountName
+	if saName == "" {
+		saName = "default"
+	}
+	if spec.AutomountServiceAccountToken != nil {
+		security.AutomountToken = *spec.AutomountServiceAccountToken
+	} else if sa, ok := wctx.serviceAccounts[saName]; ok && sa.AutomountServiceAccountToken != nil {
+		security.AutomountToken = *sa.AutomountServiceAccountToken
+	}
+
+	containers := append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...)
+	for _, container := range containers {
+		if container.SecurityContext != nil && container.SecurityContext.Privileged != nil && *container.SecurityContext.Privileged {
+			security.Privileged = true
+		}
+	}
+	for _, volume := range spec.Volumes {
+		if volume.HostPath != nil {
+			security.HostPath = true
+		}
+	}
+
+	for _, service := range wctx.services {
+		if len(service.Spec.Selector) == 0 {
+			continue
+		}
+		exposure := string(service.Spec.Type)
+		if exposure == "" {
+			exposure = string(corev1.ServiceTypeClusterIP)
+		}
+		if _, ok := exposureRanks[exposure]; !ok {
+			continue
+		}
+		if labels.SelectorFromSet(service.Spec.Selector).Matches(labels.Set(template.Labels)) &&
+			exposureRanks[exposure] > exposureRanks[security.ServiceExposure] {
+			security.ServiceExposure = exposure
+		}
+	}
+	return security
+}
+
+func (wctx workloadContext) ownerInfo(obj metav1.Object, resourceType string) (string, string) {
+	return resolveOwner(obj, resourceType, wctx.owners)
+}
+
+// Arguments of the workload insert statement, in the order of its columns
+func (w WorkloadInfo) insertArgs() []interface{} {
+	return []interface{}{
+		w.WorkloadType,
+		w.WorkloadName,
+		w.ServiceAccountName,
+		w.WorkloadIdentity,
+		w.OriginalOwnerType,
+		w.OriginalOwnerName,
+		w.Security.AutomountToken,
+		w.Security.Privileged,
+		w.Security.HostPath,
+		w.Security.HostNetwork,
+		w.Security.HostPID,
+		w.Security.ServiceExposure,
+	}
+}
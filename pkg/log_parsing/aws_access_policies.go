@@ -53,7 +53,7 @@ func retryEKSWithBackoff(input *eks.ListAssociatedAccessPoliciesInput) (*eks.Lis
 }
 
 // accessPolicy flow to handle EKS Access Policy
-func handleEKSAccessPolicy(entityName, reason, clusterName string, sess *session.Session, db *sql.DB, namespaces *v1.NamespaceList) {
+func handleEKSAccessPolicy(entityName, entityType, reason, clusterName string, sess *session.Session, db *sql.DB, namespaces *v1.NamespaceList) {
 	// Keep track of processed entities to avoid duplicates
 	for _, name := range processedEntities {
 		if name == entityName {
@@ -97,23 +97,23 @@ func handleEKSAccessPolicy(entityName, reason, clusterName string, sess *session
 	policyNames, accessScopes := listAssociatedAccessPolicies(clusterName, accessEntryArn)
 	for i, policyName := range policyNames {
 		if policyName == "AmazonEKSClusterAdminPolicy" {
-			handleEKSClusterAdminPolicy(entityName, accessEntryArn, accessScopes[i], namespaces, db)
+			handleEKSClusterAdminPolicy(entityName, entityType, accessEntryArn, accessScopes[i], namespaces, db)
 			continue
 		}
 		if policyName == "AmazonEKSAdminViewPolicy" {
-			handleEKSAdminViewPolicy(entityName, accessEntryArn, accessScopes[i], namespaces, db)
+			handleEKSAdminViewPolicy(entityName, entityType, accessEntryArn, accessScopes[i], namespaces, db)
 			continue
 		}
 		if policyName == "AmazonEKSEditPolicy" {
-			handleStaticPolicy(entityName, accessEntryArn, accessScopes[i], eksEditPolicyPermissions, namespaces, db)
+			handleStaticPolicy(entityName, entityType, accessEntryArn, accessScopes[i], policyName, eksEditPolicyPermissions, namespaces, db)
 			continue
 		}
 		if policyName == "AmazonEKSViewPolicy" {
-			handleStaticPolicy(entityName, accessEntryArn, accessScopes[i], eksViewPolicyPermissions, namespaces, db)
+			handleStaticPolicy(entityName, entityType, accessEntryArn, accessScopes[i], policyName, eksViewPolicyPermissions, namespaces, db)
 			continue
 		}
 		if policyName == "AmazonEKSAdminPolicy" {
-			handleStaticPolicy(entityName, accessEntryArn, accessScopes[i], eksAdminPolicyPermissions, namespaces, db)
+			handleStaticPolicy(entityName, entityType, accessEntryArn, accessScopes[i], policyName, eksAdminPolicyPermissions, namespaces, db)
 			continue
 		}
 	}
@@ -206,14 +206,14 @@ func isResourceTypeNamespaced(resourceType string, db *sql.DB, namespaces *v1.Na
 	return false, nil
 }
 
-func insertExpandedPermission(entityName, apiGroup, resourceType, verb, scope, accessEntryArn, policyName string, db *sql.DB) {
+func insertExpandedPermission(entityName, entityType, apiGroup, resourceType, verb, scope, accessEntryArn, policyName string, db *sql.DB) {
 	_, err := db.Exec(`
         INSERT INTO permission (
             entity_name, entity_type, api_group, resource_type, verb, permission_scope,
             permission_source, permission_source_type, permission_binding, permission_binding_type,
             last_used_time, last_used_resource
         ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-    `, entityName, "User", apiGroup, resourceType, verb, scope, policyName, "EKS Access Policy", accessEntryArn, "EKS Access Entry", nil, nil)
+    `, entityName, entityType, apiGroup, resourceType, verb, scope, policyName, "EKS Access Policy", accessEntryArn, "EKS Access Entry", nil, nil)
 	if err != nil {
 		if strings.Contains(err.Error(), "Duplicate entry") {
 			return
@@ -247,7 +247,7 @@ func getSubresources(resourceType, apiGroup string, db *sql.DB) ([]string, error
 }
 
 // Handle permissions from the static adminView policy by getting every permission in the cluster
-func handleEKSClusterAdminPolicy(entityName, accessEntryArn, accessScope string, namespaces *v1.NamespaceList, db *sql.DB) {
+func handleEKSClusterAdminPolicy(entityName, entityType, accessEntryArn, accessScope string, namespaces *v1.NamespaceList, db *sql.DB) {
 	if accessScope == "cluster" {
 		query := `
 			SELECT DISTINCT api_group, resource_type, verb
@@ -276,22 +276,22 @@ func handleEKSClusterAdminPolicy(entityName, accessEntryArn, accessScope string,
 			policyName := "AmazonEKSClusterAdminPolicy"
 			if isNamespaced {
 				for _, ns := range namespaces.Items {
-					insertExpandedPermission(entityName, apiGroup, resourceType, verb, ns.Name, accessEntryArn, policyName, db)
+					insertExpandedPermission(entityName, entityType, apiGroup, resourceType, verb, ns.Name, accessEntryArn, policyName, db)
 					// Insert subresources
 					subresources, err := getSubresources(resourceType, apiGroup, db)
 					if err == nil {
 						for _, subresource := range subresources {
-							insertExpandedPermission(entityName, apiGroup, subresource, verb, ns.Name, accessEntryArn, policyName, db)
+							insertExpandedPermission(entityName, entityType, apiGroup, subresource, verb, ns.Name, accessEntryArn, policyName, db)
 						}
 					}
 				}
 			} else {
-				insertExpandedPermission(entityName, apiGroup, resourceType, verb, "cluster-wide", accessEntryArn, policyName, db)
+				insertExpandedPermission(entityName, entityType, apiGroup, resourceType, verb, "cluster-wide", accessEntryArn, policyName, db)
 				// Insert subresources
 				subresources, err := getSubresources(resourceType, apiGroup, db)
 				if err == nil {
 					for _, subresource := range subresources {
-						insertExpandedPermission(entityName, apiGroup, subresource, verb, "cluster-wide", accessEntryArn, policyName, db)
+						insertExpandedPermission(entityName, entityType, apiGroup, subresource, verb, "cluster-wide", accessEntryArn, policyName, db)
 					}
 				}
 			}
@@ -324,12 +324,12 @@ func handleEKSClusterAdminPolicy(entityName, accessEntryArn, accessScope string,
 					continue
 				}
 				policyName := "AmazonEKSClusterAdminPolicy"
-				insertExpandedPermission(entityName, apiGroup, resourceType, verb, permissionScope, accessEntryArn, policyName, db)
+				insertExpandedPermission(entityName, entityType, apiGroup, resourceType, verb, permissionScope, accessEntryArn, policyName, db)
 				// Insert subresources
 				subresources, err := getSubresources(resourceType, apiGroup, db)
 				if err == nil {
 					for _, subresource := range subresources {
-						insertExpandedPermission(entityName, apiGroup, subresource, verb, permissionScope, accessEntryArn, policyName, db)
+						insertExpandedPermission(entityName, entityType, apiGroup, subresource, verb, permissionScope, accessEntryArn, policyName, db)
 					}
 				}
 			}
@@ -342,7 +342,7 @@ func handleEKSClusterAdminPolicy(entityName, accessEntryArn, accessScope string,
 }
 
 // Handle permissions from the static adminView policy by getting every 'view' permission in the cluster
-func handleEKSAdminViewPolicy(entityName, accessEntryArn, accessScope string, namespaces *v1.NamespaceList, db *sql.DB) {
+func handleEKSAdminViewPolicy(entityName, entityType, accessEntryArn, accessScope string, namespaces *v1.NamespaceList, db *sql.DB) {
 	if accessScope == "cluster" {
 		query := `
 			SELECT DISTINCT api_group, resource_type, verb
@@ -372,22 +372,22 @@ func handleEKSAdminViewPolicy(entityName, accessEntryArn, accessScope string, na
 			policyName := "AmazonEKSAdminViewPolicy"
 			if isNamespaced {
 				for _, ns := range namespaces.Items {
-					insertExpandedPermission(entityName, apiGroup, resourceType, verb, ns.Name, accessEntryArn, policyName, db)
+					insertExpandedPermission(entityName, entityType, apiGroup, resourceType, verb, ns.Name, accessEntryArn, policyName, db)
 					// Insert subresources
 					subresources, err := getSubresources(resourceType, apiGroup, db)
 					if err == nil {
 						for _, subresource := range subresources {
-							insertExpandedPermission(entityName, apiGroup, subresource, verb, ns.Name, accessEntryArn, policyName, db)
+							insertExpandedPermission(entityName, entityType, apiGroup, subresource, verb, ns.Name, accessEntryArn, policyName, db)
 						}
 					}
 				}
 			} else {
-				insertExpandedPermission(entityName, apiGroup, resourceType, verb, "cluster-wide", accessEntryArn, policyName, db)
+				insertExpandedPermission(entityName, entityType, apiGroup, resourceType, verb, "cluster-wide", accessEntryArn, policyName, db)
 				// Insert subresources
 				subresources, err := getSubresources(resourceType, apiGroup, db)
 				if err == nil {
 					for _, subresource := range subresources {
-						insertExpandedPermission(entityName, apiGroup, subresource, verb, "cluster-wide", accessEntryArn, policyName, db)
+						insertExpandedPermission(entityName, entityType, apiGroup, subresource, verb, "cluster-wide", accessEntryArn, policyName, db)
 					}
 				}
 			}
@@ -420,12 +420,12 @@ func handleEKSAdminViewPolicy(entityName, accessEntryArn, accessScope string, na
 					continue
 				}
 				policyName := "AmazonEKSAdminViewPolicy"
-				insertExpandedPermission(entityName, apiGroup, resourceType, verb, permissionScope, accessEntryArn, policyName, db)
+				insertExpandedPermission(entityName, entityType, apiGroup, resourceType, verb, permissionScope, accessEntryArn, policyName, db)
 				// Insert subresources
 				subresources, err := getSubresources(resourceType, apiGroup, db)
 				if err == nil {
 					for _, subresource := range subresources {
-						insertExpandedPermission(entityName, apiGroup, subresource, verb, permissionScope, accessEntryArn, policyName, db)
+						insertExpandedPermission(entityName, entityType, apiGroup, subresource, verb, permissionScope, accessEntryArn, policyName, db)
 					}
 				}
 			}
@@ -438,8 +438,8 @@ func handleEKSAdminViewPolicy(entityName, accessEntryArn, accessScope string, na
 }
 
 // Handle permissions from the static policies
-func handleStaticPolicy(entityName, accessEntryArn, accessScope string, eksEditPolicyPermissions []string, namespaces *v1.NamespaceList, db *sql.DB) {
-	for _, permission := range eksEditPolicyPermissions {
+func handleStaticPolicy(entityName, entityType, accessEntryArn, accessScope, policyName string, policyPermissions []string, namespaces *v1.NamespaceList, db *sql.DB) {
+	for _, permission := range policyPermissions {
 		parts := strings.Split(permission, ":")
 		if len(parts) != 3 {
 			fmt.Println("Invalid permission format:", permission)
@@ -462,7 +462,7 @@ func handleStaticPolicy(entityName, accessEntryArn, accessScope string, eksEditP
                             permission_source, permission_source_type, permission_binding, permission_binding_type,
                             last_used_time, last_used_resource
                         ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-                    `, entityName, "User", apiGroup, resourceType, verb, nsName, "AmazonEKSEditPolicy", "EKS Access Policy", accessEntryArn, "EKS Access Entry", nil, nil)
+                    `, entityName, entityType, apiGroup, resourceType, verb, nsName, policyName, "EKS Access Policy", accessEntryArn, "EKS Access Entry", nil, nil)
 					if err != nil {
 						if strings.Contains(err.Error(), "Duplicate entry") {
 							continue
@@ -481,7 +481,7 @@ func handleStaticPolicy(entityName, accessEntryArn, accessScope string, eksEditP
 	                            permission_source, permission_source_type, permission_binding, permission_binding_type,
 	                            last_used_time, last_used_resource
 	                        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	                    `, entityName, "User", apiGroup, subresource, verb, nsName, "AmazonEKSEditPolicy", "EKS Access Policy", accessEntryArn, "EKS Access Entry", nil, nil)
+	                    `, entityName, entityType, apiGroup, subresource, verb, nsName, policyName, "EKS Access Policy", accessEntryArn, "EKS Access Entry", nil, nil)
 								if err != nil {
 									if strings.Contains(err.Error(), "Duplicate entry") {
 										continue
@@ -502,7 +502,7 @@ func handleStaticPolicy(entityName, accessEntryArn, accessScope string, eksEditP
                         permission_source, permission_source_type, permission_binding, permission_binding_type,
                         last_used_time, last_used_resource
                     ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-                `, entityName, "User", apiGroup, resourceType, verb, accessScope, "AmazonEKSEditPolicy", "EKS Access Policy", accessEntryArn, "EKS Access Entry", nil, nil)
+                `, entityName, entityType, apiGroup, resourceType, verb, accessScope, policyName, "EKS Access Policy", accessEntryArn, "EKS Access Entry", nil, nil)
 				if err != nil {
 					if strings.Contains(err.Error(), "Duplicate entry") {
 						continue
@@ -521,7 +521,7 @@ func handleStaticPolicy(entityName, accessEntryArn, accessScope string, eksEditP
                             permission_source, permission_source_type, permission_binding, permission_binding_type,
                             last_used_time, last_used_resource
                         ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-                    `, entityName, "User", apiGroup, subresource, verb, accessScope, "AmazonEKSEditPolicy", "EKS Access Policy", accessEntryArn, "EKS Access Entry", nil, nil)
+                    `, entityName, entityType, apiGroup, subresource, verb, accessScope, policyName, "EKS Access Policy", accessEntryArn, "EKS Access Entry", nil, nil)
 							if err != nil {
 								if strings.Contains(err.Error(), "Duplicate entry") {
 									continue
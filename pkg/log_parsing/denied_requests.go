@@ -0,0 +1,78 @@
+package log_parsing
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Requests which were denied (403) - kept separately from the permission usage as attempted-access signals
+type DeniedRequest struct {
+	EntityName      string
+	EntityType      string
+	APIGroup        string
+	ResourceType    string
+	Verb            string
+	PermissionScope string
+	DeniedTime      string
+	DeniedResource  string
+}
+
+func newDeniedRequest(entityName, entityType, apiGroup, resourceType, verb, namespace, name, deniedTime string) DeniedRequest {
+	return DeniedRequest{
+		EntityName:      entityName,
+		EntityType:      entityType,
+		APIGroup:        apiGroup,
+		ResourceType:    resourceType,
+		Verb:            verb,
+		PermissionScope: getPermissionScope(namespace, name),
+		DeniedTime:      deniedTime,
+		DeniedResource:  getLastUsedResource(namespace, resourceType, name),
+	}
+}
+
+// Insert denied requests in batches, counting repeated denials of the same request
+func batchInsertDeniedRequests(db *sql.DB, deniedRequests []DeniedRequest) {
+	if len(deniedRequests) == 0 {
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		fmt.Printf("Error starting transaction: %v\n", err)
+		return
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO denied_requests (
+			entity_name, entity_type, api_group, resource_type, verb, permission_scope,
+			denied_count, first_denied_time, last_denied_time, last_denied_resource
+		) VALUES (?, ?, ?, ?, ?, ?, 1, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			denied_count = denied_count + 1,
+			first_denied_time = LEAST(first_denied_time, VALUES(first_denied_time)),
+			last_denied_resource = IF(VALUES(last_denied_time) >= last_denied_time, VALUES(last_denied_resource), last_denied_resource),
+			last_denied_time = GREATEST(last_denied_time, VALUES(last_denied_time))
+	`)
+	if err != nil {
+		fmt.Printf("Error preparing statement: %v\n", err)
+		tx.Rollback()
+		return
+	}
+	defer stmt.Close()
+
+	for _, denied := range deniedRequests {
+		_, err = stmt.Exec(
+			denied.EntityName, denied.EntityType, denied.APIGroup, denied.ResourceType, denied.Verb, denied.PermissionScope,
+			denied.DeniedTime, denied.DeniedTime, denied.DeniedResource,
+		)
+		if err != nil {
+			fmt.Printf("Error inserting denied request: %v\n", err)
+			tx.Rollback()
+			return
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		fmt.Printf("Error committing transaction: %v\n", err)
+		tx.Rollback()
+	}
+}
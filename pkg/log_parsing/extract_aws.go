@@ -22,7 +22,7 @@ import (
 )
 
 // Get logs from AWS for last 7 days
-func ExtractAWSLogs(sess *session.Session, clusterName string) (string, error) {
+func ExtractAWSLogs(sess *session.Session, clusterName string, recordDenied bool) (string, error) {
 	logGroupName := fmt.Sprintf("/aws/eks/%s/cluster", clusterName)
 	now := time.Now()
 
@@ -65,6 +65,12 @@ func ExtractAWSLogs(sess *session.Session, clusterName string) (string, error) {
 		}
 	}
 
+	// Successful requests, and denied requests if requested
+	filterPattern := `{ $.stage = "ResponseComplete" && $.responseStatus.code >= 100 && $.responseStatus.code <= 299 }`
+	if recordDenied {
+		filterPattern = `{ $.stage = "ResponseComplete" && (($.responseStatus.code >= 100 && $.responseStatus.code <= 299) || $.responseStatus.code = 403) }`
+	}
+
 	semaphore := make(chan struct{}, maxConcurrency) // Dynamic concurrency limit
 	var wg sync.WaitGroup
 	errorChan := make(chan error)
@@ -86,7 +92,7 @@ func ExtractAWSLogs(sess *session.Session, clusterName string) (string, error) {
 					LogGroupName:        aws.String(logGroupName),
 					LogStreamNamePrefix: aws.String("kube-apiserver-audit-"),
 					NextToken:           nextToken,
-					FilterPattern:       aws.String(`{ $.stage = "ResponseComplete" && $.responseStatus.code >= 100 && $.responseStatus.code <= 299 }`),
+					FilterPattern:       aws.String(filterPattern),
 				}
 				// Retry with exponential backoff for rate limit
 				filterLogEventsOutput, err := retryWithBackoff(input)
@@ -268,7 +274,11 @@ type AuditLogEvent struct {
 		APIGroup    string `json:"apiGroup"`
 		APIVersion  string `json:"apiVersion"`
 	} `json:"objectRef"`
-	RequestReceivedTimestamp string `json:"requestReceivedTimestamp"`
+	ResponseStatus struct {
+		Code int `json:"code"`
+	} `json:"responseStatus"`
+	ImpersonatedUser         *ImpersonatedUser `json:"impersonatedUser"`
+	RequestReceivedTimestamp string            `json:"requestReceivedTimestamp"`
 	Annotations              struct {
 		Reason string `json:"authorization.k8s.io/reason"`
 	} `json:"annotations"`
@@ -288,6 +298,7 @@ func HandleAWSLogs(tempFilePath string, db *sql.DB, sess *session.Session, clust
 	scanner := bufio.NewScanner(tempFile)
 	userGroups := make(map[string][]string)
 	var updateDataList []UpdateData
+	var deniedRequests []DeniedRequest
 	GlobalProgressBar.Start("cluster events processed")
 
 	for scanner.Scan() {
@@ -305,17 +316,29 @@ func HandleAWSLogs(tempFilePath string, db *sql.DB, sess *session.Session, clust
 			continue
 		}
 
-		// Handle EKS Access Policy
-		if strings.HasPrefix(auditLogEvent.Annotations.Reason, "EKS Access Policy") {
-			handleEKSAccessPolicy(auditLogEvent.User.Username, auditLogEvent.Annotations.Reason, clusterName, sess, db, namespaces)
+		// Record denied requests separately, they don't reflect permission usage
+		if auditLogEvent.ResponseStatus.Code == 403 {
+			entityName, entityType := NormalizeUsername(auditLogEvent.User.Username)
+			deniedRequests = append(deniedRequests, newDeniedRequest(entityName, entityType,
+				getAPIGroup(auditLogEvent.ObjectRef.APIGroup, auditLogEvent.ObjectRef.APIVersion),
+				getResourceType(auditLogEvent.ObjectRef.Resource, auditLogEvent.ObjectRef.Subresource),
+				auditLogEvent.Verb, auditLogEvent.ObjectRef.Namespace, auditLogEvent.ObjectRef.Name,
+				getLastUsedTime(auditLogEvent.RequestReceivedTimestamp)))
+			GlobalProgressBar.Add(1)
+			continue
 		}
 
 		// Extract identity and permissions
-		entityName, entityType := getEntityNameAndType(auditLogEvent.User.Username)
+		entityName, entityType := NormalizeUsername(auditLogEvent.User.Username)
+
+		// Handle EKS Access Policy
+		if strings.HasPrefix(auditLogEvent.Annotations.Reason, "EKS Access Policy") {
+			handleEKSAccessPolicy(entityName, entityType, auditLogEvent.Annotations.Reason, clusterName, sess, db, namespaces)
+		}
 		entityGroups := auditLogEvent.User.Groups
 		if _, exists := userGroups[entityName]; !exists {
 			userGroups[entityName] = entityGroups
-			handleGroupInheritance(db, entityName, entityGroups)
+			handleGroupInheritance(db, entityName, entityType, entityGroups)
 		}
 
 		// Process resource access details
@@ -326,6 +349,16 @@ func HandleAWSLogs(tempFilePath string, db *sql.DB, sess *session.Session, clust
 		lastUsedTime := getLastUsedTime(auditLogEvent.RequestReceivedTimestamp)
 		lastUsedResource := getLastUsedResource(auditLogEvent.ObjectRef.Namespace, resourceType, auditLogEvent.ObjectRef.Name)
 
+		// Handle impersonation - the impersonator used impersonate, the action itself is attributed to the impersonated identity
+		if impersonated := auditLogEvent.ImpersonatedUser; impersonated != nil && impersonated.Username != "" {
+			updateDataList = append(updateDataList, getImpersonationUpdateData(entityName, entityType, *impersonated, lastUsedTime)...)
+			entityName, entityType = NormalizeUsername(impersonated.Username)
+			if _, exists := userGroups[entityName]; !exists {
+				userGroups[entityName] = impersonated.Groups
+				handleGroupInheritance(db, entityName, entityType, impersonated.Groups)
+			}
+		}
+
 		updateDataList = append(updateDataList, UpdateData{
 			EntityName:       entityName,
 			EntityType:       entityType,
@@ -335,6 +368,7 @@ func HandleAWSLogs(tempFilePath string, db *sql.DB, sess *session.Session, clust
 			PermissionScope:  permissionScope,
 			LastUsedTime:     lastUsedTime,
 			LastUsedResource: lastUsedResource,
+			Reason:           parseAuthorizationReason(auditLogEvent.Annotations.Reason),
 		})
 
 		GlobalProgressBar.Add(1)
@@ -346,10 +380,15 @@ func HandleAWSLogs(tempFilePath string, db *sql.DB, sess *session.Session, clust
 			runtime.GC()
 			debug.FreeOSMemory()
 		}
+		if len(deniedRequests) > 5000 {
+			batchInsertDeniedRequests(db, deniedRequests)
+			deniedRequests = nil
+		}
 	}
 	GlobalProgressBar.Stop()
 	println()
 	batchUpdateDatabase(db, updateDataList)
+	batchInsertDeniedRequests(db, deniedRequests)
 
 	// Cleanup temp file
 	fmt.Println("Logs processed, cleaning up temp log file...")
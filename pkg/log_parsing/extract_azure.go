@@ -20,7 +20,7 @@ import (
 )
 
 // Get logs from Azure for last 7 days
-func ExtractAzureLogs(cred *azidentity.ClientSecretCredential, clusterName string, workspaceID string) (string, error) {
+func ExtractAzureLogs(cred *azidentity.ClientSecretCredential, clusterName string, workspaceID string, recordDenied bool) (string, error) {
 	client, err := azquery.NewLogsClient(cred, nil)
 	if err != nil {
 		return "", err
@@ -66,6 +66,12 @@ func ExtractAzureLogs(cred *azidentity.ClientSecretCredential, clusterName strin
 		}
 	}
 
+	// Successful requests, and denied requests if requested
+	statusFilter := "ResponseStatus.code >= 100 and ResponseStatus.code <= 299"
+	if recordDenied {
+		statusFilter = "((ResponseStatus.code >= 100 and ResponseStatus.code <= 299) or ResponseStatus.code == 403)"
+	}
+
 	semaphore := make(chan struct{}, maxConcurrency) // Dynamic concurrency limit
 	var wg sync.WaitGroup
 	errorChan := make(chan error)
@@ -84,11 +90,11 @@ func ExtractAzureLogs(cred *azidentity.ClientSecretCredential, clusterName strin
 
 			query := fmt.Sprintf(`
                 AKSAudit
-                | where ResponseStatus.code >= 100 and ResponseStatus.code <= 299 and Stage == 'ResponseComplete' and _ResourceId endswith "%v"
+                | where %v and Stage == 'ResponseComplete' and _ResourceId endswith "%v"
                 | where TimeGenerated >= datetime(%v)
                 | where TimeGenerated < datetime(%v)
-                | project TimeGenerated, Verb, User, ObjectRef
-            `, clusterName, start.Format(time.RFC3339), end.Format(time.RFC3339))
+                | project TimeGenerated, Verb, User, ObjectRef, ImpersonatedUser, Annotations, ResponseStatus
+            `, statusFilter, clusterName, start.Format(time.RFC3339), end.Format(time.RFC3339))
 
 			resp, err := client.QueryWorkspace(context.Background(), workspaceID, azquery.Body{
 				Query: to.Ptr(query),
@@ -139,6 +145,10 @@ type AzureUserInfo struct {
 	Groups   []string `json:"groups"`
 }
 
+type responseStatus struct {
+	Code int `json:"code"`
+}
+
 type objectRef struct {
 	Resource    string `json:"resource"`
 	Namespace   string `json:"namespace"`
@@ -162,6 +172,7 @@ func HandleAzureLogs(tempFilePath string, db *sql.DB) {
 	scanner := bufio.NewScanner(file)
 	userGroups := make(map[string][]string)
 	var updateDataList []UpdateData
+	var deniedRequests []DeniedRequest
 	GlobalProgressBar.Start("cluster events processed")
 
 	for scanner.Scan() {
@@ -195,11 +206,30 @@ func HandleAzureLogs(tempFilePath string, db *sql.DB) {
 				fmt.Printf("Error unmarshaling object ref: %v\n", err)
 				continue
 			}
-			entityName, entityType := getEntityNameAndType(AzureUserInfo.Username)
+			entityName, entityType := NormalizeUsername(AzureUserInfo.Username)
+
+			// Record denied requests separately, they don't reflect permission usage
+			if len(row) > 6 {
+				if statusCell, ok := row[6].(string); ok && statusCell != "" {
+					var status responseStatus
+					if err := json.Unmarshal([]byte(statusCell), &status); err == nil && status.Code == 403 {
+						verb, _ := row[1].(string)
+						deniedTime, _ := row[0].(string)
+						deniedTime = strings.Split(strings.Replace(deniedTime, "T", " ", 1), ".")[0]
+						deniedRequests = append(deniedRequests, newDeniedRequest(entityName, entityType,
+							getAPIGroup(objectRef.ApiGroup, objectRef.ApiVersion),
+							getResourceType(objectRef.Resource, objectRef.Subresource),
+							verb, objectRef.Namespace, objectRef.Name, deniedTime))
+						GlobalProgressBar.Add(1)
+						continue
+					}
+				}
+			}
+
 			entityGroups := AzureUserInfo.Groups
 			if _, exists := userGroups[entityName]; !exists {
 				userGroups[entityName] = entityGroups
-				handleGroupInheritance(db, entityName, entityGroups)
+				handleGroupInheritance(db, entityName, entityType, entityGroups)
 			}
 			apiGroup := getAPIGroup(objectRef.ApiGroup, objectRef.ApiVersion)
 			resourceType := getResourceType(objectRef.Resource, objectRef.Subresource)
@@ -217,6 +247,31 @@ func HandleAzureLogs(tempFilePath string, db *sql.DB) {
 			formattedLastUsedTime := parts[0]
 			lastUsedResource := getLastUsedResource(objectRef.Namespace, resourceType, objectRef.Name)
 
+			var reason AuthorizationReason
+			if len(row) > 5 {
+				if annotationsCell, ok := row[5].(string); ok && annotationsCell != "" {
+					var annotations map[string]string
+					if err := json.Unmarshal([]byte(annotationsCell), &annotations); err == nil {
+						reason = parseAuthorizationReason(annotations["authorization.k8s.io/reason"])
+					}
+				}
+			}
+
+			// Handle impersonation - the impersonator used impersonate, the action itself is attributed to the impersonated identity
+			if len(row) > 4 {
+				if impersonatedCell, ok := row[4].(string); ok && impersonatedCell != "" {
+					var impersonated ImpersonatedUser
+					if err := json.Unmarshal([]byte(impersonatedCell), &impersonated); err == nil && impersonated.Username != "" {
+						updateDataList = append(updateDataList, getImpersonationUpdateData(entityName, entityType, impersonated, formattedLastUsedTime)...)
+						entityName, entityType = NormalizeUsername(impersonated.Username)
+						if _, exists := userGroups[entityName]; !exists {
+							userGroups[entityName] = impersonated.Groups
+							handleGroupInheritance(db, entityName, entityType, impersonated.Groups)
+						}
+					}
+				}
+			}
+
 			updateDataList = append(updateDataList, UpdateData{
 				EntityName:       entityName,
 				EntityType:       entityType,
@@ -226,6 +281,7 @@ func HandleAzureLogs(tempFilePath string, db *sql.DB) {
 				PermissionScope:  permissionScope,
 				LastUsedTime:     formattedLastUsedTime,
 				LastUsedResource: lastUsedResource,
+				Reason:           reason,
 			})
 
 			GlobalProgressBar.Add(1)
@@ -236,11 +292,16 @@ func HandleAzureLogs(tempFilePath string, db *sql.DB) {
 				runtime.GC()
 				debug.FreeOSMemory()
 			}
+			if len(deniedRequests) > 5000 {
+				batchInsertDeniedRequests(db, deniedRequests)
+				deniedRequests = nil
+			}
 		}
 	}
 	GlobalProgressBar.Stop()
 	println()
 	batchUpdateDatabase(db, updateDataList)
+	batchInsertDeniedRequests(db, deniedRequests)
 
 	// Cleanup temp file
 	fmt.Println("Logs processed, cleaning up temp log file...")
@@ -22,7 +22,7 @@ import (
 )
 
 // Get logs from AWS for last 7 days
-func ExtractGCPLogs(creds *google.Credentials, clusterName, projectID, region string) (string, error) {
+func ExtractGCPLogs(creds *google.Credentials, clusterName, projectID, region string, recordDenied bool) (string, error) {
 	client, err := logadmin.NewClient(context.Background(), projectID, option.WithCredentials(creds))
 	if err != nil {
 		return "", err
@@ -69,6 +69,12 @@ func ExtractGCPLogs(creds *google.Credentials, clusterName, projectID, region st
 		}
 	}
 
+	// Successful requests, and denied (PERMISSION_DENIED) requests if requested
+	statusFilter := "protoPayload.status.code=0"
+	if recordDenied {
+		statusFilter = "(protoPayload.status.code=0 OR protoPayload.status.code=7)"
+	}
+
 	semaphore := make(chan struct{}, maxConcurrency) // Dynamic concurrency limit
 	var wg sync.WaitGroup
 	errorChan := make(chan error)
@@ -92,11 +98,11 @@ func ExtractGCPLogs(creds *google.Credentials, clusterName, projectID, region st
 						resource.labels.cluster_name="%s" AND
 						resource.labels.project_id="%s" AND
 						resource.labels.location="%s" AND
-						protoPayload.status.code=0 AND
+						%s AND
 						operation.last=true AND
 						timestamp>="%s" AND
 						timestamp<"%s"
-				`, projectID, clusterName, projectID, region, start.Format(time.RFC3339), end.Format(time.RFC3339))
+				`, projectID, clusterName, projectID, region, statusFilter, start.Format(time.RFC3339), end.Format(time.RFC3339))
 
 			// Retry with exponential backoff for rate limit
 			maxRetries := 6
@@ -195,7 +201,7 @@ func writeGCPLogEntryToTempFile(writer *bufio.Writer, entry *logging.Entry) erro
 }
 
 // Normalize log data and update DB in batches
-func HandleGCPLogs(tempFilePath string, db *sql.DB) {
+func HandleGCPLogs(tempFilePath string, db *sql.DB, creds *google.Credentials) {
 	fmt.Println("Processing GCP Logs and attempting to update database...")
 
 	file, err := os.Open(tempFilePath)
@@ -206,7 +212,10 @@ func HandleGCPLogs(tempFilePath string, db *sql.DB) {
 	defer file.Close()
 
 	scanner := bufio.NewScanner(file)
+	userGroups := make(map[string][]string)
+	groupResolver := newGoogleGroupResolver(creds, db)
 	var updateDataList []UpdateData
+	var deniedRequests []DeniedRequest
 	GlobalProgressBar.Start("cluster events processed")
 
 	for scanner.Scan() {
@@ -224,7 +233,21 @@ func HandleGCPLogs(tempFilePath string, db *sql.DB) {
 			continue
 		}
 
-		entityName, entityType := getEntityNameAndType(name)
+		entityName, entityType := NormalizeUsername(name)
+
+		// Record denied requests separately, they don't reflect permission usage
+		if getGCPStatusCode(&entry) == 7 {
+			deniedRequests = append(deniedRequests, newDeniedRequest(entityName, entityType, getAPIGroup(apiGroup, apiVersion),
+				resourceType, verb, namespace, resourceName, entry.Timestamp.Format("2006-01-02 15:04:05")))
+			GlobalProgressBar.Add(1)
+			continue
+		}
+
+		if _, exists := userGroups[entityName]; !exists {
+			entityGroups := groupResolver.groupsFor(name)
+			userGroups[entityName] = entityGroups
+			handleGroupInheritance(db, entityName, entityType, entityGroups)
+		}
 		finalApiGroup := getAPIGroup(apiGroup, apiVersion)
 		permissionScope := getPermissionScope(namespace, resourceName)
 
@@ -232,6 +255,17 @@ func HandleGCPLogs(tempFilePath string, db *sql.DB) {
 
 		lastUsedResource := getLastUsedResource(namespace, resourceType, resourceName)
 
+		// Handle impersonation - the impersonator used impersonate, the action itself is attributed to the impersonated identity
+		if impersonated := getGCPImpersonatedUser(&entry); impersonated.Username != "" {
+			updateDataList = append(updateDataList, getImpersonationUpdateData(entityName, entityType, impersonated, lastUsedTime)...)
+			entityName, entityType = NormalizeUsername(impersonated.Username)
+			if _, exists := userGroups[entityName]; !exists {
+				entityGroups := append(groupResolver.groupsFor(impersonated.Username), impersonated.Groups...)
+				userGroups[entityName] = entityGroups
+				handleGroupInheritance(db, entityName, entityType, entityGroups)
+			}
+		}
+
 		updateDataList = append(updateDataList, UpdateData{
 			EntityName:       entityName,
 			EntityType:       entityType,
@@ -241,6 +275,7 @@ func HandleGCPLogs(tempFilePath string, db *sql.DB) {
 			PermissionScope:  permissionScope,
 			LastUsedTime:     lastUsedTime,
 			LastUsedResource: lastUsedResource,
+			Reason:           parseAuthorizationReason(entry.Labels["authorization.k8s.io/reason"]),
 		})
 
 		GlobalProgressBar.Add(1)
@@ -251,11 +286,16 @@ func HandleGCPLogs(tempFilePath string, db *sql.DB) {
 			runtime.GC()
 			debug.FreeOSMemory()
 		}
+		if len(deniedRequests) > 5000 {
+			batchInsertDeniedRequests(db, deniedRequests)
+			deniedRequests = nil
+		}
 	}
 
 	GlobalProgressBar.Stop()
 	println()
 	batchUpdateDatabase(db, updateDataList)
+	batchInsertDeniedRequests(db, deniedRequests)
 
 	// Cleanup temp file
 	fmt.Println("Logs processed, cleaning up temp log file...")
@@ -283,7 +323,15 @@ func getvalues(entry *logging.Entry) (string, string, string, string, string, st
 		return principalEmail, "", "", "", "", "", "", nil
 	}
 
+	// Skip impersonation checks, they're handled separately by getGCPImpersonatedUser
 	authzInfo, ok := authzInfoSlice[0].(map[string]interface{})
+	for _, info := range authzInfoSlice {
+		candidate, isMap := info.(map[string]interface{})
+		if permission, _ := candidate["permission"].(string); isMap && !strings.HasSuffix(permission, ".impersonate") {
+			authzInfo, ok = candidate, true
+			break
+		}
+	}
 	if !ok {
 		return principalEmail, "", "", "", "", "", "", fmt.Errorf("authorization_info[0] is not a map[string]interface{}")
 	}
@@ -345,3 +393,55 @@ func getvalues(entry *logging.Entry) (string, string, string, string, string, st
 
 	return principalEmail, apiGroup, apiVersion, resourceType, verb, namespace, resourceName, nil
 }
+
+// GKE logs don't carry impersonatedUser - derive it from the impersonate authorization checks of the request
+func getGCPImpersonatedUser(entry *logging.Entry) ImpersonatedUser {
+	var impersonated ImpersonatedUser
+	payload, ok := entry.Payload.(map[string]interface{})
+	if !ok {
+		return impersonated
+	}
+	authzInfoSlice, ok := payload["authorization_info"].([]interface{})
+	if !ok {
+		return impersonated
+	}
+
+	for _, info := range authzInfoSlice {
+		authzInfo, ok := info.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		permission, _ := authzInfo["permission"].(string)
+		resource, _ := authzInfo["resource"].(string)
+		if !strings.HasSuffix(permission, ".impersonate") || resource == "" {
+			continue
+		}
+		// Patterns: core/v1/users/{name}, core/v1/groups/{name}, core/v1/namespaces/{namespace}/serviceaccounts/{name}, authentication.k8s.io/v1/uids/{uid}
+		resourceParts := strings.Split(resource, "/")
+		switch {
+		case len(resourceParts) >= 6 && resourceParts[2] == "namespaces" && resourceParts[4] == "serviceaccounts":
+			impersonated.Username = fmt.Sprintf("system:serviceaccount:%s:%s", resourceParts[3], resourceParts[5])
+		case len(resourceParts) >= 4 && resourceParts[2] == "users":
+			impersonated.Username = strings.Join(resourceParts[3:], "/")
+		case len(resourceParts) >= 4 && resourceParts[2] == "groups":
+			impersonated.Groups = append(impersonated.Groups, strings.Join(resourceParts[3:], "/"))
+		case len(resourceParts) >= 4 && resourceParts[2] == "uids":
+			impersonated.UID = strings.Join(resourceParts[3:], "/")
+		}
+	}
+	return impersonated
+}
+
+// Get the status code of the request (0 - OK, 7 - PERMISSION_DENIED)
+func getGCPStatusCode(entry *logging.Entry) int {
+	payload, ok := entry.Payload.(map[string]interface{})
+	if !ok {
+		return 0
+	}
+	status, ok := payload["status"].(map[string]interface{})
+	if !ok {
+		return 0
+	}
+	code, _ := status["code"].(float64)
+	return int(code)
+}
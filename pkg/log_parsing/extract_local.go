@@ -63,7 +63,7 @@ func ExtractLocalLogs(logFile string) (string, error) {
 }
 
 // Handle logs, normalize with helper functions and insert to DB
-func HandleLocalLogs(tempFilePath string, db *sql.DB) {
+func HandleLocalLogs(tempFilePath string, db *sql.DB, recordDenied bool) {
 	fmt.Println("Processing Local Logs...")
 
 	file, err := os.Open(tempFilePath)
@@ -76,6 +76,7 @@ func HandleLocalLogs(tempFilePath string, db *sql.DB) {
 	scanner := bufio.NewScanner(file)
 	userGroups := make(map[string][]string)
 	var updateDataList []UpdateData
+	var deniedRequests []DeniedRequest
 	GlobalProgressBar.Start("cluster events processed")
 
 	for scanner.Scan() {
@@ -86,12 +87,22 @@ func HandleLocalLogs(tempFilePath string, db *sql.DB) {
 			continue
 		}
 
+		// Record denied requests separately, they don't reflect permission usage
+		if recordDenied && event.Stage == "ResponseComplete" && event.ResponseStatus != nil && event.ResponseStatus.Code == 403 && event.ObjectRef != nil {
+			entityName, entityType := NormalizeUsername(event.User.Username)
+			deniedRequests = append(deniedRequests, newDeniedRequest(entityName, entityType,
+				getAPIGroup(event.ObjectRef.APIGroup, event.ObjectRef.APIVersion),
+				getResourceType(event.ObjectRef.Resource, event.ObjectRef.Subresource),
+				event.Verb, event.ObjectRef.Namespace, event.ObjectRef.Name,
+				getLocalLastUsedTime(event.RequestReceivedTimestamp)))
+		}
+
 		if event.Stage == "ResponseComplete" && event.ResponseStatus != nil && event.ResponseStatus.Code == 200 {
-			entityName, entityType := getEntityNameAndType(event.User.Username)
+			entityName, entityType := NormalizeUsername(event.User.Username)
 			entityGroups := event.User.Groups
 			if _, exists := userGroups[entityName]; !exists {
 				userGroups[entityName] = entityGroups
-				handleGroupInheritance(db, entityName, entityGroups)
+				handleGroupInheritance(db, entityName, entityType, entityGroups)
 			}
 			apiGroup := getAPIGroup(event.ObjectRef.APIGroup, event.ObjectRef.APIVersion)
 			resourceType := event.ObjectRef.Resource
@@ -99,6 +110,22 @@ func HandleLocalLogs(tempFilePath string, db *sql.DB) {
 			permissionScope := getPermissionScope(event.ObjectRef.Namespace, event.ObjectRef.Name)
 			lastUsedTime := getLocalLastUsedTime(event.RequestReceivedTimestamp)
 			lastUsedResource := getLastUsedResource(event.ObjectRef.Namespace, event.ObjectRef.Resource, event.ObjectRef.Name)
+
+			// Handle impersonation - the impersonator used impersonate, the action itself is attributed to the impersonated identity
+			if event.ImpersonatedUser != nil && event.ImpersonatedUser.Username != "" {
+				impersonated := ImpersonatedUser{
+					Username: event.ImpersonatedUser.Username,
+					UID:      event.ImpersonatedUser.UID,
+					Groups:   event.ImpersonatedUser.Groups,
+				}
+				updateDataList = append(updateDataList, getImpersonationUpdateData(entityName, entityType, impersonated, lastUsedTime)...)
+				entityName, entityType = NormalizeUsername(impersonated.Username)
+				if _, exists := userGroups[entityName]; !exists {
+					userGroups[entityName] = impersonated.Groups
+					handleGroupInheritance(db, entityName, entityType, impersonated.Groups)
+				}
+			}
+
 			updateDataList = append(updateDataList, UpdateData{
 				EntityName:       entityName,
 				EntityType:       entityType,
@@ -108,6 +135,7 @@ func HandleLocalLogs(tempFilePath string, db *sql.DB) {
 				PermissionScope:  permissionScope,
 				LastUsedTime:     lastUsedTime,
 				LastUsedResource: lastUsedResource,
+				Reason:           parseAuthorizationReason(event.Annotations["authorization.k8s.io/reason"]),
 			})
 		}
 		GlobalProgressBar.Add(1)
@@ -118,11 +146,16 @@ func HandleLocalLogs(tempFilePath string, db *sql.DB) {
 			runtime.GC()
 			debug.FreeOSMemory()
 		}
+		if len(deniedRequests) > 5000 {
+			batchInsertDeniedRequests(db, deniedRequests)
+			deniedRequests = nil
+		}
 	}
 
 	GlobalProgressBar.Stop()
 	println()
 	batchUpdateDatabase(db, updateDataList)
+	batchInsertDeniedRequests(db, deniedRequests)
 
 	// Cleanup temp file
 	fmt.Println("Logs processed, cleaning up temp log file...")
@@ -0,0 +1,100 @@
+package log_parsing
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/oauth2/google"
+	cloudidentity "google.golang.org/api/cloudidentity/v1"
+	"google.golang.org/api/option"
+)
+
+// GKE logs don't contain the groups claim - resolve Google Groups (gke-security-groups) through Cloud Identity instead
+type googleGroupResolver struct {
+	svc        *cloudidentity.Service
+	candidates map[string]string // Lowercase email -> group subject found in the permission table
+	disabled   bool
+}
+
+func newGoogleGroupResolver(creds *google.Credentials, db *sql.DB) *googleGroupResolver {
+	resolver := &googleGroupResolver{candidates: make(map[string]string)}
+
+	rows, err := db.Query(`
+		SELECT DISTINCT entity_name
+		FROM permission
+		WHERE entity_type = 'Group' AND entity_name LIKE '%@%'
+	`)
+	if err != nil {
+		fmt.Printf("Error querying group subjects: %v\n", err)
+		resolver.disabled = true
+		return resolver
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var group string
+		if err := rows.Scan(&group); err != nil {
+			fmt.Printf("Error scanning group subject: %v\n", err)
+			continue
+		}
+		resolver.candidates[strings.ToLower(group)] = group
+	}
+
+	if len(resolver.candidates) == 0 || creds == nil {
+		resolver.disabled = true
+		return resolver
+	}
+
+	svc, err := cloudidentity.NewService(context.Background(), option.WithCredentials(creds))
+	if err != nil {
+		fmt.Printf("Failed to create Cloud Identity client, Google Groups will not be resolved: %v\n", err)
+		resolver.disabled = true
+		return resolver
+	}
+	resolver.svc = svc
+	return resolver
+}
+
+// Get the groups (implicit Kubernetes groups and bound Google Groups) a GKE principal belongs to
+func (r *googleGroupResolver) groupsFor(principal string) []string {
+	groups := []string{"system:authenticated"}
+	if strings.HasPrefix(principal, "system:serviceaccount:") {
+		parts := strings.Split(strings.TrimPrefix(principal, "system:serviceaccount:"), ":")
+		groups = append(groups, "system:serviceaccounts", "system:serviceaccounts:"+parts[0])
+		return groups
+	}
+	if r.disabled || !strings.Contains(principal, "@") {
+		return groups
+	}
+
+	// A single search for the principal's groups, of which only the bound ones matter
+	err := r.svc.Groups.Memberships.SearchTransitiveGroups("groups/-").Query(transitiveGroupsQuery(principal)).
+		Pages(context.Background(), func(resp *cloudidentity.SearchTransitiveGroupsResponse) error {
+			for _, membership := range resp.Memberships {
+				if membership.GroupKey == nil {
+					continue
+				}
+				if group, ok := r.candidates[strings.ToLower(membership.GroupKey.Id)]; ok {
+					groups = append(groups, group)
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		if strings.Contains(err.Error(), "403") {
+			fmt.Printf("Missing permissions to search Google Group memberships, Google Groups will not be resolved: %v\n", err)
+			r.disabled = true
+		} else {
+			fmt.Printf("Error searching the Google Groups of %s: %v\n", principal, err)
+		}
+	}
+	return groups
+}
+
+// The Cloud Identity query for the Google Groups of a principal, which comes from the audit logs and is quoted as a
+// CEL string literal
+func transitiveGroupsQuery(principal string) string {
+	return fmt.Sprintf("member_key_id == %s && 'cloudidentity.googleapis.com/groups.discussion_forum' in labels", strconv.Quote(principal))
+}
@@ -0,0 +1,198 @@
+package log_parsing
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"golang.org/x/oauth2/google"
+	cloudresourcemanager "google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/option"
+	v1 "k8s.io/api/core/v1"
+)
+
+// Project level IAM roles which grant Kubernetes permissions on GKE without any RoleBinding
+type gkeIAMRoleProfile struct {
+	verbs            []string // Empty means every verb
+	excludeVerbs     []string
+	excludeResources []string
+}
+
+var rbacResources = []string{"roles", "clusterroles", "rolebindings", "clusterrolebindings"}
+
+var gkeIAMRoleProfiles = map[string]gkeIAMRoleProfile{
+	"roles/owner":           {},
+	"roles/container.admin": {},
+	"roles/editor": {
+		excludeVerbs:     []string{"bind", "escalate", "impersonate"},
+		excludeResources: rbacResources,
+	},
+	"roles/container.developer": {
+		excludeVerbs:     []string{"bind", "escalate", "impersonate"},
+		excludeResources: rbacResources,
+	},
+	"roles/viewer": {
+		verbs:            []string{"get", "list", "watch"},
+		excludeResources: []string{"secrets"},
+	},
+	"roles/container.viewer": {
+		verbs:            []string{"get", "list", "watch"},
+		excludeResources: []string{"secrets"},
+	},
+}
+
+// Get the project IAM policy and model GKE relevant bindings as a permission source
+func HandleGCPIAMPolicies(creds *google.Credentials, projectID string, db *sql.DB, namespaces *v1.NamespaceList) error {
+	if namespaces == nil {
+		return fmt.Errorf("no namespaces collected, skipping IAM policy mapping")
+	}
+	crmService, err := cloudresourcemanager.NewService(context.Background(), option.WithCredentials(creds))
+	if err != nil {
+		return fmt.Errorf("failed to create resource manager client: %v", err)
+	}
+	policy, err := crmService.Projects.GetIamPolicy(projectID, &cloudresourcemanager.GetIamPolicyRequest{}).Do()
+	if err != nil {
+		return fmt.Errorf("failed to get IAM policy for project %s: %v", projectID, err)
+	}
+
+	// The permissions of the cluster are the same for every member, so they are read once
+	permissions, err := getDistinctPermissions(db)
+	if err != nil {
+		return err
+	}
+	namespacedCache := make(map[string]bool)
+	for _, binding := range policy.Bindings {
+		profile, ok := gkeIAMRoleProfiles[binding.Role]
+		if !ok {
+			continue
+		}
+		for _, member := range binding.Members {
+			entityName, entityType, ok := getIAMMemberEntity(member)
+			if !ok {
+				continue
+			}
+			fmt.Printf("Mapping IAM role %s for %s...\n", binding.Role, entityName)
+			handleGKEIAMRole(entityName, entityType, binding.Role, "projects/"+projectID, profile, permissions, namespaces, namespacedCache, db)
+		}
+	}
+	return nil
+}
+
+// Convert an IAM member (user:, serviceAccount:, group:) to the entity as it appears in GKE audit logs and bindings
+func getIAMMemberEntity(member string) (string, string, bool) {
+	parts := strings.SplitN(member, ":", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	switch parts[0] {
+	case "user", "serviceAccount":
+		// GKE logs the principal email for both, which we attribute as a User
+		entityName, entityType := NormalizeUsername(parts[1])
+		return entityName, entityType, true
+	case "group":
+		return parts[1], "Group", true
+	default:
+		// domain:, allUsers, allAuthenticatedUsers and deleted: principals are not mapped
+		return "", "", false
+	}
+}
+
+// Get every distinct api_group, resource_type and verb of the permission table, as rows without an entity
+func getDistinctPermissions(db *sql.DB) ([]PermissionRow, error) {
+	rows, err := db.Query(`
+		SELECT DISTINCT api_group, resource_type, verb
+		FROM permission
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query permissions: %v", err)
+	}
+	defer rows.Close()
+
+	var permissions []PermissionRow
+	for rows.Next() {
+		var p PermissionRow
+		if err := rows.Scan(&p.api_group, &p.resource_type, &p.verb); err != nil {
+			fmt.Println("Error scanning row:", err)
+			continue
+		}
+		permissions = append(permissions, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read permissions: %v", err)
+	}
+	return permissions, nil
+}
+
+// Handle permissions granted by an IAM role from the permissions of the cluster which its profile allows
+func handleGKEIAMRole(entityName, entityType, role, policyName string, profile gkeIAMRoleProfile, permissions []PermissionRow, namespaces *v1.NamespaceList, namespacedCache map[string]bool, db *sql.DB) {
+	var permissionRows []PermissionRow
+	for _, permission := range permissions {
+		apiGroup, resourceType, verb := permission.api_group, permission.resource_type, permission.verb
+		if !profile.allows(resourceType, verb) {
+			continue
+		}
+
+		parentResource := strings.Split(resourceType, "/")[0]
+		isNamespaced, cached := namespacedCache[parentResource]
+		if !cached {
+			var err error
+			isNamespaced, err = isResourceTypeNamespaced(parentResource, db, namespaces)
+			if err != nil {
+				fmt.Printf("Error checking if resource %s is namespaced: %v\n", resourceType, err)
+				continue
+			}
+			namespacedCache[parentResource] = isNamespaced
+		}
+
+		row := PermissionRow{
+			entity_name:             entityName,
+			entity_type:             entityType,
+			api_group:               apiGroup,
+			resource_type:           resourceType,
+			verb:                    verb,
+			permission_source:       role,
+			permission_source_type:  "GCP IAM Role",
+			permission_binding:      policyName,
+			permission_binding_type: "GCP IAM Policy",
+		}
+		if isNamespaced {
+			for _, ns := range namespaces.Items {
+				row.permission_scope = ns.Name
+				permissionRows = append(permissionRows, row)
+			}
+		} else {
+			row.permission_scope = "cluster-wide"
+			permissionRows = append(permissionRows, row)
+		}
+	}
+
+	for _, row := range permissionRows {
+		if err := insertInheritedPermissionRow(db, row); err != nil {
+			fmt.Printf("Error inserting IAM permission row: %v\n", err)
+		}
+	}
+}
+
+func (p gkeIAMRoleProfile) allows(resourceType, verb string) bool {
+	parentResource := strings.Split(resourceType, "/")[0]
+	for _, excluded := range p.excludeResources {
+		if parentResource == excluded {
+			return false
+		}
+	}
+	for _, excluded := range p.excludeVerbs {
+		if verb == excluded {
+			return false
+		}
+	}
+	if len(p.verbs) == 0 {
+		return true
+	}
+	for _, v := range p.verbs {
+		if verb == v {
+			return true
+		}
+	}
+	return false
+}
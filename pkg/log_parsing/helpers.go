@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
@@ -20,13 +21,6 @@ var sessionCond = sync.NewCond(&sessionMutex)
 var sessionRef *session.Session
 
 // Functions to normalize data from the logs
-func getEntityNameAndType(username string) (string, string) {
-	if strings.HasPrefix(username, "system:serviceaccount:") {
-		return strings.TrimPrefix(username, "system:serviceaccount:"), "ServiceAccount"
-	}
-	return username, "User"
-}
-
 func getAPIGroup(apiGroup, apiVersion string) string {
 	if apiGroup == "" {
 		return apiVersion
@@ -83,6 +77,100 @@ type UpdateData struct {
 	PermissionScope  string
 	LastUsedTime     string
 	LastUsedResource string
+	Reason           AuthorizationReason
+}
+
+// The binding and source which authorized a request, taken from the authorization.k8s.io/reason annotation
+// Empty fields mean the reason wasn't available, in which case usage is marked on every matching permission
+type AuthorizationReason struct {
+	BindingName string
+	BindingType string
+	SourceName  string
+	SourceType  string
+}
+
+var authorizationReasonRegex = regexp.MustCompile(`allowed by (ClusterRoleBinding|RoleBinding) "([^"]*)" of (ClusterRole|Role) "([^"]*)" to (\w+) "([^"]*)"`)
+
+// Parse RBAC reasons, e.g. RBAC: allowed by ClusterRoleBinding "x" of ClusterRole "y" to Group "z"
+func parseAuthorizationReason(reason string) AuthorizationReason {
+	matches := authorizationReasonRegex.FindStringSubmatch(reason)
+	if matches == nil {
+		return AuthorizationReason{}
+	}
+	bindingType, bindingName := matches[1], matches[2]
+	roleType, roleName := matches[3], matches[4]
+	subjectKind, subjectName := matches[5], matches[6]
+
+	// RoleBindings are described as name/namespace
+	if bindingType == "RoleBinding" {
+		if idx := strings.LastIndex(bindingName, "/"); idx != -1 {
+			bindingName = bindingName[:idx]
+		}
+	}
+
+	// EKS access policies are described as a ClusterRoleBinding named principalArn+policy
+	if strings.HasPrefix(reason, "EKS Access Policy") {
+		return AuthorizationReason{
+			BindingName: strings.Split(bindingName, "+")[0],
+			BindingType: "EKS Access Entry",
+		}
+	}
+
+	// Permissions inherited from a group have the group as their source
+	if subjectKind == "Group" {
+		return AuthorizationReason{
+			BindingName: bindingName,
+			BindingType: bindingType,
+			SourceName:  NormalizeGroup(subjectName),
+			SourceType:  "Group",
+		}
+	}
+	return AuthorizationReason{
+		BindingName: bindingName,
+		BindingType: bindingType,
+		SourceName:  roleName,
+		SourceType:  roleType,
+	}
+}
+
+// Identity the request was made as when using impersonation (--as, --as-group, --as-uid)
+type ImpersonatedUser struct {
+	Username string   `json:"username"`
+	UID      string   `json:"uid"`
+	Groups   []string `json:"groups"`
+}
+
+// Get the usage of the impersonator's impersonate permissions - one per impersonated user/serviceaccount, group and uid
+func getImpersonationUpdateData(entityName, entityType string, impersonated ImpersonatedUser, lastUsedTime string) []UpdateData {
+	var updates []UpdateData
+	addUpdate := func(apiGroup, resourceType, namespace, name string) {
+		updates = append(updates, UpdateData{
+			EntityName:       entityName,
+			EntityType:       entityType,
+			APIGroup:         apiGroup,
+			ResourceType:     resourceType,
+			Verb:             "impersonate",
+			PermissionScope:  getPermissionScope(namespace, name),
+			LastUsedTime:     lastUsedTime,
+			LastUsedResource: getLastUsedResource(namespace, resourceType, name),
+		})
+	}
+
+	if strings.HasPrefix(impersonated.Username, "system:serviceaccount:") {
+		parts := strings.SplitN(strings.TrimPrefix(impersonated.Username, "system:serviceaccount:"), ":", 2)
+		if len(parts) == 2 {
+			addUpdate("v1", "serviceaccounts", parts[0], parts[1])
+		}
+	} else if impersonated.Username != "" {
+		addUpdate("v1", "users", "", impersonated.Username)
+	}
+	for _, group := range impersonated.Groups {
+		addUpdate("v1", "groups", "", group)
+	}
+	if impersonated.UID != "" {
+		addUpdate("authentication.k8s.io/v1", "uids", "", impersonated.UID)
+	}
+	return updates
 }
 
 // Update DB in batches
@@ -113,6 +201,8 @@ func batchUpdateDatabase(db *sql.DB, updateDataList []UpdateData) {
 				permission_scope = ? OR
 				(permission_scope like SUBSTRING_INDEX(?, '/', 1) AND ? LIKE '%/%')
 			)
+			AND (? = '' OR (permission_binding = ? AND permission_binding_type = ?))
+			AND (? = '' OR (permission_source = ? AND permission_source_type = ?))
 		`
 
 		stmt, err := tx.Prepare(query)
@@ -123,13 +213,36 @@ func batchUpdateDatabase(db *sql.DB, updateDataList []UpdateData) {
 		}
 		defer stmt.Close()
 
+		existsStmt, err := tx.Prepare(permissionExistsQuery)
+		if err != nil {
+			fmt.Printf("Error preparing statement: %v\n", err)
+			tx.Rollback()
+			return
+		}
+		defer existsStmt.Close()
+
+		var unmatchedRequests []UnmatchedRequest
 		for _, data := range batch {
-			_, err = stmt.Exec(data.LastUsedTime, data.LastUsedResource, data.EntityName, data.EntityType, data.APIGroup, data.ResourceType, data.Verb, data.LastUsedTime, data.PermissionScope, data.PermissionScope, data.PermissionScope)
+			result, err := stmt.Exec(data.LastUsedTime, data.LastUsedResource, data.EntityName, data.EntityType, data.APIGroup, data.ResourceType, data.Verb, data.LastUsedTime, data.PermissionScope, data.PermissionScope, data.PermissionScope,
+				data.Reason.BindingName, data.Reason.BindingName, data.Reason.BindingType,
+				data.Reason.SourceName, data.Reason.SourceName, data.Reason.SourceType)
 			if err != nil {
 				fmt.Printf("Error executing batch update: %v\n", err)
 				tx.Rollback()
 				return
 			}
+
+			// No row updated - either the permission already has a later usage, or there is no current permission for the request
+			if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+				var exists bool
+				err = existsStmt.QueryRow(data.EntityName, data.EntityType, data.APIGroup, data.ResourceType, data.Verb,
+					data.PermissionScope, data.PermissionScope, data.PermissionScope).Scan(&exists)
+				if err != nil {
+					fmt.Printf("Error checking for a matching permission: %v\n", err)
+				} else if !exists {
+					unmatchedRequests = append(unmatchedRequests, newUnmatchedRequest(data))
+				}
+			}
 		}
 
 		err = tx.Commit()
@@ -138,6 +251,7 @@ func batchUpdateDatabase(db *sql.DB, updateDataList []UpdateData) {
 			tx.Rollback()
 			return
 		}
+		batchInsertUnmatchedRequests(db, unmatchedRequests)
 	}
 }
 
@@ -157,9 +271,10 @@ type PermissionRow struct {
 }
 
 // Handle situations where user not in DB, but group from claim is (taken from log) - add group permissions to user (inheritance)
-func handleGroupInheritance(db *sql.DB, username string, groups []string) {
+func handleGroupInheritance(db *sql.DB, entityName, entityType string, groups []string) {
 	var rowData []PermissionRow
 	for _, group := range groups {
+		group = NormalizeGroup(group)
 		rows, err := db.Query(`
                 SELECT entity_name, entity_type, api_group, resource_type, verb, permission_scope,
                        permission_source, permission_source_type, permission_binding, permission_binding_type,
@@ -194,12 +309,8 @@ func handleGroupInheritance(db *sql.DB, username string, groups []string) {
 				continue
 			}
 
-			row.entity_name = username
-			if strings.HasPrefix(username, "system:serviceaccount:") {
-				row.entity_type = "ServiceAccount"
-			} else {
-				row.entity_type = "User"
-			}
+			row.entity_name = entityName
+			row.entity_type = entityType
 			row.permission_source = group
 			row.permission_source_type = "Group"
 
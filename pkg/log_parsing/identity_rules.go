@@ -0,0 +1,200 @@
+package log_parsing
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+// Rules to map raw usernames and group names to canonical entities
+// Binding subjects and audit log identities both go through these rules so that they match in the DB
+type IdentityRule struct {
+	Prefix      string `yaml:"prefix"`      // Match names starting with this prefix
+	Regex       string `yaml:"regex"`       // Or match names with this regular expression
+	Replacement string `yaml:"replacement"` // Regex replacement for the canonical name (capture groups allowed)
+	StripPrefix bool   `yaml:"stripPrefix"` // Remove the matched prefix from the canonical name
+	AppliesTo   string `yaml:"appliesTo"`   // user (default) or group
+	EntityType  string `yaml:"entityType"`  // Entity type for matched users (e.g. User, ServiceAccount, Node, OIDCUser)
+	re          *regexp.Regexp
+}
+
+type identityRulesFile struct {
+	Rules []IdentityRule `yaml:"rules"`
+}
+
+// Built-in rules, always evaluated after the configured ones
+var defaultIdentityRules = []IdentityRule{
+	{Prefix: "system:serviceaccount:", StripPrefix: true, EntityType: "ServiceAccount"},
+	{Prefix: "system:node:", StripPrefix: true, EntityType: "Node"},
+}
+
+var identityRules = defaultIdentityRules
+
+// Load identity normalization rules from a YAML file, e.g.
+//
+//	rules:
+//	  - prefix: "oidc:"
+//	    stripPrefix: true
+//	    entityType: OIDCUser
+//	  - prefix: "oidc:"
+//	    stripPrefix: true
+//	    appliesTo: group
+func LoadIdentityRules(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read identity rules file: %v", err)
+	}
+	var rulesFile identityRulesFile
+	if err := yaml.Unmarshal(data, &rulesFile); err != nil {
+		return fmt.Errorf("failed to parse identity rules file: %v", err)
+	}
+
+	var rules []IdentityRule
+	for i, rule := range rulesFile.Rules {
+		if (rule.Prefix == "") == (rule.Regex == "") {
+			return fmt.Errorf("identity rule %d must set exactly one of prefix or regex", i+1)
+		}
+		if rule.AppliesTo == "" {
+			rule.AppliesTo = "user"
+		}
+		if rule.AppliesTo != "user" && rule.AppliesTo != "group" {
+			return fmt.Errorf("identity rule %d has invalid appliesTo %q (user or group)", i+1, rule.AppliesTo)
+		}
+		if rule.Regex != "" {
+			re, err := regexp.Compile(rule.Regex)
+			if err != nil {
+				return fmt.Errorf("identity rule %d has invalid regex: %v", i+1, err)
+			}
+			rule.re = re
+		}
+		rules = append(rules, rule)
+	}
+	identityRules = append(rules, defaultIdentityRules...)
+	return nil
+}
+
+// Apply a rule to a name, returning the canonical name and whether the rule matched
+func (r IdentityRule) apply(name string) (string, bool) {
+	if r.re != nil {
+		if !r.re.MatchString(name) {
+			return name, false
+		}
+		if r.Replacement != "" {
+			return r.re.ReplaceAllString(name, r.Replacement), true
+		}
+		return name, true
+	}
+	if !strings.HasPrefix(name, r.Prefix) {
+		return name, false
+	}
+	if r.StripPrefix {
+		return strings.TrimPrefix(name, r.Prefix), true
+	}
+	return name, true
+}
+
+func (r IdentityRule) appliesToGroups() bool {
+	return r.AppliesTo == "group"
+}
+
+// Get the canonical entity name and type for a username (first matching rule wins)
+func NormalizeUsername(username string) (string, string) {
+	for _, rule := range identityRules {
+		if rule.appliesToGroups() {
+			continue
+		}
+		if name, ok := rule.apply(username); ok {
+			entityType := rule.EntityType
+			if entityType == "" {
+				entityType = "User"
+			}
+			return name, entityType
+		}
+	}
+	return username, "User"
+}
+
+// Get the canonical name for a group (first matching rule wins)
+func NormalizeGroup(group string) string {
+	for _, rule := range identityRules {
+		if !rule.appliesToGroups() {
+			continue
+		}
+		if name, ok := rule.apply(group); ok {
+			return name
+		}
+	}
+	return group
+}
+
+// Get the canonical entity name and type for an RBAC binding subject
+func NormalizeSubject(kind, name, namespace string) (string, string) {
+	switch kind {
+	case "ServiceAccount":
+		return NormalizeUsername(fmt.Sprintf("system:serviceaccount:%s:%s", namespace, name))
+	case "Group":
+		return NormalizeGroup(name), "Group"
+	default:
+		return NormalizeUsername(name)
+	}
+}
+
+// Get the RBAC subject for a canonical entity (the inverse of NormalizeSubject)
+// Returns false when the raw name can't be recovered, e.g. when a regex rule rewrote it
+func SubjectFromEntity(entityName, entityType string) (rbacv1.Subject, bool) {
+	if entityType == "Group" {
+		for _, candidate := range rawNameCandidates(entityName, "Group") {
+			if NormalizeGroup(candidate) == entityName {
+				return rbacv1.Subject{Kind: "Group", APIGroup: rbacv1.GroupName, Name: candidate}, true
+			}
+		}
+		return rbacv1.Subject{}, false
+	}
+
+	candidates := rawNameCandidates(entityName, entityType)
+	if entityType == "ServiceAccount" {
+		candidates = append([]string{"system:serviceaccount:" + entityName}, candidates...)
+	}
+	for _, candidate := range candidates {
+		if name, kind := NormalizeUsername(candidate); name != entityName || kind != entityType {
+			continue
+		}
+		if strings.HasPrefix(candidate, "system:serviceaccount:") {
+			parts := strings.SplitN(strings.TrimPrefix(candidate, "system:serviceaccount:"), ":", 2)
+			if len(parts) != 2 {
+				return rbacv1.Subject{}, false
+			}
+			return rbacv1.Subject{Kind: "ServiceAccount", Namespace: parts[0], Name: parts[1]}, true
+		}
+		return rbacv1.Subject{Kind: "User", APIGroup: rbacv1.GroupName, Name: candidate}, true
+	}
+	return rbacv1.Subject{}, false
+}
+
+// Possible raw names for a canonical name - names with a stripped prefix first, then the name itself
+func rawNameCandidates(entityName, entityType string) []string {
+	var candidates []string
+	for _, rule := range identityRules {
+		if !rule.StripPrefix || rule.Prefix == "" {
+			continue
+		}
+		if entityType == "Group" {
+			if rule.appliesToGroups() {
+				candidates = append(candidates, rule.Prefix+entityName)
+			}
+			continue
+		}
+		ruleType := rule.EntityType
+		if ruleType == "" {
+			ruleType = "User"
+		}
+		if !rule.appliesToGroups() && ruleType == entityType {
+			candidates = append(candidates, rule.Prefix+entityName)
+		}
+	}
+	return append(candidates, entityName)
+}
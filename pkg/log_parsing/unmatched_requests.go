@@ -0,0 +1,105 @@
+package log_parsing
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Successful requests with no matching permission in the DB - access revoked during the log window,
+// or permissions from sources which aren't collected (e.g. system:masters, the Node authorizer, webhooks)
+type UnmatchedRequest struct {
+	EntityName        string
+	EntityType        string
+	APIGroup          string
+	ResourceType      string
+	Verb              string
+	PermissionScope   string
+	ObservedTime      string
+	ObservedResource  string
+	AuthorizedBy      string
+	AuthorizedByType  string
+	AuthorizedVia     string
+	AuthorizedViaType string
+}
+
+func newUnmatchedRequest(data UpdateData) UnmatchedRequest {
+	return UnmatchedRequest{
+		EntityName:        data.EntityName,
+		EntityType:        data.EntityType,
+		APIGroup:          data.APIGroup,
+		ResourceType:      data.ResourceType,
+		Verb:              data.Verb,
+		PermissionScope:   data.PermissionScope,
+		ObservedTime:      data.LastUsedTime,
+		ObservedResource:  data.LastUsedResource,
+		AuthorizedBy:      data.Reason.BindingName,
+		AuthorizedByType:  data.Reason.BindingType,
+		AuthorizedVia:     data.Reason.SourceName,
+		AuthorizedViaType: data.Reason.SourceType,
+	}
+}
+
+// Check whether any current permission covers the request, regardless of when it was last used or which binding granted it
+const permissionExistsQuery = `
+	SELECT EXISTS (
+		SELECT 1 FROM permission
+		WHERE entity_name = ? AND entity_type = ? AND api_group = ? AND resource_type = ? AND verb = ?
+		AND (
+			permission_scope = ? OR
+			(permission_scope = SUBSTRING_INDEX(?, '/', 1) AND ? LIKE '%/%')
+		)
+	)
+`
+
+// Insert unmatched requests in batches, counting repeated requests
+func batchInsertUnmatchedRequests(db *sql.DB, unmatchedRequests []UnmatchedRequest) {
+	if len(unmatchedRequests) == 0 {
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		fmt.Printf("Error starting transaction: %v\n", err)
+		return
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO unmatched_requests (
+			entity_name, entity_type, api_group, resource_type, verb, permission_scope,
+			observed_count, first_observed_time, last_observed_time, last_observed_resource,
+			authorized_binding, authorized_binding_type, authorized_source, authorized_source_type
+		) VALUES (?, ?, ?, ?, ?, ?, 1, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			observed_count = observed_count + 1,
+			first_observed_time = LEAST(first_observed_time, VALUES(first_observed_time)),
+			last_observed_resource = IF(VALUES(last_observed_time) >= last_observed_time, VALUES(last_observed_resource), last_observed_resource),
+			authorized_binding = IF(VALUES(last_observed_time) >= last_observed_time, VALUES(authorized_binding), authorized_binding),
+			authorized_binding_type = IF(VALUES(last_observed_time) >= last_observed_time, VALUES(authorized_binding_type), authorized_binding_type),
+			authorized_source = IF(VALUES(last_observed_time) >= last_observed_time, VALUES(authorized_source), authorized_source),
+			authorized_source_type = IF(VALUES(last_observed_time) >= last_observed_time, VALUES(authorized_source_type), authorized_source_type),
+			last_observed_time = GREATEST(last_observed_time, VALUES(last_observed_time))
+	`)
+	if err != nil {
+		fmt.Printf("Error preparing statement: %v\n", err)
+		tx.Rollback()
+		return
+	}
+	defer stmt.Close()
+
+	for _, unmatched := range unmatchedRequests {
+		_, err = stmt.Exec(
+			unmatched.EntityName, unmatched.EntityType, unmatched.APIGroup, unmatched.ResourceType, unmatched.Verb, unmatched.PermissionScope,
+			unmatched.ObservedTime, unmatched.ObservedTime, unmatched.ObservedResource,
+			unmatched.AuthorizedBy, unmatched.AuthorizedByType, unmatched.AuthorizedVia, unmatched.AuthorizedViaType,
+		)
+		if err != nil {
+			fmt.Printf("Error inserting unmatched request: %v\n", err)
+			tx.Rollback()
+			return
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		fmt.Printf("Error committing transaction: %v\n", err)
+		tx.Rollback()
+	}
+}
@@ -0,0 +1,385 @@
+package remediation
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Generate least-privilege Roles and ClusterRoles from the permissions observed in the permission table
+
+// What to generate roles for - exactly one of Entity, Binding, ServiceAccount or Workload
+type RoleSelector struct {
+	Entity         string
+	EntityType     string
+	Binding        string
+	BindingType    string
+	ServiceAccount string // namespace:name
+	Workload       string
+	WorkloadType   string
+}
+
+type RoleGenerationOptions struct {
+	Selector          RoleSelector
+	Name              string // Name of the generated roles, derived from the selector if empty
+	IncludeUnobserved bool   // Also keep permissions which are granted but were not observed in the logs
+}
+
+// A single flattened permission from the DB
+type permissionTuple struct {
+	apiGroup     string
+	resourceType string
+	verb         string
+	namespace    string // Empty for cluster-scoped permissions (ClusterRole)
+	resourceName string // Empty when not restricted to a resource name
+	observed     bool
+	lastUsedTime string
+}
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// Resolve the selector to a WHERE clause on the permission table
+func (s RoleSelector) whereClause(db *sql.DB) (string, []interface{}, string, error) {
+	set := 0
+	for _, value := range []string{s.Entity, s.Binding, s.ServiceAccount, s.Workload} {
+		if value != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return "", nil, "", fmt.Errorf("exactly one of entity, binding, service account or workload must be set")
+	}
+
+	switch {
+	case s.Entity != "":
+		if s.EntityType != "" {
+			return "entity_name = ? AND entity_type = ?", []interface{}{s.Entity, s.EntityType}, s.Entity, nil
+		}
+		return "entity_name = ?", []interface{}{s.Entity}, s.Entity, nil
+	case s.Binding != "":
+		if s.BindingType != "" {
+			return "permission_binding = ? AND permission_binding_type = ?", []interface{}{s.Binding, s.BindingType}, s.Binding, nil
+		}
+		return "permission_binding = ?", []interface{}{s.Binding}, s.Binding, nil
+	case s.ServiceAccount != "":
+		return "entity_name = ? AND entity_type = 'ServiceAccount'", []interface{}{s.ServiceAccount}, s.ServiceAccount, nil
+	default:
+		serviceAccount, err := workloadServiceAccount(db, s.Workload, s.WorkloadType)
+		if err != nil {
+			return "", nil, "", err
+		}
+		return "entity_name = ? AND entity_type = 'ServiceAccount'", []interface{}{serviceAccount}, s.Workload, nil
+	}
+}
+
+// Get the ServiceAccount used by a workload from the workload_identities table
+func workloadServiceAccount(db *sql.DB, workload, workloadType string) (string, error) {
+	query := "SELECT DISTINCT service_account_name FROM workload_identities WHERE workload_name = ?"
+	args := []interface{}{workload}
+	if workloadType != "" {
+		query += " AND workload_type = ?"
+		args = append(args, workloadType)
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return "", fmt.Errorf("failed to query workload identities: %v", err)
+	}
+	defer rows.Close()
+
+	var serviceAccounts []string
+	for rows.Next() {
+		var serviceAccount string
+		if err := rows.Scan(&serviceAccount); err != nil {
+			return "", fmt.Errorf("failed to scan workload identity: %v", err)
+		}
+		serviceAccounts = append(serviceAccounts, serviceAccount)
+	}
+	switch len(serviceAccounts) {
+	case 0:
+		return "", fmt.Errorf("workload %s not found (was the collection run with --collect-workloads?)", workload)
+	case 1:
+		return serviceAccounts[0], nil
+	default:
+		return "", fmt.Errorf("workload %s matches several ServiceAccounts (%s), set the workload type or use the service account directly", workload, strings.Join(serviceAccounts, ", "))
+	}
+}
+
+// Split a permission_scope into namespace and resource name
+func parseScope(scope string) (string, string) {
+	if scope == "cluster-wide" {
+		return "", ""
+	}
+	if strings.HasPrefix(scope, "cluster-wide/") {
+		return "", strings.TrimPrefix(scope, "cluster-wide/")
+	}
+	if parts := strings.SplitN(scope, "/", 2); len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return scope, ""
+}
+
+// Convert the api_group column (group/version or version for the core group) to an RBAC apiGroup
+func rbacAPIGroup(apiGroup string) string {
+	if idx := strings.LastIndex(apiGroup, "/"); idx != -1 {
+		return apiGroup[:idx]
+	}
+	return ""
+}
+
+func loadPermissionTuples(db *sql.DB, where string, args []interface{}, includeUnobserved bool) ([]permissionTuple, error) {
+	query := fmt.Sprintf(`
+		SELECT DISTINCT api_group, resource_type, verb, permission_scope, last_used_time
+		FROM permission
+		WHERE %s
+	`, where)
+	if !includeUnobserved {
+		query += " AND last_used_time IS NOT NULL"
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query permissions: %v", err)
+	}
+	defer rows.Close()
+
+	var tuples []permissionTuple
+	for rows.Next() {
+		var apiGroup, resourceType, verb, scope string
+		var lastUsedTime sql.NullString
+		if err := rows.Scan(&apiGroup, &resourceType, &verb, &scope, &lastUsedTime); err != nil {
+			return nil, fmt.Errorf("failed to scan permission: %v", err)
+		}
+		namespace, resourceName := parseScope(scope)
+		tuples = append(tuples, permissionTuple{
+			apiGroup:     rbacAPIGroup(apiGroup),
+			resourceType: resourceType,
+			verb:         verb,
+			namespace:    namespace,
+			resourceName: resourceName,
+			observed:     lastUsedTime.Valid,
+			lastUsedTime: lastUsedTime.String,
+		})
+	}
+	return tuples, rows.Err()
+}
+
+// Sorted, comma separated key for a set of strings
+func setKey(set map[string]bool) string {
+	return strings.Join(sortedKeys(set), ",")
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func splitKey(key string) []string {
+	if key == "" {
+		return nil
+	}
+	return strings.Split(key, ",")
+}
+
+// Collapse flattened permissions into compact rules
+// Resource names are dropped where the unrestricted permission is present, then resources, verbs and
+// apiGroups sharing everything else are merged into the same rule
+func compactRules(tuples []permissionTuple) []rbacv1.PolicyRule {
+	// (apiGroup, resource, verb) -> resource names, nil when unrestricted
+	type grvKey struct{ apiGroup, resource, verb string }
+	names := make(map[grvKey]map[string]bool)
+	unrestricted := make(map[grvKey]bool)
+	for _, t := range tuples {
+		key := grvKey{t.apiGroup, t.resourceType, t.verb}
+		if t.resourceName == "" {
+			unrestricted[key] = true
+			continue
+		}
+		if names[key] == nil {
+			names[key] = make(map[string]bool)
+		}
+		names[key][t.resourceName] = true
+	}
+
+	// (apiGroup, resource, resource names) -> verbs
+	type grnKey struct{ apiGroup, resource, names string }
+	verbs := make(map[grnKey]map[string]bool)
+	addVerb := func(key grnKey, verb string) {
+		if verbs[key] == nil {
+			verbs[key] = make(map[string]bool)
+		}
+		verbs[key][verb] = true
+	}
+	for key := range unrestricted {
+		addVerb(grnKey{key.apiGroup, key.resource, ""}, key.verb)
+	}
+	for key, set := range names {
+		if unrestricted[key] {
+			continue
+		}
+		addVerb(grnKey{key.apiGroup, key.resource, setKey(set)}, key.verb)
+	}
+
+	// (apiGroup, verbs, resource names) -> resources
+	type gvnKey struct{ apiGroup, verbs, names string }
+	resources := make(map[gvnKey]map[string]bool)
+	for key, set := range verbs {
+		merged := gvnKey{key.apiGroup, setKey(set), key.names}
+		if resources[merged] == nil {
+			resources[merged] = make(map[string]bool)
+		}
+		resources[merged][key.resource] = true
+	}
+
+	// (resources, verbs, resource names) -> apiGroups
+	type rvnKey struct{ resources, verbs, names string }
+	apiGroups := make(map[rvnKey]map[string]bool)
+	for key, set := range resources {
+		merged := rvnKey{setKey(set), key.verbs, key.names}
+		if apiGroups[merged] == nil {
+			apiGroups[merged] = make(map[string]bool)
+		}
+		apiGroups[merged][key.apiGroup] = true
+	}
+
+	var rules []rbacv1.PolicyRule
+	for key, set := range apiGroups {
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups:     sortedKeys(set),
+			Resources:     splitKey(key.resources),
+			Verbs:         splitKey(key.verbs),
+			ResourceNames: splitKey(key.names),
+		})
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		a, b := rules[i], rules[j]
+		if strings.Join(a.APIGroups, ",") != strings.Join(b.APIGroups, ",") {
+			return strings.Join(a.APIGroups, ",") < strings.Join(b.APIGroups, ",")
+		}
+		if strings.Join(a.Resources, ",") != strings.Join(b.Resources, ",") {
+			return strings.Join(a.Resources, ",") < strings.Join(b.Resources, ",")
+		}
+		return strings.Join(a.ResourceNames, ",") < strings.Join(b.ResourceNames, ",")
+	})
+	return rules
+}
+
+// Sanitize a name into a valid Kubernetes object name
+func roleName(name string) string {
+	name = invalidNameChars.ReplaceAllString(strings.ToLower(name), "-")
+	name = strings.Trim(name, "-.")
+	if len(name) > 240 {
+		name = name[:240]
+	}
+	return name
+}
+
+// Annotations describing where the generated role came from
+func roleAnnotations(source string, tuples []permissionTuple, includeUnobserved bool) map[string]string {
+	var first, last string
+	unobserved := 0
+	for _, t := range tuples {
+		if !t.observed {
+			unobserved++
+			continue
+		}
+		if first == "" || t.lastUsedTime < first {
+			first = t.lastUsedTime
+		}
+		if t.lastUsedTime > last {
+			last = t.lastUsedTime
+		}
+	}
+	annotations := map[string]string{
+		"kiempossible/generated-from": source,
+	}
+	if last != "" {
+		annotations["kiempossible/observed-usage"] = fmt.Sprintf("%s - %s", first, last)
+	}
+	if includeUnobserved {
+		annotations["kiempossible/unobserved-permissions"] = fmt.Sprintf("%d", unobserved)
+	}
+	return annotations
+}
+
+// Generate the minimal Roles (per namespace) and ClusterRole for the selected entity, binding or workload
+func GenerateRoles(db *sql.DB, opts RoleGenerationOptions) ([]interface{}, error) {
+	where, args, source, err := opts.Selector.whereClause(db)
+	if err != nil {
+		return nil, err
+	}
+	tuples, err := loadPermissionTuples(db, where, args, opts.IncludeUnobserved)
+	if err != nil {
+		return nil, err
+	}
+	if len(tuples) == 0 {
+		return nil, fmt.Errorf("no observed permissions found for %s", source)
+	}
+
+	name := opts.Name
+	if name == "" {
+		name = source + "-minimal"
+	}
+	name = roleName(name)
+
+	byNamespace := make(map[string][]permissionTuple)
+	for _, t := range tuples {
+		byNamespace[t.namespace] = append(byNamespace[t.namespace], t)
+	}
+
+	var objects []interface{}
+	if clusterTuples, ok := byNamespace[""]; ok {
+		objects = append(objects, &rbacv1.ClusterRole{
+			TypeMeta: metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Annotations: roleAnnotations(source, clusterTuples, opts.IncludeUnobserved),
+			},
+			Rules: compactRules(clusterTuples),
+		})
+		delete(byNamespace, "")
+	}
+
+	namespaces := make([]string, 0, len(byNamespace))
+	for namespace := range byNamespace {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	for _, namespace := range namespaces {
+		objects = append(objects, &rbacv1.Role{
+			TypeMeta: metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   namespace,
+				Annotations: roleAnnotations(source, byNamespace[namespace], opts.IncludeUnobserved),
+			},
+			Rules: compactRules(byNamespace[namespace]),
+		})
+	}
+	return objects, nil
+}
+
+// Marshal objects as a multi-document YAML stream
+func MarshalYAMLDocuments(objects []interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	for i, object := range objects {
+		data, err := yaml.Marshal(object)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		buf.Write(data)
+	}
+	return buf.Bytes(), nil
+}
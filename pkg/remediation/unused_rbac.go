@@ -0,0 +1,407 @@
+package remediation
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/log_parsing"
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+// Remediation artifacts for unused roles, bindings and binding subjects
+
+type JSONPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+type RemediationArtifact struct {
+	Action          string               `json:"action"` // delete or remove-subjects
+	ObjectType      string               `json:"object_type"`
+	ObjectName      string               `json:"object_name"`
+	Namespace       string               `json:"namespace,omitempty"`
+	RemovedSubjects []rbacv1.Subject     `json:"removed_subjects,omitempty"`
+	Patch           []JSONPatchOperation `json:"patch,omitempty"`
+	Commands        []string             `json:"commands"`
+	EvidenceWindow  string               `json:"evidence_window"`
+	LastUsedTime    string               `json:"last_used_time"`
+}
+
+type bindingKey struct {
+	name, bindingType, namespace string
+}
+
+type subjectKey struct {
+	name, entityType string
+}
+
+type roleKey struct {
+	name, roleType, namespace string
+}
+
+// Usage of a single binding (in a single namespace for RoleBindings)
+type bindingUsage struct {
+	role            roleKey
+	lastUsed        string
+	subjects        map[subjectKey]string // Subject -> last used time
+	managed         bool                  // Any permission of the binding is managed
+	managedSubjects map[subjectKey]bool
+}
+
+func maxTime(a, b string) string {
+	if b > a {
+		return b
+	}
+	return a
+}
+
+func lastUsedOrNever(lastUsed string) string {
+	if lastUsed == "" {
+		return "never observed"
+	}
+	return lastUsed
+}
+
+// Backup the object before deleting it
+func backupCommand(kind, name, namespace string) string {
+	kind = strings.ToLower(kind)
+	if namespace == "" {
+		return fmt.Sprintf("kubectl get %s %s -o yaml > backup/%s_%s.yaml", kind, name, kind, name)
+	}
+	return fmt.Sprintf("kubectl get %s %s -n %s -o yaml > backup/%s_%s_%s.yaml", kind, name, namespace, kind, namespace, name)
+}
+
+func kubectlCommand(verb, kind, name, namespace string) string {
+	command := fmt.Sprintf("kubectl %s %s %s", verb, strings.ToLower(kind), name)
+	if namespace != "" {
+		command += " -n " + namespace
+	}
+	return command
+}
+
+// Load the usage of every RBAC binding and its subjects from the permission table
+// The permissions of a RoleBinding on cluster-scoped resources are stored cluster-wide, without the binding's namespace,
+// so they count for the RoleBindings of that name in every namespace (from the rows and the binding subjects)
+func loadBindingUsage(db *sql.DB, bindingSubjects map[bindingKey][]rbacv1.Subject) (map[bindingKey]*bindingUsage, error) {
+	rows, err := db.Query(`
+		SELECT
+			entity_name, entity_type, permission_source, permission_source_type,
+			permission_binding, permission_binding_type,
+			IF(permission_binding_type = 'RoleBinding', SUBSTRING_INDEX(permission_scope, '/', 1), '') AS binding_namespace,
+			MAX(last_used_time), MAX(managed)
+		FROM permission
+		WHERE permission_binding_type IN ('RoleBinding', 'ClusterRoleBinding')
+		GROUP BY entity_name, entity_type, permission_source, permission_source_type,
+			permission_binding, permission_binding_type, binding_namespace
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query binding usage: %v", err)
+	}
+	defer rows.Close()
+
+	type usageRow struct {
+		entityName, entityType, sourceName, sourceType, lastUsed string
+		managed                                                  bool
+	}
+	bindings := make(map[bindingKey]*bindingUsage)
+	add := func(key bindingKey, row usageRow) {
+		usage, ok := bindings[key]
+		if !ok {
+			usage = &bindingUsage{subjects: make(map[subjectKey]string), managedSubjects: make(map[subjectKey]bool)}
+			bindings[key] = usage
+		}
+		usage.lastUsed = maxTime(usage.lastUsed, row.lastUsed)
+
+		// Rows inherited from a group are usage of the group subject
+		subject := subjectKey{row.entityName, row.entityType}
+		if row.sourceType == "Group" {
+			subject = subjectKey{row.sourceName, "Group"}
+		} else {
+			roleNamespace := ""
+			if row.sourceType == "Role" {
+				roleNamespace = key.namespace
+			}
+			usage.role = roleKey{row.sourceName, row.sourceType, roleNamespace}
+		}
+		usage.subjects[subject] = maxTime(usage.subjects[subject], row.lastUsed)
+		if row.managed {
+			usage.managed = true
+			usage.managedSubjects[subject] = true
+		}
+	}
+
+	clusterWide := make(map[string][]usageRow) // RoleBinding name -> cluster-wide rows
+	for rows.Next() {
+		var row usageRow
+		var bindingName, bindingType, namespace string
+		var lastUsed sql.NullString
+		if err := rows.Scan(&row.entityName, &row.entityType, &row.sourceName, &row.sourceType, &bindingName, &bindingType, &namespace, &lastUsed, &row.managed); err != nil {
+			return nil, fmt.Errorf("failed to scan binding usage: %v", err)
+		}
+		row.lastUsed = lastUsed.String
+		if bindingType == "RoleBinding" && namespace == "cluster-wide" {
+			clusterWide[bindingName] = append(clusterWide[bindingName], row)
+			continue
+		}
+		add(bindingKey{bindingName, bindingType, namespace}, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	namespaces := make(map[string]map[string]bool) // RoleBinding name -> namespaces
+	addNamespace := func(key bindingKey) {
+		if key.bindingType != "RoleBinding" {
+			return
+		}
+		if namespaces[key.name] == nil {
+			namespaces[key.name] = make(map[string]bool)
+		}
+		namespaces[key.name][key.namespace] = true
+	}
+	for key := range bindings {
+		addNamespace(key)
+	}
+	for key := range bindingSubjects {
+		addNamespace(key)
+	}
+	// Without a known namespace the rows are dropped, there is no binding to remediate
+	for name, rows := range clusterWide {
+		for namespace := range namespaces[name] {
+			for _, row := range rows {
+				add(bindingKey{name, "RoleBinding", namespace}, row)
+			}
+		}
+	}
+	return bindings, nil
+}
+
+// Generate remediation artifacts for bindings and roles with no usage since windowStart, and for
+// subjects of multi-subject bindings with no usage since windowStart
+// Unless includeManaged is set, managed bindings, their roles and managed subjects are left as is
+func GenerateRBACRemediation(db *sql.DB, windowStart, windowEnd string, includeManaged bool) ([]RemediationArtifact, error) {
+	bindingSubjects, err := loadBindingSubjects(db)
+	if err != nil {
+		return nil, err
+	}
+	bindings, err := loadBindingUsage(db, bindingSubjects)
+	if err != nil {
+		return nil, err
+	}
+	defaultClusterRoles, err := loadDefaultClusterRoles(db)
+	if err != nil {
+		return nil, err
+	}
+	evidenceWindow := fmt.Sprintf("%s - %s", windowStart, windowEnd)
+
+	keys := make([]bindingKey, 0, len(bindings))
+	for key := range bindings {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].bindingType != keys[j].bindingType {
+			return keys[i].bindingType < keys[j].bindingType
+		}
+		if keys[i].namespace != keys[j].namespace {
+			return keys[i].namespace < keys[j].namespace
+		}
+		return keys[i].name < keys[j].name
+	})
+
+	var artifacts []RemediationArtifact
+	roleLastUsed := make(map[roleKey]string)
+	roleManaged := make(map[roleKey]bool)
+	var roles []roleKey
+	for _, key := range keys {
+		usage := bindings[key]
+		if usage.role.name != "" {
+			if _, seen := roleLastUsed[usage.role]; !seen {
+				roles = append(roles, usage.role)
+			}
+			roleLastUsed[usage.role] = maxTime(roleLastUsed[usage.role], usage.lastUsed)
+			roleManaged[usage.role] = roleManaged[usage.role] || usage.managed
+		}
+		if usage.managed && !includeManaged && usage.lastUsed < windowStart {
+			continue
+		}
+
+		// Fully unused binding - backup then delete
+		if usage.lastUsed < windowStart {
+			artifacts = append(artifacts, RemediationArtifact{
+				Action:     "delete",
+				ObjectType: key.bindingType,
+				ObjectName: key.name,
+				Namespace:  key.namespace,
+				Commands: []string{
+					backupCommand(key.bindingType, key.name, key.namespace),
+					kubectlCommand("delete", key.bindingType, key.name, key.namespace),
+				},
+				EvidenceWindow: evidenceWindow,
+				LastUsedTime:   lastUsedOrNever(usage.lastUsed),
+			})
+			continue
+		}
+
+		// Partially used binding - remove the unused subjects
+		if artifact, ok := subjectRemovalArtifact(key, usage, bindingSubjects[key], windowStart, evidenceWindow, includeManaged); ok {
+			artifacts = append(artifacts, artifact)
+		}
+	}
+
+	// Roles where every binding is unused - backup then delete. The default roles of the cluster are left as is
+	for _, role := range roles {
+		lastUsed := roleLastUsed[role]
+		if lastUsed >= windowStart || (roleManaged[role] && !includeManaged) {
+			continue
+		}
+		if role.roleType == "ClusterRole" && defaultClusterRoles[role.name] {
+			continue
+		}
+		artifacts = append(artifacts, RemediationArtifact{
+			Action:     "delete",
+			ObjectType: role.roleType,
+			ObjectName: role.name,
+			Namespace:  role.namespace,
+			Commands: []string{
+				backupCommand(role.roleType, role.name, role.namespace),
+				kubectlCommand("delete", role.roleType, role.name, role.namespace),
+			},
+			EvidenceWindow: evidenceWindow,
+			LastUsedTime:   lastUsedOrNever(lastUsed),
+		})
+	}
+	return artifacts, nil
+}
+
+// Build a JSON patch removing the unused subjects from the collected subjects of a binding
+// Each removal is preceded by a test of the subject at its index, so the patch fails if the binding changed since the
+// collection. Subjects are removed from the last, so the indexes of the earlier ones stay valid
+func subjectRemovalArtifact(key bindingKey, usage *bindingUsage, subjects []rbacv1.Subject, windowStart, evidenceWindow string, includeManaged bool) (RemediationArtifact, bool) {
+	var removedIndexes []int
+	var removed []rbacv1.Subject
+	lastUsed := ""
+	for i, subject := range subjects {
+		namespace := subject.Namespace
+		if namespace == "" {
+			namespace = key.namespace
+		}
+		entityName, entityType := log_parsing.NormalizeSubject(subject.Kind, subject.Name, namespace)
+		// Subjects without permission rows (e.g. bound to a missing role) aren't evidence of anything
+		subjectLastUsed, ok := usage.subjects[subjectKey{entityName, entityType}]
+		if !ok || subjectLastUsed >= windowStart || (usage.managedSubjects[subjectKey{entityName, entityType}] && !includeManaged) {
+			continue
+		}
+		removedIndexes = append(removedIndexes, i)
+		removed = append(removed, subject)
+		lastUsed = maxTime(lastUsed, subjectLastUsed)
+	}
+	if len(removed) == 0 || len(removed) == len(subjects) {
+		return RemediationArtifact{}, false
+	}
+
+	var patch []JSONPatchOperation
+	for i := len(removedIndexes) - 1; i >= 0; i-- {
+		path := fmt.Sprintf("/subjects/%d", removedIndexes[i])
+		patch = append(patch,
+			JSONPatchOperation{Op: "test", Path: path, Value: subjects[removedIndexes[i]]},
+			JSONPatchOperation{Op: "remove", Path: path},
+		)
+	}
+	patchJSON, err := json.Marshal(patch)
+	if err != nil {
+		return RemediationArtifact{}, false
+	}
+	return RemediationArtifact{
+		Action:          "remove-subjects",
+		ObjectType:      key.bindingType,
+		ObjectName:      key.name,
+		Namespace:       key.namespace,
+		RemovedSubjects: removed,
+		Patch:           patch,
+		Commands: []string{
+			backupCommand(key.bindingType, key.name, key.namespace),
+			fmt.Sprintf("%s --type=json -p '%s'", kubectlCommand("patch", key.bindingType, key.name, key.namespace), patchJSON),
+		},
+		EvidenceWindow: evidenceWindow,
+		LastUsedTime:   lastUsedOrNever(lastUsed),
+	}, true
+}
+
+// Load the collected subjects of the bindings, in their order in the binding
+func loadBindingSubjects(db *sql.DB) (map[bindingKey][]rbacv1.Subject, error) {
+	rows, err := db.Query(`
+		SELECT binding_type, binding_namespace, binding_name, subject_kind, subject_api_group, subject_name, subject_namespace
+		FROM binding_subjects
+		ORDER BY binding_type, binding_namespace, binding_name, subject_index
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query binding subjects: %v", err)
+	}
+	defer rows.Close()
+
+	subjects := make(map[bindingKey][]rbacv1.Subject)
+	for rows.Next() {
+		var key bindingKey
+		var subject rbacv1.Subject
+		if err := rows.Scan(&key.bindingType, &key.namespace, &key.name, &subject.Kind, &subject.APIGroup, &subject.Name, &subject.Namespace); err != nil {
+			return nil, fmt.Errorf("failed to scan binding subject: %v", err)
+		}
+		subjects[key] = append(subjects[key], subject)
+	}
+	return subjects, rows.Err()
+}
+
+// Load the bootstrap and aggregated ClusterRoles, which are never deleted
+func loadDefaultClusterRoles(db *sql.DB) (map[string]bool, error) {
+	rows, err := db.Query("SELECT name FROM default_cluster_roles")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query default ClusterRoles: %v", err)
+	}
+	defer rows.Close()
+
+	names := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan default ClusterRole: %v", err)
+		}
+		names[name] = true
+	}
+	return names, rows.Err()
+}
+
+// Write the artifacts as a reviewable shell script
+func WriteRemediationScript(path string, artifacts []RemediationArtifact) error {
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\n")
+	b.WriteString("# KIEMPossible remediation for unused RBAC - review every command before running\n")
+	b.WriteString("# Usage absence only covers what was logged in the evidence window\n")
+	b.WriteString("set -e\n")
+	b.WriteString("mkdir -p backup\n")
+	for _, artifact := range artifacts {
+		target := artifact.ObjectType + " " + artifact.ObjectName
+		if artifact.Namespace != "" {
+			target += " (namespace " + artifact.Namespace + ")"
+		}
+		b.WriteString("\n")
+		if artifact.Action == "remove-subjects" {
+			var names []string
+			for _, subject := range artifact.RemovedSubjects {
+				names = append(names, subject.Kind+" "+subject.Name)
+			}
+			fmt.Fprintf(&b, "# Remove unused subjects from %s: %s\n", target, strings.Join(names, ", "))
+		} else {
+			fmt.Fprintf(&b, "# Delete unused %s\n", target)
+		}
+		fmt.Fprintf(&b, "# Evidence window: %s, last used: %s\n", artifact.EvidenceWindow, artifact.LastUsedTime)
+		for _, command := range artifact.Commands {
+			b.WriteString(command + "\n")
+		}
+	}
+	return os.WriteFile(path, []byte(b.String()), 0755)
+}
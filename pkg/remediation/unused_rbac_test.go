@@ -0,0 +1,108 @@
+package remediation
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+func TestSubjectRemovalArtifact(t *testing.T) {
+	key := bindingKey{name: "readers", bindingType: "RoleBinding", namespace: "payments"}
+	subjects := []rbacv1.Subject{
+		{Kind: "User", APIGroup: rbacv1.GroupName, Name: "alice"},
+		{Kind: "ServiceAccount", Name: "old"},
+		{Kind: "User", APIGroup: rbacv1.GroupName, Name: "unbound"},
+		{Kind: "ServiceAccount", Name: "stale", Namespace: "payments"},
+	}
+	usage := &bindingUsage{
+		subjects: map[subjectKey]string{
+			{"alice", "User"}:                    "2026-10-17 10:00:00",
+			{"payments:old", "ServiceAccount"}:   "",
+			{"payments:stale", "ServiceAccount"}: "2026-09-01 10:00:00",
+		},
+		managedSubjects: map[subjectKey]bool{},
+	}
+
+	artifact, ok := subjectRemovalArtifact(key, usage, subjects, "2026-10-11 00:00:00", "window", false)
+	if !ok {
+		t.Fatal("subjectRemovalArtifact() found nothing to remove")
+	}
+	// The subject without permission rows is kept, and the removals go from the last index
+	wantPatch := []JSONPatchOperation{
+		{Op: "test", Path: "/subjects/3", Value: subjects[3]},
+		{Op: "remove", Path: "/subjects/3"},
+		{Op: "test", Path: "/subjects/1", Value: subjects[1]},
+		{Op: "remove", Path: "/subjects/1"},
+	}
+	if !reflect.DeepEqual(artifact.Patch, wantPatch) {
+		t.Errorf("patch = %+v, want %+v", artifact.Patch, wantPatch)
+	}
+	if !reflect.DeepEqual(artifact.RemovedSubjects, []rbacv1.Subject{subjects[1], subjects[3]}) {
+		t.Errorf("removed subjects = %+v", artifact.RemovedSubjects)
+	}
+	if artifact.LastUsedTime != "2026-09-01 10:00:00" {
+		t.Errorf("last used = %s, want the latest usage of the removed subjects", artifact.LastUsedTime)
+	}
+
+	usage.managedSubjects[subjectKey{"payments:old", "ServiceAccount"}] = true
+	usage.managedSubjects[subjectKey{"payments:stale", "ServiceAccount"}] = true
+	if _, ok := subjectRemovalArtifact(key, usage, subjects, "2026-10-11 00:00:00", "window", false); ok {
+		t.Error("subjectRemovalArtifact() removed managed subjects")
+	}
+}
+
+func TestLoadBindingUsageClusterWide(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	columns := []string{"entity_name", "entity_type", "permission_source", "permission_source_type", "permission_binding",
+		"permission_binding_type", "binding_namespace", "last_used_time", "managed"}
+	mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows(columns).
+		// A Role with resources: ["*"], used only on cluster-scoped resources
+		AddRow("alice", "User", "admin", "Role", "admins", "RoleBinding", "payments", nil, false).
+		AddRow("alice", "User", "admin", "Role", "admins", "RoleBinding", "cluster-wide", "2026-10-17 10:00:00", false).
+		// A RoleBinding to cluster-admin with only cluster-wide rows, known from its subjects
+		AddRow("bob", "User", "cluster-admin", "ClusterRole", "ops", "RoleBinding", "cluster-wide", "2026-10-16 10:00:00", false).
+		// A RoleBinding of an unknown namespace
+		AddRow("carol", "User", "view", "ClusterRole", "gone", "RoleBinding", "cluster-wide", nil, false).
+		AddRow("dave", "User", "view", "ClusterRole", "viewers", "ClusterRoleBinding", "", "2026-10-15 10:00:00", false))
+	bindingSubjects := map[bindingKey][]rbacv1.Subject{
+		{"ops", "RoleBinding", "monitoring"}: {{Kind: "User", APIGroup: rbacv1.GroupName, Name: "bob"}},
+	}
+
+	got, err := loadBindingUsage(db, bindingSubjects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[bindingKey]*bindingUsage{
+		{"admins", "RoleBinding", "payments"}: {
+			role:            roleKey{"admin", "Role", "payments"},
+			lastUsed:        "2026-10-17 10:00:00",
+			subjects:        map[subjectKey]string{{"alice", "User"}: "2026-10-17 10:00:00"},
+			managedSubjects: map[subjectKey]bool{},
+		},
+		{"ops", "RoleBinding", "monitoring"}: {
+			role:            roleKey{"cluster-admin", "ClusterRole", ""},
+			lastUsed:        "2026-10-16 10:00:00",
+			subjects:        map[subjectKey]string{{"bob", "User"}: "2026-10-16 10:00:00"},
+			managedSubjects: map[subjectKey]bool{},
+		},
+		{"viewers", "ClusterRoleBinding", ""}: {
+			role:            roleKey{"view", "ClusterRole", ""},
+			lastUsed:        "2026-10-15 10:00:00",
+			subjects:        map[subjectKey]string{{"dave", "User"}: "2026-10-15 10:00:00"},
+			managedSubjects: map[subjectKey]bool{},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		for key, usage := range got {
+			t.Logf("%+v: %+v", key, *usage)
+		}
+		t.Error("loadBindingUsage() didn't fold the cluster-wide rows into the namespaces of their RoleBindings")
+	}
+}
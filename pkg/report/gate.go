@@ -0,0 +1,131 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// CI gate - fail the run when the report has results matching the --fail-on conditions
+// Conditions are a severity (the results of that severity or higher), a rule id, or "new" for results
+// which aren't in a baseline report. Suppressed results never fail the gate
+
+var severityRanks = map[string]int{
+	"critical": 4,
+	"high":     3,
+	"medium":   2,
+	"low":      1,
+}
+
+// A result matching a condition
+type GateViolation struct {
+	Condition string
+	RuleID    string
+	Severity  string
+	Message   string
+}
+
+// Parse comma separated --fail-on conditions
+func ParseFailOn(value string) []string {
+	var conditions []string
+	for _, condition := range strings.Split(value, ",") {
+		if condition = strings.TrimSpace(condition); condition != "" {
+			conditions = append(conditions, condition)
+		}
+	}
+	return conditions
+}
+
+// Whether the conditions need a baseline report
+func NeedsBaseline(conditions []string) bool {
+	for _, condition := range conditions {
+		if condition == "new" {
+			return true
+		}
+	}
+	return false
+}
+
+// Read the sections of a JSON report written by a previous run
+func ReadJSON(path string) (Report, error) {
+	var report Report
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return report, fmt.Errorf("failed to read report: %v", err)
+	}
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(data, &sections); err != nil {
+		return report, fmt.Errorf("failed to parse report (only JSON reports are supported): %v", err)
+	}
+	for key, raw := range sections {
+		var rows []map[string]interface{}
+		if err := json.Unmarshal(raw, &rows); err == nil {
+			report.Add(Section{Key: key, Rows: rows})
+			continue
+		}
+		var group map[string][]map[string]interface{}
+		if err := json.Unmarshal(raw, &group); err != nil {
+			return report, fmt.Errorf("failed to parse report section %s: %v", key, err)
+		}
+		for groupKey, groupRows := range group {
+			report.Add(Section{Key: groupKey, Group: key, Rows: groupRows})
+		}
+	}
+	return report, nil
+}
+
+// Fingerprints of all the results of a report, suppressed or not
+func (r *Report) fingerprints() map[string]bool {
+	fingerprints := make(map[string]bool)
+	for _, section := range r.Sections {
+		if section.SARIF == nil {
+			continue
+		}
+		for _, row := range section.Rows {
+			fingerprints[section.RowFingerprint(row)] = true
+		}
+	}
+	return fingerprints
+}
+
+// Get the unsuppressed results matching any of the conditions, each reported once with the first condition it matches
+func (r *Report) Gate(conditions []string, baseline *Report) []GateViolation {
+	var baselineFingerprints map[string]bool
+	if baseline != nil {
+		baselineFingerprints = baseline.fingerprints()
+	}
+
+	var violations []GateViolation
+	for _, section := range r.Sections {
+		if section.SARIF == nil || section.Group != "" {
+			continue
+		}
+		for _, row := range section.Rows {
+			rule := section.RowRule(row)
+			for _, condition := range conditions {
+				matched := false
+				switch {
+				case condition == "any":
+					matched = true
+				case condition == "new":
+					matched = baselineFingerprints != nil && !baselineFingerprints[section.RowFingerprint(row)]
+				case severityRanks[condition] > 0:
+					matched = severityRanks[rule.Severity] >= severityRanks[condition]
+				default:
+					matched = rule.ID == condition
+				}
+				if matched {
+					violations = append(violations, GateViolation{
+						Condition: condition,
+						RuleID:    rule.ID,
+						Severity:  rule.Severity,
+						Message:   section.RowMessage(row),
+					})
+					break
+				}
+			}
+		}
+	}
+	return violations
+}
@@ -0,0 +1,121 @@
+package report
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var (
+	riskyPermissionsRule = &SARIFRule{ID: "risky-permission", Name: "Risky permission", Severity: "high", SubjectColumns: []string{"entity_name", "rule_id"}}
+	unusedRolesRule      = &SARIFRule{ID: "unused-role", Name: "Unused role", Severity: "low", SubjectColumns: []string{"role_name"}}
+)
+
+func gateReport() *Report {
+	r := &Report{}
+	r.Add(Section{Key: "risky_permissions", SARIF: riskyPermissionsRule, Rows: []map[string]interface{}{
+		{"entity_name": "alice", "rule_id": "KIEM-001", "severity": "critical", "risk_reason": "secret access"},
+		{"entity_name": "bob", "rule_id": "KIEM-004", "severity": "medium"},
+		{"entity_name": "carol", "rule_id": "KIEM-007", "severity": "low"},
+	}})
+	r.Add(Section{Key: "unused_roles", SARIF: unusedRolesRule, Rows: []map[string]interface{}{{"role_name": "old-admin"}}})
+	r.Add(Section{Key: "risky_permissions", Group: "suppressed", SARIF: riskyPermissionsRule, Rows: []map[string]interface{}{
+		{"entity_name": "dave", "rule_id": "KIEM-001", "severity": "critical"},
+	}})
+	r.Add(Section{Key: "entities", Group: "risk_scores", Rows: []map[string]interface{}{{"entity_name": "alice", "score": 95}}})
+	return r
+}
+
+// The conditions and rule ids of the violations
+func violationKeys(violations []GateViolation) []string {
+	var keys []string
+	for _, violation := range violations {
+		keys = append(keys, violation.Condition+" "+violation.RuleID)
+	}
+	return keys
+}
+
+func TestGate(t *testing.T) {
+	tests := []struct {
+		name       string
+		conditions string
+		want       []string
+	}{
+		{"critical", "critical", []string{"critical KIEM-001"}},
+		{"high and above", "high", []string{"high KIEM-001"}},
+		{"medium and above", "medium", []string{"medium KIEM-001", "medium KIEM-004"}},
+		{"low and above", "low", []string{"low KIEM-001", "low KIEM-004", "low KIEM-007", "low unused-role"}},
+		{"rule id of a row", "KIEM-004", []string{"KIEM-004 KIEM-004"}},
+		{"rule id of a section", "unused-role", []string{"unused-role unused-role"}},
+		{"unknown rule id", "KIEM-999", nil},
+		{"any", "any", []string{"any KIEM-001", "any KIEM-004", "any KIEM-007", "any unused-role"}},
+		{"first matching condition", "KIEM-004, critical,medium", []string{"critical KIEM-001", "KIEM-004 KIEM-004"}},
+		{"new without a baseline", "new", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := violationKeys(gateReport().Gate(ParseFailOn(tt.conditions), nil))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Gate(%q) = %v, want %v", tt.conditions, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGateNew(t *testing.T) {
+	// The previous run found alice and suppressed bob
+	previous := &Report{}
+	previous.Add(Section{Key: "risky_permissions", SARIF: riskyPermissionsRule, Rows: []map[string]interface{}{
+		{"entity_name": "alice", "rule_id": "KIEM-001", "severity": "critical"},
+	}})
+	previous.Add(Section{Key: "risky_permissions", Group: "suppressed", SARIF: riskyPermissionsRule, Rows: []map[string]interface{}{
+		{"entity_name": "bob", "rule_id": "KIEM-004", "severity": "medium"},
+	}})
+	previous.Add(Section{Key: "unused_roles", SARIF: unusedRolesRule})
+	path := filepath.Join(t.TempDir(), "baseline.json")
+	if err := previous.Write(path, "json"); err != nil {
+		t.Fatal(err)
+	}
+
+	baseline, err := ReadJSON(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Section definitions aren't part of the JSON report, the caller sets them again
+	rules := map[string]*SARIFRule{"risky_permissions": riskyPermissionsRule, "unused_roles": unusedRolesRule}
+	for i, section := range baseline.Sections {
+		baseline.Sections[i].SARIF = rules[section.Key]
+	}
+
+	got := violationKeys(gateReport().Gate([]string{"new"}, &baseline))
+	if want := []string{"new KIEM-007", "new unused-role"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Gate(new) = %v, want %v", got, want)
+	}
+}
+
+func TestReadJSONErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := ReadJSON(filepath.Join(dir, "missing.json")); err == nil || !strings.Contains(err.Error(), "failed to read report") {
+		t.Errorf("ReadJSON() error = %v for a missing file", err)
+	}
+	for name, content := range map[string]string{
+		"report.sarif": "<html>",
+		"report.json":  `{"risky_permissions": "none"}`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadJSON(path); err == nil || !strings.Contains(err.Error(), "failed to parse report") {
+			t.Errorf("ReadJSON() error = %v for %s", err, content)
+		}
+	}
+}
+
+func TestNeedsBaseline(t *testing.T) {
+	if !NeedsBaseline(ParseFailOn("high, new")) || NeedsBaseline(ParseFailOn("high,KIEM-001")) {
+		t.Error("NeedsBaseline() must be true only with the new condition")
+	}
+}
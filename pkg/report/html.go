@@ -0,0 +1,168 @@
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+)
+
+// Self-contained HTML output, with sortable tables and a drilldown per entity
+
+type htmlCell struct {
+	Text   string
+	Anchor string // Entity drilldown anchor, for entity cells
+}
+
+type htmlTable struct {
+	ID      string
+	Title   string
+	Columns []string
+	Rows    [][]htmlCell
+}
+
+type htmlEntity struct {
+	Anchor string
+	Name   string
+	Type   string
+	Count  int
+	Tables []htmlTable
+}
+
+type htmlReport struct {
+	GeneratedAt string
+	Tables      []htmlTable
+	Entities    []htmlEntity
+}
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>KIEMPossible report</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #1f2328; }
+h1 { margin-bottom: 0; }
+nav a { margin-right: 1em; }
+table { border-collapse: collapse; margin: 1em 0 2em; font-size: 13px; }
+th, td { border: 1px solid #d0d7de; padding: 4px 8px; text-align: left; vertical-align: top; max-width: 40em; word-break: break-word; }
+th { background: #f6f8fa; cursor: pointer; user-select: none; }
+th.asc::after { content: " \25B2"; }
+th.desc::after { content: " \25BC"; }
+tr:nth-child(even) td { background: #fafbfc; }
+details { margin: 0.5em 0; }
+summary { cursor: pointer; font-weight: 600; }
+.muted { color: #656d76; }
+</style>
+</head>
+<body>
+<h1>KIEMPossible report</h1>
+<p class="muted">Generated at {{.GeneratedAt}}</p>
+<nav>{{range .Tables}}<a href="#{{.ID}}">{{.Title}} ({{len .Rows}})</a> {{end}}<a href="#entities">Entities ({{len .Entities}})</a></nav>
+{{define "table"}}{{if .Rows}}<table class="sortable">
+<thead><tr>{{range .Columns}}<th>{{.}}</th>{{end}}</tr></thead>
+<tbody>{{range .Rows}}<tr>{{range .}}<td>{{if .Anchor}}<a href="#{{.Anchor}}">{{.Text}}</a>{{else}}{{.Text}}{{end}}</td>{{end}}</tr>
+{{end}}</tbody>
+</table>{{else}}<p class="muted">No items</p>{{end}}{{end}}
+{{range .Tables}}<h2 id="{{.ID}}">{{.Title}}</h2>
+{{template "table" .}}
+{{end}}
+<h2 id="entities">Entities</h2>
+{{range .Entities}}<details id="{{.Anchor}}">
+<summary>{{.Name}} <span class="muted">{{.Type}} - {{.Count}} items</span></summary>
+{{range .Tables}}<h3>{{.Title}}</h3>
+{{template "table" .}}
+{{end}}</details>
+{{end}}
+<script>
+document.querySelectorAll("table.sortable").forEach(function (table) {
+  table.querySelectorAll("th").forEach(function (th, index) {
+    th.addEventListener("click", function () {
+      var ascending = !th.classList.contains("asc");
+      table.querySelectorAll("th").forEach(function (other) { other.classList.remove("asc", "desc"); });
+      th.classList.add(ascending ? "asc" : "desc");
+      var body = table.tBodies[0];
+      var rows = Array.prototype.slice.call(body.rows);
+      rows.sort(function (a, b) {
+        var x = a.cells[index].textContent, y = b.cells[index].textContent;
+        var nx = parseFloat(x), ny = parseFloat(y);
+        var result = (!isNaN(nx) && !isNaN(ny)) ? nx - ny : x.localeCompare(y);
+        return ascending ? result : -result;
+      });
+      rows.forEach(function (row) { body.appendChild(row); });
+    });
+  });
+});
+document.querySelectorAll("a[href^='#entity-']").forEach(function (link) {
+  link.addEventListener("click", function () {
+    var target = document.getElementById(link.getAttribute("href").slice(1));
+    if (target) { target.open = true; }
+  });
+});
+</script>
+</body>
+</html>
+`))
+
+func (r *Report) writeHTML(w io.Writer) error {
+	type entityKey struct{ name, entityType string }
+	anchors := make(map[entityKey]string)
+	entityTables := make(map[entityKey]map[int]*htmlTable)
+	anchor := func(key entityKey) string {
+		if _, ok := anchors[key]; !ok {
+			anchors[key] = fmt.Sprintf("entity-%d", len(anchors)+1)
+		}
+		return anchors[key]
+	}
+
+	data := htmlReport{GeneratedAt: r.GeneratedAt.Format("2006-01-02 15:04:05")}
+	for i, section := range r.Sections {
+		columns := section.AllColumns()
+		table := htmlTable{ID: fmt.Sprintf("section-%d", i+1), Title: section.FullTitle(), Columns: columns}
+		for _, row := range section.Rows {
+			name, entityType, hasEntity := rowEntity(row)
+			key := entityKey{name, entityType}
+			cells := make([]htmlCell, len(columns))
+			for j, column := range columns {
+				cells[j] = htmlCell{Text: cellText(row[column])}
+				if hasEntity && (column == "entity_name" || column == "service_account_name") {
+					cells[j].Anchor = anchor(key)
+				}
+			}
+			table.Rows = append(table.Rows, cells)
+
+			if hasEntity {
+				if entityTables[key] == nil {
+					entityTables[key] = make(map[int]*htmlTable)
+				}
+				if entityTables[key][i] == nil {
+					entityTables[key][i] = &htmlTable{Title: table.Title, Columns: columns}
+				}
+				entityTables[key][i].Rows = append(entityTables[key][i].Rows, cells)
+			}
+		}
+		data.Tables = append(data.Tables, table)
+	}
+
+	for key, tables := range entityTables {
+		entity := htmlEntity{Anchor: anchors[key], Name: key.name, Type: key.entityType}
+		indexes := make([]int, 0, len(tables))
+		for index := range tables {
+			indexes = append(indexes, index)
+		}
+		sort.Ints(indexes)
+		for _, index := range indexes {
+			entity.Count += len(tables[index].Rows)
+			entity.Tables = append(entity.Tables, *tables[index])
+		}
+		data.Entities = append(data.Entities, entity)
+	}
+	sort.Slice(data.Entities, func(i, j int) bool {
+		if data.Entities[i].Name != data.Entities[j].Name {
+			return data.Entities[i].Name < data.Entities[j].Name
+		}
+		return data.Entities[i].Type < data.Entities[j].Type
+	})
+
+	return htmlTemplate.Execute(w, data)
+}
@@ -0,0 +1,163 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Report data model shared by all the output formats
+// Sections are tables of rows keyed by snake_case column names, as written in the JSON report
+
+type Report struct {
+	GeneratedAt time.Time
+	Sections    []Section
+}
+
+type Section struct {
+	Key     string // Key of the section in the JSON report
+	Group   string // Key of the object the section is nested in (e.g. suppressed), empty for top-level sections
+	Title   string
+	Columns []string // Column order for the tabular formats, other row keys follow in alphabetical order
+	Rows    []map[string]interface{}
+	SARIF   *SARIFRule // Rows are reported as SARIF results when set
+}
+
+var Formats = map[string]string{
+	"json":     "json",
+	"sarif":    "sarif",
+	"csv":      "csv",
+	"markdown": "md",
+	"html":     "html",
+}
+
+func ValidateFormat(format string) error {
+	if _, ok := Formats[format]; !ok {
+		return fmt.Errorf("unsupported output format %q (json, sarif, csv, markdown or html)", format)
+	}
+	return nil
+}
+
+// Default report file name for the format, e.g. kiempossible_report_20240101.json
+func DefaultPath(format string, date time.Time) string {
+	return fmt.Sprintf("kiempossible_report_%s.%s", date.Format("20060102"), Formats[format])
+}
+
+func (r *Report) Add(section Section) {
+	if section.Rows == nil {
+		section.Rows = []map[string]interface{}{}
+	}
+	r.Sections = append(r.Sections, section)
+}
+
+// Write the report in the given format
+func (r *Report) Write(path, format string) error {
+	if err := ValidateFormat(format); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create report file: %v", err)
+	}
+	defer file.Close()
+
+	switch format {
+	case "sarif":
+		err = r.writeSARIF(file)
+	case "csv":
+		err = r.writeCSV(file)
+	case "markdown":
+		err = r.writeMarkdown(file)
+	case "html":
+		err = r.writeHTML(file)
+	default:
+		err = r.writeJSON(file)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s report: %v", format, err)
+	}
+	return nil
+}
+
+func (r *Report) writeJSON(w io.Writer) error {
+	output := make(map[string]interface{})
+	for _, section := range r.Sections {
+		if section.Group == "" {
+			output[section.Key] = section.Rows
+			continue
+		}
+		group, ok := output[section.Group].(map[string]interface{})
+		if !ok {
+			group = make(map[string]interface{})
+			output[section.Group] = group
+		}
+		group[section.Key] = section.Rows
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(output)
+}
+
+// Title of the section including its group
+func (s Section) FullTitle() string {
+	if s.Group == "" {
+		return s.Title
+	}
+	return strings.ToUpper(s.Group[:1]) + strings.ReplaceAll(s.Group[1:], "_", " ") + " - " + s.Title
+}
+
+// Columns of the section - the declared ones, then any other row keys
+func (s Section) AllColumns() []string {
+	columns := append([]string{}, s.Columns...)
+	seen := make(map[string]bool)
+	for _, column := range columns {
+		seen[column] = true
+	}
+	var extra []string
+	for _, row := range s.Rows {
+		for key := range row {
+			if !seen[key] {
+				seen[key] = true
+				extra = append(extra, key)
+			}
+		}
+	}
+	sort.Strings(extra)
+	return append(columns, extra...)
+}
+
+// Render a cell value as text - lists of strings are comma separated, other nested values are JSON
+func cellText(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []string:
+		return strings.Join(v, ", ")
+	case bool, int, int64, float64:
+		return fmt.Sprint(v)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
+	}
+}
+
+// Entity of a row, for the per-entity views
+func rowEntity(row map[string]interface{}) (string, string, bool) {
+	if name, ok := row["entity_name"].(string); ok && name != "" {
+		entityType, _ := row["entity_type"].(string)
+		return name, entityType, true
+	}
+	if name, ok := row["service_account_name"].(string); ok && name != "" {
+		return name, "ServiceAccount", true
+	}
+	return "", "", false
+}
@@ -0,0 +1,239 @@
+package report
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// SARIF 2.1.0 output, for GitHub code scanning and other SARIF consumers
+
+// Rule of the rows of a section, used for rows without their own rule_id, severity and description
+type SARIFRule struct {
+	ID             string
+	Name           string
+	Severity       string // critical, high, medium or low
+	Description    string
+	SubjectColumns []string // Columns identifying the subject of a row, for the result message, location and fingerprint
+}
+
+var sarifLevels = map[string]string{
+	"critical": "error",
+	"high":     "error",
+	"medium":   "warning",
+	"low":      "note",
+}
+
+// security-severity drives the GitHub code scanning severity
+var sarifSecuritySeverities = map[string]string{
+	"critical": "9.5",
+	"high":     "8.0",
+	"medium":   "5.5",
+	"low":      "3.0",
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string               `json:"name"`
+	InformationURI string               `json:"informationUri"`
+	Rules          []sarifReportingRule `json:"rules"`
+}
+
+type sarifText struct {
+	Text string `json:"text"`
+}
+
+type sarifReportingRule struct {
+	ID                   string                 `json:"id"`
+	Name                 string                 `json:"name"`
+	ShortDescription     sarifText              `json:"shortDescription"`
+	FullDescription      sarifText              `json:"fullDescription"`
+	HelpURI              string                 `json:"helpUri,omitempty"`
+	DefaultConfiguration map[string]string      `json:"defaultConfiguration"`
+	Properties           map[string]interface{} `json:"properties"`
+}
+
+type sarifResult struct {
+	RuleID              string                 `json:"ruleId"`
+	Level               string                 `json:"level"`
+	Message             sarifText              `json:"message"`
+	Locations           []sarifLocation        `json:"locations"`
+	PartialFingerprints map[string]string      `json:"partialFingerprints"`
+	Suppressions        []sarifSuppression     `json:"suppressions,omitempty"`
+	Properties          map[string]interface{} `json:"properties,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation  `json:"physicalLocation"`
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation struct {
+		URI string `json:"uri"`
+	} `json:"artifactLocation"`
+	Region struct {
+		StartLine int `json:"startLine"`
+	} `json:"region"`
+}
+
+type sarifLogicalLocation struct {
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
+
+type sarifSuppression struct {
+	Kind          string `json:"kind"`
+	Status        string `json:"status"`
+	Justification string `json:"justification"`
+}
+
+func rowString(row map[string]interface{}, key string) string {
+	value, _ := row[key].(string)
+	return value
+}
+
+// Rule of a row - its own rule_id, severity and description, or the section defaults
+func (s Section) RowRule(row map[string]interface{}) SARIFRule {
+	rule := *s.SARIF
+	if id := rowString(row, "rule_id"); id != "" && rowString(row, "severity") != "" {
+		rule.ID = id
+		rule.Severity = rowString(row, "severity")
+		if name := rowString(row, "risk_reason"); name != "" {
+			rule.Name = strings.ToUpper(name[:1]) + strings.ToLower(name[1:])
+		}
+		if description := rowString(row, "description"); description != "" {
+			rule.Description = description
+		}
+	}
+	return rule
+}
+
+// Subject of a row - the values of the subject columns, as text and as a path
+func (s Section) rowSubject(row map[string]interface{}) (string, string) {
+	var subject, path []string
+	for _, column := range s.SARIF.SubjectColumns {
+		value := cellText(row[column])
+		if value == "" {
+			continue
+		}
+		subject = append(subject, column+": "+value)
+		path = append(path, url.PathEscape(value))
+	}
+	return strings.Join(subject, ", "), s.Key + "/" + strings.Join(path, "/")
+}
+
+// Short description of a row result
+func (s Section) RowMessage(row map[string]interface{}) string {
+	subject, _ := s.rowSubject(row)
+	return s.RowRule(row).Name + " - " + subject
+}
+
+// Stable identity of a row result across reports, regardless of whether it is suppressed
+func (s Section) RowFingerprint(row map[string]interface{}) string {
+	_, qualifiedName := s.rowSubject(row)
+	fingerprint := sha256.Sum256([]byte(s.RowRule(row).ID + "|" + qualifiedName))
+	return hex.EncodeToString(fingerprint[:])
+}
+
+func (s Section) sarifRule(row map[string]interface{}) sarifReportingRule {
+	rule := s.RowRule(row)
+	helpURI := ""
+	if references, ok := row["references"].([]string); ok && len(references) > 0 {
+		helpURI = references[0]
+	}
+	return sarifReportingRule{
+		ID:                   rule.ID,
+		Name:                 rule.Name,
+		ShortDescription:     sarifText{rule.Name},
+		FullDescription:      sarifText{rule.Description},
+		HelpURI:              helpURI,
+		DefaultConfiguration: map[string]string{"level": sarifLevels[rule.Severity]},
+		Properties: map[string]interface{}{
+			"security-severity": sarifSecuritySeverities[rule.Severity],
+			"tags":              []string{"security", "kubernetes", "rbac"},
+		},
+	}
+}
+
+func (s Section) sarifResult(row map[string]interface{}, rule sarifReportingRule) sarifResult {
+	_, qualifiedName := s.rowSubject(row)
+
+	var location sarifLocation
+	location.PhysicalLocation.ArtifactLocation.URI = "kubernetes/" + qualifiedName
+	location.PhysicalLocation.Region.StartLine = 1
+	location.LogicalLocations = []sarifLogicalLocation{{FullyQualifiedName: qualifiedName, Kind: "object"}}
+
+	result := sarifResult{
+		RuleID:              rule.ID,
+		Level:               rule.DefaultConfiguration["level"],
+		Message:             sarifText{s.RowMessage(row)},
+		Locations:           []sarifLocation{location},
+		PartialFingerprints: map[string]string{"kiempossible/v1": s.RowFingerprint(row)},
+	}
+	if score, ok := row["risk_score"]; ok {
+		result.Properties = map[string]interface{}{"risk_score": score}
+	}
+	if justification := rowString(row, "suppression_justification"); justification != "" {
+		result.Suppressions = []sarifSuppression{{Kind: "external", Status: "accepted", Justification: justification}}
+	}
+	return result
+}
+
+func (r *Report) writeSARIF(w io.Writer) error {
+	rules := make(map[string]sarifReportingRule)
+	results := []sarifResult{}
+	for _, section := range r.Sections {
+		if section.SARIF == nil {
+			continue
+		}
+		for _, row := range section.Rows {
+			rule := section.sarifRule(row)
+			if _, ok := rules[rule.ID]; !ok {
+				rules[rule.ID] = rule
+			}
+			results = append(results, section.sarifResult(row, rules[rule.ID]))
+		}
+	}
+
+	ruleIDs := make([]string, 0, len(rules))
+	for id := range rules {
+		ruleIDs = append(ruleIDs, id)
+	}
+	sort.Strings(ruleIDs)
+	driver := sarifDriver{
+		Name:           "KIEMPossible",
+		InformationURI: "https://github.com/PaloAltoNetworks/KIEMPossible",
+		Rules:          []sarifReportingRule{},
+	}
+	for _, id := range ruleIDs {
+		driver.Rules = append(driver.Rules, rules[id])
+	}
+
+	log := sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{{Tool: sarifTool{Driver: driver}, Results: results}},
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(log)
+}
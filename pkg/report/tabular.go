@@ -0,0 +1,78 @@
+package report
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// CSV and Markdown output
+
+// A single CSV table for all the sections - the section and group columns, then the union of the section columns
+func (r *Report) writeCSV(w io.Writer) error {
+	columns := []string{}
+	seen := make(map[string]bool)
+	for _, section := range r.Sections {
+		for _, column := range section.AllColumns() {
+			if !seen[column] {
+				seen[column] = true
+				columns = append(columns, column)
+			}
+		}
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(append([]string{"section", "group"}, columns...)); err != nil {
+		return err
+	}
+	for _, section := range r.Sections {
+		for _, row := range section.Rows {
+			record := []string{section.Key, section.Group}
+			for _, column := range columns {
+				record = append(record, cellText(row[column]))
+			}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func markdownCell(value interface{}) string {
+	text := cellText(value)
+	text = strings.ReplaceAll(text, "|", `\|`)
+	return strings.ReplaceAll(text, "\n", "<br>")
+}
+
+func (r *Report) writeMarkdown(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# KIEMPossible report\n\nGenerated at %s\n\n", r.GeneratedAt.Format("2006-01-02 15:04:05"))
+
+	b.WriteString("| Section | Items |\n| --- | --- |\n")
+	for _, section := range r.Sections {
+		fmt.Fprintf(&b, "| %s | %d |\n", section.FullTitle(), len(section.Rows))
+	}
+
+	for _, section := range r.Sections {
+		fmt.Fprintf(&b, "\n## %s\n\n", section.FullTitle())
+		if len(section.Rows) == 0 {
+			b.WriteString("No items\n")
+			continue
+		}
+		columns := section.AllColumns()
+		b.WriteString("| " + strings.Join(columns, " | ") + " |\n")
+		b.WriteString("|" + strings.Repeat(" --- |", len(columns)) + "\n")
+		for _, row := range section.Rows {
+			cells := make([]string, len(columns))
+			for i, column := range columns {
+				cells[i] = markdownCell(row[column])
+			}
+			b.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,466 @@
+package risk_analysis
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/log_parsing"
+)
+
+// Privilege escalation paths - chains of identities where each one can act as the next (by running
+// workloads with, minting tokens for, reading tokens of or impersonating it), ending at an identity
+// which holds a crown-jewel target
+
+type Identity struct {
+	Name string
+	Type string
+}
+
+// A single step in a path - From can act as To through the permission
+type EscalationStep struct {
+	From            Identity
+	To              Identity
+	Action          string
+	ResourceType    string
+	Verb            string
+	PermissionScope string
+	LastUsedTime    string // Empty when unused in the observed period
+}
+
+type EscalationPath struct {
+	Source       Identity
+	TargetID     string
+	TargetName   string
+	Steps        []EscalationStep
+	TargetHolder Finding // The finding giving the last identity the target
+}
+
+// Whether every step and the target permission were used in the observed period
+func (p EscalationPath) FullyUsed() bool {
+	for _, step := range p.Steps {
+		if step.LastUsedTime == "" {
+			return false
+		}
+	}
+	return p.TargetHolder.LastUsedTime != ""
+}
+
+// Members of system:masters bypass authorization, so impersonating the group reaches every target without a binding
+const mastersGroup = "system:masters"
+
+var workloadResourceTypes = map[string]string{
+	"pods":         "Pod",
+	"deployments":  "Deployment",
+	"statefulsets": "StatefulSet",
+	"replicasets":  "ReplicaSet",
+	"daemonsets":   "DaemonSet",
+	"jobs":         "Job",
+	"cronjobs":     "CronJob",
+}
+
+// ServiceAccounts known to the DB, the ServiceAccounts used by workloads and those with token secrets, and the other
+// users and groups holding permissions, which unrestricted impersonation reaches
+type serviceAccountIndex struct {
+	byNamespace          map[string][]string
+	byWorkload           map[string]string   // Type/namespace/name -> ServiceAccount
+	workloadsByNamespace map[string][]string // ServiceAccounts used by workloads, per namespace
+	byTokenSecret        map[string]string   // Namespace/secret -> ServiceAccount
+	tokensByNamespace    map[string][]string // ServiceAccounts with token secrets, per namespace
+	users                []Identity          // Identities which aren't ServiceAccounts or groups - users, nodes and custom types
+	groups               []string
+}
+
+func serviceAccountNamespace(serviceAccount string) string {
+	return strings.SplitN(serviceAccount, ":", 2)[0]
+}
+
+func loadServiceAccountIndex(db *sql.DB) (serviceAccountIndex, error) {
+	index := serviceAccountIndex{
+		byNamespace:          make(map[string][]string),
+		byWorkload:           make(map[string]string),
+		workloadsByNamespace: make(map[string][]string),
+		byTokenSecret:        make(map[string]string),
+		tokensByNamespace:    make(map[string][]string),
+	}
+	seen := make(map[string]bool)
+	add := func(serviceAccount string) {
+		if !seen[serviceAccount] {
+			seen[serviceAccount] = true
+			namespace := serviceAccountNamespace(serviceAccount)
+			index.byNamespace[namespace] = append(index.byNamespace[namespace], serviceAccount)
+		}
+	}
+
+	rows, err := db.Query("SELECT DISTINCT entity_name, entity_type FROM permission ORDER BY entity_type, entity_name")
+	if err != nil {
+		return index, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var entityName, entityType string
+		if err := rows.Scan(&entityName, &entityType); err != nil {
+			return index, err
+		}
+		switch entityType {
+		case "ServiceAccount":
+			add(entityName)
+		case "Group":
+			index.groups = append(index.groups, entityName)
+		default:
+			index.users = append(index.users, Identity{entityName, entityType})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return index, err
+	}
+
+	workloadRows, err := db.Query("SELECT DISTINCT workload_type, workload_name, service_account_name FROM workload_identities")
+	if err != nil {
+		return index, err
+	}
+	defer workloadRows.Close()
+	usedBy := make(map[string]bool)
+	for workloadRows.Next() {
+		var workloadType, workloadName, serviceAccount string
+		if err := workloadRows.Scan(&workloadType, &workloadName, &serviceAccount); err != nil {
+			return index, err
+		}
+		add(serviceAccount)
+		namespace := serviceAccountNamespace(serviceAccount)
+		index.byWorkload[workloadType+"/"+namespace+"/"+workloadName] = serviceAccount
+		if !usedBy[serviceAccount] {
+			usedBy[serviceAccount] = true
+			index.workloadsByNamespace[namespace] = append(index.workloadsByNamespace[namespace], serviceAccount)
+		}
+	}
+	if err := workloadRows.Err(); err != nil {
+		return index, err
+	}
+
+	secretRows, err := db.Query("SELECT service_account_name, secret_name FROM service_account_token_secrets ORDER BY service_account_name, secret_name")
+	if err != nil {
+		return index, err
+	}
+	defer secretRows.Close()
+	withToken := make(map[string]bool)
+	for secretRows.Next() {
+		var serviceAccount, secretName string
+		if err := secretRows.Scan(&serviceAccount, &secretName); err != nil {
+			return index, err
+		}
+		add(serviceAccount)
+		namespace := serviceAccountNamespace(serviceAccount)
+		index.byTokenSecret[namespace+"/"+secretName] = serviceAccount
+		if !withToken[serviceAccount] {
+			withToken[serviceAccount] = true
+			index.tokensByNamespace[namespace] = append(index.tokensByNamespace[namespace], serviceAccount)
+		}
+	}
+	return index, secretRows.Err()
+}
+
+// Load the permissions which allow acting as another identity
+func loadEscalationPermissions(db *sql.DB) ([]Permission, error) {
+	rows, err := db.Query(`
+		SELECT DISTINCT entity_name, entity_type, resource_type, verb, permission_scope, last_used_time
+		FROM permission
+		WHERE (resource_type IN ('pods', 'deployments', 'statefulsets', 'replicasets', 'daemonsets', 'jobs', 'cronjobs')
+		       AND verb IN ('create', 'patch', 'update'))
+		   OR (resource_type IN ('serviceaccounts/token', 'pods/exec') AND verb = 'create')
+		   OR (resource_type = 'secrets' AND verb IN ('get', 'list'))
+		   OR (resource_type IN ('serviceaccounts', 'users', 'groups') AND verb = 'impersonate')
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions []Permission
+	for rows.Next() {
+		var p Permission
+		var lastUsed sql.NullString
+		if err := rows.Scan(&p.EntityName, &p.EntityType, &p.ResourceType, &p.Verb, &p.PermissionScope, &lastUsed); err != nil {
+			return nil, err
+		}
+		p.LastUsedTime = lastUsed.String
+		permissions = append(permissions, p)
+	}
+	return permissions, rows.Err()
+}
+
+// Split a permission_scope into namespace and resource name
+func splitScope(scope string) (string, string) {
+	if scope == "cluster-wide" {
+		return "", ""
+	}
+	parts := strings.SplitN(scope, "/", 2)
+	if len(parts) == 2 {
+		if parts[0] == "cluster-wide" {
+			return "", parts[1]
+		}
+		return parts[0], parts[1]
+	}
+	return scope, ""
+}
+
+// Get the identities a permission allows acting as
+func escalationEdges(p Permission, index serviceAccountIndex) []EscalationStep {
+	from := Identity{p.EntityName, p.EntityType}
+	namespace, name := splitScope(p.PermissionScope)
+	step := func(to Identity, action string) EscalationStep {
+		return EscalationStep{From: from, To: to, Action: action, ResourceType: p.ResourceType, Verb: p.Verb, PermissionScope: p.PermissionScope, LastUsedTime: p.LastUsedTime}
+	}
+	serviceAccounts := func(serviceAccounts []string, action string) []EscalationStep {
+		var steps []EscalationStep
+		for _, serviceAccount := range serviceAccounts {
+			steps = append(steps, step(Identity{serviceAccount, "ServiceAccount"}, action))
+		}
+		return steps
+	}
+
+	switch {
+	case workloadResourceTypes[p.ResourceType] != "":
+		if namespace == "" {
+			return nil
+		}
+		// Creating a workload allows running it with any ServiceAccount in the namespace, as does
+		// changing the pod template of an existing controller
+		if p.Verb == "create" || (name == "" && p.ResourceType != "pods") {
+			return serviceAccounts(index.byNamespace[namespace], "Run a workload with the ServiceAccount token mounted")
+		}
+		if p.ResourceType != "pods" {
+			if serviceAccount, ok := index.byWorkload[workloadResourceTypes[p.ResourceType]+"/"+namespace+"/"+name]; ok {
+				return serviceAccounts([]string{serviceAccount}, "Change the workload to run commands with its ServiceAccount")
+			}
+		}
+	case p.ResourceType == "serviceaccounts/token":
+		if namespace == "" {
+			return nil
+		}
+		if name != "" {
+			return serviceAccounts([]string{namespace + ":" + name}, "Create a token for the ServiceAccount")
+		}
+		return serviceAccounts(index.byNamespace[namespace], "Create a token for the ServiceAccount")
+	case p.ResourceType == "pods/exec":
+		if namespace == "" {
+			return nil
+		}
+		if name != "" {
+			if serviceAccount, ok := index.byWorkload["Pod/"+namespace+"/"+name]; ok {
+				return serviceAccounts([]string{serviceAccount}, "Exec into the pod and use its ServiceAccount token")
+			}
+			return nil
+		}
+		return serviceAccounts(index.workloadsByNamespace[namespace], "Exec into a pod running with the ServiceAccount and use its token")
+	case p.ResourceType == "secrets":
+		// Legacy ServiceAccount token secrets - only the ServiceAccounts which have one
+		if namespace == "" {
+			return nil
+		}
+		if name != "" {
+			if serviceAccount, ok := index.byTokenSecret[namespace+"/"+name]; ok {
+				return serviceAccounts([]string{serviceAccount}, "Read the ServiceAccount token secret")
+			}
+			return nil
+		}
+		return serviceAccounts(index.tokensByNamespace[namespace], "Read the ServiceAccount token secret")
+	case p.ResourceType == "serviceaccounts":
+		if namespace == "" {
+			return nil
+		}
+		if name != "" {
+			return serviceAccounts([]string{namespace + ":" + name}, "Impersonate the ServiceAccount")
+		}
+		return serviceAccounts(index.byNamespace[namespace], "Impersonate the ServiceAccount")
+	case p.ResourceType == "users":
+		if name != "" {
+			// Identities are named like the bindings and logs, e.g. system:serviceaccount:ns:sa is the ServiceAccount ns:sa
+			entityName, entityType := log_parsing.NormalizeUsername(name)
+			return []EscalationStep{step(Identity{entityName, entityType}, "Impersonate the user")}
+		}
+		// ServiceAccount usernames are checked against the serviceaccounts resource instead
+		var steps []EscalationStep
+		for _, user := range index.users {
+			steps = append(steps, step(user, "Impersonate the user"))
+		}
+		return steps
+	case p.ResourceType == "groups":
+		if name != "" {
+			return []EscalationStep{step(Identity{log_parsing.NormalizeGroup(name), "Group"}, "Impersonate the group")}
+		}
+		steps := []EscalationStep{step(Identity{mastersGroup, "Group"}, "Impersonate the group")}
+		for _, group := range index.groups {
+			if group != mastersGroup {
+				steps = append(steps, step(Identity{group, "Group"}, "Impersonate the group"))
+			}
+		}
+		return steps
+	}
+	return nil
+}
+
+// Get the identities holding each target, with the finding that gives it
+func targetHolders(db *sql.DB, targets []Target, findings []Finding) (map[string]map[Identity]Finding, error) {
+	holders := make(map[string]map[Identity]Finding)
+	for _, target := range targets {
+		holders[target.ID] = make(map[Identity]Finding)
+		var targetFindings []Finding
+		for _, finding := range findings {
+			for _, ruleID := range target.Rules {
+				if finding.RuleID == ruleID {
+					targetFindings = append(targetFindings, finding)
+				}
+			}
+		}
+		if len(target.Conditions) > 0 {
+			conditionFindings, err := evaluateRule(db, Rule{ID: target.ID, Name: target.Name, Conditions: target.Conditions})
+			if err != nil {
+				return nil, err
+			}
+			targetFindings = append(targetFindings, conditionFindings...)
+		}
+		for _, finding := range targetFindings {
+			identity := Identity{finding.EntityName, finding.EntityType}
+			existing, ok := holders[target.ID][identity]
+			if !ok || finding.LastUsedTime > existing.LastUsedTime {
+				holders[target.ID][identity] = finding
+			}
+		}
+	}
+	addMastersHolders(holders, targets)
+	return holders, nil
+}
+
+// Make system:masters a holder of every target, as it isn't bound to any role
+func addMastersHolders(holders map[string]map[Identity]Finding, targets []Target) {
+	masters := Identity{mastersGroup, "Group"}
+	for _, target := range targets {
+		if _, ok := holders[target.ID][masters]; ok {
+			continue
+		}
+		holders[target.ID][masters] = Finding{
+			RuleID:               target.ID,
+			RuleName:             target.Name,
+			Severity:             "critical",
+			Description:          "Members of system:masters bypass authorization",
+			EntityName:           mastersGroup,
+			EntityType:           "Group",
+			PermissionSource:     mastersGroup,
+			PermissionSourceType: "Built-in group",
+			WidestScope:          "cluster",
+		}
+	}
+}
+
+// Compute the shortest escalation path (up to maxSteps) from every identity to every target it doesn't hold directly
+func FindEscalationPaths(db *sql.DB, targets []Target, findings []Finding, maxSteps int) ([]EscalationPath, error) {
+	index, err := loadServiceAccountIndex(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ServiceAccounts: %v", err)
+	}
+	permissions, err := loadEscalationPermissions(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load escalation permissions: %v", err)
+	}
+	holders, err := targetHolders(db, targets, findings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate targets: %v", err)
+	}
+
+	// Reverse edges (to -> steps leading to it), keeping the most recently used step per pair
+	type edgeKey struct{ from, to Identity }
+	edges := make(map[edgeKey]EscalationStep)
+	for _, p := range permissions {
+		for _, step := range escalationEdges(p, index) {
+			if step.From == step.To {
+				continue
+			}
+			key := edgeKey{step.From, step.To}
+			if existing, ok := edges[key]; !ok || step.LastUsedTime > existing.LastUsedTime {
+				edges[key] = step
+			}
+		}
+	}
+	reverse := make(map[Identity][]EscalationStep)
+	for _, step := range edges {
+		reverse[step.To] = append(reverse[step.To], step)
+	}
+	for to := range reverse {
+		steps := reverse[to]
+		sort.Slice(steps, func(i, j int) bool {
+			if steps[i].From.Name != steps[j].From.Name {
+				return steps[i].From.Name < steps[j].From.Name
+			}
+			return steps[i].From.Type < steps[j].From.Type
+		})
+	}
+
+	var paths []EscalationPath
+	for _, target := range targets {
+		// Breadth-first search from the holders over the reverse edges, next[x] is the first step from x
+		next := make(map[Identity]EscalationStep)
+		distance := make(map[Identity]int)
+		var queue []Identity
+		var holderIdentities []Identity
+		for identity := range holders[target.ID] {
+			holderIdentities = append(holderIdentities, identity)
+		}
+		sort.Slice(holderIdentities, func(i, j int) bool {
+			if holderIdentities[i].Name != holderIdentities[j].Name {
+				return holderIdentities[i].Name < holderIdentities[j].Name
+			}
+			return holderIdentities[i].Type < holderIdentities[j].Type
+		})
+		for _, identity := range holderIdentities {
+			distance[identity] = 0
+			queue = append(queue, identity)
+		}
+		for len(queue) > 0 {
+			current := queue[0]
+			queue = queue[1:]
+			if distance[current] >= maxSteps {
+				continue
+			}
+			for _, step := range reverse[current] {
+				if _, visited := distance[step.From]; visited {
+					continue
+				}
+				distance[step.From] = distance[current] + 1
+				next[step.From] = step
+				queue = append(queue, step.From)
+			}
+		}
+
+		for source := range next {
+			path := EscalationPath{Source: source, TargetID: target.ID, TargetName: target.Name}
+			current := source
+			for {
+				step, ok := next[current]
+				if !ok {
+					break
+				}
+				path.Steps = append(path.Steps, step)
+				current = step.To
+			}
+			path.TargetHolder = holders[target.ID][current]
+			paths = append(paths, path)
+		}
+	}
+
+	sort.Slice(paths, func(i, j int) bool {
+		a, b := paths[i], paths[j]
+		if a.TargetID != b.TargetID {
+			return a.TargetID < b.TargetID
+		}
+		if len(a.Steps) != len(b.Steps) {
+			return len(a.Steps) < len(b.Steps)
+		}
+		if a.Source.Name != b.Source.Name {
+			return a.Source.Name < b.Source.Name
+		}
+		return a.Source.Type < b.Source.Type
+	})
+	return paths, nil
+}
@@ -0,0 +1,74 @@
+package risk_analysis
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEscalationEdges(t *testing.T) {
+	index := serviceAccountIndex{
+		byNamespace:          map[string][]string{"payments": {"payments:api", "payments:legacy", "payments:default"}},
+		byWorkload:           map[string]string{"Pod/payments/api-0": "payments:api"},
+		workloadsByNamespace: map[string][]string{"payments": {"payments:api"}},
+		byTokenSecret:        map[string]string{"payments/legacy-token-x7k": "payments:legacy"},
+		tokensByNamespace:    map[string][]string{"payments": {"payments:legacy"}},
+		users:                []Identity{{"alice", "User"}, {"node-1", "Node"}},
+		groups:               []string{"devs", "system:masters"},
+	}
+	tests := []struct {
+		name string
+		p    Permission
+		want []Identity
+	}{
+		{"secrets of a namespace", Permission{ResourceType: "secrets", Verb: "list", PermissionScope: "payments"},
+			[]Identity{{"payments:legacy", "ServiceAccount"}}},
+		{"named token secret", Permission{ResourceType: "secrets", Verb: "get", PermissionScope: "payments/legacy-token-x7k"},
+			[]Identity{{"payments:legacy", "ServiceAccount"}}},
+		{"named other secret", Permission{ResourceType: "secrets", Verb: "get", PermissionScope: "payments/db-password"}, nil},
+		{"namespace without token secrets", Permission{ResourceType: "secrets", Verb: "get", PermissionScope: "billing"}, nil},
+		{"exec into a pod", Permission{ResourceType: "pods/exec", Verb: "create", PermissionScope: "payments/api-0"},
+			[]Identity{{"payments:api", "ServiceAccount"}}},
+		{"impersonate a user", Permission{ResourceType: "users", Verb: "impersonate", PermissionScope: "cluster-wide/alice"},
+			[]Identity{{"alice", "User"}}},
+		{"impersonate a ServiceAccount username", Permission{ResourceType: "users", Verb: "impersonate", PermissionScope: "cluster-wide/system:serviceaccount:payments:api"},
+			[]Identity{{"payments:api", "ServiceAccount"}}},
+		{"impersonate a node username", Permission{ResourceType: "users", Verb: "impersonate", PermissionScope: "cluster-wide/system:node:node-1"},
+			[]Identity{{"node-1", "Node"}}},
+		{"impersonate a group", Permission{ResourceType: "groups", Verb: "impersonate", PermissionScope: "cluster-wide/system:masters"},
+			[]Identity{{"system:masters", "Group"}}},
+		{"impersonate any user", Permission{ResourceType: "users", Verb: "impersonate", PermissionScope: "cluster-wide"},
+			[]Identity{{"alice", "User"}, {"node-1", "Node"}}},
+		{"impersonate any group", Permission{ResourceType: "groups", Verb: "impersonate", PermissionScope: "cluster-wide"},
+			[]Identity{{"system:masters", "Group"}, {"devs", "Group"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []Identity
+			for _, step := range escalationEdges(tt.p, index) {
+				got = append(got, step.To)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("escalationEdges() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddMastersHolders(t *testing.T) {
+	masters := Identity{"system:masters", "Group"}
+	holders := map[string]map[Identity]Finding{
+		"cluster-admin":      {{"alice", "User"}: {RuleID: "KIEM-001", EntityName: "alice", EntityType: "User"}},
+		"kube-system-secret": {masters: {RuleID: "KIEM-002", EntityName: "system:masters", EntityType: "Group", LastUsedTime: "2026-10-18 12:00:00"}},
+	}
+	addMastersHolders(holders, []Target{{ID: "cluster-admin", Name: "Cluster admin"}, {ID: "kube-system-secret", Name: "kube-system secrets"}})
+
+	if holder, ok := holders["cluster-admin"][masters]; !ok || holder.RuleName != "Cluster admin" || holder.PermissionSourceType != "Built-in group" {
+		t.Errorf("addMastersHolders() holder = %+v, want the system:masters holder of the target", holder)
+	}
+	if len(holders["cluster-admin"]) != 2 {
+		t.Errorf("addMastersHolders() = %d holders, want the existing holder kept", len(holders["cluster-admin"]))
+	}
+	if holder := holders["kube-system-secret"][masters]; holder.RuleID != "KIEM-002" {
+		t.Errorf("addMastersHolders() replaced an existing holder with %+v", holder)
+	}
+}
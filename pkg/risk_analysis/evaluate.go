@@ -0,0 +1,296 @@
+package risk_analysis
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+)
+
+// A single row of the permission table
+type Permission struct {
+	EntityName            string
+	EntityType            string
+	APIGroup              string
+	ResourceType          string
+	Verb                  string
+	PermissionScope       string
+	PermissionSource      string
+	PermissionSourceType  string
+	PermissionBinding     string
+	PermissionBindingType string
+	LastUsedTime          string // Empty when unused in the observed period
+	Managed               bool   // Permission of a managed identity or binding
+}
+
+// A rule matched by the permissions an entity gets through a single source and binding
+type Finding struct {
+	RuleID                string
+	RuleName              string
+	Severity              string
+	Description           string
+	References            []string
+	EntityName            string
+	EntityType            string
+	PermissionSource      string
+	PermissionSourceType  string
+	PermissionBinding     string
+	PermissionBindingType string
+	LastUsedTime          string // Latest usage of the matched permissions, empty when unused
+	WidestScope           string // Widest scope type of the matched permissions - cluster, namespace or resourceName
+	Namespace             string // Namespace of the matched permissions, empty when cluster scoped or in several namespaces
+	Managed               bool   // Matched permissions of a managed identity or binding
+	Score                 int    // Set by ScoreFindings
+}
+
+// A workload whose ServiceAccount has a finding
+type WorkloadFinding struct {
+	WorkloadType       string
+	WorkloadName       string
+	ServiceAccountName string
+	Finding            Finding
+	Score              int // The finding's score adjusted by the workload's pod spec
+	AutomountToken     bool
+	Privileged         bool
+	HostPath           bool
+	HostNetwork        bool
+	HostPID            bool
+	ServiceExposure    string
+	CloudIdentity      string // Cloud identity the workload's pods can assume through the ServiceAccount
+	CloudIdentityType  string // IRSA, EKSPodIdentity, AzureWorkloadIdentity or GKEWorkloadIdentity
+}
+
+type findingKey struct {
+	entityName, entityType, source, sourceType, binding, bindingType string
+}
+
+// Progress of a rule for a single key
+type ruleMatch struct {
+	conditions []bool
+	verbs      []map[string]bool // Verbs seen per condition, for allVerbs
+	lastUsed   string
+	scope      string
+	namespaces map[string]bool // "" for cluster scoped permissions
+	managed    bool
+}
+
+func (m *ruleMatch) matched(rule Rule) bool {
+	for i, condition := range rule.Conditions {
+		if !m.conditions[i] {
+			return false
+		}
+		if condition.AllVerbs && len(m.verbs[i]) < len(condition.Verbs) {
+			return false
+		}
+	}
+	return true
+}
+
+// Get the permissions which may match the condition
+func queryConditionPermissions(db *sql.DB, condition Condition) ([]Permission, error) {
+	filter, args := condition.sqlFilter()
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT entity_name, entity_type, api_group, resource_type, verb, permission_scope,
+		       permission_source, permission_source_type, permission_binding, permission_binding_type, last_used_time, managed
+		FROM permission
+		WHERE %s
+	`, filter), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions []Permission
+	for rows.Next() {
+		var p Permission
+		var lastUsed sql.NullString
+		if err := rows.Scan(&p.EntityName, &p.EntityType, &p.APIGroup, &p.ResourceType, &p.Verb, &p.PermissionScope,
+			&p.PermissionSource, &p.PermissionSourceType, &p.PermissionBinding, &p.PermissionBindingType, &lastUsed, &p.Managed); err != nil {
+			return nil, err
+		}
+		p.LastUsedTime = lastUsed.String
+		if condition.matches(p) {
+			permissions = append(permissions, p)
+		}
+	}
+	return permissions, rows.Err()
+}
+
+// Evaluate a single rule against the permission table
+func evaluateRule(db *sql.DB, rule Rule) ([]Finding, error) {
+	matches := make(map[findingKey]*ruleMatch)
+	for i, condition := range rule.Conditions {
+		permissions, err := queryConditionPermissions(db, condition)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range permissions {
+			key := findingKey{p.EntityName, p.EntityType, p.PermissionSource, p.PermissionSourceType, p.PermissionBinding, p.PermissionBindingType}
+			match, ok := matches[key]
+			if !ok {
+				match = &ruleMatch{
+					conditions: make([]bool, len(rule.Conditions)),
+					verbs:      make([]map[string]bool, len(rule.Conditions)),
+					namespaces: make(map[string]bool),
+				}
+				matches[key] = match
+			}
+			match.conditions[i] = true
+			if match.verbs[i] == nil {
+				match.verbs[i] = make(map[string]bool)
+			}
+			match.verbs[i][p.Verb] = true
+			if p.LastUsedTime > match.lastUsed {
+				match.lastUsed = p.LastUsedTime
+			}
+			if scopeWeights[scopeType(p.PermissionScope)] > scopeWeights[match.scope] {
+				match.scope = scopeType(p.PermissionScope)
+			}
+			match.namespaces[ScopeNamespace(p.PermissionScope)] = true
+			match.managed = match.managed || p.Managed
+		}
+	}
+
+	var findings []Finding
+	for key, match := range matches {
+		if !match.matched(rule) {
+			continue
+		}
+		namespace := ""
+		if len(match.namespaces) == 1 {
+			for ns := range match.namespaces {
+				namespace = ns
+			}
+		}
+		findings = append(findings, Finding{
+			RuleID:                rule.ID,
+			RuleName:              rule.Name,
+			Severity:              rule.Severity,
+			Description:           rule.Description,
+			References:            rule.References,
+			EntityName:            key.entityName,
+			EntityType:            key.entityType,
+			PermissionSource:      key.source,
+			PermissionSourceType:  key.sourceType,
+			PermissionBinding:     key.binding,
+			PermissionBindingType: key.bindingType,
+			LastUsedTime:          match.lastUsed,
+			WidestScope:           match.scope,
+			Namespace:             namespace,
+			Managed:               match.managed,
+		})
+	}
+	return findings, nil
+}
+
+// Evaluate all the rules, sorted by entity and rule
+func EvaluateRules(db *sql.DB, rules []Rule) ([]Finding, error) {
+	var findings []Finding
+	for _, rule := range rules {
+		ruleFindings, err := evaluateRule(db, rule)
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate rule %s: %v", rule.ID, err)
+		}
+		findings = append(findings, ruleFindings...)
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if a.EntityName != b.EntityName {
+			return a.EntityName < b.EntityName
+		}
+		if a.EntityType != b.EntityType {
+			return a.EntityType < b.EntityType
+		}
+		if a.RuleName != b.RuleName {
+			return a.RuleName < b.RuleName
+		}
+		if a.PermissionBinding != b.PermissionBinding {
+			return a.PermissionBinding < b.PermissionBinding
+		}
+		return a.PermissionSource < b.PermissionSource
+	})
+	return findings, nil
+}
+
+// Get the workloads running with a ServiceAccount that has findings, one per workload and rule
+func WorkloadFindings(db *sql.DB, findings []Finding) ([]WorkloadFinding, error) {
+	// Keep a single finding per ServiceAccount and rule - the highest scored one
+	serviceAccountFindings := make(map[string]map[string]Finding)
+	for _, finding := range findings {
+		if finding.EntityType != "ServiceAccount" {
+			continue
+		}
+		if serviceAccountFindings[finding.EntityName] == nil {
+			serviceAccountFindings[finding.EntityName] = make(map[string]Finding)
+		}
+		existing, ok := serviceAccountFindings[finding.EntityName][finding.RuleID]
+		if !ok || finding.Score > existing.Score {
+			serviceAccountFindings[finding.EntityName][finding.RuleID] = finding
+		}
+	}
+	if len(serviceAccountFindings) == 0 {
+		return nil, nil
+	}
+
+	// The type of the cloud identity comes from the identities of the ServiceAccount
+	rows, err := db.Query(`
+		SELECT DISTINCT w.workload_type, w.workload_name, w.service_account_name,
+			w.automount_token, w.privileged, w.host_path, w.host_network, w.host_pid, w.service_exposure, w.workload_identity,
+			COALESCE((
+				SELECT MIN(i.identity_type) FROM service_account_identities i
+				WHERE i.service_account_name = w.service_account_name AND i.identity = w.workload_identity
+			), '')
+		FROM workload_identities w
+		ORDER BY w.workload_type, w.workload_name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query workload identities: %v", err)
+	}
+	defer rows.Close()
+
+	var workloadFindings []WorkloadFinding
+	for rows.Next() {
+		var workload WorkloadFinding
+		err := rows.Scan(&workload.WorkloadType, &workload.WorkloadName, &workload.ServiceAccountName,
+			&workload.AutomountToken, &workload.Privileged, &workload.HostPath, &workload.HostNetwork, &workload.HostPID, &workload.ServiceExposure,
+			&workload.CloudIdentity, &workload.CloudIdentityType)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan workload identity: %v", err)
+		}
+		byRule := serviceAccountFindings[workload.ServiceAccountName]
+		ruleIDs := make([]string, 0, len(byRule))
+		for ruleID := range byRule {
+			ruleIDs = append(ruleIDs, ruleID)
+		}
+		sort.Strings(ruleIDs)
+		for _, ruleID := range ruleIDs {
+			workloadFinding := workload
+			workloadFinding.Finding = byRule[ruleID]
+			workloadFinding.Score = workloadFindingScore(workloadFinding)
+			workloadFindings = append(workloadFindings, workloadFinding)
+		}
+	}
+	return workloadFindings, rows.Err()
+}
+
+// Get the cloud identities of the ServiceAccounts, as "identity (type)" keyed by namespace:name
+func ServiceAccountIdentities(db *sql.DB) (map[string][]string, error) {
+	rows, err := db.Query(`
+		SELECT service_account_name, identity_type, identity
+		FROM service_account_identities
+		ORDER BY service_account_name, identity_type, identity
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query service account identities: %v", err)
+	}
+	defer rows.Close()
+
+	identities := make(map[string][]string)
+	for rows.Next() {
+		var serviceAccount, identityType, identity string
+		if err := rows.Scan(&serviceAccount, &identityType, &identity); err != nil {
+			return nil, fmt.Errorf("failed to scan service account identity: %v", err)
+		}
+		identities[serviceAccount] = append(identities[serviceAccount], fmt.Sprintf("%s (%s)", identity, identityType))
+	}
+	return identities, rows.Err()
+}
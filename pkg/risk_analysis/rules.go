@@ -0,0 +1,273 @@
+package risk_analysis
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Declarative risk rules, matched against the flattened permissions in the permission table
+
+//go:embed default_rules.yaml
+var defaultRulesYAML []byte
+
+type Rule struct {
+	ID          string      `yaml:"id"`
+	Name        string      `yaml:"name"`
+	Severity    string      `yaml:"severity"` // critical, high, medium or low
+	Description string      `yaml:"description"`
+	References  []string    `yaml:"references"`
+	Conditions  []Condition `yaml:"conditions"` // Every condition must be matched
+	Disabled    bool        `yaml:"disabled"`   // Used by user rules to turn off a built-in rule
+}
+
+// A condition is matched by a single permission. Empty fields match anything, values support globs
+type Condition struct {
+	APIGroups  []string `yaml:"apiGroups"`  // API group without the version, "" for the core group
+	Resources  []string `yaml:"resources"`  // Resource or resource/subresource
+	Verbs      []string `yaml:"verbs"`      // Any of the verbs, or all of them with allVerbs
+	AllVerbs   bool     `yaml:"allVerbs"`   // Every verb must be granted (with the same source and binding)
+	Scopes     []string `yaml:"scopes"`     // permission_scope values, e.g. cluster-wide, kube-system, kube-system/*
+	ScopeTypes []string `yaml:"scopeTypes"` // cluster, namespace or resourceName
+}
+
+// Crown-jewel capability which escalation paths are computed to
+type Target struct {
+	ID          string      `yaml:"id"`
+	Name        string      `yaml:"name"`
+	Description string      `yaml:"description"`
+	Rules       []string    `yaml:"rules"`      // Reached by identities with a finding of any of these rules
+	Conditions  []Condition `yaml:"conditions"` // Or by identities whose permissions match all of these conditions
+	Disabled    bool        `yaml:"disabled"`
+}
+
+type RuleSet struct {
+	Rules   []Rule
+	Targets []Target
+}
+
+type rulesFile struct {
+	Rules   []Rule   `yaml:"rules"`
+	Targets []Target `yaml:"targets"`
+}
+
+var severityWeights = map[string]int{
+	"critical": 4,
+	"high":     3,
+	"medium":   2,
+	"low":      1,
+}
+
+func validateConditions(id string, conditions []Condition) error {
+	for _, condition := range conditions {
+		for _, scopeType := range condition.ScopeTypes {
+			if scopeType != "cluster" && scopeType != "namespace" && scopeType != "resourceName" {
+				return fmt.Errorf("%s has invalid scopeType %q (cluster, namespace or resourceName)", id, scopeType)
+			}
+		}
+	}
+	return nil
+}
+
+func parseRules(data []byte) (rulesFile, error) {
+	var file rulesFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return file, err
+	}
+	for _, rule := range file.Rules {
+		if rule.ID == "" {
+			return file, fmt.Errorf("rule %q has no id", rule.Name)
+		}
+		if rule.Disabled {
+			continue
+		}
+		if rule.Name == "" || len(rule.Conditions) == 0 {
+			return file, fmt.Errorf("rule %s must have a name and at least one condition", rule.ID)
+		}
+		if _, ok := severityWeights[rule.Severity]; !ok {
+			return file, fmt.Errorf("rule %s has invalid severity %q (critical, high, medium or low)", rule.ID, rule.Severity)
+		}
+		if err := validateConditions("rule "+rule.ID, rule.Conditions); err != nil {
+			return file, err
+		}
+	}
+	for _, target := range file.Targets {
+		if target.ID == "" {
+			return file, fmt.Errorf("target %q has no id", target.Name)
+		}
+		if target.Disabled {
+			continue
+		}
+		if target.Name == "" || (len(target.Rules) == 0 && len(target.Conditions) == 0) {
+			return file, fmt.Errorf("target %s must have a name and rules or conditions", target.ID)
+		}
+		if err := validateConditions("target "+target.ID, target.Conditions); err != nil {
+			return file, err
+		}
+	}
+	return file, nil
+}
+
+// Load the built-in rules and targets, and the user ones from userRulesPath (optional)
+// User rules and targets with the id of a built-in one replace it, or turn it off when disabled
+func LoadRules(userRulesPath string) (RuleSet, error) {
+	defaults, err := parseRules(defaultRulesYAML)
+	if err != nil {
+		return RuleSet{}, fmt.Errorf("failed to parse built-in rules: %v", err)
+	}
+	rules, targets := defaults.Rules, defaults.Targets
+
+	if userRulesPath != "" {
+		data, err := os.ReadFile(userRulesPath)
+		if err != nil {
+			return RuleSet{}, fmt.Errorf("failed to read rules file: %v", err)
+		}
+		user, err := parseRules(data)
+		if err != nil {
+			return RuleSet{}, fmt.Errorf("failed to parse rules file: %v", err)
+		}
+		for _, userRule := range user.Rules {
+			replaced := false
+			for i := range rules {
+				if rules[i].ID == userRule.ID {
+					rules[i] = userRule
+					replaced = true
+					break
+				}
+			}
+			if !replaced {
+				rules = append(rules, userRule)
+			}
+		}
+		for _, userTarget := range user.Targets {
+			replaced := false
+			for i := range targets {
+				if targets[i].ID == userTarget.ID {
+					targets[i] = userTarget
+					replaced = true
+					break
+				}
+			}
+			if !replaced {
+				targets = append(targets, userTarget)
+			}
+		}
+	}
+
+	var ruleSet RuleSet
+	for _, rule := range rules {
+		if !rule.Disabled {
+			ruleSet.Rules = append(ruleSet.Rules, rule)
+		}
+	}
+	for _, target := range targets {
+		if !target.Disabled {
+			ruleSet.Targets = append(ruleSet.Targets, target)
+		}
+	}
+	return ruleSet, nil
+}
+
+func matchesAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if pattern == value {
+			return true
+		}
+		if ok, err := path.Match(pattern, value); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether a resource (or resource/subresource) is named by a condition of any of the rules
+// Conditions without resources aren't about a resource, and don't make every resource sensitive
+func (rs RuleSet) SensitiveResource(resourceType string) bool {
+	for _, rule := range rs.Rules {
+		for _, condition := range rule.Conditions {
+			if len(condition.Resources) > 0 && matchesAny(condition.Resources, resourceType) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Type of a permission_scope value - cluster, namespace or resourceName
+func scopeType(scope string) string {
+	if scope == "cluster-wide" {
+		return "cluster"
+	}
+	if strings.Contains(scope, "/") {
+		return "resourceName"
+	}
+	return "namespace"
+}
+
+// Namespace of a permission_scope value, empty when cluster scoped
+func ScopeNamespace(scope string) string {
+	if scopeType(scope) == "cluster" || strings.HasPrefix(scope, "cluster-wide/") {
+		return ""
+	}
+	return strings.SplitN(scope, "/", 2)[0]
+}
+
+// Get the API group (without the version) from the api_group column
+func apiGroupName(apiGroup string) string {
+	if idx := strings.LastIndex(apiGroup, "/"); idx != -1 {
+		return apiGroup[:idx]
+	}
+	return ""
+}
+
+func (c Condition) matches(p Permission) bool {
+	return matchesAny(c.APIGroups, apiGroupName(p.APIGroup)) &&
+		matchesAny(c.Resources, p.ResourceType) &&
+		matchesAny(c.Verbs, p.Verb) &&
+		matchesAny(c.Scopes, p.PermissionScope) &&
+		matchesAny(c.ScopeTypes, scopeType(p.PermissionScope))
+}
+
+// Literal values (no globs) which can be used to filter the permission table in SQL
+func literalValues(values []string) ([]string, bool) {
+	if len(values) == 0 {
+		return nil, false
+	}
+	for _, value := range values {
+		if strings.ContainsAny(value, "*?[") {
+			return nil, false
+		}
+	}
+	return values, true
+}
+
+// SQL filter selecting the permissions which may match the condition
+func (c Condition) sqlFilter() (string, []interface{}) {
+	var clauses []string
+	var args []interface{}
+	for _, filter := range []struct {
+		column string
+		values []string
+	}{{"resource_type", c.Resources}, {"verb", c.Verbs}} {
+		column := filter.column
+		literals, ok := literalValues(filter.values)
+		if !ok {
+			continue
+		}
+		clauses = append(clauses, fmt.Sprintf("%s IN (?%s)", column, strings.Repeat(", ?", len(literals)-1)))
+		for _, literal := range literals {
+			args = append(args, literal)
+		}
+	}
+	if len(clauses) == 0 {
+		return "1 = 1", nil
+	}
+	return strings.Join(clauses, " AND "), args
+}
@@ -0,0 +1,248 @@
+package risk_analysis
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestConditionMatches(t *testing.T) {
+	secrets := Permission{APIGroup: "v1", ResourceType: "secrets", Verb: "get", PermissionScope: "kube-system"}
+	tests := []struct {
+		name      string
+		condition Condition
+		p         Permission
+		want      bool
+	}{
+		{"empty condition", Condition{}, secrets, true},
+		{"resource and verb", Condition{Resources: []string{"secrets"}, Verbs: []string{"get", "list"}}, secrets, true},
+		{"other verb", Condition{Resources: []string{"secrets"}, Verbs: []string{"list"}}, secrets, false},
+		{"core group", Condition{APIGroups: []string{""}}, secrets, true},
+		{"named group", Condition{APIGroups: []string{"apps"}}, Permission{APIGroup: "apps/v1", ResourceType: "deployments"}, true},
+		{"group glob", Condition{APIGroups: []string{"*.k8s.io"}}, Permission{APIGroup: "rbac.authorization.k8s.io/v1"}, true},
+		{"resource glob", Condition{Resources: []string{"pods/*"}}, Permission{ResourceType: "pods/exec"}, true},
+		{"resource glob without subresource", Condition{Resources: []string{"pods/*"}}, Permission{ResourceType: "pods"}, false},
+		{"scope", Condition{Scopes: []string{"kube-system", "kube-system/*"}}, secrets, true},
+		{"scope glob", Condition{Scopes: []string{"kube-system/*"}}, Permission{PermissionScope: "kube-system/admin-token"}, true},
+		{"other scope", Condition{Scopes: []string{"kube-system"}}, Permission{PermissionScope: "payments"}, false},
+		{"cluster scope type", Condition{ScopeTypes: []string{"cluster"}}, Permission{PermissionScope: "cluster-wide"}, true},
+		{"namespace scope type", Condition{ScopeTypes: []string{"cluster"}}, secrets, false},
+		{"resource name scope type", Condition{ScopeTypes: []string{"resourceName"}}, Permission{PermissionScope: "payments/api"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.condition.matches(tt.p); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConditionSQLFilter(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition Condition
+		want      string
+		wantArgs  []interface{}
+	}{
+		{"no fields", Condition{}, "1 = 1", nil},
+		{"literals", Condition{Resources: []string{"secrets"}, Verbs: []string{"get", "list"}},
+			"resource_type IN (?) AND verb IN (?, ?)", []interface{}{"secrets", "get", "list"}},
+		{"globs are matched in Go", Condition{Resources: []string{"pods/*"}, Verbs: []string{"create"}},
+			"verb IN (?)", []interface{}{"create"}},
+		{"only globs", Condition{Verbs: []string{"*"}}, "1 = 1", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, args := tt.condition.sqlFilter()
+			if got != tt.want || !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("sqlFilter() = %q %v, want %q %v", got, args, tt.want, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestRuleMatchMatched(t *testing.T) {
+	rule := Rule{Conditions: []Condition{
+		{Resources: []string{"nodes/proxy"}, Verbs: []string{"create", "get"}, AllVerbs: true},
+		{Resources: []string{"nodes"}, Verbs: []string{"list"}},
+	}}
+	tests := []struct {
+		name       string
+		conditions []bool
+		verbs      []map[string]bool
+		want       bool
+	}{
+		{"every condition and verb", []bool{true, true}, []map[string]bool{{"create": true, "get": true}, {"list": true}}, true},
+		{"missing verb of allVerbs", []bool{true, true}, []map[string]bool{{"get": true}, {"list": true}}, false},
+		{"missing condition", []bool{true, false}, []map[string]bool{{"create": true, "get": true}, nil}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &ruleMatch{conditions: tt.conditions, verbs: tt.verbs}
+			if got := m.matched(rule); got != tt.want {
+				t.Errorf("matched() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+var permissionColumns = []string{
+	"entity_name", "entity_type", "api_group", "resource_type", "verb", "permission_scope",
+	"permission_source", "permission_source_type", "permission_binding", "permission_binding_type", "last_used_time", "managed",
+}
+
+func TestEvaluateRuleAllVerbs(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// alice is granted both verbs through one binding, bob gets them through two bindings
+	mock.ExpectQuery("FROM permission").WithArgs("nodes/proxy", "create", "get").WillReturnRows(sqlmock.NewRows(permissionColumns).
+		AddRow("alice", "User", "v1", "nodes/proxy", "create", "cluster-wide", "proxy", "ClusterRole", "alice-proxy", "ClusterRoleBinding", nil, false).
+		AddRow("alice", "User", "v1", "nodes/proxy", "get", "cluster-wide", "proxy", "ClusterRole", "alice-proxy", "ClusterRoleBinding", "2026-10-17 10:00:00", false).
+		AddRow("bob", "User", "v1", "nodes/proxy", "create", "cluster-wide", "proxy-create", "ClusterRole", "bob-create", "ClusterRoleBinding", nil, false).
+		AddRow("bob", "User", "v1", "nodes/proxy", "get", "cluster-wide", "proxy-get", "ClusterRole", "bob-get", "ClusterRoleBinding", nil, false))
+
+	rule := Rule{ID: "KIEM-002", Name: "nodes/proxy", Severity: "critical", Conditions: []Condition{
+		{Resources: []string{"nodes/proxy"}, Verbs: []string{"create", "get"}, AllVerbs: true, ScopeTypes: []string{"cluster"}},
+	}}
+	findings, err := evaluateRule(db, rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if len(findings) != 1 {
+		t.Fatalf("evaluateRule() = %d findings, want 1", len(findings))
+	}
+	f := findings[0]
+	if f.EntityName != "alice" || f.PermissionBinding != "alice-proxy" || f.LastUsedTime != "2026-10-17 10:00:00" || f.WidestScope != "cluster" {
+		t.Errorf("finding = %+v", f)
+	}
+}
+
+func writeRules(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func findRule(rules []Rule, id string) (Rule, bool) {
+	for _, rule := range rules {
+		if rule.ID == id {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+func TestLoadRulesUserRules(t *testing.T) {
+	path := writeRules(t, `
+rules:
+  - id: KIEM-001
+    name: Secret access
+    severity: low
+    conditions:
+      - resources: [secrets]
+        verbs: [list]
+  - id: KIEM-006
+    disabled: true
+  - id: ORG-001
+    name: ConfigMap writes
+    severity: medium
+    conditions:
+      - resources: [configmaps]
+        verbs: [update]
+targets:
+  - id: admission-control
+    disabled: true
+`)
+	ruleSet, err := LoadRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule, ok := findRule(ruleSet.Rules, "KIEM-001"); !ok || rule.Severity != "low" {
+		t.Errorf("KIEM-001 = %+v, want the user rule", rule)
+	}
+	if _, ok := findRule(ruleSet.Rules, "KIEM-006"); ok {
+		t.Error("disabled KIEM-006 is loaded")
+	}
+	if _, ok := findRule(ruleSet.Rules, "ORG-001"); !ok {
+		t.Error("new user rule ORG-001 isn't loaded")
+	}
+	if _, ok := findRule(ruleSet.Rules, "KIEM-002"); !ok {
+		t.Error("built-in rule KIEM-002 isn't loaded")
+	}
+	for _, target := range ruleSet.Targets {
+		if target.ID == "admission-control" {
+			t.Error("disabled target admission-control is loaded")
+		}
+	}
+}
+
+func TestLoadRulesErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"invalid YAML", "rules: [", "failed to parse rules file"},
+		{"missing id", "rules:\n  - name: No id\n    severity: low\n    conditions: [{verbs: [get]}]\n", "has no id"},
+		{"no conditions", "rules:\n  - id: ORG-001\n    name: Empty\n    severity: low\n", "at least one condition"},
+		{"invalid severity", "rules:\n  - id: ORG-001\n    name: Bad\n    severity: urgent\n    conditions: [{verbs: [get]}]\n", "invalid severity"},
+		{"invalid scope type", "rules:\n  - id: ORG-001\n    name: Bad\n    severity: low\n    conditions: [{scopeTypes: [global]}]\n", "invalid scopeType"},
+		{"target without rules", "targets:\n  - id: jewels\n    name: Jewels\n", "rules or conditions"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadRules(writeRules(t, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadRules() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := LoadRules(filepath.Join(t.TempDir(), "missing.yaml")); err == nil || !strings.Contains(err.Error(), "failed to read rules file") {
+		t.Errorf("LoadRules() error = %v for a missing file", err)
+	}
+}
+
+func TestDefaultWorkloadCreationScope(t *testing.T) {
+	ruleSet, err := LoadRules("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule, ok := findRule(ruleSet.Rules, "KIEM-006")
+	if !ok {
+		t.Fatal("KIEM-006 isn't a built-in rule")
+	}
+	for scope, want := range map[string]bool{"cluster-wide": true, "kube-system": true, "kube-system/api": true, "payments": false} {
+		p := Permission{APIGroup: "v1", ResourceType: "pods", Verb: "create", PermissionScope: scope}
+		if got := rule.Conditions[0].matches(p); got != want {
+			t.Errorf("KIEM-006 matches pods creation in %s = %v, want %v", scope, got, want)
+		}
+	}
+}
+
+func TestSensitiveResource(t *testing.T) {
+	ruleSet := RuleSet{Rules: []Rule{
+		{ID: "A", Conditions: []Condition{{Resources: []string{"secrets", "pods/*"}}}},
+		{ID: "B", Conditions: []Condition{{Verbs: []string{"impersonate"}}}},
+	}}
+	for resource, want := range map[string]bool{"secrets": true, "pods/exec": true, "pods": false, "configmaps": false} {
+		if got := ruleSet.SensitiveResource(resource); got != want {
+			t.Errorf("SensitiveResource(%q) = %v, want %v", resource, got, want)
+		}
+	}
+}
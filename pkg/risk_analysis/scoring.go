@@ -0,0 +1,216 @@
+package risk_analysis
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+)
+
+// Risk scores (0-100) for prioritizing findings, entities, bindings and workloads
+// A finding's score combines the rule severity and the scope of the matched permissions, raised when the
+// permissions are unused (removing them is unlikely to break anything) and when the identity runs in workloads
+
+// Aggregated score of an entity, binding or workload
+type Score struct {
+	Name         string
+	Type         string
+	Score        int
+	FindingCount int
+}
+
+var scopeWeights = map[string]float64{
+	"":             0,
+	"resourceName": 0.5,
+	"namespace":    1,
+	"cluster":      1.5,
+}
+
+const (
+	severityPoints      = 10 // Per severity weight (low 1 - critical 4)
+	neverUsedPoints     = 20
+	maxStaleUsagePoints = 10 // For permissions used, scaled by the days since the last usage
+	staleUsageDays      = 30
+	workloadPoints      = 15
+	extraFindingPoints  = 2 // For every finding beyond the highest scored one when aggregating
+	maxScore            = 100
+)
+
+// Workload findings are adjusted by the pod spec - how easily the ServiceAccount token can be taken from the workload,
+// and what else the workload can reach
+const (
+	unmountedTokenFactor = 0.5 // The token isn't mounted into the pods
+	privilegedPoints     = 20
+	hostAccessPoints     = 10 // hostPath volumes, hostNetwork or hostPID
+)
+
+var exposurePoints = map[string]float64{
+	"":             0,
+	"ClusterIP":    5,
+	"NodePort":     10,
+	"LoadBalancer": 10,
+}
+
+// Get the ServiceAccounts used by workloads
+func workloadServiceAccounts(db *sql.DB) (map[string]bool, error) {
+	rows, err := db.Query("SELECT DISTINCT service_account_name FROM workload_identities")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	serviceAccounts := make(map[string]bool)
+	for rows.Next() {
+		var serviceAccount string
+		if err := rows.Scan(&serviceAccount); err != nil {
+			return nil, err
+		}
+		serviceAccounts[serviceAccount] = true
+	}
+	return serviceAccounts, rows.Err()
+}
+
+func findingScore(finding Finding, runsInWorkloads bool, now time.Time) int {
+	score := float64(severityWeights[finding.Severity]*severityPoints) * scopeWeights[finding.WidestScope]
+
+	if finding.LastUsedTime == "" {
+		score += neverUsedPoints
+	} else if lastUsed, err := time.Parse("2006-01-02 15:04:05", finding.LastUsedTime); err == nil {
+		days := now.Sub(lastUsed).Hours() / 24
+		if days > staleUsageDays {
+			days = staleUsageDays
+		}
+		if days > 0 {
+			score += maxStaleUsagePoints * days / staleUsageDays
+		}
+	}
+
+	if runsInWorkloads {
+		score += workloadPoints
+	}
+	if score > maxScore {
+		score = maxScore
+	}
+	return int(score + 0.5)
+}
+
+// Set the score of every finding
+func ScoreFindings(db *sql.DB, findings []Finding) error {
+	serviceAccounts, err := workloadServiceAccounts(db)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for i := range findings {
+		runsInWorkloads := findings[i].EntityType == "ServiceAccount" && serviceAccounts[findings[i].EntityName]
+		findings[i].Score = findingScore(findings[i], runsInWorkloads, now)
+	}
+	return nil
+}
+
+// Aggregate finding scores by key - the highest score, raised for every additional finding
+func aggregateScores(scores map[Score][]int) []Score {
+	var aggregated []Score
+	for key, values := range scores {
+		sort.Sort(sort.Reverse(sort.IntSlice(values)))
+		score := values[0] + extraFindingPoints*(len(values)-1)
+		if score > maxScore {
+			score = maxScore
+		}
+		aggregated = append(aggregated, Score{Name: key.Name, Type: key.Type, Score: score, FindingCount: len(values)})
+	}
+	sort.Slice(aggregated, func(i, j int) bool {
+		if aggregated[i].Score != aggregated[j].Score {
+			return aggregated[i].Score > aggregated[j].Score
+		}
+		if aggregated[i].Name != aggregated[j].Name {
+			return aggregated[i].Name < aggregated[j].Name
+		}
+		return aggregated[i].Type < aggregated[j].Type
+	})
+	return aggregated
+}
+
+// Scores per entity and per binding, highest first
+func EntityAndBindingScores(findings []Finding) ([]Score, []Score) {
+	entities := make(map[Score][]int)
+	bindings := make(map[Score][]int)
+	for _, finding := range findings {
+		entity := Score{Name: finding.EntityName, Type: finding.EntityType}
+		entities[entity] = append(entities[entity], finding.Score)
+		binding := Score{Name: finding.PermissionBinding, Type: finding.PermissionBindingType}
+		bindings[binding] = append(bindings[binding], finding.Score)
+	}
+	return aggregateScores(entities), aggregateScores(bindings)
+}
+
+func workloadFindingScore(workloadFinding WorkloadFinding) int {
+	score := float64(workloadFinding.Finding.Score)
+	if !workloadFinding.AutomountToken {
+		score *= unmountedTokenFactor
+	}
+	if workloadFinding.Privileged {
+		score += privilegedPoints
+	}
+	if workloadFinding.HostPath || workloadFinding.HostNetwork || workloadFinding.HostPID {
+		score += hostAccessPoints
+	}
+	score += exposurePoints[workloadFinding.ServiceExposure]
+	if score > maxScore {
+		score = maxScore
+	}
+	return int(score + 0.5)
+}
+
+// Workloads are identified by namespace/name, the namespace is taken from the ServiceAccount
+func workloadScoreKey(workloadFinding WorkloadFinding) Score {
+	return Score{Name: serviceAccountNamespace(workloadFinding.ServiceAccountName) + "/" + workloadFinding.WorkloadName, Type: workloadFinding.WorkloadType}
+}
+
+// Scores per workload, highest first
+func WorkloadScores(workloadFindings []WorkloadFinding) []Score {
+	workloads := make(map[Score][]int)
+	for _, workloadFinding := range workloadFindings {
+		key := workloadScoreKey(workloadFinding)
+		workloads[key] = append(workloads[key], workloadFinding.Score)
+	}
+	return aggregateScores(workloads)
+}
+
+func scoreIndex(scores []Score) map[Score]int {
+	index := make(map[Score]int)
+	for _, score := range scores {
+		index[Score{Name: score.Name, Type: score.Type}] = score.Score
+	}
+	return index
+}
+
+// Sort findings by the score of their entity, then by their own score
+func SortFindingsByScore(findings []Finding, entityScores []Score) {
+	index := scoreIndex(entityScores)
+	sort.SliceStable(findings, func(i, j int) bool {
+		a := index[Score{Name: findings[i].EntityName, Type: findings[i].EntityType}]
+		b := index[Score{Name: findings[j].EntityName, Type: findings[j].EntityType}]
+		if a != b {
+			return a > b
+		}
+		if findings[i].EntityName != findings[j].EntityName || findings[i].EntityType != findings[j].EntityType {
+			return findings[i].EntityName+findings[i].EntityType < findings[j].EntityName+findings[j].EntityType
+		}
+		return findings[i].Score > findings[j].Score
+	})
+}
+
+// Sort workload findings by the score of their workload, then by their own score
+func SortWorkloadFindingsByScore(workloadFindings []WorkloadFinding, workloadScores []Score) {
+	index := scoreIndex(workloadScores)
+	sort.SliceStable(workloadFindings, func(i, j int) bool {
+		a := index[workloadScoreKey(workloadFindings[i])]
+		b := index[workloadScoreKey(workloadFindings[j])]
+		if a != b {
+			return a > b
+		}
+		if keyA, keyB := workloadScoreKey(workloadFindings[i]), workloadScoreKey(workloadFindings[j]); keyA != keyB {
+			return keyA.Name+keyA.Type < keyB.Name+keyB.Type
+		}
+		return workloadFindings[i].Score > workloadFindings[j].Score
+	})
+}
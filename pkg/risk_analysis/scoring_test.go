@@ -0,0 +1,101 @@
+package risk_analysis
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFindingScore(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) string {
+		return now.AddDate(0, 0, -days).Format("2006-01-02 15:04:05")
+	}
+	tests := []struct {
+		name            string
+		finding         Finding
+		runsInWorkloads bool
+		want            int
+	}{
+		{"never used cluster-wide critical in workloads", Finding{Severity: "critical", WidestScope: "cluster"}, true, 95},
+		{"never used resource name low", Finding{Severity: "low", WidestScope: "resourceName"}, false, 25},
+		{"used now", Finding{Severity: "high", WidestScope: "namespace", LastUsedTime: daysAgo(0)}, false, 30},
+		{"used half the stale period ago", Finding{Severity: "high", WidestScope: "namespace", LastUsedTime: daysAgo(15)}, false, 35},
+		{"stale usage is capped", Finding{Severity: "high", WidestScope: "namespace", LastUsedTime: daysAgo(90)}, false, 40},
+		{"usage after now", Finding{Severity: "high", WidestScope: "namespace", LastUsedTime: daysAgo(-1)}, false, 30},
+		{"rounded", Finding{Severity: "medium", WidestScope: "resourceName", LastUsedTime: daysAgo(2)}, false, 11},
+		{"invalid usage time", Finding{Severity: "medium", WidestScope: "cluster", LastUsedTime: "yesterday"}, false, 30},
+		{"no scope", Finding{Severity: "critical"}, false, 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := findingScore(tt.finding, tt.runsInWorkloads, now); got != tt.want {
+				t.Errorf("findingScore() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAggregateScores(t *testing.T) {
+	tests := []struct {
+		name   string
+		scores map[Score][]int
+		want   []Score
+	}{
+		{
+			name:   "highest score raised per additional finding",
+			scores: map[Score][]int{{Name: "alice", Type: "User"}: {40, 80, 60}},
+			want:   []Score{{Name: "alice", Type: "User", Score: 84, FindingCount: 3}},
+		},
+		{
+			name:   "capped",
+			scores: map[Score][]int{{Name: "payments:api", Type: "ServiceAccount"}: {99, 99, 90}},
+			want:   []Score{{Name: "payments:api", Type: "ServiceAccount", Score: 100, FindingCount: 3}},
+		},
+		{
+			name: "sorted by score, name and type",
+			scores: map[Score][]int{
+				{Name: "devs", Type: "User"}:  {50},
+				{Name: "devs", Type: "Group"}: {50},
+				{Name: "alice", Type: "User"}: {50},
+				{Name: "bob", Type: "User"}:   {70},
+			},
+			want: []Score{
+				{Name: "bob", Type: "User", Score: 70, FindingCount: 1},
+				{Name: "alice", Type: "User", Score: 50, FindingCount: 1},
+				{Name: "devs", Type: "Group", Score: 50, FindingCount: 1},
+				{Name: "devs", Type: "User", Score: 50, FindingCount: 1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := aggregateScores(tt.scores); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("aggregateScores() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorkloadFindingScore(t *testing.T) {
+	tests := []struct {
+		name     string
+		workload WorkloadFinding
+		want     int
+	}{
+		{"mounted token", WorkloadFinding{Finding: Finding{Score: 80}, AutomountToken: true}, 80},
+		{"unmounted token", WorkloadFinding{Finding: Finding{Score: 80}}, 40},
+		{"unmounted token rounded", WorkloadFinding{Finding: Finding{Score: 33}}, 17},
+		{"privileged is capped", WorkloadFinding{Finding: Finding{Score: 90}, AutomountToken: true, Privileged: true}, 100},
+		{"host access counted once", WorkloadFinding{Finding: Finding{Score: 60}, AutomountToken: true, HostPath: true, HostPID: true, ServiceExposure: "ClusterIP"}, 75},
+		{"unmounted token with host network and NodePort", WorkloadFinding{Finding: Finding{Score: 80}, HostNetwork: true, ServiceExposure: "NodePort"}, 60},
+		{"LoadBalancer", WorkloadFinding{Finding: Finding{Score: 50}, AutomountToken: true, ServiceExposure: "LoadBalancer"}, 60},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := workloadFindingScore(tt.workload); got != tt.want {
+				t.Errorf("workloadFindingScore() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package risk_analysis
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Accepted risks - report items matching a suppression are moved to the suppressed section of the report
+
+// Report sections without a rule are matched by these rule ids
+const (
+	EscalationPathRuleID   = "escalation-path"
+	UnusedRoleRuleID       = "unused-role"
+	UnusedBindingRuleID    = "unused-binding"
+	DeniedRequestRuleID    = "denied-request"
+	UnmatchedRequestRuleID = "unmatched-request"
+	DanglingBindingRuleID  = "dangling-binding"
+	UnboundRoleRuleID      = "unbound-role"
+)
+
+// A suppression matches items where every set field matches. Values are globs, or regular expressions with regex
+type Suppression struct {
+	Entity        string `yaml:"entity"`
+	EntityType    string `yaml:"entityType"`
+	Binding       string `yaml:"binding"`
+	Role          string `yaml:"role"`
+	Namespace     string `yaml:"namespace"`
+	RuleID        string `yaml:"ruleId"`
+	Regex         bool   `yaml:"regex"`
+	Expires       string `yaml:"expires"` // YYYY-MM-DD, the suppression applies until the end of that day
+	Justification string `yaml:"justification"`
+	patterns      []*regexp.Regexp
+}
+
+// A report item as seen by suppressions - fields which don't apply to the item are empty
+type SuppressionSubject struct {
+	Entity     string
+	EntityType string
+	Binding    string
+	Role       string
+	Namespace  string
+	RuleID     string
+}
+
+type Suppressions struct {
+	Active  []Suppression
+	Expired []Suppression
+}
+
+type suppressionsFile struct {
+	Suppressions []Suppression `yaml:"suppressions"`
+}
+
+func (s Suppression) fields() []string {
+	return []string{s.Entity, s.EntityType, s.Binding, s.Role, s.Namespace, s.RuleID}
+}
+
+func (subject SuppressionSubject) fields() []string {
+	return []string{subject.Entity, subject.EntityType, subject.Binding, subject.Role, subject.Namespace, subject.RuleID}
+}
+
+// Load the suppressions from a YAML file, split into the active and the expired ones (as of now)
+func LoadSuppressions(suppressionsPath string, now time.Time) (Suppressions, error) {
+	var suppressions Suppressions
+	if suppressionsPath == "" {
+		return suppressions, nil
+	}
+	data, err := os.ReadFile(suppressionsPath)
+	if err != nil {
+		return suppressions, fmt.Errorf("failed to read suppressions file: %v", err)
+	}
+	var file suppressionsFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return suppressions, fmt.Errorf("failed to parse suppressions file: %v", err)
+	}
+
+	for i, suppression := range file.Suppressions {
+		if strings.TrimSpace(suppression.Justification) == "" {
+			return suppressions, fmt.Errorf("suppression %d has no justification", i+1)
+		}
+		empty := true
+		for _, value := range suppression.fields() {
+			if value != "" {
+				empty = false
+			}
+		}
+		if empty {
+			return suppressions, fmt.Errorf("suppression %d must set at least one of entity, entityType, binding, role, namespace or ruleId", i+1)
+		}
+		if suppression.Regex {
+			for _, value := range suppression.fields() {
+				var re *regexp.Regexp
+				if value != "" {
+					re, err = regexp.Compile("^(?:" + value + ")$")
+					if err != nil {
+						return suppressions, fmt.Errorf("suppression %d has invalid regex: %v", i+1, err)
+					}
+				}
+				suppression.patterns = append(suppression.patterns, re)
+			}
+		} else {
+			for _, value := range suppression.fields() {
+				if _, err := path.Match(value, ""); err != nil {
+					return suppressions, fmt.Errorf("suppression %d has invalid glob %q: %v", i+1, value, err)
+				}
+			}
+		}
+		if suppression.Expires != "" {
+			expires, err := time.ParseInLocation("2006-01-02", suppression.Expires, time.Local)
+			if err != nil {
+				return suppressions, fmt.Errorf("suppression %d has invalid expires %q (YYYY-MM-DD)", i+1, suppression.Expires)
+			}
+			if !now.Before(expires.AddDate(0, 0, 1)) {
+				suppressions.Expired = append(suppressions.Expired, suppression)
+				continue
+			}
+		}
+		suppressions.Active = append(suppressions.Active, suppression)
+	}
+	return suppressions, nil
+}
+
+func (s Suppression) matches(subject SuppressionSubject) bool {
+	values := subject.fields()
+	for i, pattern := range s.fields() {
+		if pattern == "" {
+			continue
+		}
+		if s.Regex {
+			if !s.patterns[i].MatchString(values[i]) {
+				return false
+			}
+		} else if !matchesAny([]string{pattern}, values[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Get the first active suppression matching the subject
+func (s Suppressions) Match(subject SuppressionSubject) (Suppression, bool) {
+	for _, suppression := range s.Active {
+		if suppression.matches(subject) {
+			return suppression, true
+		}
+	}
+	return Suppression{}, false
+}
+
+// Split the findings into the unsuppressed and the suppressed ones
+func (s Suppressions) FilterFindings(findings []Finding) ([]Finding, []Finding) {
+	var kept, suppressed []Finding
+	for _, finding := range findings {
+		if _, ok := s.Match(finding.SuppressionSubject()); ok {
+			suppressed = append(suppressed, finding)
+		} else {
+			kept = append(kept, finding)
+		}
+	}
+	return kept, suppressed
+}
+
+func (f Finding) SuppressionSubject() SuppressionSubject {
+	namespace := f.Namespace
+	if namespace == "" && f.EntityType == "ServiceAccount" {
+		namespace = serviceAccountNamespace(f.EntityName)
+	}
+	return SuppressionSubject{
+		Entity:     f.EntityName,
+		EntityType: f.EntityType,
+		Binding:    f.PermissionBinding,
+		Role:       f.PermissionSource,
+		Namespace:  namespace,
+		RuleID:     f.RuleID,
+	}
+}
+
+// Workload findings are matched like the ServiceAccount finding, in the namespace of the workload
+func (w WorkloadFinding) SuppressionSubject() SuppressionSubject {
+	subject := w.Finding.SuppressionSubject()
+	subject.Namespace = serviceAccountNamespace(w.ServiceAccountName)
+	return subject
+}
+
+// Escalation paths are matched by the source identity and the finding of the identity holding the target
+func (p EscalationPath) SuppressionSubject() SuppressionSubject {
+	namespace := ""
+	if p.Source.Type == "ServiceAccount" {
+		namespace = serviceAccountNamespace(p.Source.Name)
+	}
+	return SuppressionSubject{
+		Entity:     p.Source.Name,
+		EntityType: p.Source.Type,
+		Binding:    p.TargetHolder.PermissionBinding,
+		Role:       p.TargetHolder.PermissionSource,
+		Namespace:  namespace,
+		RuleID:     EscalationPathRuleID,
+	}
+}
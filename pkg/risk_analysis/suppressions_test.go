@@ -0,0 +1,105 @@
+package risk_analysis
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadSuppressionsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"no justification", "suppressions:\n  - entity: alice\n", "has no justification"},
+		{"blank justification", "suppressions:\n  - entity: alice\n    justification: '  '\n", "has no justification"},
+		{"empty suppression", "suppressions:\n  - justification: Accepted\n    regex: true\n", "must set at least one of"},
+		{"invalid regex", "suppressions:\n  - entity: 'payments:(api'\n    regex: true\n    justification: Accepted\n", "invalid regex"},
+		{"invalid glob", "suppressions:\n  - binding: 'admins-['\n    justification: Accepted\n", "invalid glob"},
+		{"invalid expires", "suppressions:\n  - ruleId: KIEM-001\n    expires: 18/10/2026\n    justification: Accepted\n", "invalid expires"},
+		{"invalid YAML", "suppressions: [", "failed to parse suppressions file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadSuppressions(writeRules(t, tt.content), time.Now())
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadSuppressions() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadSuppressionsExpiry(t *testing.T) {
+	path := writeRules(t, `
+suppressions:
+  - ruleId: KIEM-001
+    expires: "2026-10-18"
+    justification: Until the migration is done
+  - ruleId: KIEM-002
+    justification: Accepted
+`)
+	tests := []struct {
+		name        string
+		now         time.Time
+		wantActive  int
+		wantExpired int
+	}{
+		{"before the day", time.Date(2026, 10, 17, 12, 0, 0, 0, time.Local), 2, 0},
+		{"end of the day", time.Date(2026, 10, 18, 23, 59, 59, 0, time.Local), 2, 0},
+		{"next day", time.Date(2026, 10, 19, 0, 0, 0, 0, time.Local), 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			suppressions, err := LoadSuppressions(path, tt.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(suppressions.Active) != tt.wantActive || len(suppressions.Expired) != tt.wantExpired {
+				t.Errorf("LoadSuppressions() = %d active and %d expired, want %d and %d",
+					len(suppressions.Active), len(suppressions.Expired), tt.wantActive, tt.wantExpired)
+			}
+		})
+	}
+}
+
+func TestSuppressionMatch(t *testing.T) {
+	suppressions, err := LoadSuppressions(writeRules(t, `
+suppressions:
+  - entity: "payments:*"
+    ruleId: KIEM-001
+    justification: Glob
+  - entity: "billing:(api|web)"
+    regex: true
+    justification: Regex
+  - entity: "ci:.*"
+    justification: Regex characters in a glob
+  - namespace: monitoring
+    justification: ServiceAccount namespace
+`), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name           string
+		finding        Finding
+		wantSuppressed bool
+		wantReason     string
+	}{
+		{"glob", Finding{EntityName: "payments:api", EntityType: "ServiceAccount", RuleID: "KIEM-001"}, true, "Glob"},
+		{"glob with another rule", Finding{EntityName: "payments:api", EntityType: "ServiceAccount", RuleID: "KIEM-002"}, false, ""},
+		{"regex", Finding{EntityName: "billing:web", EntityType: "ServiceAccount", RuleID: "KIEM-003"}, true, "Regex"},
+		{"regex is anchored", Finding{EntityName: "billing:web-2", EntityType: "ServiceAccount", RuleID: "KIEM-003"}, false, ""},
+		{"glob doesn't match as a regex", Finding{EntityName: "ci:runner", EntityType: "ServiceAccount", RuleID: "KIEM-003"}, false, ""},
+		{"glob matches literally", Finding{EntityName: "ci:.*", EntityType: "User", RuleID: "KIEM-003"}, true, "Regex characters in a glob"},
+		{"namespace of a ServiceAccount", Finding{EntityName: "monitoring:agent", EntityType: "ServiceAccount", RuleID: "KIEM-003"}, true, "ServiceAccount namespace"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			suppression, ok := suppressions.Match(tt.finding.SuppressionSubject())
+			if ok != tt.wantSuppressed || suppression.Justification != tt.wantReason {
+				t.Errorf("Match() = %q %v, want %q %v", suppression.Justification, ok, tt.wantReason, tt.wantSuppressed)
+			}
+		})
+	}
+}
@@ -0,0 +1,557 @@
+package simulation
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/kube_collection"
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/log_parsing"
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/risk_analysis"
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+// What-if simulation of proposed RBAC changes - the permissions of the bindings affected by the changes are recomputed in
+// memory with the collection logic, and the usage stored by a previous run is replayed against them to find the observed
+// requests which would be denied. Permissions from other sources (EKS access entries, GCP IAM...) are kept as they are
+
+// Objects of the proposal with this annotation are deleted instead of added or modified
+const DeleteAnnotation = "kiempossible.io/simulate"
+
+// A stored or recomputed permission
+type grant struct {
+	entityName, entityType, apiGroup, resourceType, verb, scope string
+	source, sourceType, binding, bindingType                    string
+	lastUsedTime, lastUsedResource                              string
+}
+
+type bindingKey struct {
+	bindingType, name, namespace string // The namespace is empty for ClusterRoleBindings
+}
+
+func (k bindingKey) String() string {
+	if k.namespace != "" {
+		return fmt.Sprintf("%s %s/%s", k.bindingType, k.namespace, k.name)
+	}
+	return fmt.Sprintf("%s %s", k.bindingType, k.name)
+}
+
+type roleKey struct {
+	roleType, name, namespace string // The namespace is empty for ClusterRoles
+}
+
+// A permission of a role, independent of the binding namespace - used for the roles which aren't in the proposal
+type roleTemplate struct {
+	apiGroup, resourceType, verb, resourceName string
+	namespaced                                 bool
+}
+
+type subject struct {
+	name, entityType string
+}
+
+// A binding changed by the proposal, directly or through its role
+type BindingChange struct {
+	BindingType       string `json:"binding_type"`
+	Binding           string `json:"binding"`
+	Namespace         string `json:"namespace,omitempty"`
+	Change            string `json:"change"` // added, modified, deleted, role-modified or role-deleted
+	Role              string `json:"role,omitempty"`
+	PermissionsBefore int    `json:"permissions_before"`
+	PermissionsAfter  int    `json:"permissions_after"`
+}
+
+// An observed request which the proposed RBAC would deny
+type BrokenRequest struct {
+	EntityName       string   `json:"entity_name"`
+	EntityType       string   `json:"entity_type"`
+	Verb             string   `json:"verb"`
+	ResourceType     string   `json:"resource_type"`
+	APIGroup         string   `json:"api_group"`
+	Scope            string   `json:"scope"`
+	LastUsedTime     string   `json:"last_used_time"`
+	LastUsedResource string   `json:"last_used_resource,omitempty"`
+	GrantedBy        []string `json:"granted_by"` // Bindings which granted the request before the changes
+}
+
+type Result struct {
+	Changes          []BindingChange `json:"changes"`
+	ObservedRequests int             `json:"observed_requests"`
+	Broken           []BrokenRequest `json:"broken"`
+	Warnings         []string        `json:"warnings,omitempty"`
+}
+
+type simulator struct {
+	proposal      *kube_collection.OfflineObjects
+	resourceTypes []kube_collection.ResourceType
+	subresources  map[string]string
+	namespaces    []string
+	grants        []grant
+	byBinding     map[bindingKey][]int
+	roleTemplates map[roleKey]map[roleTemplate]bool
+	groupMembers  map[string]map[subject]bool // Entities which inherited permissions from a group, from the logs
+	warnings      []string
+}
+
+func isDeleted(annotations map[string]string) bool {
+	return annotations[DeleteAnnotation] == "delete"
+}
+
+// Get the binding of a stored permission. RoleBindings are identified by the namespace of their permissions,
+// and their cluster-wide permissions (cluster-scoped resources) by their name only
+func grantBinding(g grant) bindingKey {
+	key := bindingKey{bindingType: g.bindingType, name: g.binding}
+	if g.bindingType == "RoleBinding" {
+		key.namespace = risk_analysis.ScopeNamespace(g.scope)
+	}
+	return key
+}
+
+func apiGroupName(apiGroup string) string {
+	if idx := strings.LastIndex(apiGroup, "/"); idx != -1 {
+		return apiGroup[:idx]
+	}
+	return ""
+}
+
+func loadGrants(db *sql.DB) ([]grant, error) {
+	rows, err := db.Query(`
+		SELECT entity_name, entity_type, api_group, resource_type, verb, permission_scope,
+		       permission_source, permission_source_type, permission_binding, permission_binding_type,
+		       last_used_time, last_used_resource
+		FROM permission
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query permissions: %v", err)
+	}
+	defer rows.Close()
+
+	var grants []grant
+	for rows.Next() {
+		var g grant
+		var lastUsed, lastUsedResource sql.NullString
+		if err := rows.Scan(&g.entityName, &g.entityType, &g.apiGroup, &g.resourceType, &g.verb, &g.scope,
+			&g.source, &g.sourceType, &g.binding, &g.bindingType, &lastUsed, &lastUsedResource); err != nil {
+			return nil, fmt.Errorf("failed to scan permission: %v", err)
+		}
+		g.lastUsedTime = lastUsed.String
+		g.lastUsedResource = lastUsedResource.String
+		grants = append(grants, g)
+	}
+	return grants, rows.Err()
+}
+
+// Index the stored permissions by binding, role and group
+func (s *simulator) index() {
+	s.byBinding = make(map[bindingKey][]int)
+	s.roleTemplates = make(map[roleKey]map[roleTemplate]bool)
+	s.groupMembers = make(map[string]map[subject]bool)
+	namespaces := make(map[string]bool)
+	for _, namespace := range s.proposal.AllNamespaces() {
+		namespaces[namespace.Name] = true
+	}
+
+	for i, g := range s.grants {
+		key := grantBinding(g)
+		s.byBinding[key] = append(s.byBinding[key], i)
+		if namespace := risk_analysis.ScopeNamespace(g.scope); namespace != "" {
+			namespaces[namespace] = true
+		}
+
+		switch g.sourceType {
+		case "Group":
+			if s.groupMembers[g.source] == nil {
+				s.groupMembers[g.source] = make(map[subject]bool)
+			}
+			s.groupMembers[g.source][subject{g.entityName, g.entityType}] = true
+		case "Role", "ClusterRole":
+			role := roleKey{roleType: g.sourceType, name: g.source}
+			if g.sourceType == "Role" {
+				role.namespace = key.namespace
+			}
+			if s.roleTemplates[role] == nil {
+				s.roleTemplates[role] = make(map[roleTemplate]bool)
+			}
+			template := roleTemplate{apiGroup: g.apiGroup, resourceType: g.resourceType, verb: g.verb}
+			// Permissions of namespaced resources are always in a namespace, even from ClusterRoleBindings
+			template.namespaced = !strings.HasPrefix(g.scope, "cluster-wide")
+			if _, name, ok := strings.Cut(g.scope, "/"); ok {
+				template.resourceName = name
+			}
+			s.roleTemplates[role][template] = true
+		}
+	}
+
+	for namespace := range namespaces {
+		s.namespaces = append(s.namespaces, namespace)
+	}
+	sort.Strings(s.namespaces)
+}
+
+// Get the stored binding of a key, with its role and subjects. Group-inherited permissions don't define the binding
+func (s *simulator) storedBinding(key bindingKey) (roleKey, []subject, bool) {
+	var role roleKey
+	var subjects []subject
+	seen := make(map[subject]bool)
+	for _, i := range s.byBinding[key] {
+		g := s.grants[i]
+		if g.sourceType != "Role" && g.sourceType != "ClusterRole" {
+			continue
+		}
+		role = roleKey{roleType: g.sourceType, name: g.source}
+		if g.sourceType == "Role" {
+			role.namespace = key.namespace
+		}
+		if subj := (subject{g.entityName, g.entityType}); !seen[subj] {
+			seen[subj] = true
+			subjects = append(subjects, subj)
+		}
+	}
+	return role, subjects, role.name != ""
+}
+
+// Look up a role of the proposal, and whether the proposal deletes it
+func (s *simulator) proposedRole(role roleKey) ([]rbacv1.PolicyRule, bool, bool) {
+	if role.roleType == "Role" {
+		if r, ok := s.proposal.Roles[role.namespace+"/"+role.name]; ok {
+			return r.Rules, isDeleted(r.Annotations), true
+		}
+		return nil, false, false
+	}
+	if r, ok := s.proposal.ClusterRoles[role.name]; ok {
+		return r.Rules, isDeleted(r.Annotations), true
+	}
+	return nil, false, false
+}
+
+// Recompute the permissions a binding grants to its subjects and to the entities inheriting them from group subjects
+func (s *simulator) bindingGrants(key bindingKey, role roleKey, subjects []subject) ([]grant, error) {
+	var recipients []kube_collection.PermissionContext
+	for _, subj := range subjects {
+		ctx := kube_collection.PermissionContext{
+			EntityName:  subj.name,
+			EntityType:  subj.entityType,
+			SourceName:  role.name,
+			SourceType:  role.roleType,
+			BindingName: key.name,
+			BindingType: key.bindingType,
+		}
+		recipients = append(recipients, ctx)
+		if subj.entityType == "Group" {
+			for member := range s.groupMembers[subj.name] {
+				ctx.EntityName, ctx.EntityType = member.name, member.entityType
+				ctx.SourceName, ctx.SourceType = subj.name, "Group"
+				recipients = append(recipients, ctx)
+			}
+		}
+	}
+
+	rules, deleted, proposed := s.proposedRole(role)
+	if deleted {
+		return nil, nil
+	}
+	var grants []grant
+	if proposed {
+		for _, ctx := range recipients {
+			permissions, err := kube_collection.ComputePermissions(ctx, rules, key.namespace, s.resourceTypes, s.subresources, s.namespaces)
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range permissions {
+				grants = append(grants, grant{
+					entityName: p.EntityName, entityType: p.EntityType, apiGroup: p.ResourceType.APIGroup,
+					resourceType: p.ResourceType.ResourceType, verb: p.Verb, scope: p.Scope,
+					source: p.SourceName, sourceType: p.SourceType, binding: p.BindingName, bindingType: p.BindingType,
+				})
+			}
+		}
+		return grants, nil
+	}
+
+	// Roles which aren't in the proposal are rebuilt from the permissions they granted through any binding
+	templates, ok := s.roleTemplates[role]
+	if !ok {
+		s.warnings = append(s.warnings, fmt.Sprintf("%s %s of %s is not in the proposal or the collected permissions, it grants nothing", role.roleType, role.name, key))
+		return nil, nil
+	}
+	scopes := func(t roleTemplate) []string {
+		var scopes []string
+		switch {
+		case !t.namespaced:
+			scopes = []string{"cluster-wide"}
+		case key.namespace != "":
+			scopes = []string{key.namespace}
+		default:
+			scopes = append([]string{}, s.namespaces...)
+		}
+		if t.resourceName != "" {
+			for i := range scopes {
+				scopes[i] = scopes[i] + "/" + t.resourceName
+			}
+		}
+		return scopes
+	}
+	for _, ctx := range recipients {
+		for t := range templates {
+			for _, scope := range scopes(t) {
+				grants = append(grants, grant{
+					entityName: ctx.EntityName, entityType: ctx.EntityType, apiGroup: t.apiGroup,
+					resourceType: t.resourceType, verb: t.verb, scope: scope,
+					source: ctx.SourceName, sourceType: ctx.SourceType, binding: ctx.BindingName, bindingType: ctx.BindingType,
+				})
+			}
+		}
+	}
+	return grants, nil
+}
+
+// Normalize the subjects of a proposed binding like the collection does
+func proposedSubjects(subjects []rbacv1.Subject, defaultNamespace string) []subject {
+	var normalized []subject
+	for _, subj := range subjects {
+		namespace := subj.Namespace
+		if namespace == "" {
+			namespace = defaultNamespace
+		}
+		name, entityType := log_parsing.NormalizeSubject(subj.Kind, subj.Name, namespace)
+		normalized = append(normalized, subject{name, entityType})
+	}
+	return normalized
+}
+
+func roleRefKey(ref rbacv1.RoleRef, namespace string) roleKey {
+	role := roleKey{roleType: ref.Kind, name: ref.Name}
+	if ref.Kind == "Role" {
+		role.namespace = namespace
+	}
+	return role
+}
+
+// The scope of an observed request, from the permission which granted it and the last used resource
+func requestScope(g grant) string {
+	namespace := risk_analysis.ScopeNamespace(g.scope)
+	if namespace != "" {
+		if name, ok := strings.CutPrefix(g.lastUsedResource, namespace+"/"+g.resourceType+"/"); ok {
+			return namespace + "/" + name
+		}
+		if g.lastUsedResource == namespace+"/"+g.resourceType {
+			return namespace
+		}
+		return g.scope
+	}
+	if name, ok := strings.CutPrefix(g.lastUsedResource, g.resourceType+"/"); ok {
+		return "cluster-wide/" + name
+	}
+	if g.lastUsedResource == g.resourceType {
+		return "cluster-wide"
+	}
+	return g.scope
+}
+
+// Whether a permission scope grants a request scope - the same scope, or the namespace (or cluster-wide) of a named request
+func scopeCovers(permissionScope, scope string) bool {
+	if permissionScope == scope {
+		return true
+	}
+	parent, _, named := strings.Cut(scope, "/")
+	return named && permissionScope == parent
+}
+
+// Simulate the proposal against the permissions and usage of the previous run
+func Simulate(db *sql.DB, proposal *kube_collection.OfflineObjects, resourceTypes []kube_collection.ResourceType, subresources map[string]string) (Result, error) {
+	grants, err := loadGrants(db)
+	if err != nil {
+		return Result{}, err
+	}
+	return simulate(grants, proposal, resourceTypes, subresources)
+}
+
+func simulate(grants []grant, proposal *kube_collection.OfflineObjects, resourceTypes []kube_collection.ResourceType, subresources map[string]string) (Result, error) {
+	var result Result
+	s := &simulator{proposal: proposal, resourceTypes: resourceTypes, subresources: subresources, grants: grants}
+	s.index()
+
+	// Bindings of the proposal, then the stored bindings of the proposed roles
+	type change struct {
+		BindingChange
+		key      bindingKey
+		role     roleKey
+		subjects []subject
+	}
+	var changes []change
+	changed := make(map[bindingKey]bool)
+	addChange := func(key bindingKey, kind string, role roleKey, subjects []subject) {
+		changed[key] = true
+		changes = append(changes, change{
+			BindingChange: BindingChange{BindingType: key.bindingType, Binding: key.name, Namespace: key.namespace, Change: kind, Role: role.roleType + " " + role.name},
+			key:           key, role: role, subjects: subjects,
+		})
+	}
+	bindingChange := func(key bindingKey, annotations map[string]string) string {
+		if isDeleted(annotations) {
+			return "deleted"
+		}
+		if _, _, exists := s.storedBinding(key); exists {
+			return "modified"
+		}
+		return "added"
+	}
+	for _, crb := range proposal.ClusterRoleBindings {
+		key := bindingKey{bindingType: "ClusterRoleBinding", name: crb.Name}
+		addChange(key, bindingChange(key, crb.Annotations), roleRefKey(crb.RoleRef, ""), proposedSubjects(crb.Subjects, ""))
+	}
+	for _, rb := range proposal.RoleBindings {
+		key := bindingKey{bindingType: "RoleBinding", name: rb.Name, namespace: rb.Namespace}
+		addChange(key, bindingChange(key, rb.Annotations), roleRefKey(rb.RoleRef, rb.Namespace), proposedSubjects(rb.Subjects, rb.Namespace))
+	}
+
+	var storedKeys []bindingKey
+	for key := range s.byBinding {
+		storedKeys = append(storedKeys, key)
+	}
+	sort.Slice(storedKeys, func(i, j int) bool { return storedKeys[i].String() < storedKeys[j].String() })
+	for _, key := range storedKeys {
+		if changed[key] {
+			continue
+		}
+		role, subjects, ok := s.storedBinding(key)
+		if !ok {
+			continue
+		}
+		if _, deleted, proposed := s.proposedRole(role); proposed {
+			kind := "role-modified"
+			if deleted {
+				kind = "role-deleted"
+			}
+			addChange(key, kind, role, subjects)
+		}
+	}
+
+	// Replace the permissions of the changed bindings. RoleBindings also replace the cluster-wide permissions of their name
+	replaced := make(map[int]bool)
+	var recomputed []grant
+	for i, c := range changes {
+		before := s.byBinding[c.key]
+		if c.key.bindingType == "RoleBinding" && c.key.namespace != "" {
+			before = append(append([]int{}, before...), s.byBinding[bindingKey{bindingType: "RoleBinding", name: c.key.name}]...)
+		}
+		for _, j := range before {
+			replaced[j] = true
+		}
+		changes[i].PermissionsBefore = len(before)
+		if c.Change == "deleted" {
+			continue
+		}
+		after, err := s.bindingGrants(c.key, c.role, c.subjects)
+		if err != nil {
+			return result, err
+		}
+		changes[i].PermissionsAfter = len(after)
+		recomputed = append(recomputed, after...)
+	}
+	for _, c := range changes {
+		result.Changes = append(result.Changes, c.BindingChange)
+	}
+
+	// Index the permissions after the changes
+	type permissionKey struct{ entityName, entityType, apiGroup, resourceType, verb string }
+	after := make(map[permissionKey][]string)
+	addAfter := func(g grant) {
+		key := permissionKey{g.entityName, g.entityType, apiGroupName(g.apiGroup), g.resourceType, g.verb}
+		after[key] = append(after[key], g.scope)
+	}
+	for i, g := range s.grants {
+		if !replaced[i] {
+			addAfter(g)
+		}
+	}
+	for _, g := range recomputed {
+		addAfter(g)
+	}
+
+	// Replay the observed requests - the latest usage of each permission
+	type requestKey struct {
+		permissionKey
+		scope string
+	}
+	requests := make(map[requestKey]*BrokenRequest)
+	var order []requestKey
+	for _, g := range s.grants {
+		if g.lastUsedTime == "" {
+			continue
+		}
+		key := requestKey{permissionKey{g.entityName, g.entityType, apiGroupName(g.apiGroup), g.resourceType, g.verb}, requestScope(g)}
+		request, ok := requests[key]
+		if !ok {
+			request = &BrokenRequest{EntityName: g.entityName, EntityType: g.entityType, Verb: g.verb, ResourceType: g.resourceType, APIGroup: g.apiGroup, Scope: key.scope}
+			requests[key] = request
+			order = append(order, key)
+		}
+		if g.lastUsedTime > request.LastUsedTime {
+			request.LastUsedTime = g.lastUsedTime
+			request.LastUsedResource = g.lastUsedResource
+		}
+		grantedBy := grantBinding(g).String()
+		if !containsString(request.GrantedBy, grantedBy) {
+			request.GrantedBy = append(request.GrantedBy, grantedBy)
+		}
+	}
+	result.ObservedRequests = len(order)
+	for _, key := range order {
+		covered := false
+		for _, scope := range after[key.permissionKey] {
+			if scopeCovers(scope, key.scope) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			result.Broken = append(result.Broken, *requests[key])
+		}
+	}
+	sort.SliceStable(result.Broken, func(i, j int) bool { return result.Broken[i].LastUsedTime > result.Broken[j].LastUsedTime })
+	result.Warnings = s.warnings
+	return result, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Write the result as human readable text
+func WriteText(w io.Writer, result Result) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d bindings changed, %d observed requests replayed, %d would be denied\n", len(result.Changes), result.ObservedRequests, len(result.Broken))
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(&b, "Warning: %s\n", warning)
+	}
+
+	b.WriteString("\nChanges:\n")
+	for _, c := range result.Changes {
+		key := bindingKey{bindingType: c.BindingType, name: c.Binding, namespace: c.Namespace}
+		fmt.Fprintf(&b, "  %s %s (%s) - %d -> %d permissions\n", c.Change, key, c.Role, c.PermissionsBefore, c.PermissionsAfter)
+	}
+
+	if len(result.Broken) == 0 {
+		b.WriteString("\nNo observed request would be denied\n")
+	} else {
+		b.WriteString("\nObserved requests which would be denied:\n")
+	}
+	for _, r := range result.Broken {
+		fmt.Fprintf(&b, "  %s %s - %s %s (%s) in %s\n", r.EntityType, r.EntityName, r.Verb, r.ResourceType, r.APIGroup, r.Scope)
+		fmt.Fprintf(&b, "    last used %s", r.LastUsedTime)
+		if r.LastUsedResource != "" {
+			fmt.Fprintf(&b, " on %s", r.LastUsedResource)
+		}
+		fmt.Fprintf(&b, ", granted by %s\n", strings.Join(r.GrantedBy, ", "))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,211 @@
+package simulation
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/kube_collection"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Stored permissions - alice and bob through the RoleBinding payments/readers, erin through the group devs bound by it,
+// and carol through the ClusterRoleBinding viewers
+func storedGrants() []grant {
+	reader := grant{apiGroup: "v1", resourceType: "pods", verb: "get", scope: "payments", source: "reader", sourceType: "Role", binding: "readers", bindingType: "RoleBinding"}
+	alice, bob, erin := reader, reader, reader
+	alice.entityName, alice.entityType = "alice", "User"
+	alice.lastUsedTime, alice.lastUsedResource = "2026-10-17 10:00:00", "payments/pods/api-0"
+	bob.entityName, bob.entityType = "bob", "User"
+	erin.entityName, erin.entityType, erin.source, erin.sourceType = "erin", "User", "devs", "Group"
+	erin.lastUsedTime, erin.lastUsedResource = "2026-10-16 10:00:00", "payments/pods"
+
+	viewer := grant{entityName: "carol", entityType: "User", apiGroup: "v1", resourceType: "pods", verb: "list", source: "view", sourceType: "ClusterRole", binding: "viewers", bindingType: "ClusterRoleBinding"}
+	carolPayments, carolBilling := viewer, viewer
+	carolPayments.scope = "payments"
+	carolBilling.scope = "billing"
+	carolBilling.lastUsedTime, carolBilling.lastUsedResource = "2026-10-15 10:00:00", "billing/pods"
+	return []grant{alice, bob, erin, carolPayments, carolBilling}
+}
+
+func testResources(t *testing.T) ([]kube_collection.ResourceType, map[string]string) {
+	resourceTypes, subresources, err := kube_collection.OfflineResources([]*metav1.APIResourceList{{
+		GroupVersion: "v1",
+		APIResources: []metav1.APIResource{{Name: "pods", Namespaced: true, Kind: "Pod", Verbs: metav1.Verbs{"get", "list", "watch"}}},
+	}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resourceTypes, subresources
+}
+
+var deleted = metav1.ObjectMeta{Annotations: map[string]string{DeleteAnnotation: "delete"}}
+
+func withName(meta metav1.ObjectMeta, name, namespace string) metav1.ObjectMeta {
+	meta.Name, meta.Namespace = name, namespace
+	return meta
+}
+
+func user(name string) rbacv1.Subject {
+	return rbacv1.Subject{Kind: "User", APIGroup: rbacv1.GroupName, Name: name}
+}
+
+func TestSimulate(t *testing.T) {
+	readerRef := rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: "reader"}
+	tests := []struct {
+		name        string
+		proposal    kube_collection.OfflineObjects
+		wantChanges []BindingChange
+		wantBroken  []string // Entity and scope of the broken requests
+	}{
+		{
+			name: "binding deleted",
+			proposal: kube_collection.OfflineObjects{RoleBindings: []rbacv1.RoleBinding{
+				{ObjectMeta: withName(deleted, "readers", "payments"), RoleRef: readerRef},
+			}},
+			wantChanges: []BindingChange{{BindingType: "RoleBinding", Binding: "readers", Namespace: "payments", Change: "deleted", Role: "Role reader", PermissionsBefore: 3}},
+			wantBroken:  []string{"alice payments/api-0", "erin payments"},
+		},
+		{
+			name: "binding modified",
+			proposal: kube_collection.OfflineObjects{RoleBindings: []rbacv1.RoleBinding{{
+				ObjectMeta: metav1.ObjectMeta{Name: "readers", Namespace: "payments"},
+				RoleRef:    readerRef,
+				Subjects:   []rbacv1.Subject{user("bob"), {Kind: "Group", APIGroup: rbacv1.GroupName, Name: "devs"}},
+			}}},
+			// The role is rebuilt from the stored rows, and erin inherits it from devs again
+			wantChanges: []BindingChange{{BindingType: "RoleBinding", Binding: "readers", Namespace: "payments", Change: "modified", Role: "Role reader", PermissionsBefore: 3, PermissionsAfter: 3}},
+			wantBroken:  []string{"alice payments/api-0"},
+		},
+		{
+			name: "role deleted",
+			proposal: kube_collection.OfflineObjects{Roles: map[string]*rbacv1.Role{
+				"payments/reader": {ObjectMeta: withName(deleted, "reader", "payments")},
+			}},
+			wantChanges: []BindingChange{{BindingType: "RoleBinding", Binding: "readers", Namespace: "payments", Change: "role-deleted", Role: "Role reader", PermissionsBefore: 3}},
+			wantBroken:  []string{"alice payments/api-0", "erin payments"},
+		},
+		{
+			name: "role modified",
+			proposal: kube_collection.OfflineObjects{Roles: map[string]*rbacv1.Role{
+				"payments/reader": {
+					ObjectMeta: metav1.ObjectMeta{Name: "reader", Namespace: "payments"},
+					Rules:      []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"list"}}},
+				},
+			}},
+			// The stored subjects of the binding, without the group members
+			wantChanges: []BindingChange{{BindingType: "RoleBinding", Binding: "readers", Namespace: "payments", Change: "role-modified", Role: "Role reader", PermissionsBefore: 3, PermissionsAfter: 2}},
+			wantBroken:  []string{"alice payments/api-0", "erin payments"},
+		},
+		{
+			name: "ClusterRoleBinding replaced by a RoleBinding to the ClusterRole",
+			proposal: kube_collection.OfflineObjects{
+				ClusterRoleBindings: []rbacv1.ClusterRoleBinding{{ObjectMeta: withName(deleted, "viewers", ""), RoleRef: rbacv1.RoleRef{Kind: "ClusterRole", Name: "view"}}},
+				RoleBindings: []rbacv1.RoleBinding{{
+					ObjectMeta: metav1.ObjectMeta{Name: "billing-viewers", Namespace: "billing"},
+					RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "view"},
+					Subjects:   []rbacv1.Subject{user("carol")},
+				}},
+			},
+			// The ClusterRole is rebuilt in the namespace of the RoleBinding only
+			wantChanges: []BindingChange{
+				{BindingType: "ClusterRoleBinding", Binding: "viewers", Change: "deleted", Role: "ClusterRole view", PermissionsBefore: 2},
+				{BindingType: "RoleBinding", Binding: "billing-viewers", Namespace: "billing", Change: "added", Role: "ClusterRole view", PermissionsAfter: 1},
+			},
+		},
+		{
+			name: "RoleBinding to a ClusterRole of the proposal",
+			proposal: kube_collection.OfflineObjects{
+				ClusterRoles: map[string]*rbacv1.ClusterRole{"pod-reader": {
+					ObjectMeta: metav1.ObjectMeta{Name: "pod-reader"},
+					Rules:      []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get", "list"}}},
+				}},
+				RoleBindings: []rbacv1.RoleBinding{{
+					ObjectMeta: metav1.ObjectMeta{Name: "pod-readers", Namespace: "billing"},
+					RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "pod-reader"},
+					Subjects:   []rbacv1.Subject{user("dave")},
+				}},
+			},
+			wantChanges: []BindingChange{{BindingType: "RoleBinding", Binding: "pod-readers", Namespace: "billing", Change: "added", Role: "ClusterRole pod-reader", PermissionsAfter: 2}},
+		},
+	}
+	resourceTypes, subresources := testResources(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := simulate(storedGrants(), &tt.proposal, resourceTypes, subresources)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result.Changes, tt.wantChanges) {
+				t.Errorf("changes = %+v, want %+v", result.Changes, tt.wantChanges)
+			}
+			var broken []string
+			for _, request := range result.Broken {
+				broken = append(broken, request.EntityName+" "+request.Scope)
+			}
+			if !reflect.DeepEqual(broken, tt.wantBroken) {
+				t.Errorf("broken = %v, want %v", broken, tt.wantBroken)
+			}
+			if result.ObservedRequests != 3 {
+				t.Errorf("observed requests = %d, want 3", result.ObservedRequests)
+			}
+		})
+	}
+}
+
+func TestSimulateUnknownRole(t *testing.T) {
+	proposal := &kube_collection.OfflineObjects{RoleBindings: []rbacv1.RoleBinding{{
+		ObjectMeta: metav1.ObjectMeta{Name: "writers", Namespace: "payments"},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: "writer"},
+		Subjects:   []rbacv1.Subject{user("alice")},
+	}}}
+	result, err := simulate(storedGrants(), proposal, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Warnings) != 1 || len(result.Broken) != 0 {
+		t.Errorf("simulate() = %d warnings and %d broken requests, want a warning for the unknown role", len(result.Warnings), len(result.Broken))
+	}
+}
+
+func TestRequestScope(t *testing.T) {
+	tests := []struct {
+		name string
+		g    grant
+		want string
+	}{
+		{"named resource in a namespace", grant{resourceType: "pods", scope: "payments", lastUsedResource: "payments/pods/api-0"}, "payments/api-0"},
+		{"namespace", grant{resourceType: "pods", scope: "payments", lastUsedResource: "payments/pods"}, "payments"},
+		{"other resource", grant{resourceType: "pods", scope: "payments", lastUsedResource: "payments/secrets/db"}, "payments"},
+		{"named cluster-scoped resource", grant{resourceType: "nodes", scope: "cluster-wide", lastUsedResource: "nodes/node-1"}, "cluster-wide/node-1"},
+		{"cluster-scoped resource", grant{resourceType: "nodes", scope: "cluster-wide", lastUsedResource: "nodes"}, "cluster-wide"},
+		{"named permission", grant{resourceType: "secrets", scope: "payments/db", lastUsedResource: "payments/secrets/db"}, "payments/db"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := requestScope(tt.g); got != tt.want {
+				t.Errorf("requestScope() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScopeCovers(t *testing.T) {
+	tests := []struct {
+		permissionScope, scope string
+		want                   bool
+	}{
+		{"payments", "payments", true},
+		{"payments", "payments/api-0", true},
+		{"cluster-wide", "cluster-wide/node-1", true},
+		{"payments/api-0", "payments", false},
+		{"payments/api-0", "payments/api-1", false},
+		{"billing", "payments/api-0", false},
+		{"cluster-wide", "payments", false},
+	}
+	for _, tt := range tests {
+		if got := scopeCovers(tt.permissionScope, tt.scope); got != tt.want {
+			t.Errorf("scopeCovers(%q, %q) = %v, want %v", tt.permissionScope, tt.scope, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package verification
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"math/rand"
+	"slices"
+	"strings"
+
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/kube_collection"
+	"github.com/PaloAltoNetworks/KIEMPossible/pkg/log_parsing"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Verification of the stored permissions against the live cluster - a sample of the RBAC permissions is checked with
+// SubjectAccessReviews, which go through the apiserver's real authorizer chain (RBAC, Node, webhooks, cloud authorizers).
+// For every sampled permission, a verb the DB doesn't grant on the same resource is also checked, to find access the
+// permission model misses
+
+// Permission tuples are only sampled from RBAC bindings - the cluster usernames of cloud IAM identities aren't stored
+var rbacBindingTypes = []string{"RoleBinding", "ClusterRoleBinding"}
+
+type Options struct {
+	Sample       int    // Number of permissions to sample
+	Seed         int64  // Seed of the sample, for reproducible runs
+	Entity       string // Only sample the permissions of this entity
+	CheckMissing bool   // Also check verbs which the DB doesn't grant
+}
+
+type tuple struct {
+	entityName, entityType, apiGroup, resourceType, verb, scope string
+}
+
+// A tuple where the DB and the cluster's authorizer disagree
+type Discrepancy struct {
+	EntityName     string   `json:"entity_name"`
+	EntityType     string   `json:"entity_type"`
+	Verb           string   `json:"verb"`
+	ResourceType   string   `json:"resource_type"`
+	APIGroup       string   `json:"api_group"`
+	Scope          string   `json:"scope"`
+	DBAllowed      bool     `json:"db_allowed"`
+	ClusterAllowed bool     `json:"cluster_allowed"`
+	GrantedBy      []string `json:"granted_by,omitempty"`     // Bindings granting the permission in the DB
+	ClusterReason  string   `json:"cluster_reason,omitempty"` // Reason or evaluation error returned by the authorizer
+}
+
+type Result struct {
+	Seed          int64         `json:"seed"`
+	Checked       int           `json:"checked"`
+	Agreed        int           `json:"agreed"`
+	Skipped       int           `json:"skipped"` // Sampled permissions of entities which can't be mapped to a username
+	Discrepancies []Discrepancy `json:"discrepancies"`
+	Warnings      []string      `json:"warnings,omitempty"`
+}
+
+// The identity a SubjectAccessReview is made for
+type reviewIdentity struct {
+	user   string
+	groups []string
+}
+
+func Verify(ctx context.Context, db *sql.DB, client kubernetes.Interface, opts Options) (Result, error) {
+	result := Result{Seed: opts.Seed, Discrepancies: []Discrepancy{}}
+	sample, err := sampleTuples(db, opts)
+	if err != nil {
+		return result, err
+	}
+
+	identities := map[string]*reviewIdentity{}
+	rng := rand.New(rand.NewSource(opts.Seed))
+	for _, t := range sample {
+		key := t.entityType + "/" + t.entityName
+		identity, seen := identities[key]
+		if !seen {
+			identity, err = resolveIdentity(db, t.entityName, t.entityType)
+			if err != nil {
+				return result, err
+			}
+			identities[key] = identity
+			if identity == nil {
+				result.Warnings = append(result.Warnings, fmt.Sprintf("%s %s can't be mapped back to a cluster username - its permissions were skipped", t.entityType, t.entityName))
+			}
+		}
+		if identity == nil {
+			result.Skipped++
+			continue
+		}
+
+		checks := []tuple{t}
+		if opts.CheckMissing {
+			if missing, ok, err := missingVerb(db, t, rng); err != nil {
+				return result, err
+			} else if ok {
+				checks = append(checks, missing)
+			}
+		}
+		for i, check := range checks {
+			dbAllowed := i == 0
+			clusterAllowed, reason, err := review(ctx, client, identity, check)
+			if err != nil {
+				return result, err
+			}
+			result.Checked++
+			if clusterAllowed == dbAllowed {
+				result.Agreed++
+				continue
+			}
+			discrepancy := Discrepancy{
+				EntityName:     check.entityName,
+				EntityType:     check.entityType,
+				Verb:           check.verb,
+				ResourceType:   check.resourceType,
+				APIGroup:       check.apiGroup,
+				Scope:          check.scope,
+				DBAllowed:      dbAllowed,
+				ClusterAllowed: clusterAllowed,
+				ClusterReason:  reason,
+			}
+			if dbAllowed {
+				discrepancy.GrantedBy, err = grantingBindings(db, check)
+				if err != nil {
+					return result, err
+				}
+			}
+			result.Discrepancies = append(result.Discrepancies, discrepancy)
+		}
+	}
+	return result, nil
+}
+
+// Sample distinct permission tuples granted by RBAC bindings
+func sampleTuples(db *sql.DB, opts Options) ([]tuple, error) {
+	query := `
+		SELECT DISTINCT entity_name, entity_type, api_group, resource_type, verb, permission_scope
+		FROM permission
+		WHERE permission_binding_type IN (?, ?) AND (? = '' OR entity_name = ?)
+		ORDER BY RAND(?)
+		LIMIT ?
+	`
+	rows, err := db.Query(query, rbacBindingTypes[0], rbacBindingTypes[1], opts.Entity, opts.Entity, opts.Seed, opts.Sample)
+	if err != nil {
+		return nil, fmt.Errorf("error sampling permissions: %v", err)
+	}
+	defer rows.Close()
+
+	var sample []tuple
+	for rows.Next() {
+		var t tuple
+		if err := rows.Scan(&t.entityName, &t.entityType, &t.apiGroup, &t.resourceType, &t.verb, &t.scope); err != nil {
+			return nil, fmt.Errorf("error scanning permission row: %v", err)
+		}
+		sample = append(sample, t)
+	}
+	return sample, rows.Err()
+}
+
+// Get the username and groups of an entity - the groups the apiserver adds for the kind of identity, and the ones it
+// inherits permissions from in the DB, so that the review sees the same group memberships as the collection
+// Returns nil when the entity can't be mapped back
+func resolveIdentity(db *sql.DB, entityName, entityType string) (*reviewIdentity, error) {
+	subject, ok := log_parsing.SubjectFromEntity(entityName, entityType)
+	if !ok {
+		return nil, nil
+	}
+	identity := &reviewIdentity{}
+	switch subject.Kind {
+	case "Group":
+		identity.groups = []string{subject.Name}
+		return identity, nil
+	case "ServiceAccount":
+		identity.user = fmt.Sprintf("system:serviceaccount:%s:%s", subject.Namespace, subject.Name)
+		identity.groups = []string{"system:serviceaccounts", "system:serviceaccounts:" + subject.Namespace}
+	default:
+		identity.user = subject.Name
+		if entityType == "Node" {
+			identity.groups = []string{"system:nodes"}
+		}
+	}
+	if identity.user != "system:anonymous" {
+		identity.groups = append(identity.groups, "system:authenticated")
+	}
+
+	rows, err := db.Query(`
+		SELECT DISTINCT permission_source FROM permission
+		WHERE entity_name = ? AND entity_type = ? AND permission_source_type = 'Group'
+	`, entityName, entityType)
+	if err != nil {
+		return nil, fmt.Errorf("error querying the groups of %s: %v", entityName, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("error scanning group row: %v", err)
+		}
+		group, ok := log_parsing.SubjectFromEntity(name, "Group")
+		if ok && !slices.Contains(identity.groups, group.Name) {
+			identity.groups = append(identity.groups, group.Name)
+		}
+	}
+	return identity, rows.Err()
+}
+
+// Pick a verb of the resource which no permission in the DB grants the entity on the same scope
+func missingVerb(db *sql.DB, t tuple, rng *rand.Rand) (tuple, bool, error) {
+	verbs, err := kube_collection.GetVerbsForResourceType(t.resourceType)
+	if err != nil {
+		return tuple{}, false, err
+	}
+	rng.Shuffle(len(verbs), func(i, j int) { verbs[i], verbs[j] = verbs[j], verbs[i] })
+	for _, verb := range verbs {
+		candidate := t
+		candidate.verb = verb
+		granted, err := dbGrants(db, candidate)
+		if err != nil {
+			return tuple{}, false, err
+		}
+		if !granted {
+			return candidate, true, nil
+		}
+	}
+	return tuple{}, false, nil
+}
+
+// Check whether any permission in the DB covers the tuple - the same scope, or a wider one
+func dbGrants(db *sql.DB, t tuple) (bool, error) {
+	var exists bool
+	err := db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM permission
+			WHERE entity_name = ? AND entity_type = ? AND api_group = ? AND resource_type = ? AND verb = ?
+			AND (
+				permission_scope = ? OR permission_scope = 'cluster-wide' OR
+				(permission_scope = SUBSTRING_INDEX(?, '/', 1) AND ? LIKE '%/%')
+			)
+		)
+	`, t.entityName, t.entityType, t.apiGroup, t.resourceType, t.verb, t.scope, t.scope, t.scope).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("error querying permissions: %v", err)
+	}
+	return exists, nil
+}
+
+func grantingBindings(db *sql.DB, t tuple) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT DISTINCT permission_binding_type, permission_binding FROM permission
+		WHERE entity_name = ? AND entity_type = ? AND api_group = ? AND resource_type = ? AND verb = ? AND permission_scope = ?
+		ORDER BY permission_binding_type, permission_binding
+	`, t.entityName, t.entityType, t.apiGroup, t.resourceType, t.verb, t.scope)
+	if err != nil {
+		return nil, fmt.Errorf("error querying bindings: %v", err)
+	}
+	defer rows.Close()
+	var bindings []string
+	for rows.Next() {
+		var bindingType, binding string
+		if err := rows.Scan(&bindingType, &binding); err != nil {
+			return nil, fmt.Errorf("error scanning binding row: %v", err)
+		}
+		bindings = append(bindings, fmt.Sprintf("%s %s", bindingType, binding))
+	}
+	return bindings, rows.Err()
+}
+
+// Ask the cluster's authorizer whether the identity is allowed the tuple
+func review(ctx context.Context, client kubernetes.Interface, identity *reviewIdentity, t tuple) (bool, string, error) {
+	sar := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:               identity.user,
+			Groups:             identity.groups,
+			ResourceAttributes: resourceAttributes(t),
+		},
+	}
+	response, err := client.AuthorizationV1().SubjectAccessReviews().Create(ctx, sar, metav1.CreateOptions{})
+	if err != nil {
+		return false, "", fmt.Errorf("error creating SubjectAccessReview: %v", err)
+	}
+	reason := response.Status.Reason
+	if response.Status.EvaluationError != "" {
+		reason = strings.TrimSpace(reason + " " + response.Status.EvaluationError)
+	}
+	return response.Status.Allowed, reason, nil
+}
+
+// Map a stored permission to the request attributes - api_group is group/version ("v1" for the core group), the scope
+// is cluster-wide or a namespace, optionally followed by a resource name
+func resourceAttributes(t tuple) *authorizationv1.ResourceAttributes {
+	attributes := &authorizationv1.ResourceAttributes{Verb: t.verb}
+	if group, version, found := strings.Cut(t.apiGroup, "/"); found {
+		attributes.Group, attributes.Version = group, version
+	} else {
+		attributes.Version = t.apiGroup
+	}
+	attributes.Resource, attributes.Subresource, _ = strings.Cut(t.resourceType, "/")
+	namespace, name, _ := strings.Cut(t.scope, "/")
+	if namespace != "cluster-wide" {
+		attributes.Namespace = namespace
+	}
+	attributes.Name = name
+	return attributes
+}
+
+// Write the result as human readable text
+func WriteText(w io.Writer, result Result) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d checks (seed %d), %d agreed, %d discrepancies, %d sampled permissions skipped\n",
+		result.Checked, result.Seed, result.Agreed, len(result.Discrepancies), result.Skipped)
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(&b, "Warning: %s\n", warning)
+	}
+
+	if len(result.Discrepancies) == 0 {
+		b.WriteString("\nThe DB matches the cluster's authorizer for every check\n")
+	} else {
+		b.WriteString("\nDiscrepancies:\n")
+	}
+	for _, d := range result.Discrepancies {
+		fmt.Fprintf(&b, "  %s %s - %s %s (%s) in %s\n", d.EntityType, d.EntityName, d.Verb, d.ResourceType, d.APIGroup, d.Scope)
+		if d.DBAllowed {
+			fmt.Fprintf(&b, "    allowed in the DB by %s, denied by the cluster", strings.Join(d.GrantedBy, ", "))
+		} else {
+			b.WriteString("    not in the DB, allowed by the cluster")
+		}
+		if d.ClusterReason != "" {
+			fmt.Fprintf(&b, " (%s)", d.ClusterReason)
+		}
+		b.WriteString("\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
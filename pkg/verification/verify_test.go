@@ -0,0 +1,191 @@
+package verification
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestResourceAttributes(t *testing.T) {
+	tests := []struct {
+		name string
+		t    tuple
+		want authorizationv1.ResourceAttributes
+	}{
+		{
+			name: "core group",
+			t:    tuple{apiGroup: "v1", resourceType: "secrets", verb: "get", scope: "payments"},
+			want: authorizationv1.ResourceAttributes{Verb: "get", Version: "v1", Resource: "secrets", Namespace: "payments"},
+		},
+		{
+			name: "named group",
+			t:    tuple{apiGroup: "apps/v1", resourceType: "deployments", verb: "list", scope: "cluster-wide"},
+			want: authorizationv1.ResourceAttributes{Verb: "list", Group: "apps", Version: "v1", Resource: "deployments"},
+		},
+		{
+			name: "subresource with a name",
+			t:    tuple{apiGroup: "v1", resourceType: "pods/exec", verb: "create", scope: "payments/api-0"},
+			want: authorizationv1.ResourceAttributes{Verb: "create", Version: "v1", Resource: "pods", Subresource: "exec", Namespace: "payments", Name: "api-0"},
+		},
+		{
+			name: "cluster-wide name",
+			t:    tuple{apiGroup: "v1", resourceType: "nodes", verb: "get", scope: "cluster-wide/node-1"},
+			want: authorizationv1.ResourceAttributes{Verb: "get", Version: "v1", Resource: "nodes", Name: "node-1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resourceAttributes(tt.t); !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("resourceAttributes() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveIdentity(t *testing.T) {
+	tests := []struct {
+		name       string
+		entityName string
+		entityType string
+		dbGroups   []string
+		want       *reviewIdentity
+	}{
+		{
+			name:       "service account",
+			entityName: "payments:api",
+			entityType: "ServiceAccount",
+			want: &reviewIdentity{
+				user:   "system:serviceaccount:payments:api",
+				groups: []string{"system:serviceaccounts", "system:serviceaccounts:payments", "system:authenticated"},
+			},
+		},
+		{
+			name:       "node",
+			entityName: "node-1",
+			entityType: "Node",
+			want:       &reviewIdentity{user: "system:node:node-1", groups: []string{"system:nodes", "system:authenticated"}},
+		},
+		{
+			name:       "user with groups from the DB",
+			entityName: "alice",
+			entityType: "User",
+			dbGroups:   []string{"devs", "system:authenticated"},
+			want:       &reviewIdentity{user: "alice", groups: []string{"system:authenticated", "devs"}},
+		},
+		{
+			name:       "group",
+			entityName: "devs",
+			entityType: "Group",
+			want:       &reviewIdentity{groups: []string{"devs"}},
+		},
+		{
+			name:       "service account without a namespace",
+			entityName: "api",
+			entityType: "ServiceAccount",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			if tt.want != nil && tt.entityType != "Group" {
+				rows := sqlmock.NewRows([]string{"permission_source"})
+				for _, group := range tt.dbGroups {
+					rows.AddRow(group)
+				}
+				mock.ExpectQuery("SELECT DISTINCT permission_source FROM permission").WithArgs(tt.entityName, tt.entityType).WillReturnRows(rows)
+			}
+
+			got, err := resolveIdentity(db, tt.entityName, tt.entityType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolveIdentity() = %+v, want %+v", got, tt.want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT DISTINCT entity_name, entity_type").WillReturnRows(
+		sqlmock.NewRows([]string{"entity_name", "entity_type", "api_group", "resource_type", "verb", "permission_scope"}).
+			AddRow("payments:api", "ServiceAccount", "v1", "secrets", "get", "payments").
+			AddRow("alice", "User", "apps/v1", "deployments", "delete", "cluster-wide").
+			AddRow("api", "ServiceAccount", "v1", "pods", "list", "payments"))
+	// payments:api - allowed by both, and a verb missing from the DB which the cluster allows
+	mock.ExpectQuery("SELECT DISTINCT permission_source FROM permission").WillReturnRows(sqlmock.NewRows([]string{"permission_source"}))
+	mock.ExpectQuery("SELECT EXISTS").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	// alice - allowed in the DB and denied by the cluster, and a missing verb denied by both
+	mock.ExpectQuery("SELECT DISTINCT permission_source FROM permission").WillReturnRows(sqlmock.NewRows([]string{"permission_source"}).AddRow("devs"))
+	mock.ExpectQuery("SELECT EXISTS").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery("SELECT DISTINCT permission_binding_type, permission_binding").
+		WithArgs("alice", "User", "apps/v1", "deployments", "delete", "cluster-wide").
+		WillReturnRows(sqlmock.NewRows([]string{"permission_binding_type", "permission_binding"}).AddRow("ClusterRoleBinding", "deployers"))
+	// api - no namespace, skipped without queries
+
+	var reviews []authorizationv1.SubjectAccessReviewSpec
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		sar := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		reviews = append(reviews, sar.Spec)
+		sar.Status.Allowed = sar.Spec.User == "system:serviceaccount:payments:api"
+		if !sar.Status.Allowed {
+			sar.Status.Reason = "no RBAC policy matched"
+		}
+		return true, sar, nil
+	})
+
+	result, err := Verify(context.Background(), db, client, Options{Sample: 10, Seed: 1, CheckMissing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	if result.Checked != 4 || result.Agreed != 2 || result.Skipped != 1 || len(result.Warnings) != 1 {
+		t.Errorf("Verify() checked %d, agreed %d, skipped %d with %d warnings, want 4, 2, 1 and 1",
+			result.Checked, result.Agreed, result.Skipped, len(result.Warnings))
+	}
+	if len(result.Discrepancies) != 2 {
+		t.Fatalf("Verify() found %d discrepancies, want 2", len(result.Discrepancies))
+	}
+	missing := result.Discrepancies[0]
+	if missing.EntityName != "payments:api" || missing.DBAllowed || !missing.ClusterAllowed || missing.Verb == "get" {
+		t.Errorf("first discrepancy = %+v, want a verb of payments:api missing from the DB", missing)
+	}
+	denied := result.Discrepancies[1]
+	want := Discrepancy{
+		EntityName: "alice", EntityType: "User", Verb: "delete", ResourceType: "deployments", APIGroup: "apps/v1",
+		Scope: "cluster-wide", DBAllowed: true, GrantedBy: []string{"ClusterRoleBinding deployers"}, ClusterReason: "no RBAC policy matched",
+	}
+	if !reflect.DeepEqual(denied, want) {
+		t.Errorf("second discrepancy = %+v, want %+v", denied, want)
+	}
+
+	if len(reviews) != 4 {
+		t.Fatalf("%d SubjectAccessReviews created, want 4", len(reviews))
+	}
+	if groups := reviews[2].Groups; !reflect.DeepEqual(groups, []string{"system:authenticated", "devs"}) {
+		t.Errorf("groups of alice = %v, want the implicit and DB groups", groups)
+	}
+}